@@ -23,7 +23,9 @@ type PlanCmd struct {
 type ApplyCmd struct {
 	Dryrun bool `default:"false" hidden`
 }
-type LintCmd struct{}
+type LintCmd struct {
+	RequireSchemaTags []string `help:"Metadata tags every schema must declare, like owner,pii"`
+}
 
 type LintBrokersCmd struct {
 	OnlyErrors bool `flag default:"false" `
@@ -67,32 +69,32 @@ func (cmd *PlanCmd) Run(ctx *CLIContext) error {
 	return nil
 }
 
-func parseOffsetsArg(arg *string) (map[int32]int64, error) {
-	offsets := make(map[int32]int64)
+// Parse a TOPIC=partition:offset,partition:offset argument. Offsets can be symbolic, see parseOffsetSpec
+func parseOffsetsArg(arg *string) (string, map[int32]offsetSpec, error) {
+	offsets := make(map[int32]offsetSpec)
 	splitStrings := strings.Split(*arg, "=")
-	if len(splitStrings) != 2 {
-		return offsets, fmt.Errorf("= not found in SetOffsets param. Expected TOPIC=partition:offset")
+	if len(splitStrings) != 2 || splitStrings[0] == "" {
+		return "", offsets, fmt.Errorf("= not found in SetOffsets param. Expected TOPIC=partition:offset")
 	}
-	// topic not used so assign to blank var
-	_, offsetStr := splitStrings[0], splitStrings[1]
+	topic, offsetStr := splitStrings[0], splitStrings[1]
 	partOffsetPairs := strings.Split(offsetStr, ",")
 	for _, partOffsetPair := range partOffsetPairs {
 		// Split into partition and offset and merge into offsets map
 		splitStrings = strings.Split(partOffsetPair, ":")
 		if len(splitStrings) != 2 {
-			return offsets, fmt.Errorf("expected format partition:offset. Found: %s", partOffsetPair)
+			return topic, offsets, fmt.Errorf("expected format partition:offset. Found: %s", partOffsetPair)
 		}
 		partition, err := strconv.ParseInt(splitStrings[0], 10, 32)
 		if err != nil {
-			return offsets, err
+			return topic, offsets, err
 		}
-		offset, err := strconv.ParseInt(splitStrings[1], 10, 64)
+		offset, err := parseOffsetSpec(splitStrings[1])
 		if err != nil {
-			return offsets, err
+			return topic, offsets, err
 		}
 		offsets[int32(partition)] = offset
 	}
-	return offsets, nil
+	return topic, offsets, nil
 }
 
 func (cmd *LintCmd) Run(ctx *CLIContext) error {
@@ -103,6 +105,7 @@ func (cmd *LintCmd) Run(ctx *CLIContext) error {
 		res := LintTopic(topic)
 		results = append(results, res...)
 	}
+	results = append(results, LintSchemas(inputData.Topics, inputData.Subjects, cmd.RequireSchemaTags)...)
 	PrettyPrintLintResults(results)
 	return nil
 }
@@ -185,13 +188,15 @@ func DoSync(kafkadmin *KafkaAdmin, sradmin *SRAdmin, mdsadmin *MDSAdmin, connect
 	var connectResults []ConnectorResult
 	var clusterLinkResults []ClusterLinkResult
 	var schemaResults []SchemaResult
+	var exporterResults []SchemaExporterResult
 	topicResults := kafkadmin.ReconcileTopics(inputData.Topics, dryRun)
 
 	if sradmin.IsUsuable() {
-		schemaResults = sradmin.Reconcile(inputData.Topics, dryRun)
+		schemaResults = sradmin.Reconcile(inputData.Topics, inputData.Subjects, inputData.SchemaRegistry, dryRun)
+		exporterResults = sradmin.ReconcileExporters(inputData.SchemaExporters, dryRun)
 	}
 	// Do MDS
-	roleResults := mdsadmin.Reconcile(inputData.Clients, dryRun)
+	roleResults := mdsadmin.Reconcile(inputData.Clients, inputData.Topics, dryRun)
 	if (*connectadmin != ConnectAdmin{}) {
 		connectResults = connectadmin.Reconcile(inputData.Connectors, dryRun)
 	}
@@ -200,5 +205,6 @@ func DoSync(kafkadmin *KafkaAdmin, sradmin *SRAdmin, mdsadmin *MDSAdmin, connect
 		clusterLinkResults = clusterLinkAdmin.Reconcile(inputData.ClusterLinks, dryRun)
 	}
 
-	return NewReport(topicResults, schemaResults, roleResults, connectResults, clusterLinkResults, dryRun)
+	report := NewReport(topicResults, schemaResults, exporterResults, roleResults, connectResults, clusterLinkResults, dryRun)
+	return report
 }
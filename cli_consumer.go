@@ -1,41 +1,121 @@
 package main
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
 type ConsumerCmd struct {
 	Topics           []string `required arg help:"Topic to read from"`
-	MaxRecords       int      `default:"0" help:"Max reacords to read. default to no limit"` // 0 means no limit
+	MaxRecords       int      `default:"0" help:"Max reacords to read (matching --filter if set). default to no limit"` // 0 means no limit
 	DeserializeKey   bool     `default:"false" help:"Deserialize message key"`
 	DeserializeValue bool     `default:"false" help:"Deserialize message value"`
+	KeyFormat        string   `help:"How to render keys: string,hex,base64,int32,int64,uuid,registry-avro,auto. Defaults to string, or registry-avro with --deserialize-key"`
+	ValueFormat      string   `help:"How to render values: string,hex,base64,int32,int64,uuid,registry-avro,auto. Defaults to string, or registry-avro with --deserialize-value"`
 	GroupID          string   `help:"Consumer group ID to use"`
 	FromBeginning    bool     `default:"false" help:"Start reading from the beginning of the topic"`
-	SetOffsets       string   `help:"Set offsets for partition on topic. Syntax is: TOPICNAME=partition:offset,partition:offset,.."`
+	SetOffsets       []string `sep:"none" help:"Set offsets for partitions of a topic. Syntax is: TOPICNAME=partition:offset,partition:offset,.. Offsets can be earliest, latest or -N (N records before the end). Repeat for more topics"`
 	RecordTemplate   string   `help:"Path to a golan template to format records"`
-	OutputFormat     string   `default:"text" help:"Output format. [text,json]"`
+	OutputFormat     string   `default:"text" help:"Output format. [text,json,csv,avro]"`
+	Output           string   `help:"Write records to this file instead of stdout"`
+	CSVColumns       []string `name:"csv-columns" help:"Columns of the csv output format, as --filter fields like value.customer.id. Defaults to topic,partition,offset,timestamp,key,value"`
+	RotateBytes      int64    `default:"0" help:"Start a new output file when the current one reaches this size in bytes"`
+	RotateRecords    int      `default:"0" help:"Start a new output file after this many records"`
+	ValidateJSON     bool     `default:"false" help:"Validate JSON Schema payloads against their schema when deserializing"`
+	FromTime         string   `help:"Start from the first record at or after this time. RFC3339 or relative to now, like -15m"`
+	UntilTime        string   `help:"Stop each partition at the first record after this time. RFC3339 or relative to now, like -5m"`
+	Assign           bool     `default:"false" help:"Consume all partitions directly, without a consumer group. No offsets are committed"`
+	ExitAtEnd        bool     `default:"false" help:"Exit once all partitions reached the end offsets they had at start"`
+	Filter           string   `help:"Only print records matching this expression, like: value.customer.id == \"C-1042\""`
 }
 
 func (cmd *ConsumerCmd) Run(ctx *CLIContext) error {
 	config := LoadConfig(ctx.Config)
-	var offsets map[int32]int64
+	offsets := make(map[string]map[int32]offsetSpec)
 	var err error
 	var useOffsets bool = false
-	if cmd.SetOffsets != "" {
+	for _, arg := range cmd.SetOffsets {
 		useOffsets = true
-		offsets, err = parseOffsetsArg(&cmd.SetOffsets)
+		topic, topicOffsets, err := parseOffsetsArg(&arg)
 		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		offsets = make(map[int32]int64)
+		if !containsString(cmd.Topics, topic) {
+			log.Fatalf("--set-offsets for topic %s, which is not consumed", topic)
+		}
+		if _, exists := offsets[topic]; !exists {
+			offsets[topic] = make(map[int32]offsetSpec)
+		}
+		for partition, offset := range topicOffsets {
+			offsets[topic][partition] = offset
+		}
 	}
 
-	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, cmd.Topics, cmd.GroupID, offsets, useOffsets, cmd.DeserializeKey, cmd.DeserializeValue, cmd.FromBeginning, cmd.RecordTemplate, nil)
-	switch cmd.OutputFormat {
-	case "text":
+	if cmd.Assign && cmd.GroupID != "" {
+		log.Fatal("--assign and --group-id can't be used together")
+	}
+
+	var fromTime, untilTime time.Time
+	now := time.Now()
+	if cmd.FromTime != "" {
+		if useOffsets {
+			log.Fatal("--from-time and --set-offsets can't be used together")
+		}
+		if fromTime, err = parseTimeArg(cmd.FromTime, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cmd.UntilTime != "" {
+		if untilTime, err = parseTimeArg(cmd.UntilTime, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, cmd.Topics, cmd.GroupID, cmd.Assign, offsets, useOffsets, cmd.DeserializeKey, cmd.DeserializeValue, cmd.FromBeginning, cmd.RecordTemplate, nil)
+	consumer.SetValidateJSON(cmd.ValidateJSON)
+	if cmd.DeserializeKey && cmd.KeyFormat != "" && cmd.KeyFormat != payloadFormatRegistry {
+		log.Fatalf("--deserialize-key and --key-format %s can't be used together", cmd.KeyFormat)
+	}
+	if cmd.DeserializeValue && cmd.ValueFormat != "" && cmd.ValueFormat != payloadFormatRegistry {
+		log.Fatalf("--deserialize-value and --value-format %s can't be used together", cmd.ValueFormat)
+	}
+	if err := consumer.SetPayloadFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		log.Fatal(err)
+	}
+	if cmd.Filter != "" {
+		filter, err := NewRecordFilter(cmd.Filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consumer.SetFilter(filter)
+	}
+	if !fromTime.IsZero() || !untilTime.IsZero() {
+		if err := consumer.SetTimeRange(fromTime, untilTime); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cmd.ExitAtEnd {
+		if err := consumer.SetExitAtEnd(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch {
+	case cmd.Output != "" || cmd.OutputFormat == "csv" || cmd.OutputFormat == "avro" || cmd.RotateBytes > 0 || cmd.RotateRecords > 0:
+		if cmd.OutputFormat == "text" {
+			log.Fatal("text output can't be written to a file. Use json, csv or avro")
+		}
+		if cmd.RecordTemplate != "" {
+			log.Fatal("--record-template can't be used with --output or the csv and avro formats")
+		}
+		sink, err := NewRecordSink(cmd.Output, cmd.OutputFormat, cmd.CSVColumns, cmd.RotateBytes, cmd.RotateRecords, consumer.SRClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consumer.SetSink(sink)
+	case cmd.OutputFormat == "text":
 		consumer.SetRecordPrinterFunc(prettyPrintRecord)
-	case "json":
+	case cmd.OutputFormat == "json":
 		consumer.SetRecordPrinterFunc(jsonPrintRecord)
 	default:
 		log.Fatal("Unknown output format")
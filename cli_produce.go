@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/kmetaxas/srclient"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +25,14 @@ type ProduceCmd struct {
 	ValueSchemaFile string              `help:"Path to schema file for Value. If empty, the latest version will be pulled from the SchemaRegistry"`
 	KeySchemaFile   string              `help:"Path to schema file for Key. If empty, the latest version will be pulled from the SchemaRegistry"`
 	WholeFile       string              `help:"Read whole file as value payload. (for example to test max size). No schema will be used"`
+	Input           string              `help:"Produce the records of a JSON lines file, as the consumer's json output format prints them. Use - for stdin"`
+	ProgressEvery   time.Duration       `default:"5s" help:"How often to print progress to stderr with --input or --generate. 0 to only print a summary"`
+	Generate        bool                `help:"Produce random records that are valid for the value schema: --value-schema-file, or else the latest schema of the topic"`
+	Spec            string              `help:"YAML file with hints for --generate: field ranges, enums, patterns, fakers and the key"`
+	SchemaType      string              `help:"Type of --value-schema-file with --generate (AVRO, PROTOBUF or JSON). Defaults from the file extension"`
+	Count           int64               `default:"10" help:"Number of records to produce with --generate. 0 to produce until interrupted"`
+	Rate            float64             `help:"Records per second to produce with --generate. 0 for as fast as possible"`
+	Seed            int64               `help:"Random seed for --generate, to produce the same records again. 0 for a random one"`
 }
 
 func (cmd *ProduceCmd) Run(ctx *CLIContext) error {
@@ -27,8 +40,32 @@ func (cmd *ProduceCmd) Run(ctx *CLIContext) error {
 	if cmd.Tombstone && (cmd.Separator != "") {
 		log.Fatal("tombstone can't be used with separator because value will always be null")
 	}
+	if cmd.Input != "" && (cmd.Separator != "" || cmd.Tombstone || cmd.WholeFile != "") {
+		log.Fatal("--input can't be used with --separator, --tombstone or --whole-file. Records set their own key and value")
+	}
+	if cmd.Generate && (cmd.Input != "" || cmd.Separator != "" || cmd.Tombstone || cmd.WholeFile != "") {
+		log.Fatal("--generate can't be used with --input, --separator, --tombstone or --whole-file")
+	}
+	if !cmd.Generate && (cmd.Spec != "" || cmd.SchemaType != "") {
+		log.Fatal("--spec and --schema-type can only be used with --generate")
+	}
 	config := LoadConfig(ctx.Config)
 	producer := NewProducer(config.Connections.Kafka, &config.Connections.Schemaregistry, cmd.Acks, cmd.Idempotent)
+	if cmd.Generate {
+		return cmd.generate(producer)
+	}
+	if cmd.Input != "" {
+		input := os.Stdin
+		if cmd.Input != "-" {
+			file, err := os.Open(cmd.Input)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			input = file
+		}
+		return producer.ProduceInput(cmd.Topic, input, cmd.Serialize, cmd.ValueSchemaFile, cmd.KeySchemaFile, os.Stderr, cmd.ProgressEvery)
+	}
 	// If WholeFile is provided, read the file and produce it.
 	if cmd.WholeFile != "" {
 		data, err := os.ReadFile(cmd.WholeFile)
@@ -72,3 +109,35 @@ func (cmd *ProduceCmd) Run(ctx *CLIContext) error {
 	}
 	return nil
 }
+
+func (cmd *ProduceCmd) generate(producer *Producer) error {
+	var spec *generateSpec
+	if cmd.Spec != "" {
+		var err error
+		spec, err = loadGenerateSpec(cmd.Spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	schemaType := srclient.SchemaType(strings.ToUpper(cmd.SchemaType))
+	if schemaType != "" && schemaType != srclient.Avro && schemaType != srclient.Protobuf && schemaType != srclient.Json {
+		log.Fatalf("unknown schema type %s. Expected one of AVRO,PROTOBUF,JSON", cmd.SchemaType)
+	}
+	if cmd.Count < 0 || cmd.Rate < 0 {
+		log.Fatal("--count and --rate can't be negative")
+	}
+	// Stop on interrupt, which is how runs without a count end
+	generateCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigterm)
+	go func() {
+		select {
+		case <-sigterm:
+			cancel()
+		case <-generateCtx.Done():
+		}
+	}()
+	return producer.ProduceGenerated(generateCtx, cmd.Topic, cmd.ValueSchemaFile, schemaType, spec, cmd.Seed, cmd.Count, cmd.Rate, os.Stderr, cmd.ProgressEvery)
+}
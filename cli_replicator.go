@@ -25,7 +25,7 @@ func (cmd *ReplicatorCmd) Run(ctx *CLIContext) error {
 	}
 
 	// Create consumer
-	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, []string{cmd.SourceTopic}, groupID, nil, false, false, false, true, "", nil)
+	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, []string{cmd.SourceTopic}, groupID, false, nil, false, false, false, true, "", nil)
 
 	// Create Producer make it idempotent)
 	producer := NewProducer(destConfig.Connections.Kafka, &config.Connections.Schemaregistry, -1, true)
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/kmetaxas/srclient"
 	log "github.com/sirupsen/logrus"
 )
 
 type SchemaCmd struct {
-	CheckExists CheckExistsCmd `cmd help:"Check if provided schema is registered"`
-	SchemaDiff  SchemaDiffCmd  `cmd help:"Get the diff between a schema file and a registered schema"`
-	SchemaCache SchemasCache   `cmd help:"TEST get schema cache"`
+	CheckExists CheckExistsCmd   `cmd help:"Check if provided schema is registered"`
+	SchemaDiff  SchemaDiffCmd    `cmd help:"Get the diff between a schema file and a registered schema"`
+	SchemaCache SchemasCache     `cmd help:"TEST get schema cache"`
+	Check       SchemaCheckCmd   `cmd help:"Check compatibility of a schema file against previous versions without the registry"`
+	Export      SchemaExportCmd  `cmd help:"Export all subjects to a directory"`
+	Import      SchemaImportCmd  `cmd help:"Import an exported directory into an empty schema registry"`
+	History     SchemaHistoryCmd `cmd help:"List the versions of a subject and how its fields evolved"`
 }
 
 type CheckExistsCmd struct {
@@ -22,6 +32,30 @@ type SchemaDiffCmd struct {
 }
 type SchemasCache struct {
 }
+type SchemaCheckCmd struct {
+	SchemaFile    string `required help:"Schema file to check"`
+	Subject       string `required help:"Subject the schema will be registered under"`
+	SchemaType    string `help:"Schema type. Only AVRO schemas can be checked offline" default:"AVRO" enum:"AVRO"`
+	Compatibility string `help:"Compatibility level. Defaults to the subject's level when using the cache, BACKWARD otherwise"`
+	FromCache     bool   `help:"Read previous versions from the _schemas topic" xor:"source"`
+	FromDir       string `help:"Read previous versions from a directory of exported schemas (DIR/<subject>/<version>.avsc)" xor:"source"`
+	GitRev        string `help:"Compare against the schema file as it is in this git revision" xor:"source"`
+}
+type SchemaExportCmd struct {
+	Dir       string `required help:"Directory to export to"`
+	Latest    bool   `help:"Only export the latest version of each subject"`
+	FromCache bool   `help:"Read from the _schemas topic instead of the REST API"`
+}
+type SchemaImportCmd struct {
+	Dir string `required help:"Directory with an export"`
+}
+type SchemaHistoryCmd struct {
+	Subject string `arg required help:"Subject to show the history of"`
+	From    int    `help:"Show the field changes from this version. Defaults to the version registered before --to"`
+	To      int    `help:"Show the field changes up to this version. Defaults to the latest"`
+	Changes bool   `help:"Show the field changes of every version against the one registered before it"`
+	Output  string `help:"Output format" default:"text" enum:"text,json"`
+}
 
 // Check if a schema is registered in specified subject. Shows version and Id if it is
 func (cmd *CheckExistsCmd) Run(ctx *CLIContext) error {
@@ -51,17 +85,21 @@ func (cmd *CheckExistsCmd) Run(ctx *CLIContext) error {
 func (cmd *SchemaDiffCmd) Run(ctx *CLIContext) error {
 	config := LoadConfig(ctx.Config)
 	_, sradmin, _, _, _ := GetAdminClients(config)
-	schema, err := CreateSchema(cmd.Subject, cmd.SchemaFile, "BACKWARD", "AVRO")
+	existingSchema, err := sradmin.Client.GetSchemaByVersion(cmd.Subject, cmd.Version)
 	if err != nil {
 		return err
 	}
-	existingSchema, err := sradmin.Client.GetSchemaByVersion(cmd.Subject, cmd.Version)
+	schemaType := srclient.Avro
+	if existingSchema.SchemaType() != nil {
+		schemaType = *existingSchema.SchemaType()
+	}
+	schema, err := CreateSchema(cmd.Subject, cmd.SchemaFile, "BACKWARD", schemaType)
 	if err != nil {
 		return err
 	}
 	isEqual, diffString := schema.SchemaDiff(existingSchema.Schema())
 	if isEqual {
-		fmt.Printf("Schemas are equal\n")
+		fmt.Printf("Schemas are equal (after normalization)\n")
 	} else {
 		fmt.Printf("Schemas are NOT equal. Below is a diff:\n")
 		fmt.Printf("%s\n", diffString)
@@ -76,8 +114,255 @@ func (cmd *SchemasCache) Run(ctx *CLIContext) error {
 	if err != nil {
 		return err
 	}
-	cache.ReadSchemaTopic("_topic")
+	cache.ReadSchemaTopic("_schemas")
 	cache.ListSubjects()
 
 	return nil
 }
+
+// Check compatibility of a schema file locally. Previous versions come from the _schemas topic, a directory or git
+func (cmd *SchemaCheckCmd) Run(ctx *CLIContext) error {
+	config := LoadConfig(ctx.Config)
+	schemaType := srclient.SchemaType(cmd.SchemaType)
+	schema, err := CreateSchema(cmd.Subject, cmd.SchemaFile, cmd.Compatibility, schemaType)
+	if err != nil {
+		return err
+	}
+	compatibility := cmd.Compatibility
+	var previous []schemaVersion
+	switch {
+	case cmd.FromCache:
+		cache, err := NewSchemaRegistryCache(&config)
+		if err != nil {
+			return err
+		}
+		cache.ReadSchemaTopic("_schemas")
+		previous = cache.GetSchemaVersions(cmd.Subject)
+		if compatibility == "" {
+			compatibility = cache.GetCompatibilityForSubject(cmd.Subject)
+		}
+		if compatibility == "" {
+			compatibility = cache.GetGlobalCompatibility()
+		}
+	case cmd.FromDir != "":
+		previous, err = readSchemaVersionsFromDir(cmd.FromDir, cmd.Subject, schemaType)
+		if err != nil {
+			return err
+		}
+	case cmd.GitRev != "":
+		previous, err = readSchemaVersionFromGit(cmd.GitRev, normalizeSchemaPath(cmd.SchemaFile), cmd.Subject, schemaType)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("one of --from-cache, --from-dir or --git-rev is required")
+	}
+	if compatibility == "" {
+		compatibility = "BACKWARD"
+	}
+	if len(previous) == 0 {
+		fmt.Printf("No previous versions of %s found. Nothing to check\n", cmd.Subject)
+		return nil
+	}
+	isCompatible, messages, err := checkSchemaCompatibility(schema, previous, compatibility)
+	if err != nil {
+		return err
+	}
+	if isCompatible {
+		fmt.Printf("Schema is compatible with %d previous version(s) of %s (%s)\n", len(previous), cmd.Subject, strings.ToUpper(compatibility))
+		return nil
+	}
+	fmt.Printf("Schema is NOT compatible with %s (%s):\n", cmd.Subject, strings.ToUpper(compatibility))
+	for _, message := range messages {
+		fmt.Printf("  - %s\n", message)
+	}
+	return fmt.Errorf("schema %s is not compatible", cmd.SchemaFile)
+}
+
+// Export subjects, versions and compatibility settings to a directory
+func (cmd *SchemaExportCmd) Run(ctx *CLIContext) error {
+	config := LoadConfig(ctx.Config)
+	var export *schemaExport
+	if cmd.FromCache {
+		cache, err := NewSchemaRegistryCache(&config)
+		if err != nil {
+			return err
+		}
+		cache.ReadSchemaTopic("_schemas")
+		export = cache.ReadSchemaExport(cmd.Latest)
+	} else {
+		sradmin := NewSRAdmin(&config)
+		var err error
+		export, err = sradmin.ReadSchemaExport(cmd.Latest)
+		if err != nil {
+			return err
+		}
+	}
+	index, err := writeSchemaExport(cmd.Dir, export)
+	if err != nil {
+		return err
+	}
+	var versions int
+	for _, subject := range index.Subjects {
+		versions += len(subject.Versions)
+	}
+	fmt.Printf("Exported %d subjects (%d versions) to %s\n", len(index.Subjects), versions, cmd.Dir)
+	return nil
+}
+
+// Import an export into an empty registry, keeping IDs and versions
+func (cmd *SchemaImportCmd) Run(ctx *CLIContext) error {
+	config := LoadConfig(ctx.Config)
+	export, err := readSchemaExport(cmd.Dir)
+	if err != nil {
+		return err
+	}
+	sradmin := NewSRAdmin(&config)
+	imported, err := sradmin.ImportSchemas(export)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d subjects (%d versions) from %s\n", len(export.Subjects()), imported, cmd.Dir)
+	return nil
+}
+
+// List the versions of a subject in registration order, or the field changes between two of them
+func (cmd *SchemaHistoryCmd) Run(ctx *CLIContext) error {
+	config := LoadConfig(ctx.Config)
+	cache, err := NewSchemaRegistryCache(&config)
+	if err != nil {
+		return err
+	}
+	cache.ReadSchemaTopic("_schemas")
+	entries := cache.GetSchemaHistory(cmd.Subject)
+	if len(entries) == 0 {
+		return fmt.Errorf("subject %s not found", cmd.Subject)
+	}
+	if cmd.From != 0 || cmd.To != 0 {
+		return cmd.renderDiff(cache, entries)
+	}
+	if cmd.Changes {
+		for i := 1; i < len(entries); i++ {
+			changes, fallback, err := diffSubjectVersions(cache, cmd.Subject, entries[i-1].Version, entries[i].Version)
+			if err != nil {
+				return err
+			}
+			entries[i].Changes = changes
+			entries[i].Diff = fallback
+		}
+	}
+	if cmd.Output == "json" {
+		return printJSON(entries)
+	}
+	tb := table.NewWriter()
+	tb.SetStyle(table.StyleLight)
+	tb.SetOutputMirror(os.Stdout)
+	header := table.Row{"Order", "Version", "ID", "Type", "Compatibility", "Deleted"}
+	if cmd.Changes {
+		header = append(header, "Changes")
+	}
+	tb.AppendHeader(header)
+	for _, entry := range entries {
+		row := table.Row{entry.Order, entry.Version, entry.ID, entry.SchemaType, entry.Compatibility, entry.Deleted}
+		if cmd.Changes {
+			var lines []string
+			for _, change := range entry.Changes {
+				lines = append(lines, change.String())
+			}
+			if entry.Diff != "" {
+				lines = append(lines, entry.Diff)
+			}
+			row = append(row, strings.Join(lines, "\n"))
+		}
+		tb.AppendRow(row)
+	}
+	tb.Render()
+	return nil
+}
+
+// Show the field changes between --from and --to
+func (cmd *SchemaHistoryCmd) renderDiff(cache *SchemaRegistryCache, entries []schemaHistoryEntry) error {
+	to := cmd.To
+	if to == 0 {
+		to = entries[len(entries)-1].Version
+	}
+	from := cmd.From
+	if from == 0 {
+		for i, entry := range entries {
+			if entry.Version == to && i > 0 {
+				from = entries[i-1].Version
+			}
+		}
+		if from == 0 {
+			return fmt.Errorf("version %d of %s has no previous version to compare with", to, cmd.Subject)
+		}
+	}
+	changes, fallback, err := diffSubjectVersions(cache, cmd.Subject, from, to)
+	if err != nil {
+		return err
+	}
+	if cmd.Output == "json" {
+		type diffOutput struct {
+			Subject string              `json:"subject"`
+			From    int                 `json:"from"`
+			To      int                 `json:"to"`
+			Changes []schemaFieldChange `json:"changes"`
+			Diff    string              `json:"diff,omitempty"` // Text diff, for schema types without field level diffs
+		}
+		return printJSON(diffOutput{Subject: cmd.Subject, From: from, To: to, Changes: changes, Diff: fallback})
+	}
+	fmt.Printf("Changes of %s from version %d to %d:\n", cmd.Subject, from, to)
+	if fallback != "" {
+		fmt.Println(fallback)
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("  No field changes")
+	}
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	return nil
+}
+
+// Field changes between two versions of a subject. Schema types without field level diffs get a text diff instead
+func diffSubjectVersions(cache *SchemaRegistryCache, subject string, from, to int) ([]schemaFieldChange, string, error) {
+	oldSchema, _, err := cache.getSchemaForHistory(subject, from)
+	if err != nil {
+		return nil, "", err
+	}
+	newSchema, _, err := cache.getSchemaForHistory(subject, to)
+	if err != nil {
+		return nil, "", err
+	}
+	if oldSchema.SchemaType != newSchema.SchemaType {
+		return []schemaFieldChange{{Change: fieldTypeChanged, Field: "(schema)", Old: string(oldSchema.SchemaType), New: string(newSchema.SchemaType)}}, "", nil
+	}
+	if newSchema.SchemaType == srclient.Json {
+		return nil, textDiff(oldSchema.SchemaData, newSchema.SchemaData), nil
+	}
+	// Protobuf schemas only compile with the schemas they import
+	oldImports, newImports := make(map[string]string), make(map[string]string)
+	if newSchema.SchemaType == srclient.Protobuf {
+		if err := resolveSchemaReferences(cache, oldSchema.References, oldImports); err != nil {
+			return nil, "", err
+		}
+		if err := resolveSchemaReferences(cache, newSchema.References, newImports); err != nil {
+			return nil, "", err
+		}
+	}
+	changes, err := diffSchemaFields(newSchema.SchemaType, oldSchema.SchemaData, newSchema.SchemaData, oldImports, newImports)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to diff versions %d and %d of %s: %w", from, to, subject, err)
+	}
+	return changes, "", nil
+}
+
+func printJSON(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
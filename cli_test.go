@@ -3,32 +3,52 @@ package main
 import (
 	"reflect"
 	"testing"
+
+	"github.com/IBM/sarama"
 )
 
 func TestParseOffsetsArg(t *testing.T) {
-	var expected map[int32]int64
+	var expected map[int32]offsetSpec
 	var param string
-	expected = make(map[int32]int64)
+	expected = make(map[int32]offsetSpec)
 	param = "TOPIC=0:4,1:300,2:5124"
-	res, err := parseOffsetsArg(&param)
+	topic, res, err := parseOffsetsArg(&param)
+	if err != nil {
+		t.Error(err)
+	}
+	if topic != "TOPIC" {
+		t.Errorf("topic %s not equal to TOPIC", topic)
+	}
+	expected[0], expected[1], expected[2] = offsetSpec{Offset: 4}, offsetSpec{Offset: 300}, offsetSpec{Offset: 5124}
+	if !reflect.DeepEqual(&res, &expected) {
+		t.Errorf("%+vs not equal to %+vs", res, expected)
+	}
+	// symbolic offsets
+	param = "TOPIC=0:earliest,1:latest,2:-100"
+	_, res, err = parseOffsetsArg(&param)
 	if err != nil {
 		t.Error(err)
 	}
-	expected[0], expected[1], expected[2] = 4, 300, 5124
+	expected[0], expected[1], expected[2] = offsetSpec{Offset: sarama.OffsetOldest}, offsetSpec{Offset: sarama.OffsetNewest}, offsetSpec{FromEnd: 100}
 	if !reflect.DeepEqual(&res, &expected) {
 		t.Errorf("%+vs not equal to %+vs", res, expected)
 	}
 	// now break it
 	param = "TOPIC0:4,1:300,2:5124"
-	res, err = parseOffsetsArg(&param)
+	_, _, err = parseOffsetsArg(&param)
 	if err == nil {
 		t.Error("Should get error about wrong structure")
 	}
 	param = "TOPIC=0:4,,1:300,2:5124"
-	res, err = parseOffsetsArg(&param)
+	_, _, err = parseOffsetsArg(&param)
 	if err == nil {
 		t.Error("Should get error about wrong structure")
 	}
+	param = "TOPIC=0:oldest"
+	_, _, err = parseOffsetsArg(&param)
+	if err == nil {
+		t.Error("Should get error about unknown offset")
+	}
 }
 
 // test LoadConfig()
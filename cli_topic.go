@@ -19,10 +19,12 @@ import (
 var topicDescribeTmplData string
 
 type TopicCmd struct {
-	Describe   DescribeTopicCmd   `cmd help:"Describe topic"`
-	List       ListTopicsCmd      `cmd help:"List topics"`
-	Create     CreateTopicCmd     `cmd help:"Create topic"`
-	Partitions PartitionsTopicCmd `cmd help:"Change partition count and replication factor"`
+	Describe    DescribeTopicCmd    `cmd help:"Describe topic"`
+	List        ListTopicsCmd       `cmd help:"List topics"`
+	Create      CreateTopicCmd      `cmd help:"Create topic"`
+	Partitions  PartitionsTopicCmd  `cmd help:"Change partition count and replication factor"`
+	Materialize MaterializeTopicCmd `cmd help:"Print the latest value of every key of a compacted topic"`
+	Stats       StatsTopicCmd       `cmd help:"Report partition, key, size, header and schema statistics of a topic"`
 }
 
 type DescribeTopicCmd struct {
@@ -295,3 +297,95 @@ func (cmd *PartitionsTopicCmd) Run(ctx *CLIContext) error {
 
 	return nil
 }
+
+type MaterializeTopicCmd struct {
+	Name             string   `arg required help:"Topic name"`
+	DeserializeKey   bool     `default:"false" help:"Deserialize message key"`
+	DeserializeValue bool     `default:"false" help:"Deserialize message value"`
+	KeyPattern       string   `help:"Only keep keys matching this regular expression. Matched against the deserialized key"`
+	OutputFormat     string   `default:"table" help:"Output format: table, json, csv"`
+	CSVColumns       []string `name:"csv-columns" help:"Columns of the csv output format, as consumer --filter fields. Defaults to topic,partition,offset,timestamp,key,value"`
+	DiskStore        bool     `default:"false" help:"Keep values on disk instead of in memory, for topics larger than memory"`
+	StoreDir         string   `help:"Directory of the disk store. Defaults to the system temporary directory"`
+}
+
+func (cmd *MaterializeTopicCmd) Run(ctx *CLIContext) error {
+	if !containsString(materializeFormats, cmd.OutputFormat) {
+		return fmt.Errorf("unknown output format: %s (use table, json, or csv)", cmd.OutputFormat)
+	}
+	var keyPattern *regexp.Regexp
+	if cmd.KeyPattern != "" {
+		var err error
+		keyPattern, err = regexp.Compile(cmd.KeyPattern)
+		if err != nil {
+			return fmt.Errorf("invalid key pattern: %w", err)
+		}
+	}
+	var store materializeStore = newMemoryStore()
+	if cmd.DiskStore {
+		var err error
+		store, err = newDiskStore(cmd.StoreDir)
+		if err != nil {
+			return fmt.Errorf("failed to create disk store: %w", err)
+		}
+	}
+	materializer := NewMaterializer(store, keyPattern)
+	defer materializer.Release()
+
+	config := LoadConfig(ctx.Config)
+	// Read every partition from the beginning to the end it has now, without a consumer group
+	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, []string{cmd.Name}, "", true, nil, false, cmd.DeserializeKey, cmd.DeserializeValue, true, "", nil)
+	if err := consumer.SetExitAtEnd(); err != nil {
+		return err
+	}
+	consumer.SetSink(materializer)
+	if err := consumer.Consume(0); err != nil {
+		return err
+	}
+	if err := materializer.Render(os.Stdout, cmd.OutputFormat, cmd.CSVColumns); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Materialized %s\n", materializer.Summary())
+	return nil
+}
+
+type StatsTopicCmd struct {
+	Name         string `arg required help:"Topic name"`
+	Sample       int64  `default:"0" help:"Only read the last N records of each partition. 0 reads the whole topic"`
+	Deserialize  bool   `default:"false" help:"Deserialize keys and values with their schema to count the ones that fail"`
+	OutputFormat string `default:"text" help:"Output format: text, json"`
+}
+
+func (cmd *StatsTopicCmd) Run(ctx *CLIContext) error {
+	if cmd.OutputFormat != "text" && cmd.OutputFormat != "json" {
+		return fmt.Errorf("unknown output format: %s (use text or json)", cmd.OutputFormat)
+	}
+	if cmd.Sample < 0 {
+		return fmt.Errorf("sample must be a positive number of records, got %d", cmd.Sample)
+	}
+	config := LoadConfig(ctx.Config)
+	// Records are counted raw, so the consumer doesn't deserialize them
+	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, []string{cmd.Name}, "", true, nil, false, false, false, true, "", nil)
+	var err error
+	if cmd.Sample > 0 {
+		err = consumer.SetLastRecords(cmd.Sample)
+	} else {
+		err = consumer.SetExitAtEnd()
+	}
+	if err != nil {
+		return err
+	}
+	stats := NewTopicStats(cmd.Name)
+	if cmd.Deserialize {
+		stats.SetDeserializer(consumer.DeserializePayload)
+	}
+	consumer.SetSink(stats)
+	if err := consumer.Consume(0); err != nil {
+		return err
+	}
+	stats.Finish()
+	if config.Connections.Schemaregistry.Url != "" {
+		stats.ResolveSubjects(consumer.SRClient)
+	}
+	return stats.Render(os.Stdout, cmd.OutputFormat)
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -57,6 +59,13 @@ type MDSAdmin struct {
 	KSQLClusterID           string
 	RolebindingsCache       map[string]MDSRolebindings // [principal]rolbindings
 	TlsConfig               *tls.Config
+	Topics                  map[string]Topic // Declared topics. Used to resolve subject names when the topic uses a record name strategy
+}
+
+// A subject resource pattern to bind roles on
+type subjectPattern struct {
+	Name      string
+	IsLiteral bool
 }
 
 const (
@@ -280,16 +289,64 @@ func (admin *MDSAdmin) getRoleBindingsForPrincipal(principal string) MDSRolebind
 	return allRoles
 }
 
+/*
+Work out the subjects a role on a topic should also grant, following the subject name strategy of the topic.
+TopicNameStrategy keeps the <topic>-key/<topic>-value (or prefix) behaviour.
+With the record name strategies the subjects of the declared topic are used. For RecordNameStrategy and a
+prefixed topic the subjects of all declared topics matching the prefix are granted literally, since record names
+don't share the topic prefix.
+*/
+func (admin *MDSAdmin) subjectsForTopic(topic string, isLiteral bool) []subjectPattern {
+	var res []subjectPattern
+	strategy := defaultSubjectStrategy()
+	// Subjects of a topic in a registry context are granted by their qualified name, like :.ctx:topic-value
+	var context string
+	declared, isDeclared := admin.Topics[topic]
+	if isDeclared {
+		strategy = declared.SubjectStrategy
+		context = declared.SchemaContext
+	}
+	if strategy == TopicNameStrategy || (strategy == TopicRecordNameStrategy && !isLiteral) {
+		if isLiteral {
+			return []subjectPattern{{Name: qualifySubject(context, fmt.Sprintf("%s-value", topic)), IsLiteral: true}, {Name: qualifySubject(context, fmt.Sprintf("%s-key", topic)), IsLiteral: true}}
+		}
+		return []subjectPattern{{Name: qualifySubject(context, topic), IsLiteral: false}}
+	}
+	if isLiteral {
+		if !isDeclared {
+			if strategy == TopicRecordNameStrategy {
+				return []subjectPattern{{Name: fmt.Sprintf("%s-", topic), IsLiteral: false}}
+			}
+			log.Warnf("Topic %s uses %s but is not declared. Can't resolve its subjects for rolebindings", topic, strategy)
+			return res
+		}
+		for _, schema := range declared.Schemas() {
+			res = append(res, subjectPattern{Name: schema.SubjectName, IsLiteral: true})
+		}
+		return res
+	}
+	// RecordNameStrategy with a prefixed topic
+	seen := make(map[string]bool)
+	for name, candidate := range admin.Topics {
+		if !strings.HasPrefix(name, topic) || candidate.SubjectStrategy != RecordNameStrategy {
+			continue
+		}
+		for _, schema := range candidate.Schemas() {
+			if !seen[schema.SubjectName] {
+				seen[schema.SubjectName] = true
+				res = append(res, subjectPattern{Name: schema.SubjectName, IsLiteral: true})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
 func (admin *MDSAdmin) doConsumerFor(topic string, principal string, isLiteral bool, dryRun bool) ([]ClientResult, error) {
 	var res []ClientResult
 	var err error
-	var subjects []string
 	existingRoles := admin.getRoleBindingsForPrincipal(principal)
-	if isLiteral {
-		subjects = []string{fmt.Sprintf("%s-value", topic), fmt.Sprintf("%s-key", topic)}
-	} else {
-		subjects = []string{topic}
-	}
+	subjects := admin.subjectsForTopic(topic, isLiteral)
 	newRole := ClientResult{Principal: principal, ResourceType: "Topic", ResourceName: topic, Role: "DeveloperRead", PatternType: getPrefixStr(isLiteral)}
 	if !dryRun && !admin.roleExists(newRole, existingRoles) {
 		err = admin.SetRoleBinding(CTX_KAFKA, "Topic", topic, principal, []string{"DeveloperRead"}, isLiteral, dryRun)
@@ -302,9 +359,9 @@ func (admin *MDSAdmin) doConsumerFor(topic string, principal string, isLiteral b
 	}
 	// Set DeveloperRead on subject value
 	for _, subject := range subjects {
-		newRole = ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject, Role: "DeveloperRead", PatternType: getPrefixStr(isLiteral)}
+		newRole = ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject.Name, Role: "DeveloperRead", PatternType: getPrefixStr(subject.IsLiteral)}
 		if !dryRun && !admin.roleExists(newRole, existingRoles) {
-			err = admin.SetRoleBinding(CTX_SR, "Subject", subject, principal, []string{"DeveloperRead"}, isLiteral, dryRun)
+			err = admin.SetRoleBinding(CTX_SR, "Subject", subject.Name, principal, []string{"DeveloperRead"}, subject.IsLiteral, dryRun)
 			if err != nil {
 				return res, err
 			}
@@ -321,13 +378,8 @@ func (admin *MDSAdmin) doProducerFor(topic string, principal string, isLiteral,
 	var err error
 	// Default role for SR is Read but if strict=false then add Write
 	srRoles := []string{"DeveloperRead"}
-	var subjects []string
 	existingRoles := admin.getRoleBindingsForPrincipal(principal)
-	if isLiteral {
-		subjects = []string{fmt.Sprintf("%s-value", topic), fmt.Sprintf("%s-key", topic)}
-	} else {
-		subjects = []string{topic}
-	}
+	subjects := admin.subjectsForTopic(topic, isLiteral)
 	if !strict {
 		srRoles = append(srRoles, "DeveloperWrite")
 	}
@@ -364,14 +416,14 @@ func (admin *MDSAdmin) doProducerFor(topic string, principal string, isLiteral,
 	for _, subject := range subjects {
 		// Prepare plan/result
 		for _, sRole := range srRoles {
-			newRole := ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject, Role: sRole, PatternType: getPrefixStr(isLiteral)}
+			newRole := ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject.Name, Role: sRole, PatternType: getPrefixStr(subject.IsLiteral)}
 			if !admin.roleExists(newRole, existingRoles) {
 				res = append(res, newRole)
 			}
 		}
 		// Actually change it, if needed
 		if !dryRun && !admin.roleExists(newRole, existingRoles) {
-			err = admin.SetRoleBinding(CTX_SR, "Subject", subject, principal, srRoles, isLiteral, dryRun)
+			err = admin.SetRoleBinding(CTX_SR, "Subject", subject.Name, principal, srRoles, subject.IsLiteral, dryRun)
 			if err != nil {
 				return res, err
 			}
@@ -385,13 +437,8 @@ func (admin *MDSAdmin) doResourceOwnerFor(topic string, principal string, isLite
 	var res []ClientResult
 	var err error
 	roles := []string{"ResourceOwner"}
-	var subjects []string
 	existingRoles := admin.getRoleBindingsForPrincipal(principal)
-	if isLiteral {
-		subjects = []string{fmt.Sprintf("%s-value", topic), fmt.Sprintf("%s-key", topic)}
-	} else {
-		subjects = []string{topic}
-	}
+	subjects := admin.subjectsForTopic(topic, isLiteral)
 
 	// Add ResourceOwner on the topic
 	newRole := ClientResult{Principal: principal, ResourceType: "Topic", ResourceName: topic, Role: "ResourceOwner", PatternType: getPrefixStr(isLiteral)}
@@ -421,10 +468,10 @@ func (admin *MDSAdmin) doResourceOwnerFor(topic string, principal string, isLite
 	}
 	// Add Schemaregistry rolebindings
 	for _, subject := range subjects {
-		newRole = ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject, Role: "ResourceOwner", PatternType: getPrefixStr(isLiteral)}
+		newRole = ClientResult{Principal: principal, ResourceType: "Subject", ResourceName: subject.Name, Role: "ResourceOwner", PatternType: getPrefixStr(subject.IsLiteral)}
 
 		if !dryRun && !admin.roleExists(newRole, existingRoles) {
-			err = admin.SetRoleBinding(CTX_SR, "Subject", subject, principal, roles, isLiteral, dryRun)
+			err = admin.SetRoleBinding(CTX_SR, "Subject", subject.Name, principal, roles, subject.IsLiteral, dryRun)
 		}
 		if !admin.roleExists(newRole, existingRoles) {
 			res = append(res, newRole)
@@ -497,8 +544,9 @@ func (admin *MDSAdmin) doTransactionalIdRole(transactionalIdName, principal stri
 	return res, err
 }
 
-func (admin *MDSAdmin) Reconcile(clients map[string]Client, dryRun bool) []ClientResult {
+func (admin *MDSAdmin) Reconcile(clients map[string]Client, topics map[string]Topic, dryRun bool) []ClientResult {
 	var clientResults []ClientResult
+	admin.Topics = topics
 	for _, client := range clients {
 		for _, consumerRole := range client.ConsumerFor {
 			clientRes, err := admin.doConsumerFor(consumerRole.Topic, client.Principal, consumerRole.IsLiteral, dryRun)
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func getTestAdmin() *MDSAdmin {
 	mdsAdmin := MDSAdmin{
@@ -76,5 +79,35 @@ func Test_roleExists(t *testing.T) {
 	}
 }
 
+func TestSubjectsForTopic(t *testing.T) {
+	admin := getTestAdmin()
+	admin.Topics = map[string]Topic{
+		"orders":       {Name: "orders", SubjectStrategy: RecordNameStrategy, Value: Schema{SubjectName: "com.example.OrderCreated"}, Values: []Schema{{SubjectName: "com.example.OrderCancelled"}}},
+		"orders.audit": {Name: "orders.audit", SubjectStrategy: RecordNameStrategy, Value: Schema{SubjectName: "com.example.OrderCreated"}},
+		"payments":     {Name: "payments", SubjectStrategy: TopicRecordNameStrategy, Value: Schema{SubjectName: "payments-com.example.Payment"}},
+		"tenant.users": {Name: "tenant.users", SubjectStrategy: TopicNameStrategy, SchemaContext: ".tenant"},
+	}
+	tests := []struct {
+		topic     string
+		isLiteral bool
+		expected  []subjectPattern
+	}{
+		{"users", true, []subjectPattern{{"users-value", true}, {"users-key", true}}},
+		{"users", false, []subjectPattern{{"users", false}}},
+		{"orders", true, []subjectPattern{{"com.example.OrderCreated", true}, {"com.example.OrderCancelled", true}}},
+		{"orders", false, []subjectPattern{{"com.example.OrderCancelled", true}, {"com.example.OrderCreated", true}}},
+		{"payments", true, []subjectPattern{{"payments-com.example.Payment", true}}},
+		{"payments", false, []subjectPattern{{"payments", false}}},
+		{"tenant.users", true, []subjectPattern{{":.tenant:tenant.users-value", true}, {":.tenant:tenant.users-key", true}}},
+		{"tenant.users", false, []subjectPattern{{":.tenant:tenant.users", false}}},
+	}
+	for _, test := range tests {
+		res := admin.subjectsForTopic(test.topic, test.isLiteral)
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("subjectsForTopic(%s, %v) = %v, expected %v", test.topic, test.isLiteral, res, test.expected)
+		}
+	}
+}
+
 func Test_getKafkaClusterID(t *testing.T) {
 }
@@ -97,6 +97,10 @@ type SRConfig struct {
 	SkipRestForReads bool `yaml:"skipRegistryForReads"`
 	// When this is true, Gafkalo will check schema compatibility before registration
 	CheckCompatibility bool `yaml:"checkCompatibility"`
+	// When this is true, schemas are registered and looked up with normalize=true
+	Normalize bool `yaml:"normalize"`
+	// Directory for the snapshot of the _schemas cache. Defaults to the user cache directory
+	CacheDir string `yaml:"cacheDir"`
 }
 type Configuration struct {
 	Connections struct {
@@ -108,7 +112,8 @@ type Configuration struct {
 	} `yaml:"connections"`
 	Kafkalo struct {
 		InputDirs                    []string `yaml:"input_dirs"`
-		SchemaDir                    string   `yaml:"schema_dir"` // Directory to look for schemas when using a relative path
+		SchemaDir                    string   `yaml:"schema_dir"`       // Directory to look for schemas when using a relative path
+		SubjectStrategy              string   `yaml:"subject_strategy"` // Default subject name strategy for topics that don't set one
 		ConnectorsSensitiveKeysRegex string   `yaml:"connectors_sensitive_keys"`
 	} `yaml:"kafkalo"`
 }
@@ -182,6 +187,24 @@ func normalizeSchemaPath(inputFile string) string {
 	return res
 }
 
+/*
+Resolve a schema path declared in an input YAML in directory inputDir.
+Relative paths are looked up next to the YAML first. If the file isn't there, schema_dir (or the working directory) is used,
+so that inputs written for a global schema_dir keep working
+*/
+func resolveSchemaPath(inputDir string, schemaPath string) string {
+	if filepath.IsAbs(schemaPath) {
+		return schemaPath
+	}
+	if inputDir != "" {
+		candidate := filepath.Join(inputDir, schemaPath)
+		if isValidInputFile(candidate) {
+			return candidate
+		}
+	}
+	return normalizeSchemaPath(schemaPath)
+}
+
 // Validates an input filename as valid (exists, is not dir etc)
 func isValidInputFile(filename string) bool {
 	fi, err := os.Stat(filename)
@@ -2,9 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -17,6 +16,7 @@ import (
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/bufbuild/protocompile/linker"
 	"github.com/fatih/color"
 	"github.com/kmetaxas/srclient"
 	log "github.com/sirupsen/logrus"
@@ -34,7 +34,8 @@ func (w *lockedWriter) Write(b []byte) (int, error) {
 	return w.writer.Write(b)
 }
 
-type RecordPrinterFunc func(topic, key, value string, timestamp time.Time, partition int32, offset int64, keySchemaID, valSchemaID int, headers []*sarama.RecordHeader)
+// Prints a consumed record. The key and value of the record are rendered in the consumer's formats
+type RecordPrinterFunc func(record *sinkRecord)
 
 // The main consumer struct
 type Consumer struct {
@@ -42,24 +43,39 @@ type Consumer struct {
 	SRClient             *srclient.SchemaRegistryClient
 	ConsumerGroup        sarama.ConsumerGroup
 	Topics               []string
-	PartitionOffsets     map[int32]int64 // map of partion number, offset to start fro
+	PartitionOffsets     map[string]map[int32]int64 // offsets to start from, by topic and partition
 	UsePartitionOffsets  bool
 	ready                chan bool
-	msgCount             int             // consumed messages count
+	msgCount             int // consumed messages count. Only those matching the filter if there is one
+	scannedCount         int // consumed messages count, matching the filter or not
+	countMutex           sync.Mutex
+	filter               *RecordFilter   // Only records matching it are printed, if set
 	maxRecords           int             //  max records to read
 	ctx                  context.Context // tell the consumer to stop
 	cancel               context.CancelFunc
-	deserializeKey       bool
-	deserializeValue     bool
+	keyFormat            string // How keys are rendered. One of payloadFormats
+	valueFormat          string // How values are rendered. One of payloadFormats
 	customRecordTemplate *template.Template
 	serializingWriter    *lockedWriter
+	sink                 recordWriter // Records are written to it instead of printed, if set
 	// The consumerHandler to use for Setup/ConsumeClaim() etc.
 	// The NewConsumerGroup will default to itself since Consumer implements this interface by default,
 	// But we want users of Consumer to be able to implement their own handlers
 	consumerGroupHandler sarama.ConsumerGroupHandler
 	recordPrinterFunc    RecordPrinterFunc
+	validateJSON         bool                // Validate JSON Schema payloads against their schema
+	protobufFiles        map[int]linker.File // Compiled Protobuf schemas by ID
+	protobufFilesMutex   sync.Mutex
+	assign               bool                                 // Consume partitions directly instead of with a consumer group
+	bounds               map[string]map[int32]partitionBounds // Offsets to consume per partition, if bounded
+	untilTime            time.Time
+	donePartitions       map[string]map[int32]bool // Partitions that reached their end
+	donePartitionsMutex  sync.Mutex
 }
 
+// Printed as the value of tombstones when values are deserialized
+const tombstoneText = "Null (Tombstone?)"
+
 type CustomRecordTemplateContext struct {
 	Topic       string            `json:"topic"`
 	Key         string            `json:"key"`
@@ -70,6 +86,8 @@ type CustomRecordTemplateContext struct {
 	KeySchemaID int               `json:"key_schema_id"`   // The schema registry ID of the Key schema
 	ValSchemaID int               `json:"value_schema_id"` // The Schema registry ID of the Value schema
 	Headers     map[string]string `json:"headers"`
+	KeyBytes    []byte            `json:"-"` // The key as it was consumed, before deserialization
+	ValueBytes  []byte            `json:"-"` // The value as it was consumed, before deserialization. Nil for tombstones
 }
 
 type RecordPrinterContext struct {
@@ -82,6 +100,9 @@ type RecordPrinterContext struct {
 	KeySchemaID int               `json:"key_schema_id"`   // The schema registry ID of the Key schema
 	ValSchemaID int               `json:"value_schema_id"` // The Schema registry ID of the Value schema
 	Headers     map[string]string `json:"headers"`
+	// How key and value are encoded if they are not text, like base64. Empty for text and JSON
+	KeyEncoding   string `json:"key_encoding,omitempty"`
+	ValueEncoding string `json:"value_encoding,omitempty"`
 }
 
 // Naive random string implementation ( https://golangdocs.com/generate-random-string-in-golang )
@@ -102,11 +123,15 @@ func loadTemplate(path string) *template.Template {
 	if err != nil {
 		log.Fatal(err)
 	}
-	tmpl := template.Must(template.New("kafkarecord").Parse(string(tplData)))
+	tmpl := template.Must(template.New("kafkarecord").Funcs(recordTemplateFuncs).Parse(string(tplData)))
 	return tmpl
 }
 
-func NewConsumer(kConf KafkaConfig, srConf *SRConfig, topics []string, groupID string, partitionOffsets map[int32]int64, useOffsets bool, deserializeKey, deserializeValue bool, fromBeginning bool, customTemplateFile string, consumerGroupHandler sarama.ConsumerGroupHandler) *Consumer {
+/*
+Create a consumer. With assign set, all partitions of the topics are consumed directly, without a consumer group,
+so no offsets are committed and groupID is ignored
+*/
+func NewConsumer(kConf KafkaConfig, srConf *SRConfig, topics []string, groupID string, assign bool, partitionOffsets map[string]map[int32]offsetSpec, useOffsets bool, deserializeKey, deserializeValue bool, fromBeginning bool, customTemplateFile string, consumerGroupHandler sarama.ConsumerGroupHandler) *Consumer {
 	var consumer Consumer
 	kafkaConf := SaramaConfigFromKafkaConfig(kConf)
 
@@ -121,6 +146,8 @@ func NewConsumer(kConf KafkaConfig, srConf *SRConfig, topics []string, groupID s
 	if srConf.Username != "" && srConf.Password != "" {
 		consumer.SRClient.SetCredentials(srConf.Username, srConf.Password)
 	}
+	// Codecs can only be created for AVRO schemas. They are created when needed instead
+	consumer.SRClient.CodecCreationEnabled(false)
 	if groupID == "" {
 		randGroupPart := RandomString(5)
 		groupID = fmt.Sprintf("gafkalo-consumer-%s", randGroupPart)
@@ -134,20 +161,34 @@ func NewConsumer(kConf KafkaConfig, srConf *SRConfig, topics []string, groupID s
 	if err != nil {
 		log.Fatal(err)
 	}
-	cGroup, err := sarama.NewConsumerGroupFromClient(groupID, client)
-	if err != nil {
-		log.Fatal(err)
+	if !assign {
+		cGroup, err := sarama.NewConsumerGroupFromClient(groupID, client)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consumer.ConsumerGroup = cGroup
 	}
 
 	consumer.recordPrinterFunc = prettyPrintRecord
 
 	consumer.Client = client
 	consumer.Topics = topics
-	consumer.ConsumerGroup = cGroup
-	consumer.PartitionOffsets = partitionOffsets
+	consumer.assign = assign
+	if useOffsets {
+		consumer.PartitionOffsets, err = resolveOffsetSpecs(client, partitionOffsets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	consumer.UsePartitionOffsets = useOffsets
-	consumer.deserializeKey = deserializeKey
-	consumer.deserializeValue = deserializeValue
+	consumer.keyFormat = payloadFormatString
+	if deserializeKey {
+		consumer.keyFormat = payloadFormatRegistry
+	}
+	consumer.valueFormat = payloadFormatString
+	if deserializeValue {
+		consumer.valueFormat = payloadFormatRegistry
+	}
 	if customTemplateFile != "" {
 		consumer.customRecordTemplate = loadTemplate(customTemplateFile)
 	}
@@ -156,8 +197,7 @@ func NewConsumer(kConf KafkaConfig, srConf *SRConfig, topics []string, groupID s
 	} else {
 		consumer.consumerGroupHandler = &consumer
 	}
-	// Set serializingWriter to stdout
-	// We should allow customization of this to a a file or whatever
+	// Set serializingWriter to stdout. Use a RecordSink to write to files
 	consumer.serializingWriter = &lockedWriter{writer: os.Stdout}
 	return &consumer
 }
@@ -166,6 +206,21 @@ func (c *Consumer) SetRecordPrinterFunc(f RecordPrinterFunc) {
 	c.recordPrinterFunc = f
 }
 
+// Write records to the sink instead of printing them
+func (c *Consumer) SetSink(sink recordWriter) {
+	c.sink = sink
+}
+
+// Only print records matching the filter
+func (c *Consumer) SetFilter(filter *RecordFilter) {
+	c.filter = filter
+}
+
+// Validate JSON Schema payloads against their schema when deserializing
+func (c *Consumer) SetValidateJSON(validate bool) {
+	c.validateJSON = validate
+}
+
 func (c *Consumer) Consume(maxRecords int) error {
 	// wait for ready
 	c.ready = make(chan bool)
@@ -174,22 +229,33 @@ func (c *Consumer) Consume(maxRecords int) error {
 	c.cancel = cancel
 	c.ctx = ctx
 	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			if err := c.ConsumerGroup.Consume(ctx, c.Topics, c.consumerGroupHandler); err != nil {
-				log.Panicf("Error from consumer: %v", err)
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				return
-			}
-			c.ready = make(chan bool)
+	var partitionConsumer sarama.Consumer
+	if c.assign {
+		var err error
+		partitionConsumer, err = c.consumePartitions(ctx, wg)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return err
 		}
-	}()
+	} else {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := c.ConsumerGroup.Consume(ctx, c.Topics, c.consumerGroupHandler); err != nil {
+					log.Panicf("Error from consumer: %v", err)
+				}
+				// check if context was cancelled, signaling that the consumer should stop
+				if ctx.Err() != nil {
+					return
+				}
+				c.ready = make(chan bool)
+			}
+		}()
 
-	<-c.ready // Await till the consumer has been set up
+		<-c.ready // Await till the consumer has been set up
+	}
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
 	select {
@@ -200,6 +266,20 @@ func (c *Consumer) Consume(maxRecords int) error {
 	}
 	cancel()
 	wg.Wait()
+	if partitionConsumer != nil {
+		if err := partitionConsumer.Close(); err != nil {
+			log.Warnf("Error closing consumer: %v", err)
+		}
+	}
+	if c.sink != nil {
+		if err := c.sink.Close(); err != nil {
+			log.Errorf("Error closing output: %v", err)
+		}
+	}
+	if c.filter != nil {
+		// Printed regardless of verbosity, but not to stdout where the records go
+		fmt.Fprintf(os.Stderr, "Scanned %d records, %d matched the filter\n", c.scannedCount, c.msgCount)
+	}
 	if err := c.Client.Close(); err != nil {
 		log.Panicf("Error closing client: %v", err)
 	}
@@ -209,11 +289,13 @@ func (c *Consumer) Consume(maxRecords int) error {
 func (c *Consumer) Setup(session sarama.ConsumerGroupSession) error {
 	close(c.ready)
 	if c.UsePartitionOffsets {
-		for partition, offset := range c.PartitionOffsets {
-			// TODO support multiple topics. For now only onet topic is supported by offset reset
-			session.ResetOffset(c.Topics[0], partition, offset, "Gafkalo CLI offset rest")
+		for topic, partitions := range c.PartitionOffsets {
+			for partition, offset := range partitions {
+				setSessionOffset(session, topic, partition, offset)
+			}
 		}
 	}
+	c.setupBounds(session)
 	return nil
 }
 
@@ -221,68 +303,33 @@ func (c *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-// Deserialize a binary payload (wire format)
-// return the deserialized string, the schema id , error
-func (c *Consumer) DeserializePayload(payload []byte) (string, int, error) {
-	var resp string
-	if len(payload) < 5 {
-		return resp, 0, errors.New("payload <5 bytes. Not schema registry wire format")
-	}
-	schemaID := binary.BigEndian.Uint32(payload[1:5])
-	// No need to cache the schema ourselves , as the srclient will do caching internally
-	schema, err := c.SRClient.GetSchema(int(schemaID))
-	if err != nil {
-		return resp, 0, fmt.Errorf("failed to deserialize schema ID [%d] with error: %s", schemaID, err)
-	}
-	native, _, _ := schema.Codec().NativeFromBinary(payload[5:])
-	deserialized, _ := schema.Codec().TextualFromNative(nil, native)
-	resp = string(deserialized)
-	return resp, int(schemaID), nil
-}
-
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
 func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if c.startsPastEnd(claim.Topic(), claim.Partition(), claim.InitialOffset()) {
+		c.markPartitionDone(claim.Topic(), claim.Partition())
+	}
+	idle := time.NewTicker(endCheckInterval)
+	defer idle.Stop()
+	received := false
 	for {
 		select {
 		case message := <-claim.Messages():
-			var key, val string
-			var err error
-			var keySchemaID, valSchemaID int
-			if c.deserializeKey {
-				key, keySchemaID, err = c.DeserializePayload(message.Key)
-				if err != nil {
-					log.Fatal(err)
-				}
-			} else {
-				key = string(message.Key)
+			if message == nil {
+				return nil
 			}
-			if c.deserializeValue {
-				if message.Value == nil {
-					val = "Null (Tombstone?)"
-				} else {
-
-					val, valSchemaID, err = c.DeserializePayload(message.Value)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-			} else {
-				val = string(message.Value)
-			}
-			// Print the record. Either with a user provided template or our own "prettyprint" function
-			if c.customRecordTemplate != nil {
-				c.printRecordWithCustomTemplate(message.Topic, key, val, message.Timestamp, message.Partition, message.Offset, keySchemaID, valSchemaID, message.Headers)
-			} else {
-				c.recordPrinterFunc(message.Topic, key, val, message.Timestamp, message.Partition, message.Offset, keySchemaID, valSchemaID, message.Headers)
+			received = true
+			if c.pastEnd(message) {
+				c.markPartitionDone(message.Topic, message.Partition)
+				continue
 			}
+			printed := c.processMessage(message)
 			session.MarkMessage(message, "")
-			// Do we need to call Commit()?
-			c.msgCount += 1
-			if c.maxRecords == c.msgCount {
-				fmt.Printf("Reached user defined message limit of %d. Stoppping.\n", c.maxRecords)
-				c.cancel()
-				break
+			c.countMessage(message, printed)
+		case <-idle.C:
+			if !received && c.idleAtEnd(claim.Topic(), claim.Partition(), claim.HighWaterMarkOffset()) {
+				c.markPartitionDone(claim.Topic(), claim.Partition())
 			}
+			received = false
 		case <-session.Context().Done():
 			return nil
 
@@ -290,75 +337,165 @@ func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 	}
 }
 
-func (c *Consumer) printRecordWithCustomTemplate(topic, key, value string, timestamp time.Time, partition int32, offset int64, keySchemaID, valSchemaID int, headers []*sarama.RecordHeader) {
+// Deserialize and print a message. Returns false if it doesn't match the filter and was not printed
+func (c *Consumer) processMessage(message *sarama.ConsumerMessage) bool {
+	var val string
+	var valSchemaID int
+	key, keySchemaID := c.renderPayload(message, message.Key, c.keyFormat, "key")
+	if message.Value == nil && c.valueFormat == payloadFormatRegistry {
+		val = tombstoneText
+	} else {
+		val, valSchemaID = c.renderPayload(message, message.Value, c.valueFormat, "value")
+	}
+	if c.filter != nil {
+		matched, err := c.filter.Match(message, key, val)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !matched {
+			return false
+		}
+	}
+	// Print the record. Either to the sink, with a user provided template or our own "prettyprint" function
+	record := sinkRecord{Message: message, Key: key, Value: val, KeySchemaID: keySchemaID, ValSchemaID: valSchemaID}
+	if c.sink != nil {
+		if err := c.sink.Write(&record); err != nil {
+			log.Fatal(err)
+		}
+	} else if c.customRecordTemplate != nil {
+		c.printRecordWithCustomTemplate(message, key, val, keySchemaID, valSchemaID)
+	} else {
+		c.recordPrinterFunc(&record)
+	}
+	return true
+}
+
+// Render the key or value of a message. Payloads the format fails on are rendered as strings, so one record doesn't stop the consumer
+func (c *Consumer) renderPayload(message *sarama.ConsumerMessage, payload []byte, format, what string) (string, int) {
+	rendered, schemaID, err := c.formatPayload(payload, format)
+	if err != nil {
+		log.Warnf("Failed to render the %s of %s/%d offset %d as %s. Printing it as a string: %s", what, message.Topic, message.Partition, message.Offset, format, err)
+		return safePayloadString(payload), 0
+	}
+	return rendered, schemaID
+}
+
+// Count a consumed message. Stops the consumer at the end of all partitions or at the user defined limit of printed messages
+func (c *Consumer) countMessage(message *sarama.ConsumerMessage, printed bool) {
+	if c.lastBeforeEnd(message) {
+		c.markPartitionDone(message.Topic, message.Partition)
+	}
+	c.countMutex.Lock()
+	defer c.countMutex.Unlock()
+	c.scannedCount += 1
+	if !printed {
+		return
+	}
+	c.msgCount += 1
+	if c.maxRecords == c.msgCount {
+		fmt.Printf("Reached user defined message limit of %d. Stoppping.\n", c.maxRecords)
+		c.cancel()
+	}
+}
+
+func (c *Consumer) printRecordWithCustomTemplate(message *sarama.ConsumerMessage, key, value string, keySchemaID, valSchemaID int) {
 	headersMap := make(map[string]string)
-	for _, header := range headers {
-		headersMap[string(header.Key)] = string(header.Value)
+	for _, header := range message.Headers {
+		headersMap[safePayloadString(header.Key)] = safePayloadString(header.Value)
 	}
-	context := CustomRecordTemplateContext{Topic: topic, Key: key, Value: value, Timestamp: timestamp, Partition: partition, Offset: offset, KeySchemaID: keySchemaID, ValSchemaID: valSchemaID, Headers: headersMap}
+	context := CustomRecordTemplateContext{Topic: message.Topic, Key: key, Value: value, Timestamp: message.Timestamp, Partition: message.Partition, Offset: message.Offset,
+		KeySchemaID: keySchemaID, ValSchemaID: valSchemaID, Headers: headersMap, KeyBytes: message.Key, ValueBytes: message.Value}
 	err := c.customRecordTemplate.Execute(c.serializingWriter, context)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func prettyPrintRecord(topic, key, value string, timestamp time.Time, partition int32, offset int64, keySchemaID, valSchemaID int, headers []*sarama.RecordHeader) {
+func prettyPrintRecord(record *sinkRecord) {
 	fmtOffset := color.New(color.FgCyan).SprintFunc()
 	fmtValue := color.New(color.FgGreen).SprintFunc()
 	fmtKey := color.New(color.FgBlue).SprintFunc()
 	fmtHeaders := color.New(color.FgYellow).SprintFunc()
+	message := record.Message
 	var msg string
-	if len(headers) > 0 {
+	if len(message.Headers) > 0 {
 		var headerPairs []string
-		for _, header := range headers {
-			headerPairs = append(headerPairs, fmt.Sprintf("%s:%s", string(header.Key), string(header.Value)))
+		for _, header := range message.Headers {
+			headerPairs = append(headerPairs, fmt.Sprintf("%s:%s", safePayloadString(header.Key), safePayloadString(header.Value)))
 		}
 		msg = fmt.Sprintf("Headers[%s] ", fmtHeaders(fmt.Sprintf("%v", headerPairs)))
 	}
-	if keySchemaID > 0 {
-		msg = fmt.Sprintf("%s SchemaID(key)[%d] ", msg, keySchemaID)
+	if record.KeySchemaID > 0 {
+		msg = fmt.Sprintf("%s SchemaID(key)[%d] ", msg, record.KeySchemaID)
+	}
+	if record.ValSchemaID > 0 {
+		msg = fmt.Sprintf("%s SchemaID(value)[%d] ", msg, record.ValSchemaID)
+	}
+	keyLabel, valueLabel := "Key", "Value"
+	if isEscapedPayload(record.Key, message.Key, record.KeySchemaID) {
+		keyLabel = "Key(escaped)"
 	}
-	if valSchemaID > 0 {
-		msg = fmt.Sprintf("%s SchemaID(value)[%d] ", msg, valSchemaID)
+	if isEscapedPayload(record.Value, message.Value, record.ValSchemaID) {
+		valueLabel = "Value(escaped)"
 	}
-	msg = fmt.Sprintf("%s Topic[%s] Offset[%s] Partition[%d] Timestamp[%s]: Key:=%s, Value:=%s", msg, topic, fmtOffset(fmt.Sprint(offset)), partition, timestamp, fmtKey(key), fmtValue(value))
+	msg = fmt.Sprintf("%s Topic[%s] Offset[%s] Partition[%d] Timestamp[%s]: %s:=%s, %s:=%s", msg, message.Topic, fmtOffset(fmt.Sprint(message.Offset)), message.Partition, message.Timestamp, keyLabel, fmtKey(record.Key), valueLabel, fmtValue(record.Value))
 	fmt.Println(msg)
 }
 
-func jsonPrintRecord(topic, key, value string, timestamp time.Time, partition int32, offset int64, keySchemaID, valSchemaID int, headers []*sarama.RecordHeader) {
-	var valI interface{}
-	var keyI interface{}
+func jsonPrintRecord(record *sinkRecord) {
+	jsondata, err := jsonRecord(record)
+	if err != nil {
+		fmt.Printf("Error marshaling %s/%d offset %d to json\n", record.Message.Topic, record.Message.Partition, record.Message.Offset)
+	} else {
+		fmt.Println(string(jsondata))
+	}
+}
+
+// The encoding of keys and values in the json output that are not text. The producer decodes them
+const payloadEncodingBase64 = "base64"
 
+/*
+A record as a JSON object. Keys and values that are JSON objects, arrays, numbers or booleans are embedded as JSON, numbers
+with all their digits. Other text, JSON strings included, is a string. Null keys and values are null.
+Payloads that are not text and were escaped to print them are base64 with an encoding, so they can be replayed as they were
+*/
+func jsonRecord(record *sinkRecord) ([]byte, error) {
+	message := record.Message
 	headersMap := make(map[string]string)
-	for _, header := range headers {
-		headersMap[string(header.Key)] = string(header.Value)
+	for _, header := range message.Headers {
+		headersMap[safePayloadString(header.Key)] = safePayloadString(header.Value)
 	}
 
 	recordCtx := RecordPrinterContext{
-		Topic:       topic,
-		Key:         key,
-		Value:       value,
-		Timestamp:   timestamp,
-		Partition:   partition,
-		Offset:      offset,
-		KeySchemaID: keySchemaID,
-		ValSchemaID: valSchemaID,
+		Topic:       message.Topic,
+		Timestamp:   message.Timestamp,
+		Partition:   message.Partition,
+		Offset:      message.Offset,
+		KeySchemaID: record.KeySchemaID,
+		ValSchemaID: record.ValSchemaID,
 		Headers:     headersMap,
 	}
-	// Try to deserialize key + value in order to provide nicer output. If it fails just use as is
-	err := json.Unmarshal([]byte(value), &valI)
-	if err == nil {
-		recordCtx.Value = valI
+	recordCtx.Key, recordCtx.KeyEncoding = jsonPayload(record.Key, message.Key, record.KeySchemaID)
+	recordCtx.Value, recordCtx.ValueEncoding = jsonPayload(record.Value, message.Value, record.ValSchemaID)
+	return json.Marshal(&recordCtx)
+}
+
+// A rendered key or value for the json output, and its encoding if it is not the rendered text
+func jsonPayload(rendered string, payload []byte, schemaID int) (interface{}, string) {
+	if payload == nil && rendered == "" {
+		return nil, ""
 	}
-	err = json.Unmarshal([]byte(key), &keyI)
-	if err == nil {
-		recordCtx.Key = keyI
+	if isEscapedPayload(rendered, payload, schemaID) {
+		return base64.StdEncoding.EncodeToString(payload), payloadEncodingBase64
 	}
-
-	jsondata, err := json.Marshal(&recordCtx)
+	parsed, err := decodeJSONNumbers(rendered)
 	if err != nil {
-		fmt.Printf("Error marshaling %+v to json\n", recordCtx)
-	} else {
-		fmt.Println(string(jsondata))
+		return rendered, ""
+	}
+	switch parsed.(type) {
+	case nil, string:
+		// Quoted text and "null" stay strings, so the producer sends them as they are
+		return rendered, ""
 	}
+	return json.RawMessage(rendered), ""
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Assign mode: every partition of the topics is consumed directly with a partition consumer.
+There is no consumer group, so nothing is committed and other consumers are not affected.
+*/
+
+// Offset to start consuming a partition from in assign mode
+func (c *Consumer) startOffset(topic string, partition int32) int64 {
+	if bounds, exists := c.bounds[topic][partition]; exists && bounds.Start >= 0 {
+		return bounds.Start
+	}
+	if c.UsePartitionOffsets {
+		if offset, exists := c.PartitionOffsets[topic][partition]; exists {
+			return offset
+		}
+	}
+	return c.Client.Config().Consumer.Offsets.Initial
+}
+
+// Start consuming all partitions of the topics, one goroutine per partition added to wg. The returned consumer must be closed once they are done
+func (c *Consumer) consumePartitions(ctx context.Context, wg *sync.WaitGroup) (sarama.Consumer, error) {
+	consumer, err := sarama.NewConsumerFromClient(c.Client)
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range c.Topics {
+		partitions, err := c.Client.Partitions(topic)
+		if err != nil {
+			return consumer, fmt.Errorf("failed to get partitions of %s: %w", topic, err)
+		}
+		for _, partition := range partitions {
+			offset := c.startOffset(topic, partition)
+			if c.startsPastEnd(topic, partition, offset) {
+				c.markPartitionDone(topic, partition)
+				continue
+			}
+			partitionConsumer, err := consumer.ConsumePartition(topic, partition, offset)
+			if err != nil {
+				return consumer, fmt.Errorf("failed to consume %s/%d from offset %d: %w", topic, partition, offset, err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.consumePartition(ctx, topic, partition, partitionConsumer)
+			}()
+		}
+	}
+	return consumer, nil
+}
+
+func (c *Consumer) consumePartition(ctx context.Context, topic string, partition int32, partitionConsumer sarama.PartitionConsumer) {
+	defer func() {
+		if err := partitionConsumer.Close(); err != nil {
+			log.Warnf("Error closing partition consumer: %v", err)
+		}
+	}()
+	idle := time.NewTicker(endCheckInterval)
+	defer idle.Stop()
+	received := false
+	for {
+		select {
+		case message := <-partitionConsumer.Messages():
+			if message == nil {
+				return
+			}
+			received = true
+			if c.pastEnd(message) {
+				c.markPartitionDone(message.Topic, message.Partition)
+				continue
+			}
+			c.countMessage(message, c.processMessage(message))
+		case <-idle.C:
+			if !received && c.idleAtEnd(topic, partition, partitionConsumer.HighWaterMarkOffset()) {
+				c.markPartitionDone(topic, partition)
+			}
+			received = false
+		case err := <-partitionConsumer.Errors():
+			if err != nil {
+				log.Warnf("Error consuming %s/%d: %v", err.Topic, err.Partition, err.Err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
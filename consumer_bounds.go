@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Bounds of what is consumed from each partition: a start offset and an end offset where the partition stops.
+They come from a time range (--from-time, --until-time) and from the high watermarks at start (--exit-at-end).
+The consumer stops once every partition reached its end.
+*/
+
+// Offsets of a topic partition to consume. Start is -1 to use the group's (or the initial) offset.
+// End is exclusive, and -1 if it is not known (yet)
+type partitionBounds struct {
+	Start  int64
+	End    int64
+	Oldest int64 // Oldest offset of the partition when the bounds were resolved
+}
+
+// Add bounds to the consumer. The later start and the earlier end win
+func (c *Consumer) mergeBounds(bounds map[string]map[int32]partitionBounds) {
+	if c.bounds == nil {
+		c.bounds = make(map[string]map[int32]partitionBounds)
+		c.donePartitions = make(map[string]map[int32]bool)
+	}
+	for topic, partitions := range bounds {
+		if _, exists := c.bounds[topic]; !exists {
+			c.bounds[topic] = make(map[int32]partitionBounds)
+		}
+		for partition, added := range partitions {
+			merged, exists := c.bounds[topic][partition]
+			if !exists {
+				merged = partitionBounds{Start: -1, End: -1, Oldest: added.Oldest}
+			}
+			if added.Start > merged.Start {
+				merged.Start = added.Start
+			}
+			if added.End >= 0 && (merged.End < 0 || added.End < merged.End) {
+				merged.End = added.End
+			}
+			c.bounds[topic][partition] = merged
+			log.Debugf("Bounds of %s/%d: offsets %d to %d", topic, partition, merged.Start, merged.End)
+		}
+	}
+}
+
+// Bounds ending at the newest offset (high watermark) of each partition of the topics
+func resolveNewestBounds(client offsetGetter, topics []string) (map[string]map[int32]partitionBounds, error) {
+	topicBounds := make(map[string]map[int32]partitionBounds)
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partitions of %s: %w", topic, err)
+		}
+		topicBounds[topic] = make(map[int32]partitionBounds)
+		for _, partition := range partitions {
+			oldest, newest, err := partitionOffsetRange(client, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			topicBounds[topic][partition] = partitionBounds{Start: -1, End: newest, Oldest: oldest}
+		}
+	}
+	return topicBounds, nil
+}
+
+// Stop each partition at the newest offset it has now. Must be called before Consume
+func (c *Consumer) SetExitAtEnd() error {
+	bounds, err := resolveNewestBounds(c.Client, c.Topics)
+	if err != nil {
+		return err
+	}
+	c.mergeBounds(bounds)
+	return nil
+}
+
+// Bounds covering the last records of each partition of the topics, up to the newest offset
+func resolveLastRecordsBounds(client offsetGetter, topics []string, records int64) (map[string]map[int32]partitionBounds, error) {
+	topicBounds, err := resolveNewestBounds(client, topics)
+	if err != nil {
+		return nil, err
+	}
+	for _, partitions := range topicBounds {
+		for partition, bounds := range partitions {
+			bounds.Start = max(bounds.End-records, bounds.Oldest)
+			partitions[partition] = bounds
+		}
+	}
+	return topicBounds, nil
+}
+
+// Consume only the last records of each partition and stop at the newest offset it has now. Must be called before Consume
+func (c *Consumer) SetLastRecords(records int64) error {
+	bounds, err := resolveLastRecordsBounds(c.Client, c.Topics, records)
+	if err != nil {
+		return err
+	}
+	c.mergeBounds(bounds)
+	return nil
+}
+
+// Move the group's offset of a partition to offset, backwards or forwards
+func setSessionOffset(session sarama.ConsumerGroupSession, topic string, partition int32, offset int64) {
+	// ResetOffset only moves backwards and MarkOffset only forwards
+	session.ResetOffset(topic, partition, offset, "Gafkalo CLI offset reset")
+	session.MarkOffset(topic, partition, offset, "Gafkalo CLI offset reset")
+}
+
+// Set the start offsets of the bounds in the group
+func (c *Consumer) setupBounds(session sarama.ConsumerGroupSession) {
+	for topic, partitions := range c.bounds {
+		for partition, bounds := range partitions {
+			if bounds.Start >= 0 {
+				setSessionOffset(session, topic, partition, bounds.Start)
+			}
+		}
+	}
+}
+
+// Is the message past the end of its partition
+func (c *Consumer) pastEnd(message *sarama.ConsumerMessage) bool {
+	if c.bounds == nil {
+		return false
+	}
+	bounds, exists := c.bounds[message.Topic][message.Partition]
+	if exists && bounds.End >= 0 {
+		return message.Offset >= bounds.End
+	}
+	return !c.untilTime.IsZero() && message.Timestamp.After(c.untilTime)
+}
+
+// Is the message the last one before the end of its partition
+func (c *Consumer) lastBeforeEnd(message *sarama.ConsumerMessage) bool {
+	bounds, exists := c.bounds[message.Topic][message.Partition]
+	return exists && bounds.End >= 0 && message.Offset+1 >= bounds.End
+}
+
+// How long a partition gets no message before it is checked for offsets that are never delivered before its end
+var endCheckInterval = 2 * time.Second
+
+/*
+Is a partition that got no message for endCheckInterval at its end. Transaction markers and compacted records
+use offsets but are never delivered, so the record just before the end may not exist.
+Once the high watermark reached the end, a partition without new messages is past every record before it
+*/
+func (c *Consumer) idleAtEnd(topic string, partition int32, highWaterMark int64) bool {
+	bounds, exists := c.bounds[topic][partition]
+	return exists && bounds.End >= 0 && highWaterMark >= bounds.End
+}
+
+/*
+Does consuming a partition from offset start at or past its end. Then no message will ever tell it is done.
+That happens with empty partitions, and when the group's offset is past the end
+*/
+func (c *Consumer) startsPastEnd(topic string, partition int32, offset int64) bool {
+	bounds, exists := c.bounds[topic][partition]
+	if !exists || bounds.End < 0 {
+		return false
+	}
+	switch offset {
+	case sarama.OffsetOldest:
+		return bounds.Oldest >= bounds.End
+	case sarama.OffsetNewest:
+		// The end offset is never past the newest offset
+		return true
+	}
+	return offset >= bounds.End
+}
+
+// Record that a partition reached its end. Stops the consumer when all partitions did
+func (c *Consumer) markPartitionDone(topic string, partition int32) {
+	c.donePartitionsMutex.Lock()
+	defer c.donePartitionsMutex.Unlock()
+	if _, exists := c.donePartitions[topic]; !exists {
+		c.donePartitions[topic] = make(map[int32]bool)
+	}
+	if c.donePartitions[topic][partition] {
+		return
+	}
+	c.donePartitions[topic][partition] = true
+	log.Debugf("Partition %s/%d reached its end", topic, partition)
+	for topic, partitions := range c.bounds {
+		for partition := range partitions {
+			if !c.donePartitions[topic][partition] {
+				return
+			}
+		}
+	}
+	log.Println("All partitions reached their end")
+	c.cancel()
+}
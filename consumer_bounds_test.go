@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitAtEndBounds(t *testing.T) {
+	base := time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC)
+	at := func(minutes int) int64 { return base.Add(time.Duration(minutes) * time.Minute).UnixMilli() }
+	client := fakeOffsetGetter{
+		0: {at(0), at(3), at(5), at(9)},
+		1: {at(1), at(2)},
+		2: {},
+	}
+	bounds, err := resolveNewestBounds(client, []string{"orders"})
+	require.NoError(t, err)
+	require.Equal(t, partitionBounds{Start: -1, End: 4}, bounds["orders"][0])
+	require.Equal(t, partitionBounds{Start: -1, End: 0}, bounds["orders"][2])
+
+	// Combined with a time range the earlier end wins
+	consumer := &Consumer{}
+	timeBounds, err := resolveTimeBounds(client, []string{"orders"}, base.Add(2*time.Minute), base.Add(4*time.Minute), base.Add(time.Hour))
+	require.NoError(t, err)
+	consumer.mergeBounds(timeBounds)
+	consumer.mergeBounds(bounds)
+	require.Equal(t, partitionBounds{Start: 1, End: 2}, consumer.bounds["orders"][0])
+	require.Equal(t, partitionBounds{Start: 1, End: 2}, consumer.bounds["orders"][1])
+	require.Equal(t, partitionBounds{Start: 0, End: 0}, consumer.bounds["orders"][2])
+}
+
+func TestLastRecordsBounds(t *testing.T) {
+	client := fakeOffsetGetter{0: make([]int64, 500), 1: make([]int64, 40)}
+	bounds, err := resolveLastRecordsBounds(client, []string{"orders"}, 100)
+	require.NoError(t, err)
+	require.Equal(t, partitionBounds{Start: 400, End: 500}, bounds["orders"][0])
+	require.Equal(t, partitionBounds{Start: 0, End: 40}, bounds["orders"][1])
+}
+
+func TestStartsPastEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	consumer := &Consumer{cancel: cancel}
+	consumer.mergeBounds(map[string]map[int32]partitionBounds{
+		"orders": {0: {Start: -1, End: 4}, 1: {Start: -1, End: 7, Oldest: 7}},
+	})
+	require.False(t, consumer.startsPastEnd("orders", 0, sarama.OffsetOldest))
+	require.False(t, consumer.startsPastEnd("orders", 0, 3))
+	require.True(t, consumer.startsPastEnd("orders", 0, 4))
+	require.True(t, consumer.startsPastEnd("orders", 0, sarama.OffsetNewest))
+	// Empty partition, or all its records were deleted
+	require.True(t, consumer.startsPastEnd("orders", 1, sarama.OffsetOldest))
+	// Not bounded
+	require.False(t, consumer.startsPastEnd("payments", 0, sarama.OffsetNewest))
+
+	consumer.markPartitionDone("orders", 1)
+	require.NoError(t, ctx.Err())
+	consumer.markPartitionDone("orders", 0)
+	require.Error(t, ctx.Err())
+}
+
+// Partition consumer whose last offsets before the high watermark are never delivered, like transaction markers
+type gapPartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark int64
+}
+
+func (p *gapPartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return p.messages }
+func (p *gapPartitionConsumer) Errors() <-chan *sarama.ConsumerError     { return nil }
+func (p *gapPartitionConsumer) HighWaterMarkOffset() int64               { return p.highWaterMark }
+func (p *gapPartitionConsumer) Close() error                             { return nil }
+
+func TestExitAtEndWithGap(t *testing.T) {
+	defer func(interval time.Duration) { endCheckInterval = interval }(endCheckInterval)
+	endCheckInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consumer := &Consumer{cancel: cancel}
+	consumer.SetRecordPrinterFunc(func(record *sinkRecord) {
+	})
+	consumer.mergeBounds(map[string]map[int32]partitionBounds{"orders": {0: {Start: -1, End: 10}}})
+	// Offsets 8 and 9 are a transaction marker and a compacted record
+	partitionConsumer := &gapPartitionConsumer{messages: make(chan *sarama.ConsumerMessage, 8), highWaterMark: 10}
+	for offset := range int64(8) {
+		partitionConsumer.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: offset, Value: []byte("order")}
+	}
+	consumer.consumePartition(ctx, "orders", 0, partitionConsumer)
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	require.Equal(t, 8, consumer.scannedCount)
+
+	// Not at the end while the high watermark is still before it
+	require.True(t, consumer.idleAtEnd("orders", 0, 10))
+	require.False(t, consumer.idleAtEnd("orders", 0, 9))
+	require.False(t, consumer.idleAtEnd("payments", 0, 10))
+}
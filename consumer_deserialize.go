@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/kmetaxas/srclient"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+/*
+Deserialization of schema registry wire format payloads: a magic byte, the 4 byte schema ID and the data.
+Protobuf payloads have the message indexes between the schema ID and the data.
+All schema types are rendered as JSON.
+*/
+
+// Deserialize a binary payload (wire format)
+// return the deserialized string, the schema id , error
+func (c *Consumer) DeserializePayload(payload []byte) (string, int, error) {
+	schemaID, err := wireFormatSchemaID(payload)
+	if err != nil {
+		return "", 0, err
+	}
+	// No need to cache the schema ourselves , as the srclient will do caching internally
+	schema, err := c.SRClient.GetSchema(schemaID)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to deserialize schema ID [%d] with error: %s", schemaID, err)
+	}
+	schemaType := srclient.Avro
+	if schema.SchemaType() != nil && *schema.SchemaType() != "" {
+		schemaType = *schema.SchemaType()
+	}
+	var resp string
+	switch schemaType {
+	case srclient.Protobuf:
+		resp, err = c.deserializeProtobuf(schema, payload[5:])
+	case srclient.Json:
+		resp, err = c.deserializeJSON(schema, payload[5:])
+	default:
+		resp, err = deserializeAvro(schema, payload[5:])
+	}
+	if err != nil {
+		return "", schemaID, fmt.Errorf("failed to deserialize %s payload with schema ID [%d]: %w", schemaType, schemaID, err)
+	}
+	return resp, schemaID, nil
+}
+
+// Schema ID of a wire format payload
+func wireFormatSchemaID(payload []byte) (int, error) {
+	if len(payload) < 5 || payload[0] != 0 {
+		return 0, errors.New("payload <5 bytes or no magic byte. Not schema registry wire format")
+	}
+	return int(binary.BigEndian.Uint32(payload[1:5])), nil
+}
+
+func deserializeAvro(schema *srclient.Schema, data []byte) (string, error) {
+	codec := schema.Codec()
+	if codec == nil {
+		return "", errors.New("invalid AVRO schema")
+	}
+	native, _, err := codec.NativeFromBinary(data)
+	if err != nil {
+		return "", err
+	}
+	deserialized, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return "", err
+	}
+	return string(deserialized), nil
+}
+
+// JSON Schema payloads are JSON already. They are validated against the schema if enabled
+func (c *Consumer) deserializeJSON(schema *srclient.Schema, data []byte) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", err
+	}
+	if c.validateJSON {
+		jsonSchema := schema.JsonSchema()
+		if jsonSchema == nil {
+			return "", errors.New("can't compile the JSON schema to validate the payload")
+		}
+		if err := jsonSchema.Validate(value); err != nil {
+			return "", fmt.Errorf("payload is not valid: %w", err)
+		}
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, data); err != nil {
+		return "", err
+	}
+	return compacted.String(), nil
+}
+
+func (c *Consumer) deserializeProtobuf(schema *srclient.Schema, data []byte) (string, error) {
+	indexes, data, err := readMessageIndexes(data)
+	if err != nil {
+		return "", err
+	}
+	file, err := c.protobufFile(schema)
+	if err != nil {
+		return "", err
+	}
+	descriptor, err := messageForIndexes(file, indexes)
+	if err != nil {
+		return "", err
+	}
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(data, message); err != nil {
+		return "", err
+	}
+	marshalled, err := protojson.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	// protojson randomly adds whitespace so output can't be relied on. Compact it
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, marshalled); err != nil {
+		return "", err
+	}
+	return compacted.String(), nil
+}
+
+/*
+Read the message indexes of a Protobuf payload: the path to the message type in the schema, as zigzag varints.
+The first is the number of indexes. The common case of the first message in the file ([0]) is a single 0.
+Returns the indexes and the remaining data
+*/
+func readMessageIndexes(data []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, nil, errors.New("invalid message indexes")
+	}
+	data = data[n:]
+	if count == 0 {
+		return []int{0}, data, nil
+	}
+	if count < 0 || count > int64(len(data)) {
+		return nil, nil, fmt.Errorf("invalid message index count %d", count)
+	}
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, n := binary.Varint(data)
+		if n <= 0 || index < 0 {
+			return nil, nil, errors.New("invalid message indexes")
+		}
+		indexes[i] = int(index)
+		data = data[n:]
+	}
+	return indexes, data, nil
+}
+
+// Find the message at the index path: a top level message, then nested messages
+func messageForIndexes(file linker.File, indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := file.Messages()
+	var descriptor protoreflect.MessageDescriptor
+	for _, index := range indexes {
+		if index >= messages.Len() {
+			return nil, fmt.Errorf("message index %v not found in schema", indexes)
+		}
+		descriptor = messages.Get(index)
+		messages = descriptor.Messages()
+	}
+	return descriptor, nil
+}
+
+// Compiled Protobuf schema with all its references. Compiled once per schema ID
+func (c *Consumer) protobufFile(schema *srclient.Schema) (linker.File, error) {
+	c.protobufFilesMutex.Lock()
+	defer c.protobufFilesMutex.Unlock()
+	if file, exists := c.protobufFiles[schema.ID()]; exists {
+		return file, nil
+	}
+	imports := make(map[string]string)
+	if err := c.resolveReferences(schema.References(), imports); err != nil {
+		return nil, err
+	}
+	file, err := compileProtobufSchema(schema.Schema(), imports)
+	if err != nil {
+		return nil, err
+	}
+	if c.protobufFiles == nil {
+		c.protobufFiles = make(map[int]linker.File)
+	}
+	c.protobufFiles[schema.ID()] = file
+	return file, nil
+}
+
+// Fetch referenced schemas, and the ones they reference, into imports. Keyed by reference name, which is the import path
+func (c *Consumer) resolveReferences(references []srclient.Reference, imports map[string]string) error {
+	return resolveSchemaReferences(c.SRClient, references, imports)
+}
+
+// Where referenced schemas are fetched from: the registry client, or the _schemas cache for offline commands
+type referencedSchemaGetter interface {
+	GetSchemaByVersion(subject string, version int) (*srclient.Schema, error)
+}
+
+func resolveSchemaReferences(client referencedSchemaGetter, references []srclient.Reference, imports map[string]string) error {
+	for _, ref := range references {
+		if _, exists := imports[ref.Name]; exists {
+			continue
+		}
+		referenced, err := client.GetSchemaByVersion(ref.Subject, ref.Version)
+		if err != nil {
+			return fmt.Errorf("failed to get reference %s (%s version %d): %w", ref.Name, ref.Subject, ref.Version, err)
+		}
+		imports[ref.Name] = referenced.Schema()
+		if err := resolveSchemaReferences(client, referenced.References(), imports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
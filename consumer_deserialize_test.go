@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testCommonProto = `syntax = "proto3";
+package common;
+message Money { string currency = 1; int64 units = 2; }
+`
+
+const testOrderProto = `syntax = "proto3";
+package orders;
+import "common/money.proto";
+message Header { string id = 1; }
+message Order {
+  message Line { string sku = 1; common.Money price = 2; }
+  string id = 1;
+  repeated Line lines = 2;
+}
+`
+
+func newDeserializeTestConsumer(t *testing.T) *Consumer {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response interface{}
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			response = map[string]interface{}{"schema": `{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`}
+		case "/schemas/ids/2":
+			response = map[string]interface{}{"schemaType": "PROTOBUF", "schema": testOrderProto,
+				"references": []srclient.Reference{{Name: "common/money.proto", Subject: "common-money", Version: 1}}}
+		case "/subjects/common-money/versions/1":
+			response = map[string]interface{}{"subject": "common-money", "version": 1, "id": 3, "schemaType": "PROTOBUF", "schema": testCommonProto}
+		case "/schemas/ids/5":
+			response = map[string]interface{}{"schema": `{"type":"record","name":"User","fields":[{"name":"name","type":"string"},{"name":"age","type":"int","default":0}]}`}
+		case "/schemas/ids/4":
+			response = map[string]interface{}{"schemaType": "JSON", "schema": `{"type":"object","properties":{"id":{"type":"integer"}},"required":["id"]}`}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			response = map[string]interface{}{"error_code": 40403, "message": "Schema not found"}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	consumer := &Consumer{SRClient: srclient.CreateSchemaRegistryClient(server.URL)}
+	consumer.SRClient.CodecCreationEnabled(false)
+	return consumer
+}
+
+func wireFormat(schemaID int, data []byte) []byte {
+	payload := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(payload[1:], uint32(schemaID))
+	return append(payload, data...)
+}
+
+func TestDeserializeAvro(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	codec, err := goavro.NewCodec(`{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`)
+	require.NoError(t, err)
+	data, err := codec.BinaryFromNative(nil, map[string]interface{}{"name": "jane"})
+	require.NoError(t, err)
+	value, schemaID, err := consumer.DeserializePayload(wireFormat(1, data))
+	require.NoError(t, err)
+	require.Equal(t, 1, schemaID)
+	require.JSONEq(t, `{"name":"jane"}`, value)
+
+	_, _, err = consumer.DeserializePayload([]byte("plain"))
+	require.Error(t, err)
+}
+
+func TestDeserializeProtobuf(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	file, err := compileProtobufSchema(testOrderProto, map[string]string{"common/money.proto": testCommonProto})
+	require.NoError(t, err)
+
+	// Order is the second top level message: indexes [1]
+	order := dynamicpb.NewMessage(file.Messages().ByName("Order"))
+	order.Set(order.Descriptor().Fields().ByName("id"), protoreflect.ValueOfString("o-1"))
+	data, err := proto.Marshal(order)
+	require.NoError(t, err)
+	indexes := binary.AppendVarint(binary.AppendVarint(nil, 1), 1)
+	value, schemaID, err := consumer.DeserializePayload(wireFormat(2, append(indexes, data...)))
+	require.NoError(t, err)
+	require.Equal(t, 2, schemaID)
+	require.JSONEq(t, `{"id":"o-1"}`, value)
+
+	// Header is the first message, encoded as a single 0
+	header := dynamicpb.NewMessage(file.Messages().ByName("Header"))
+	header.Set(header.Descriptor().Fields().ByName("id"), protoreflect.ValueOfString("h-1"))
+	data, err = proto.Marshal(header)
+	require.NoError(t, err)
+	value, _, err = consumer.DeserializePayload(wireFormat(2, append([]byte{0}, data...)))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"h-1"}`, value)
+
+	// Order.Line is nested: indexes [1, 0]. Its price is a referenced type
+	line := dynamicpb.NewMessage(file.Messages().ByName("Order").Messages().ByName("Line"))
+	price := dynamicpb.NewMessage(line.Descriptor().Fields().ByName("price").Message())
+	price.Set(price.Descriptor().Fields().ByName("currency"), protoreflect.ValueOfString("EUR"))
+	line.Set(line.Descriptor().Fields().ByName("price"), protoreflect.ValueOfMessage(price))
+	data, err = proto.Marshal(line)
+	require.NoError(t, err)
+	indexes = binary.AppendVarint(binary.AppendVarint(binary.AppendVarint(nil, 2), 1), 0)
+	value, _, err = consumer.DeserializePayload(wireFormat(2, append(indexes, data...)))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"price":{"currency":"EUR"}}`, value)
+
+	indexes = binary.AppendVarint(binary.AppendVarint(nil, 1), 5)
+	_, _, err = consumer.DeserializePayload(wireFormat(2, append(indexes, data...)))
+	require.Error(t, err)
+}
+
+func TestDeserializeJSONSchema(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	value, schemaID, err := consumer.DeserializePayload(wireFormat(4, []byte(`{ "id": 1 }`)))
+	require.NoError(t, err)
+	require.Equal(t, 4, schemaID)
+	require.Equal(t, `{"id":1}`, value)
+
+	// Invalid payloads are only rejected with validation
+	_, _, err = consumer.DeserializePayload(wireFormat(4, []byte(`{"name":"x"}`)))
+	require.NoError(t, err)
+	consumer.SetValidateJSON(true)
+	_, _, err = consumer.DeserializePayload(wireFormat(4, []byte(`{"name":"x"}`)))
+	require.Error(t, err)
+	_, _, err = consumer.DeserializePayload(wireFormat(4, []byte(`{"id":2}`)))
+	require.NoError(t, err)
+}
+
+func TestReadMessageIndexes(t *testing.T) {
+	indexes, rest, err := readMessageIndexes([]byte{0, 42})
+	require.NoError(t, err)
+	require.Equal(t, []int{0}, indexes)
+	require.Equal(t, []byte{42}, rest)
+
+	_, _, err = readMessageIndexes([]byte{})
+	require.Error(t, err)
+	_, _, err = readMessageIndexes(binary.AppendVarint(nil, 3))
+	require.Error(t, err)
+}
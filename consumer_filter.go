@@ -0,0 +1,630 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/IBM/sarama"
+)
+
+/*
+Record filters for the consumer. A filter is an expression evaluated against each deserialized record, like:
+
+	value.customer.id == "C-1042" && headers["source"] != "replay"
+	key startswith "eu-" || (partition == 3 && offset >= 1000)
+	timestamp > "-1h" && value.items[0].sku matches "^SKU-[0-9]+$"
+
+Fields are key, value, headers, topic, partition, offset and timestamp. Key and value are parsed as JSON when they
+are JSON, so their fields can be selected with .name and [index]. Comparisons are ==, !=, <, <=, >, >= and the
+string operators contains, startswith, endswith and matches (a regular expression). Timestamps compare to times
+in the --from-time syntax. Missing fields are null. Numbers are compared exactly, so 64-bit IDs don't lose precision.
+*/
+
+// A compiled record filter
+type RecordFilter struct {
+	source string
+	root   filterNode
+}
+
+// Fields of a record a filter is evaluated against
+type filterRecord struct {
+	Topic     string
+	Key       interface{}
+	Value     interface{}
+	Headers   map[string]string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+type filterNode func(record *filterRecord) (interface{}, error)
+
+var filterFields = []string{"key", "value", "headers", "topic", "partition", "offset", "timestamp"}
+
+// Compile a filter expression
+func NewRecordFilter(expression string) (*RecordFilter, error) {
+	tokens, err := lexFilter(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expression, err)
+	}
+	parser := &filterParser{tokens: tokens, now: time.Now()}
+	root, err := parser.parseOr()
+	if err == nil && parser.peek().kind != filterTokenEnd {
+		err = fmt.Errorf("unexpected %q", parser.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expression, err)
+	}
+	return &RecordFilter{source: expression, root: root}, nil
+}
+
+// Does the record match the filter. key and value are the deserialized key and value
+func (f *RecordFilter) Match(message *sarama.ConsumerMessage, key, value string) (bool, error) {
+	result, err := f.Evaluate(message, key, value)
+	if err != nil {
+		return false, fmt.Errorf("filter %w", err)
+	}
+	return filterTruthy(result), nil
+}
+
+// Evaluate the expression against the record. A path alone, like value.customer.id, selects that field
+func (f *RecordFilter) Evaluate(message *sarama.ConsumerMessage, key, value string) (interface{}, error) {
+	record := filterRecord{
+		Topic:     message.Topic,
+		Key:       filterJSONValue(message.Key, key),
+		Value:     filterJSONValue(message.Value, value),
+		Headers:   make(map[string]string),
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+	for _, header := range message.Headers {
+		record.Headers[string(header.Key)] = string(header.Value)
+	}
+	result, err := f.root(&record)
+	if err != nil {
+		return nil, fmt.Errorf("%q failed on %s/%d offset %d: %w", f.source, message.Topic, message.Partition, message.Offset, err)
+	}
+	return result, nil
+}
+
+// A key or value as parsed JSON, with numbers as json.Number, else as a string. Null if there is no payload (tombstones)
+func filterJSONValue(payload []byte, deserialized string) interface{} {
+	if payload == nil {
+		return nil
+	}
+	if parsed, err := decodeJSONNumbers(deserialized); err == nil {
+		return parsed
+	}
+	return deserialized
+}
+
+// Parse a JSON document keeping its numbers as json.Number, so large integers are not rounded to float64
+func decodeJSONNumbers(data string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var parsed interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return parsed, nil
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEnd filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenNumber
+	filterTokenOperator
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", "."}
+
+func lexFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			// Strings are quoted with either quote, and use Go escapes
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			quoted := string(runes[i+1 : j])
+			if r == '\'' {
+				quoted = strings.ReplaceAll(strings.ReplaceAll(quoted, `\'`, `'`), `"`, `\"`)
+			}
+			text, err := strconv.Unquote(`"` + quoted + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: text})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenIdent, text: string(runes[i:j])})
+			i = j
+		default:
+			matched := false
+			for _, op := range filterOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, filterToken{kind: filterTokenOperator, text: op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, filterToken{kind: filterTokenEnd}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	now    time.Time // Relative times in the filter are relative to this
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != filterTokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+// Consume the next token if it is the operator op
+func (p *filterParser) accept(op string) bool {
+	if token := p.peek(); token.kind == filterTokenOperator && token.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = func(left, right filterNode) filterNode {
+			return func(record *filterRecord) (interface{}, error) {
+				value, err := left(record)
+				if err != nil || filterTruthy(value) {
+					return true, err
+				}
+				value, err = right(record)
+				return filterTruthy(value), err
+			}
+		}(left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = func(left, right filterNode) filterNode {
+			return func(record *filterRecord) (interface{}, error) {
+				value, err := left(record)
+				if err != nil || !filterTruthy(value) {
+					return false, err
+				}
+				value, err = right(record)
+				return filterTruthy(value), err
+			}
+		}(left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(record *filterRecord) (interface{}, error) {
+			value, err := operand(record)
+			return !filterTruthy(value), err
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+var filterStringOperators = []string{"contains", "startswith", "endswith", "matches"}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	isComparison := token.kind == filterTokenOperator && containsString([]string{"==", "!=", "<", "<=", ">", ">="}, token.text)
+	if !isComparison && !(token.kind == filterTokenIdent && containsString(filterStringOperators, token.text)) {
+		return left, nil
+	}
+	p.next()
+	op := token.text
+	if op == "matches" {
+		pattern := p.next()
+		if pattern.kind != filterTokenString {
+			return nil, fmt.Errorf("matches needs a quoted regular expression")
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, err
+		}
+		return func(record *filterRecord) (interface{}, error) {
+			value, err := left(record)
+			if err != nil || value == nil {
+				return false, err
+			}
+			return re.MatchString(filterString(value)), nil
+		}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	now := p.now
+	return func(record *filterRecord) (interface{}, error) {
+		leftValue, err := left(record)
+		if err != nil {
+			return nil, err
+		}
+		rightValue, err := right(record)
+		if err != nil {
+			return nil, err
+		}
+		return compareFilterValues(op, leftValue, rightValue, now)
+	}, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	token := p.next()
+	switch token.kind {
+	case filterTokenString:
+		return func(*filterRecord) (interface{}, error) { return token.text, nil }, nil
+	case filterTokenNumber:
+		if _, ok := filterNumber(json.Number(token.text)); !ok {
+			return nil, fmt.Errorf("invalid number %s", token.text)
+		}
+		number := json.Number(token.text)
+		return func(*filterRecord) (interface{}, error) { return number, nil }, nil
+	case filterTokenOperator:
+		if token.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, fmt.Errorf("missing )")
+			}
+			return node, nil
+		}
+	case filterTokenIdent:
+		switch token.text {
+		case "true", "false":
+			value := token.text == "true"
+			return func(*filterRecord) (interface{}, error) { return value, nil }, nil
+		case "null":
+			return func(*filterRecord) (interface{}, error) { return nil, nil }, nil
+		}
+		return p.parsePath(token.text)
+	case filterTokenEnd:
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+// A field of the record, followed by .name and [index] selectors
+func (p *filterParser) parsePath(field string) (filterNode, error) {
+	if !containsString(filterFields, field) {
+		return nil, fmt.Errorf("unknown field %s. Expected one of %s", field, strings.Join(filterFields, ","))
+	}
+	selectors, err := p.parseSelectors(field)
+	if err != nil {
+		return nil, err
+	}
+	return func(record *filterRecord) (interface{}, error) {
+		value := record.field(field)
+		for _, selector := range selectors {
+			value = selectFilterValue(value, selector)
+		}
+		return value, nil
+	}, nil
+}
+
+// The .name and [index] selectors after field. Names are strings and indexes ints
+func (p *filterParser) parseSelectors(field string) ([]interface{}, error) {
+	var selectors []interface{}
+	for {
+		if p.accept(".") {
+			name := p.next()
+			if name.kind != filterTokenIdent {
+				return nil, fmt.Errorf("expected a field name after %s", field)
+			}
+			selectors = append(selectors, name.text)
+		} else if p.accept("[") {
+			index := p.next()
+			switch index.kind {
+			case filterTokenString:
+				selectors = append(selectors, index.text)
+			case filterTokenNumber:
+				number, err := strconv.Atoi(index.text)
+				if err != nil || number < 0 {
+					return nil, fmt.Errorf("invalid index %s", index.text)
+				}
+				selectors = append(selectors, number)
+			default:
+				return nil, fmt.Errorf("expected a quoted name or an index in []")
+			}
+			if !p.accept("]") {
+				return nil, fmt.Errorf("missing ]")
+			}
+		} else {
+			break
+		}
+	}
+	return selectors, nil
+}
+
+func (r *filterRecord) field(name string) interface{} {
+	switch name {
+	case "key":
+		return r.Key
+	case "value":
+		return r.Value
+	case "headers":
+		return r.Headers
+	case "topic":
+		return r.Topic
+	case "partition":
+		return json.Number(strconv.FormatInt(int64(r.Partition), 10))
+	case "offset":
+		return json.Number(strconv.FormatInt(r.Offset, 10))
+	case "timestamp":
+		return r.Timestamp
+	}
+	return nil
+}
+
+// Select a field by name or an element by index. Null if there is none
+func selectFilterValue(value interface{}, selector interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if name, ok := selector.(string); ok {
+			return typed[name]
+		}
+	case map[string]string:
+		if name, ok := selector.(string); ok {
+			if header, exists := typed[name]; exists {
+				return header
+			}
+		}
+	case []interface{}:
+		if index, ok := selector.(int); ok && index < len(typed) {
+			return typed[index]
+		}
+	}
+	return nil
+}
+
+func filterTruthy(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typed
+	case string:
+		return typed != ""
+	case json.Number:
+		number, ok := filterNumber(typed)
+		return ok && number.Sign() != 0
+	}
+	return true
+}
+
+// Render a value as a string to compare it to strings. Objects and arrays as JSON
+func filterString(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case json.Number:
+		return typed.String()
+	case time.Time:
+		return typed.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(typed)
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// Compare two values. Numbers compare numerically, also to strings holding numbers, times to times in the --from-time syntax and anything else as strings
+func compareFilterValues(op string, left, right interface{}, now time.Time) (bool, error) {
+	switch op {
+	case "contains":
+		switch typed := left.(type) {
+		case nil:
+			return false, nil
+		case []interface{}:
+			for _, element := range typed {
+				if equal, _ := compareFilterValues("==", element, right, now); equal {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			_, exists := typed[filterString(right)]
+			return exists, nil
+		}
+		return strings.Contains(filterString(left), filterString(right)), nil
+	case "startswith":
+		return left != nil && strings.HasPrefix(filterString(left), filterString(right)), nil
+	case "endswith":
+		return left != nil && strings.HasSuffix(filterString(left), filterString(right)), nil
+	}
+	if left == nil || right == nil {
+		switch op {
+		case "==":
+			return left == nil && right == nil, nil
+		case "!=":
+			return !(left == nil && right == nil), nil
+		}
+		return false, nil
+	}
+	var order int
+	switch {
+	case isFilterTime(left) || isFilterTime(right):
+		leftTime, err := filterTime(left, now)
+		if err != nil {
+			return false, err
+		}
+		rightTime, err := filterTime(right, now)
+		if err != nil {
+			return false, err
+		}
+		order = leftTime.Compare(rightTime)
+	case isFilterNumberPair(left, right):
+		leftNumber, _ := filterNumber(left)
+		rightNumber, _ := filterNumber(right)
+		order = leftNumber.Cmp(rightNumber)
+	case isFilterScalar(left) && isFilterScalar(right):
+		order = strings.Compare(filterString(left), filterString(right))
+	default:
+		// Booleans, objects and arrays are only equal or not
+		if op != "==" && op != "!=" {
+			return false, nil
+		}
+		return reflect.DeepEqual(left, right) == (op == "=="), nil
+	}
+	switch op {
+	case "==":
+		return order == 0, nil
+	case "!=":
+		return order != 0, nil
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	case ">=":
+		return order >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+func isFilterTime(value interface{}) bool {
+	_, ok := value.(time.Time)
+	return ok
+}
+
+func isFilterScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, json.Number:
+		return true
+	}
+	return false
+}
+
+// Are both values numbers, or one a number and the other a string holding one
+func isFilterNumberPair(left, right interface{}) bool {
+	_, leftIsNumber := left.(json.Number)
+	_, rightIsNumber := right.(json.Number)
+	_, leftOK := filterNumber(left)
+	_, rightOK := filterNumber(right)
+	return (leftIsNumber || rightIsNumber) && leftOK && rightOK
+}
+
+// A number, or a string holding one. 256 bits of precision keep 64-bit integers exact
+func filterNumber(value interface{}) (*big.Float, bool) {
+	var text string
+	switch typed := value.(type) {
+	case json.Number:
+		text = typed.String()
+	case string:
+		text = strings.TrimSpace(typed)
+	default:
+		return nil, false
+	}
+	number, ok := new(big.Float).SetPrec(256).SetString(text)
+	return number, ok
+}
+
+func filterTime(value interface{}, now time.Time) (time.Time, error) {
+	switch typed := value.(type) {
+	case time.Time:
+		return typed, nil
+	case string:
+		return parseTimeArg(typed, now)
+	case json.Number:
+		// Epoch milliseconds, like Avro timestamp-millis
+		if millis, err := typed.Int64(); err == nil {
+			return time.UnixMilli(millis), nil
+		}
+		if millis, err := typed.Float64(); err == nil {
+			return time.UnixMilli(int64(millis)), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't compare %v to a timestamp", value)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFilter(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 14, 5, 0, 0, time.UTC)
+	message := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    1200,
+		Timestamp: timestamp,
+		Key:       []byte("eu-42"),
+		Value:     []byte(`{"customer":{"id":"C-1042","vip":true},"total":99.5,"items":[{"sku":"SKU-1"},{"sku":"SKU-22"}]}`),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("abc")}},
+	}
+	tests := []struct {
+		expression string
+		matches    bool
+	}{
+		{`value.customer.id == "C-1042"`, true},
+		{`value.customer.id == 'C-1042'`, true},
+		{`value.customer.id != "C-1042"`, false},
+		{`value.customer.vip`, true},
+		{`!value.customer.vip`, false},
+		{`value.customer.vip == true`, true},
+		{`value.total > 50 && value.total <= 99.5`, true},
+		{`value.total > "50"`, true},
+		{`value.items[1].sku == "SKU-22"`, true},
+		{`value.items[5].sku == null`, true},
+		{`value.missing == null`, true},
+		{`value.missing > 1`, false},
+		{`value.items[0].sku matches "^SKU-[0-9]$"`, true},
+		{`value contains "customer"`, true},
+		{`key startswith "eu-"`, true},
+		{`key endswith "-43"`, false},
+		{`key contains "u-4"`, true},
+		{`headers["trace-id"] == "abc"`, true},
+		{`headers.missing == null`, true},
+		{`topic == "orders" && (partition == 2 || offset >= 1000)`, true},
+		{`partition == 2 || offset < 1000`, false},
+		{`timestamp >= "2024-03-01T14:00:00Z" && timestamp < "2024-03-01T14:10:00Z"`, true},
+		{`timestamp > "2024-03-01T14:05:00Z"`, false},
+	}
+	for _, test := range tests {
+		filter, err := NewRecordFilter(test.expression)
+		require.NoError(t, err, test.expression)
+		matches, err := filter.Match(message, string(message.Key), string(message.Value))
+		require.NoError(t, err, test.expression)
+		require.Equal(t, test.matches, matches, test.expression)
+	}
+
+	// Keys that are JSON are parsed too. Numbers compare to strings holding them
+	keyed := &sarama.ConsumerMessage{Key: []byte("1042"), Value: nil}
+	filter, err := NewRecordFilter(`key == "1042" && key == 1042 && value == null`)
+	require.NoError(t, err)
+	matches, err := filter.Match(keyed, "1042", "Null (Tombstone?)")
+	require.NoError(t, err)
+	require.True(t, matches)
+
+	// 64-bit IDs past 2^53 compare exactly
+	large := &sarama.ConsumerMessage{Value: []byte(`{"id":9007199254740993}`)}
+	for expression, expected := range map[string]bool{
+		`value.id == 9007199254740993`:   true,
+		`value.id == 9007199254740992`:   false,
+		`value.id > 9007199254740992`:    true,
+		`value.id == "9007199254740993"`: true,
+		`value.id == 9007199254740993.0`: true,
+	} {
+		filter, err := NewRecordFilter(expression)
+		require.NoError(t, err, expression)
+		matches, err := filter.Match(large, "", string(large.Value))
+		require.NoError(t, err, expression)
+		require.Equal(t, expected, matches, expression)
+	}
+
+	// Times that don't parse fail at evaluation
+	filter, err = NewRecordFilter(`timestamp > "yesterday"`)
+	require.NoError(t, err)
+	_, err = filter.Match(message, "", "")
+	require.Error(t, err)
+}
+
+func TestRecordFilterErrors(t *testing.T) {
+	for _, expression := range []string{
+		`value.id ==`,
+		`valeu.id == 1`,
+		`(key == "a"`,
+		`key == "a`,
+		`key matches "["`,
+		`key matches value`,
+		`key == "a" key`,
+		`key # 1`,
+	} {
+		_, err := NewRecordFilter(expression)
+		require.Error(t, err, expression)
+	}
+}
+
+func TestFilterCounts(t *testing.T) {
+	filter, err := NewRecordFilter(`value == "match"`)
+	require.NoError(t, err)
+	printed := 0
+	consumer := &Consumer{filter: filter, maxRecords: 2, cancel: func() {}}
+	consumer.SetRecordPrinterFunc(func(record *sinkRecord) {
+		printed++
+	})
+	for offset, value := range []string{"match", "other", "other", "match", "match"} {
+		message := &sarama.ConsumerMessage{Topic: "orders", Offset: int64(offset), Value: []byte(value)}
+		consumer.countMessage(message, consumer.processMessage(message))
+	}
+	require.Equal(t, 3, printed)
+	require.Equal(t, 5, consumer.scannedCount)
+	require.Equal(t, 3, consumer.msgCount)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+How keys and values are rendered for printing, filtering and sinks. Payloads are bytes and only some of them are text,
+so binary ones can be shown as hex, base64, big-endian numbers (like the Java Kafka serializers write) or UUIDs.
+Strings that are not valid UTF-8 or hold control characters are escaped, so they can't garble the terminal or the JSON output.
+*/
+
+const (
+	payloadFormatString   = "string"
+	payloadFormatHex      = "hex"
+	payloadFormatBase64   = "base64"
+	payloadFormatInt32    = "int32"
+	payloadFormatInt64    = "int64"
+	payloadFormatUUID     = "uuid"
+	payloadFormatRegistry = "registry-avro"
+	payloadFormatAuto     = "auto"
+)
+
+var payloadFormats = []string{payloadFormatString, payloadFormatHex, payloadFormatBase64, payloadFormatInt32, payloadFormatInt64, payloadFormatUUID, payloadFormatRegistry, payloadFormatAuto}
+
+// Set how keys and values are rendered. Empty formats are left as they are. Must be called before Consume
+func (c *Consumer) SetPayloadFormats(keyFormat, valueFormat string) error {
+	for _, format := range []string{keyFormat, valueFormat} {
+		if format != "" && !containsString(payloadFormats, format) {
+			return fmt.Errorf("unknown format %s. Expected one of %s", format, strings.Join(payloadFormats, ","))
+		}
+	}
+	if keyFormat != "" {
+		c.keyFormat = keyFormat
+	}
+	if valueFormat != "" {
+		c.valueFormat = valueFormat
+	}
+	return nil
+}
+
+// Render a key or value in format. Returns the schema ID of wire format payloads that were deserialized
+func (c *Consumer) formatPayload(payload []byte, format string) (string, int, error) {
+	// Null keys and tombstones have nothing to render. Wire format payloads can't be null
+	if payload == nil && format != payloadFormatRegistry {
+		return "", 0, nil
+	}
+	switch format {
+	case payloadFormatRegistry:
+		return c.DeserializePayload(payload)
+	case payloadFormatAuto:
+		// A magic byte can also be the first byte of a number or of binary data, so fall back to a string
+		if _, err := wireFormatSchemaID(payload); err == nil {
+			rendered, schemaID, err := c.DeserializePayload(payload)
+			if err == nil {
+				return rendered, schemaID, nil
+			}
+			log.Debugf("Payload starts with the magic byte but is not wire format: %s", err)
+		}
+		return safePayloadString(payload), 0, nil
+	}
+	rendered, err := formatRawPayload(payload, format)
+	return rendered, 0, err
+}
+
+// Render a payload without the schema registry
+func formatRawPayload(payload []byte, format string) (string, error) {
+	switch format {
+	case payloadFormatHex:
+		return hex.EncodeToString(payload), nil
+	case payloadFormatBase64:
+		return base64.StdEncoding.EncodeToString(payload), nil
+	case payloadFormatInt32:
+		if len(payload) != 4 {
+			return "", fmt.Errorf("payload of %d bytes is not an int32", len(payload))
+		}
+		return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(payload))), 10), nil
+	case payloadFormatInt64:
+		if len(payload) != 8 {
+			return "", fmt.Errorf("payload of %d bytes is not an int64", len(payload))
+		}
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(payload)), 10), nil
+	case payloadFormatUUID:
+		if len(payload) != 16 {
+			return "", fmt.Errorf("payload of %d bytes is not a UUID", len(payload))
+		}
+		encoded := hex.EncodeToString(payload)
+		return fmt.Sprintf("%s-%s-%s-%s-%s", encoded[0:8], encoded[8:12], encoded[12:16], encoded[16:20], encoded[20:32]), nil
+	}
+	return safePayloadString(payload), nil
+}
+
+/*
+A payload as a string that is safe to print. Invalid UTF-8 bytes are escaped as \xNN and control characters
+other than newlines and tabs as \xNN or \uNNNN. Text is returned as it is. Backslashes are never escaped, so text
+keeps them as it is. Escaped output can look like text and is marked where it is printed, see isEscapedPayload
+*/
+func safePayloadString(payload []byte) string {
+	if isSafeText(payload) {
+		return string(payload)
+	}
+	var builder strings.Builder
+	for len(payload) > 0 {
+		r, size := utf8.DecodeRune(payload)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&builder, `\x%02x`, payload[0])
+		case isSafeRune(r):
+			builder.WriteRune(r)
+		case r < utf8.RuneSelf:
+			fmt.Fprintf(&builder, `\x%02x`, r)
+		default:
+			fmt.Fprintf(&builder, `\u%04x`, r)
+		}
+		payload = payload[size:]
+	}
+	return builder.String()
+}
+
+/*
+Was a payload rendered as escaped text by safePayloadString. Strings that are not text are, in the string and auto formats
+and when a format fails. Other formats render text the producer can't turn back into the bytes anyway
+*/
+func isEscapedPayload(rendered string, payload []byte, schemaID int) bool {
+	return schemaID == 0 && payload != nil && !isSafeText(payload) && rendered == safePayloadString(payload)
+}
+
+func isSafeRune(r rune) bool {
+	return r == '\n' || r == '\t' || !unicode.IsControl(r)
+}
+
+func isSafeText(payload []byte) bool {
+	if !utf8.Valid(payload) {
+		return false
+	}
+	for _, r := range string(payload) {
+		if !isSafeRune(r) {
+			return false
+		}
+	}
+	return true
+}
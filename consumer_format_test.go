@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRawPayload(t *testing.T) {
+	tests := []struct {
+		payload  []byte
+		format   string
+		expected string
+	}{
+		{[]byte("plain"), payloadFormatString, "plain"},
+		{[]byte("line\n\ttab"), payloadFormatString, "line\n\ttab"},
+		{[]byte("näive"), payloadFormatString, "näive"},
+		{[]byte{0xca, 0xfe}, payloadFormatHex, "cafe"},
+		{[]byte{0xca, 0xfe}, payloadFormatBase64, "yv4="},
+		{[]byte{0, 0, 1, 0}, payloadFormatInt32, "256"},
+		{[]byte{0xff, 0xff, 0xff, 0xfe}, payloadFormatInt32, "-2"},
+		{[]byte{0, 0, 0, 0, 0, 0, 0x30, 0x39}, payloadFormatInt64, "12345"},
+		{[]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}, payloadFormatUUID, "123e4567-e89b-12d3-a456-426614174000"},
+	}
+	for _, test := range tests {
+		rendered, err := formatRawPayload(test.payload, test.format)
+		require.NoError(t, err, test.format)
+		require.Equal(t, test.expected, rendered, test.format)
+	}
+	for _, format := range []string{payloadFormatInt32, payloadFormatInt64, payloadFormatUUID} {
+		_, err := formatRawPayload([]byte{1, 2, 3}, format)
+		require.Error(t, err, format)
+	}
+}
+
+func TestSafePayloadString(t *testing.T) {
+	require.Equal(t, `a\b\xff`, safePayloadString([]byte("a\\b\xff")))
+	require.Equal(t, `\xff\xfeab`, safePayloadString([]byte{0xff, 0xfe, 'a', 'b'}))
+	require.Equal(t, `\x1b[31mred`, safePayloadString([]byte("\x1b[31mred")))
+	require.Equal(t, `x\u0085y`, safePayloadString([]byte("x\u0085y")))
+	// Text, backslashes included, is left as it is. Only escaped payloads are marked as escaped
+	require.Equal(t, `C:\path`, safePayloadString([]byte(`C:\path`)))
+	require.False(t, isEscapedPayload(`\xff`, []byte(`\xff`), 0))
+	require.True(t, isEscapedPayload(`\xff`, []byte{0xff}, 0))
+	require.False(t, isEscapedPayload("ff", []byte{0xff}, 0))
+
+	// The json printer writes escaped payloads as base64, so they can be replayed
+	message := &sarama.ConsumerMessage{Topic: "t", Key: []byte{0x00, 0x01}, Value: []byte{0xff}}
+	data, err := jsonRecord(&sinkRecord{Message: message, Key: safePayloadString(message.Key), Value: safePayloadString(message.Value)})
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, "AAE=", decoded["key"])
+	require.Equal(t, "base64", decoded["key_encoding"])
+	require.Equal(t, "/w==", decoded["value"])
+	require.Equal(t, "base64", decoded["value_encoding"])
+}
+
+func TestFormatPayload(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	require.Error(t, consumer.SetPayloadFormats("binary", ""))
+	require.NoError(t, consumer.SetPayloadFormats(payloadFormatInt64, payloadFormatAuto))
+
+	codec, err := goavro.NewCodec(`{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`)
+	require.NoError(t, err)
+	data, err := codec.BinaryFromNative(nil, map[string]interface{}{"name": "jane"})
+	require.NoError(t, err)
+
+	// Auto detects wire format by the magic byte, and falls back to a string when it isn't
+	rendered, schemaID, err := consumer.formatPayload(wireFormat(1, data), payloadFormatAuto)
+	require.NoError(t, err)
+	require.Equal(t, 1, schemaID)
+	require.JSONEq(t, `{"name":"jane"}`, rendered)
+	rendered, schemaID, err = consumer.formatPayload([]byte{0, 0, 0, 0, 99, 1}, payloadFormatAuto)
+	require.NoError(t, err)
+	require.Equal(t, 0, schemaID)
+	require.Equal(t, `\x00\x00\x00\x00c\x01`, rendered)
+	rendered, _, err = consumer.formatPayload([]byte("plain"), payloadFormatAuto)
+	require.NoError(t, err)
+	require.Equal(t, "plain", rendered)
+
+	// Null keys and tombstones render empty in fixed size formats
+	rendered, _, err = consumer.formatPayload(nil, payloadFormatInt64)
+	require.NoError(t, err)
+	require.Equal(t, "", rendered)
+
+	var printedKey, printedValue string
+	consumer.SetRecordPrinterFunc(func(record *sinkRecord) {
+		printedKey, printedValue = record.Key, record.Value
+	})
+	consumer.processMessage(&sarama.ConsumerMessage{Key: []byte{0, 0, 0, 0, 0, 0, 0, 42}, Value: wireFormat(1, data)})
+	require.Equal(t, "42", printedKey)
+	require.JSONEq(t, `{"name":"jane"}`, printedValue)
+
+	// Payloads the format fails on are printed as strings and the consumer goes on
+	consumer.processMessage(&sarama.ConsumerMessage{Key: []byte{0xff, 'k'}, Value: wireFormat(1, data)})
+	require.Equal(t, `\xffk`, printedKey)
+	require.JSONEq(t, `{"name":"jane"}`, printedValue)
+	require.NoError(t, consumer.SetPayloadFormats(payloadFormatString, payloadFormatRegistry))
+	consumer.processMessage(&sarama.ConsumerMessage{Key: []byte("k"), Value: []byte("not wire format")})
+	require.Equal(t, "k", printedKey)
+	require.Equal(t, "not wire format", printedValue)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+/*
+Start offsets given with --set-offsets. Besides absolute offsets a partition can start at earliest, latest or a
+number of records back from the end (-100). Those are resolved to absolute offsets when the consumer is created.
+*/
+
+// Offset to start a partition from
+type offsetSpec struct {
+	Offset  int64 // Absolute offset, or sarama.OffsetOldest / sarama.OffsetNewest
+	FromEnd int64 // Records back from the newest offset. Offset is ignored if set
+}
+
+// Parse an offset: a number, earliest, latest, or -N for N records back from the end
+func parseOffsetSpec(arg string) (offsetSpec, error) {
+	switch strings.ToLower(arg) {
+	case "earliest":
+		return offsetSpec{Offset: sarama.OffsetOldest}, nil
+	case "latest":
+		return offsetSpec{Offset: sarama.OffsetNewest}, nil
+	}
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return offsetSpec{}, fmt.Errorf("invalid offset %s. Expected a number, earliest, latest or -N: %w", arg, err)
+	}
+	if offset < 0 {
+		return offsetSpec{FromEnd: -offset}, nil
+	}
+	return offsetSpec{Offset: offset}, nil
+}
+
+// Resolve start offsets to absolute offsets. Offsets back from the end stop at the oldest offset
+func resolveOffsetSpecs(client offsetGetter, specs map[string]map[int32]offsetSpec) (map[string]map[int32]int64, error) {
+	offsets := make(map[string]map[int32]int64)
+	for topic, partitions := range specs {
+		offsets[topic] = make(map[int32]int64)
+		for partition, spec := range partitions {
+			if spec.FromEnd == 0 && spec.Offset >= 0 {
+				offsets[topic][partition] = spec.Offset
+				continue
+			}
+			oldest, newest, err := partitionOffsetRange(client, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case spec.FromEnd > 0:
+				offsets[topic][partition] = max(newest-spec.FromEnd, oldest)
+			case spec.Offset == sarama.OffsetOldest:
+				offsets[topic][partition] = oldest
+			default:
+				offsets[topic][partition] = newest
+			}
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveOffsetSpecs(t *testing.T) {
+	client := fakeOffsetGetter{0: make([]int64, 500), 1: make([]int64, 40)}
+	offsets, err := resolveOffsetSpecs(client, map[string]map[int32]offsetSpec{
+		"orders":   {0: {FromEnd: 100}, 1: {FromEnd: 100}},
+		"payments": {0: {Offset: sarama.OffsetOldest}, 1: {Offset: sarama.OffsetNewest}, 2: {Offset: 7}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[int32]int64{0: 400, 1: 0}, offsets["orders"])
+	require.Equal(t, map[int32]int64{0: 0, 1: 40, 2: 7}, offsets["payments"])
+}
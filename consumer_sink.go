@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/kmetaxas/srclient"
+	"github.com/linkedin/goavro/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Sinks write consumed records to stdout or to files, as JSON lines, CSV or Avro Object Container Files.
+Files are rotated by size or record count: the first file is the given path, the next ones get a sequence number
+before the extension (orders.jsonl, orders.1.jsonl, orders.2.jsonl..).
+*/
+
+var sinkFormats = []string{"json", "csv", "avro"}
+
+// Default CSV columns
+var defaultCSVColumns = []string{"topic", "partition", "offset", "timestamp", "key", "value"}
+
+// A consumed record, as given to a sink
+type sinkRecord struct {
+	Message     *sarama.ConsumerMessage
+	Key         string // Deserialized key
+	Value       string // Deserialized value
+	KeySchemaID int
+	ValSchemaID int
+}
+
+// Receives the records of a consumer instead of them being printed
+type recordWriter interface {
+	Write(record *sinkRecord) error
+	Close() error
+}
+
+// Encodes records in one format to one file
+type recordEncoder interface {
+	// Can the record go in the current file. Avro files hold a single schema
+	Accepts(record *sinkRecord) (bool, error)
+	Encode(record *sinkRecord) error
+	Flush() error
+}
+
+type RecordSink struct {
+	path         string // Empty for stdout
+	maxBytes     int64  // Rotate files at this size. 0 to not rotate by size
+	maxRecords   int    // Rotate files at this many records. 0 to not rotate by count
+	newEncoder   func(w io.Writer) recordEncoder
+	output       io.Writer // Stdout, or the current file
+	file         *lazyFile
+	buffered     *bufio.Writer
+	written      *countingWriter
+	encoder      recordEncoder
+	fileRecords  int // Records in the current file
+	fileSequence int
+	mutex        sync.Mutex
+}
+
+// A file created on the first write, so no file is left empty when nothing was written to it, like Avro files of tombstones
+type lazyFile struct {
+	path string
+	file *os.File
+}
+
+func (f *lazyFile) Write(b []byte) (int, error) {
+	if f.file == nil {
+		file, err := os.Create(f.path)
+		if err != nil {
+			return 0, err
+		}
+		log.Debugf("Writing records to %s", f.path)
+		f.file = file
+	}
+	return f.file.Write(b)
+}
+
+// Was anything written to the file
+func (f *lazyFile) created() bool {
+	return f != nil && f.file != nil
+}
+
+func (f *lazyFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// Counts bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	w.count += int64(n)
+	return n, err
+}
+
+/*
+Create a sink writing records in format to path, or to stdout if path is empty.
+CSV columns are fields of the record in the --filter syntax, like value.customer.id.
+Avro files need the schema registry to fetch the schema of the records.
+*/
+func NewRecordSink(path, format string, csvColumns []string, maxBytes int64, maxRecords int, srClient *srclient.SchemaRegistryClient) (*RecordSink, error) {
+	sink := &RecordSink{path: path, maxBytes: maxBytes, maxRecords: maxRecords}
+	switch format {
+	case "json":
+		sink.newEncoder = func(w io.Writer) recordEncoder { return &jsonlEncoder{writer: w} }
+	case "csv":
+		if len(csvColumns) == 0 {
+			csvColumns = defaultCSVColumns
+		}
+		var columns []*RecordFilter
+		for _, column := range csvColumns {
+			compiled, err := NewRecordFilter(column)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV column: %w", err)
+			}
+			columns = append(columns, compiled)
+		}
+		sink.newEncoder = func(w io.Writer) recordEncoder {
+			return &csvEncoder{writer: csv.NewWriter(w), names: csvColumns, columns: columns}
+		}
+	case "avro":
+		if path == "" {
+			return nil, errors.New("avro output must be written to a file with --output")
+		}
+		sink.newEncoder = func(w io.Writer) recordEncoder { return &avroEncoder{writer: w, srClient: srClient} }
+	default:
+		return nil, fmt.Errorf("unknown sink format %s. Expected one of %s", format, strings.Join(sinkFormats, ","))
+	}
+	if path == "" {
+		if maxBytes > 0 || maxRecords > 0 {
+			return nil, errors.New("only files can be rotated. Set --output")
+		}
+		sink.writeTo(os.Stdout)
+	}
+	return sink, nil
+}
+
+// Write to w instead of a file
+func (s *RecordSink) writeTo(w io.Writer) {
+	s.path = ""
+	s.buffered = bufio.NewWriter(w)
+	s.output = s.buffered
+}
+
+// Path of the file with the sequence number. The first file is the path itself
+func sinkFilePath(path string, sequence int) string {
+	if sequence == 0 {
+		return path
+	}
+	extension := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, extension), sequence, extension)
+}
+
+// Write a record. Safe to call from the consumer's goroutines
+func (s *RecordSink) Write(record *sinkRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.encoder != nil && s.path != "" {
+		accepts, err := s.encoder.Accepts(record)
+		if err != nil {
+			return err
+		}
+		full := (s.maxRecords > 0 && s.fileRecords >= s.maxRecords) || (s.maxBytes > 0 && s.written.count >= s.maxBytes)
+		if !accepts || full {
+			file := s.file
+			if err := s.closeFile(); err != nil {
+				return err
+			}
+			// A file that was never created keeps its name for the next records
+			if file.created() {
+				s.fileSequence++
+			}
+		}
+	}
+	if s.encoder == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if err := s.encoder.Encode(record); err != nil {
+		return err
+	}
+	s.fileRecords++
+	// Stdout is read as it is written
+	if s.path == "" {
+		return s.flush()
+	}
+	return nil
+}
+
+// Start a new file, or the encoder for stdout
+func (s *RecordSink) open() error {
+	if s.path != "" {
+		s.file = &lazyFile{path: sinkFilePath(s.path, s.fileSequence)}
+		s.buffered = bufio.NewWriter(s.file)
+		s.output = s.buffered
+	}
+	s.written = &countingWriter{writer: s.output}
+	s.encoder = s.newEncoder(s.written)
+	s.fileRecords = 0
+	return nil
+}
+
+func (s *RecordSink) flush() error {
+	if err := s.encoder.Flush(); err != nil {
+		return err
+	}
+	return s.buffered.Flush()
+}
+
+func (s *RecordSink) closeFile() error {
+	if s.encoder == nil {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.encoder = nil
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Flush and close the current file
+func (s *RecordSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeFile()
+}
+
+// One JSON object per line, like the json output format
+type jsonlEncoder struct {
+	writer io.Writer
+}
+
+func (e *jsonlEncoder) Accepts(record *sinkRecord) (bool, error) {
+	return true, nil
+}
+
+func (e *jsonlEncoder) Encode(record *sinkRecord) error {
+	data, err := jsonRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = e.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return nil
+}
+
+// A header row with the column names, then one row per record
+type csvEncoder struct {
+	writer        *csv.Writer
+	names         []string
+	columns       []*RecordFilter
+	headerWritten bool
+}
+
+func (e *csvEncoder) Accepts(record *sinkRecord) (bool, error) {
+	return true, nil
+}
+
+func (e *csvEncoder) Encode(record *sinkRecord) error {
+	if !e.headerWritten {
+		if err := e.writer.Write(e.names); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	row := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value, err := column.Evaluate(record.Message, record.Key, record.Value)
+		if err != nil {
+			return fmt.Errorf("CSV column %w", err)
+		}
+		if value != nil {
+			row[i] = filterString(value)
+		}
+	}
+	if err := e.writer.Write(row); err != nil {
+		return err
+	}
+	// Flush every row so the file size is known for rotation
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// Avro Object Container File of the record values, with their registry schema. A file holds one schema
+type avroEncoder struct {
+	writer   io.Writer
+	srClient *srclient.SchemaRegistryClient
+	ocf      *goavro.OCFWriter
+	schemaID int
+}
+
+func (e *avroEncoder) Accepts(record *sinkRecord) (bool, error) {
+	if record.Message.Value == nil || e.ocf == nil {
+		return true, nil
+	}
+	schemaID, err := wireFormatSchemaID(record.Message.Value)
+	if err != nil {
+		return false, fmt.Errorf("can't write %s/%d offset %d to an Avro file: %w", record.Message.Topic, record.Message.Partition, record.Message.Offset, err)
+	}
+	return schemaID == e.schemaID, nil
+}
+
+func (e *avroEncoder) Encode(record *sinkRecord) error {
+	message := record.Message
+	// Tombstones have no value to write
+	if message.Value == nil {
+		log.Debugf("Skipping tombstone %s/%d offset %d in Avro file", message.Topic, message.Partition, message.Offset)
+		return nil
+	}
+	schemaID, err := wireFormatSchemaID(message.Value)
+	if err != nil {
+		return fmt.Errorf("can't write %s/%d offset %d to an Avro file: %w", message.Topic, message.Partition, message.Offset, err)
+	}
+	schema, err := e.srClient.GetSchema(schemaID)
+	if err != nil {
+		return fmt.Errorf("failed to get schema ID [%d]: %w", schemaID, err)
+	}
+	if schema.SchemaType() != nil && *schema.SchemaType() != "" && *schema.SchemaType() != srclient.Avro {
+		return fmt.Errorf("%s/%d offset %d has a %s schema. Only AVRO records can be written to an Avro file", message.Topic, message.Partition, message.Offset, *schema.SchemaType())
+	}
+	codec := schema.Codec()
+	if codec == nil {
+		return fmt.Errorf("invalid AVRO schema ID [%d]", schemaID)
+	}
+	if e.ocf == nil {
+		e.ocf, err = goavro.NewOCFWriter(goavro.OCFConfig{W: e.writer, Codec: codec})
+		if err != nil {
+			return err
+		}
+		e.schemaID = schemaID
+	}
+	native, _, err := codec.NativeFromBinary(message.Value[5:])
+	if err != nil {
+		return fmt.Errorf("failed to decode %s/%d offset %d with schema ID [%d]: %w", message.Topic, message.Partition, message.Offset, schemaID, err)
+	}
+	return e.ocf.Append([]interface{}{native})
+}
+
+func (e *avroEncoder) Flush() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func testSinkRecord(offset int64, key, value string) *sinkRecord {
+	message := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 1,
+		Offset:    offset,
+		Timestamp: time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC),
+		Key:       []byte(key),
+		Value:     []byte(value),
+	}
+	return &sinkRecord{Message: message, Key: key, Value: value}
+}
+
+func readLines(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestSinkFilePath(t *testing.T) {
+	require.Equal(t, "out/orders.jsonl", sinkFilePath("out/orders.jsonl", 0))
+	require.Equal(t, "out/orders.2.jsonl", sinkFilePath("out/orders.jsonl", 2))
+	require.Equal(t, "orders.1", sinkFilePath("orders", 1))
+}
+
+func TestJSONLSinkRotatesByCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+	sink, err := NewRecordSink(path, "json", nil, 0, 2, nil)
+	require.NoError(t, err)
+	for offset := int64(0); offset < 5; offset++ {
+		require.NoError(t, sink.Write(testSinkRecord(offset, "k", `{"id":1}`)))
+	}
+	require.NoError(t, sink.Close())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{"topic":"orders","key":"k","value":{"id":1},"timestamp":"2024-03-01T14:00:00Z","partition":1,"offset":0,"key_schema_id":0,"value_schema_id":0,"headers":{}}`, lines[0])
+	require.Len(t, readLines(t, sinkFilePath(path, 1)), 2)
+	require.Len(t, readLines(t, sinkFilePath(path, 2)), 1)
+	require.NoFileExists(t, sinkFilePath(path, 3))
+}
+
+func TestCSVSinkRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.csv")
+	sink, err := NewRecordSink(path, "csv", []string{"offset", "key", "value.customer.id", "value.missing", "timestamp"}, 100, 0, nil)
+	require.NoError(t, err)
+	for offset := int64(0); offset < 3; offset++ {
+		require.NoError(t, sink.Write(testSinkRecord(offset, "eu,1", `{"customer":{"id":"C-1042"}}`)))
+	}
+	require.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"offset", "key", "value.customer.id", "value.missing", "timestamp"},
+		{"0", "eu,1", "C-1042", "", "2024-03-01T14:00:00Z"},
+		{"1", "eu,1", "C-1042", "", "2024-03-01T14:00:00Z"},
+	}, rows)
+	// The next file starts with the header again
+	lines := readLines(t, sinkFilePath(path, 1))
+	require.Equal(t, []string{"offset,key,value.customer.id,value.missing,timestamp", `2,"eu,1",C-1042,,2024-03-01T14:00:00Z`}, lines)
+
+	_, err = NewRecordSink("", "csv", []string{"valeu.id"}, 0, 0, nil)
+	require.Error(t, err)
+	_, err = NewRecordSink("", "json", nil, 0, 10, nil)
+	require.Error(t, err)
+	_, err = NewRecordSink("", "avro", nil, 0, 0, nil)
+	require.Error(t, err)
+}
+
+func TestAvroSink(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	v1, err := goavro.NewCodec(`{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`)
+	require.NoError(t, err)
+	v2, err := goavro.NewCodec(`{"type":"record","name":"User","fields":[{"name":"name","type":"string"},{"name":"age","type":"int","default":0}]}`)
+	require.NoError(t, err)
+	encode := func(codec *goavro.Codec, schemaID int, native map[string]interface{}) string {
+		data, err := codec.BinaryFromNative(nil, native)
+		require.NoError(t, err)
+		return string(wireFormat(schemaID, data))
+	}
+
+	path := filepath.Join(t.TempDir(), "users.avro")
+	sink, err := NewRecordSink(path, "avro", nil, 0, 0, consumer.SRClient)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(testSinkRecord(0, "", encode(v1, 1, map[string]interface{}{"name": "jane"}))))
+	tombstone := testSinkRecord(1, "", "")
+	tombstone.Message.Value = nil
+	require.NoError(t, sink.Write(tombstone))
+	require.NoError(t, sink.Write(testSinkRecord(2, "", encode(v1, 1, map[string]interface{}{"name": "john"}))))
+	// A new schema starts a new file
+	require.NoError(t, sink.Write(testSinkRecord(3, "", encode(v2, 5, map[string]interface{}{"name": "anna", "age": 41}))))
+	require.Error(t, sink.Write(testSinkRecord(4, "", "not avro")))
+	require.NoError(t, sink.Close())
+
+	readOCF := func(path string) []interface{} {
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		reader, err := goavro.NewOCFReader(file)
+		require.NoError(t, err)
+		var records []interface{}
+		for reader.Scan() {
+			record, err := reader.Read()
+			require.NoError(t, err)
+			records = append(records, record)
+		}
+		return records
+	}
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "jane"}, map[string]interface{}{"name": "john"}}, readOCF(path))
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "anna", "age": int32(41)}}, readOCF(sinkFilePath(path, 1)))
+
+	// Files are only created once a record is written to them, so tombstones don't leave files without a header
+	path = filepath.Join(t.TempDir(), "tombstones.avro")
+	sink, err = NewRecordSink(path, "avro", nil, 0, 1, consumer.SRClient)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(tombstone))
+	require.NoError(t, sink.Write(testSinkRecord(2, "", encode(v1, 1, map[string]interface{}{"name": "john"}))))
+	require.NoError(t, sink.Write(tombstone))
+	require.NoError(t, sink.Close())
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "john"}}, readOCF(path))
+	_, err = os.Stat(sinkFilePath(path, 1))
+	require.True(t, os.IsNotExist(err))
+}
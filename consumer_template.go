@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+Functions available to --record-template templates. Values are the decoded strings of CustomRecordTemplateContext
+and the raw bytes are in KeyBytes and ValueBytes, for binary keys and values.
+*/
+
+var recordTemplateFuncs = template.FuncMap{
+	"jsonPath":   templateJSONPath,
+	"fromJson":   templateFromJSON,
+	"toJson":     templateToJSON,
+	"prettyJson": templatePrettyJSON,
+	"hex":        templateHex,
+	"b64":        templateBase64,
+	"b64dec":     templateBase64Decode,
+	"formatTime": templateFormatTime,
+	"unixMillis": func(t time.Time) int64 { return t.UnixMilli() },
+	"utc":        func(t time.Time) time.Time { return t.UTC() },
+	"default":    templateDefault,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"truncate":   templateTruncate,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+}
+
+// Compiled jsonPath paths. Templates run once per record with the same paths
+var templatePaths sync.Map
+
+// Parse a path in the --filter selector syntax: customer.id, items[0].sku, ["a key"]
+func parseTemplatePath(path string) ([]interface{}, error) {
+	if cached, exists := templatePaths.Load(path); exists {
+		return cached.([]interface{}), nil
+	}
+	source := path
+	if !strings.HasPrefix(source, ".") && !strings.HasPrefix(source, "[") {
+		source = "." + source
+	}
+	tokens, err := lexFilter(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	parser := filterParser{tokens: tokens}
+	selectors, err := parser.parseSelectors("path")
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	if token := parser.peek(); token.kind != filterTokenEnd {
+		return nil, fmt.Errorf("invalid path %q: unexpected %q", path, token.text)
+	}
+	templatePaths.Store(path, selectors)
+	return selectors, nil
+}
+
+// Select a field out of JSON data. Data is a string or bytes holding JSON, or already parsed JSON. Nil if there is no such field or data is not JSON
+func templateJSONPath(path string, data interface{}) (interface{}, error) {
+	selectors, err := parseTemplatePath(path)
+	if err != nil {
+		return nil, err
+	}
+	value, isJSON := templateJSONValue(data)
+	if !isJSON {
+		return nil, nil
+	}
+	for _, selector := range selectors {
+		value = selectFilterValue(value, selector)
+	}
+	return value, nil
+}
+
+/*
+JSON in strings and bytes is parsed, with numbers as json.Number so they print as they were written.
+Anything else is returned as it is. False if a string or bytes don't hold JSON, like tombstones and plain text
+*/
+func templateJSONValue(data interface{}) (interface{}, bool) {
+	var raw string
+	switch typed := data.(type) {
+	case string:
+		raw = typed
+	case []byte:
+		raw = string(typed)
+	default:
+		return data, true
+	}
+	parsed, err := decodeJSONNumbers(raw)
+	if err != nil {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// Parsed JSON, or nil if data is not JSON
+func templateFromJSON(data interface{}) interface{} {
+	parsed, _ := templateJSONValue(data)
+	return parsed
+}
+
+// Strings and bytes holding JSON are encoded as the JSON they hold, other strings as JSON strings
+func templateJSONData(value interface{}) interface{} {
+	switch value.(type) {
+	case string, []byte:
+		if parsed, isJSON := templateJSONValue(value); isJSON {
+			return parsed
+		}
+		if raw, ok := value.([]byte); ok {
+			return string(raw)
+		}
+	}
+	return value
+}
+
+func templateToJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(templateJSONData(value))
+	return string(encoded), err
+}
+
+func templatePrettyJSON(value interface{}) (string, error) {
+	encoded, err := json.MarshalIndent(templateJSONData(value), "", "  ")
+	return string(encoded), err
+}
+
+func templateBytes(value interface{}) []byte {
+	switch typed := value.(type) {
+	case []byte:
+		return typed
+	case string:
+		return []byte(typed)
+	}
+	return []byte(fmt.Sprint(value))
+}
+
+func templateHex(value interface{}) string {
+	return hex.EncodeToString(templateBytes(value))
+}
+
+func templateBase64(value interface{}) string {
+	return base64.StdEncoding.EncodeToString(templateBytes(value))
+}
+
+func templateBase64Decode(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	return string(decoded), err
+}
+
+// Named layouts for formatTime. Anything else is a Go time layout
+var templateTimeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+func templateFormatTime(layout string, t time.Time) string {
+	if named, exists := templateTimeLayouts[layout]; exists {
+		layout = named
+	}
+	return t.Format(layout)
+}
+
+// The value, or def if the value is missing or empty
+func templateDefault(def, value interface{}) interface{} {
+	switch typed := value.(type) {
+	case nil:
+		return def
+	case string:
+		if typed == "" {
+			return def
+		}
+	case []byte:
+		if len(typed) == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// The first length characters of s, with "..." if it was cut
+func templateTruncate(length int, s string) string {
+	runes := []rune(s)
+	if length < 0 || len(runes) <= length {
+		return s
+	}
+	return string(runes[:length]) + "..."
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func renderRecordTemplate(t *testing.T, text string, context CustomRecordTemplateContext) string {
+	tmpl, err := template.New("test").Funcs(recordTemplateFuncs).Parse(text)
+	require.NoError(t, err)
+	var output bytes.Buffer
+	require.NoError(t, tmpl.Execute(&output, context))
+	return output.String()
+}
+
+func TestRecordTemplateFuncs(t *testing.T) {
+	context := CustomRecordTemplateContext{
+		Topic:      "orders",
+		Key:        "\x01\xff",
+		Value:      `{"customer":{"id":"C-1042","name":"  Ada  "},"total":99.5,"items":[{"sku":"SKU-1"},{"sku":"SKU-22"}]}`,
+		Timestamp:  time.Date(2024, 3, 1, 14, 5, 0, 0, time.FixedZone("EET", 2*3600)),
+		KeyBytes:   []byte{1, 255},
+		ValueBytes: []byte(`{"total":99.5}`),
+		Headers:    map[string]string{"trace-id": "abc"},
+	}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{jsonPath "customer.id" .Value}}`, "C-1042"},
+		{`{{jsonPath ".items[1].sku" .Value}}`, "SKU-22"},
+		{`{{jsonPath "total" .Value}}`, "99.5"},
+		{`{{jsonPath "customer" .Value | toJson}}`, `{"id":"C-1042","name":"  Ada  "}`},
+		{`{{jsonPath "missing" .Value | default "none"}}`, "none"},
+		{`{{jsonPath "total" .ValueBytes}}`, "99.5"},
+		{`{{with fromJson .Value}}{{.customer.id}}{{end}}`, "C-1042"},
+		{`{{toJson .ValueBytes}}`, `{"total":99.5}`},
+		{`{{toJson "plain"}}`, `"plain"`},
+		{`{{prettyJson .ValueBytes}}`, "{\n  \"total\": 99.5\n}"},
+		{`{{hex .KeyBytes}}`, "01ff"},
+		{`{{b64 .KeyBytes}}`, "Af8="},
+		{`{{b64 "hi" | b64dec}}`, "hi"},
+		{`{{formatTime "RFC3339" .Timestamp}}`, "2024-03-01T14:05:00+02:00"},
+		{`{{.Timestamp | utc | formatTime "2006-01-02 15:04"}}`, "2024-03-01 12:05"},
+		{`{{unixMillis .Timestamp}}`, "1709294700000"},
+		{`{{default "none" .Headers.missing}}`, "none"},
+		{`{{index .Headers "trace-id" | upper}}`, "ABC"},
+		{`{{jsonPath "customer.name" .Value | trim | lower}}`, "ada"},
+		{`{{truncate 10 .Value}}`, `{"customer...`},
+		{`{{truncate 10 .Topic}}`, "orders"},
+		{`{{replace "o" "0" .Topic}}`, "0rders"},
+		{`{{if contains "der" .Topic}}yes{{end}}`, "yes"},
+		{`{{if hasPrefix "ord" .Topic}}yes{{end}}{{if hasSuffix "x" .Topic}}no{{end}}`, "yes"},
+		{`{{split "-" "a-b-c" | join ","}}`, "a,b,c"},
+		// Numbers print as they were written
+		{`{{jsonPath "id" "{\"id\":12345678}"}}`, "12345678"},
+		{`{{jsonPath "id" "{\"id\":9007199254740993}" | toJson}}`, "9007199254740993"},
+		// Values that are not JSON, like tombstones, have no fields
+		{`{{jsonPath "a" .Topic | default "-"}}`, "-"},
+		{`{{jsonPath "id" "Null (Tombstone?)" | default "deleted"}}`, "deleted"},
+		{`{{with fromJson .Topic}}{{.id}}{{else}}none{{end}}`, "none"},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, renderRecordTemplate(t, test.template, context), test.template)
+	}
+
+	// Invalid paths fail the template
+	for _, text := range []string{`{{jsonPath "a..b" .Value}}`, `{{b64dec "!"}}`} {
+		tmpl, err := template.New("test").Funcs(recordTemplateFuncs).Parse(text)
+		require.NoError(t, err)
+		require.Error(t, tmpl.Execute(&bytes.Buffer{}, context), text)
+	}
+}
+
+func TestPrintRecordWithCustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record.tpl")
+	require.NoError(t, os.WriteFile(path, []byte(`{{.Offset}} {{hex .KeyBytes}} {{jsonPath "id" .Value}}`+"\n"), 0644))
+	var output bytes.Buffer
+	consumer := &Consumer{customRecordTemplate: loadTemplate(path), serializingWriter: &lockedWriter{writer: &output}}
+	message := &sarama.ConsumerMessage{Topic: "orders", Offset: 7, Key: []byte{0xca, 0xfe}, Value: []byte(`{"id":42}`)}
+	consumer.printRecordWithCustomTemplate(message, "key", string(message.Value), 0, 0)
+	require.Equal(t, "7 cafe 42\n", output.String())
+}
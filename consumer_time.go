@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+/*
+Consuming a time range. The start time is resolved to an offset per partition with the broker's time index.
+The end time is resolved the same way to the first offset past the range, and each partition stops there.
+When the broker has no record past the end time yet, a partition stops at the first record timestamped after it.
+*/
+
+// Parse a time argument: RFC3339, "now", or relative to now like -15m or -1h30m
+func parseTimeArg(arg string, now time.Time) (time.Time, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+") {
+		duration, err := time.ParseDuration(arg)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %s: %w", arg, err)
+		}
+		return now.Add(duration), nil
+	}
+	parsed, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s. Expected RFC3339 (2006-01-02T15:04:05Z) or relative (-15m): %w", arg, err)
+	}
+	return parsed, nil
+}
+
+// Offset getter, as implemented by sarama.Client
+type offsetGetter interface {
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+	Partitions(topic string) ([]int32, error)
+}
+
+// Oldest and newest offsets of a partition
+func partitionOffsetRange(client offsetGetter, topic string, partition int32) (int64, int64, error) {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get oldest offset of %s/%d: %w", topic, partition, err)
+	}
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get newest offset of %s/%d: %w", topic, partition, err)
+	}
+	return oldest, newest, nil
+}
+
+/*
+Resolve a time range to offsets for all partitions of the topics. A zero from or until leaves that side open:
+Start is then -1 (the group's offset is used) and End -1.
+*/
+func resolveTimeBounds(client offsetGetter, topics []string, from, until, now time.Time) (map[string]map[int32]partitionBounds, error) {
+	topicBounds := make(map[string]map[int32]partitionBounds)
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partitions of %s: %w", topic, err)
+		}
+		topicBounds[topic] = make(map[int32]partitionBounds)
+		for _, partition := range partitions {
+			oldest, newest, err := partitionOffsetRange(client, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			bounds := partitionBounds{Start: -1, End: -1, Oldest: oldest}
+			if !from.IsZero() {
+				bounds.Start, err = offsetForTime(client, topic, partition, from, newest)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !until.IsZero() {
+				bounds.End, err = offsetForTime(client, topic, partition, until, newest)
+				if err != nil {
+					return nil, err
+				}
+				// Nothing was written after the end time yet. If that is in the future, more records in the range may come
+				if bounds.End == newest && until.After(now) {
+					bounds.End = -1
+				}
+			}
+			topicBounds[topic][partition] = bounds
+		}
+	}
+	return topicBounds, nil
+}
+
+// First offset with a timestamp at or after t. The newest offset if there is none
+func offsetForTime(client offsetGetter, topic string, partition int32, t time.Time, newest int64) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, t.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset of %s/%d for %s: %w", topic, partition, t.Format(time.RFC3339), err)
+	}
+	if offset < 0 {
+		return newest, nil
+	}
+	return offset, nil
+}
+
+// Consume only records in the time range. Must be called before Consume
+func (c *Consumer) SetTimeRange(from, until time.Time) error {
+	now := time.Now()
+	if !from.IsZero() && !until.IsZero() && !until.After(from) {
+		return fmt.Errorf("end time %s is not after start time %s", until.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	bounds, err := resolveTimeBounds(c.Client, c.Topics, from, until, now)
+	if err != nil {
+		return err
+	}
+	c.untilTime = until
+	c.mergeBounds(bounds)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	parsed, err := parseTimeArg("-15m", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-15*time.Minute), parsed)
+	parsed, err = parseTimeArg("-1h30m", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-90*time.Minute), parsed)
+	parsed, err = parseTimeArg("2024-03-01T14:02:00+02:00", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 3, 1, 12, 2, 0, 0, time.UTC), parsed.UTC())
+	parsed, err = parseTimeArg("now", now)
+	require.NoError(t, err)
+	require.Equal(t, now, parsed)
+	_, err = parseTimeArg("14:02", now)
+	require.Error(t, err)
+	_, err = parseTimeArg("-15x", now)
+	require.Error(t, err)
+}
+
+// Records of a partition with their timestamps in milliseconds, starting at offset 0
+type fakeOffsetGetter map[int32][]int64
+
+func (f fakeOffsetGetter) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	for partition := range f {
+		partitions = append(partitions, partition)
+	}
+	return partitions, nil
+}
+
+func (f fakeOffsetGetter) GetOffset(topic string, partition int32, timestamp int64) (int64, error) {
+	records := f[partition]
+	switch timestamp {
+	case sarama.OffsetNewest:
+		return int64(len(records)), nil
+	case sarama.OffsetOldest:
+		return 0, nil
+	}
+	for offset, recordTime := range records {
+		if recordTime >= timestamp {
+			return int64(offset), nil
+		}
+	}
+	return -1, nil
+}
+
+func TestResolveTimeBounds(t *testing.T) {
+	base := time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC)
+	at := func(minutes int) int64 { return base.Add(time.Duration(minutes) * time.Minute).UnixMilli() }
+	client := fakeOffsetGetter{
+		0: {at(0), at(3), at(5), at(9), at(12), at(70)}, // Timestamps may be in the future
+		1: {at(1), at(2)},
+	}
+	now := base.Add(time.Hour)
+	bounds, err := resolveTimeBounds(client, []string{"orders"}, base.Add(2*time.Minute), base.Add(10*time.Minute), now)
+	require.NoError(t, err)
+	require.Equal(t, partitionBounds{Start: 1, End: 4}, bounds["orders"][0])
+	// Nothing after the end time: stop at the newest offset
+	require.Equal(t, partitionBounds{Start: 1, End: 2}, bounds["orders"][1])
+
+	// An end time in the future is not known yet
+	bounds, err = resolveTimeBounds(client, []string{"orders"}, time.Time{}, now.Add(time.Minute), now)
+	require.NoError(t, err)
+	require.Equal(t, partitionBounds{Start: -1, End: 5}, bounds["orders"][0])
+	require.Equal(t, partitionBounds{Start: -1, End: -1}, bounds["orders"][1])
+}
+
+func TestTimeRangeEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	until := time.Date(2024, 3, 1, 14, 10, 0, 0, time.UTC)
+	consumer := &Consumer{
+		cancel:         cancel,
+		untilTime:      until,
+		donePartitions: make(map[string]map[int32]bool),
+		bounds: map[string]map[int32]partitionBounds{
+			"orders": {0: {Start: 1, End: 4}, 1: {Start: -1, End: -1}},
+		},
+	}
+	require.False(t, consumer.pastEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 3}))
+	require.True(t, consumer.lastBeforeEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 3}))
+	require.True(t, consumer.pastEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 4}))
+	// Without an end offset the record timestamp is used
+	require.False(t, consumer.pastEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 9, Timestamp: until}))
+	require.True(t, consumer.pastEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 9, Timestamp: until.Add(time.Second)}))
+	require.False(t, consumer.lastBeforeEnd(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 9}))
+
+	consumer.markPartitionDone("orders", 0)
+	require.NoError(t, ctx.Err())
+	consumer.markPartitionDone("orders", 1)
+	require.Error(t, ctx.Err())
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/getsops/sops/v3"
 	"github.com/getsops/sops/v3/decrypt"
@@ -12,18 +15,39 @@ import (
 // This represents the desired state that the user asked for
 // It a merge of all individual input files
 type DesiredState struct {
-	Topics       map[string]Topic
-	Clients      map[string]Client
-	Connectors   map[string]Connector
-	ClusterLinks map[string]ClusterLink
+	Topics          map[string]Topic
+	Subjects        map[string]Schema // Standalone subjects, not tied to a topic
+	SchemaRegistry  SchemaRegistrySettings
+	SchemaExporters map[string]SchemaExporter
+	Clients         map[string]Client
+	Connectors      map[string]Connector
+	ClusterLinks    map[string]ClusterLink
 }
 
 // This is the input Yaml file schema
 type InputYaml struct {
-	Topics       []Topic       `yaml:"topics"`
-	Clients      []Client      `yaml:"clients"`
-	Connectors   []Connector   `yaml:"connectors"`
-	ClusterLinks []ClusterLink `yaml:"clusterlinks"`
+	Topics          []Topic                `yaml:"topics"`
+	Subjects        []Schema               `yaml:"subjects"`
+	SchemaRegistry  SchemaRegistrySettings `yaml:"schema_registry"`
+	SchemaExporters []SchemaExporter       `yaml:"schema_exporters"`
+	Clients         []Client               `yaml:"clients"`
+	Connectors      []Connector            `yaml:"connectors"`
+	ClusterLinks    []ClusterLink          `yaml:"clusterlinks"`
+}
+
+// Read the schema files of the input, from an input YAML in directory inputDir, and name the subjects of topics
+func (input *InputYaml) resolveSchemas(inputDir string) error {
+	for i := range input.Topics {
+		if err := input.Topics[i].resolveSchemas(inputDir); err != nil {
+			return err
+		}
+	}
+	for i := range input.Subjects {
+		if err := input.Subjects[i].loadSchemaFile(inputDir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (state *DesiredState) mergeInput(data *InputYaml) error {
@@ -34,6 +58,63 @@ func (state *DesiredState) mergeInput(data *InputYaml) error {
 		}
 		state.Topics[topic.Name] = topic
 	}
+	for _, subject := range data.Subjects {
+		if subject.SubjectName == "" {
+			log.Fatalf("Standalone subject definition without a subject name: %+v", subject)
+		}
+		if _, exists := state.Subjects[subject.SubjectName]; exists {
+			log.Fatalf("Duplicate definition for subject %s", subject.SubjectName)
+		}
+		state.Subjects[subject.SubjectName] = subject
+	}
+	if data.SchemaRegistry.Mode != "" {
+		if !isValidSchemaMode(data.SchemaRegistry.Mode) {
+			log.Fatalf("Unknown schema registry mode %s", data.SchemaRegistry.Mode)
+		}
+		if state.SchemaRegistry.Mode != "" && !strings.EqualFold(state.SchemaRegistry.Mode, data.SchemaRegistry.Mode) {
+			log.Fatalf("Conflicting schema registry modes %s and %s", state.SchemaRegistry.Mode, data.SchemaRegistry.Mode)
+		}
+		state.SchemaRegistry.Mode = strings.ToUpper(data.SchemaRegistry.Mode)
+	}
+	if data.SchemaRegistry.RetainVersions != 0 {
+		if state.SchemaRegistry.RetainVersions != 0 && state.SchemaRegistry.RetainVersions != data.SchemaRegistry.RetainVersions {
+			log.Fatalf("Conflicting schema registry retain_versions %d and %d", state.SchemaRegistry.RetainVersions, data.SchemaRegistry.RetainVersions)
+		}
+		state.SchemaRegistry.RetainVersions = data.SchemaRegistry.RetainVersions
+	}
+	state.SchemaRegistry.HardDelete = state.SchemaRegistry.HardDelete || data.SchemaRegistry.HardDelete
+	if orphans := data.SchemaRegistry.OrphanSubjects; orphans.Policy != "" {
+		if !isValidOrphanPolicy(orphans.Policy) {
+			log.Fatalf("Unknown orphan_subjects policy %s", orphans.Policy)
+		}
+		if orphans.Policy != OrphanKeep && len(orphans.Prefixes) == 0 {
+			log.Fatalf("orphan_subjects policy %s needs prefixes, to limit it to subjects gafkalo manages", orphans.Policy)
+		}
+		if state.SchemaRegistry.OrphanSubjects.Policy != "" {
+			log.Fatalf("Duplicate definition of orphan_subjects")
+		}
+		state.SchemaRegistry.OrphanSubjects = orphans
+	}
+	for _, context := range data.SchemaRegistry.Contexts {
+		if !isValidSchemaContext(context.Name) {
+			log.Fatalf("Invalid schema registry context name %s. Context names start with a dot", context.Name)
+		}
+		if context.Mode != "" && !isValidSchemaMode(context.Mode) {
+			log.Fatalf("Unknown mode %s for context %s", context.Mode, context.Name)
+		}
+		for _, existing := range state.SchemaRegistry.Contexts {
+			if existing.Name == context.Name {
+				log.Fatalf("Duplicate definition for context %s", context.Name)
+			}
+		}
+		state.SchemaRegistry.Contexts = append(state.SchemaRegistry.Contexts, context)
+	}
+	for _, exporter := range data.SchemaExporters {
+		if _, exists := state.SchemaExporters[exporter.Name]; exists {
+			log.Fatalf("Duplicate definition for schema exporter %s", exporter.Name)
+		}
+		state.SchemaExporters[exporter.Name] = exporter
+	}
 	// We don't do deduplication fore clients because its normal to have multiple definitions. We merge the rules in one big object for each principal
 	for _, client := range data.Clients {
 		if _, exists := state.Clients[client.Principal]; exists {
@@ -61,12 +142,27 @@ func (state *DesiredState) mergeInput(data *InputYaml) error {
 	return nil
 }
 
+// Standalone subjects must not redefine a subject that a topic already derives.
+// Only possible to check once all input files are merged, as topics and subjects can live in different files
+func (state *DesiredState) checkSubjectConflicts() error {
+	for _, topic := range state.Topics {
+		for _, schema := range topic.Schemas() {
+			if _, exists := state.Subjects[schema.SubjectName]; exists {
+				return fmt.Errorf("subject %s is defined both as a standalone subject and by topic %s", schema.SubjectName, topic.Name)
+			}
+		}
+	}
+	return nil
+}
+
 func Parse(inputFiles []string) DesiredState {
 	desiredState := DesiredState{
-		Topics:       make(map[string]Topic),
-		Clients:      make(map[string]Client, 20),
-		Connectors:   make(map[string]Connector),
-		ClusterLinks: make(map[string]ClusterLink),
+		Topics:          make(map[string]Topic),
+		Subjects:        make(map[string]Schema),
+		SchemaExporters: make(map[string]SchemaExporter),
+		Clients:         make(map[string]Client, 20),
+		Connectors:      make(map[string]Connector),
+		ClusterLinks:    make(map[string]ClusterLink),
 	}
 	for _, filename := range inputFiles {
 		log.Debugf("Processing YAML file %s", filename)
@@ -88,11 +184,18 @@ func Parse(inputFiles []string) DesiredState {
 		if err != nil {
 			log.Fatalf("unable to unmarshal yaml with error %s\n", err)
 		}
+		// Relative schema paths are resolved against the directory of the file
+		if err := inputdata.resolveSchemas(filepath.Dir(filename)); err != nil {
+			log.Fatalf("%s: %s", filename, err)
+		}
 		err = desiredState.mergeInput(&inputdata)
 		if err != nil {
 			log.Fatalf("Failed to merge topic data: %s\n", err)
 		}
 	}
+	if err := desiredState.checkSubjectConflicts(); err != nil {
+		log.Fatal(err)
+	}
 
 	return desiredState
 }
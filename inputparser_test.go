@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestDesiredState() DesiredState {
+	return DesiredState{
+		Topics:          make(map[string]Topic),
+		Subjects:        make(map[string]Schema),
+		SchemaExporters: make(map[string]SchemaExporter),
+		Clients:         make(map[string]Client),
+		Connectors:      make(map[string]Connector),
+		ClusterLinks:    make(map[string]ClusterLink),
+	}
+}
+
+func TestMergeInputSubjects(t *testing.T) {
+	gafkaloConfig.Kafkalo.SchemaDir = "testdata/files/data"
+	defer func() { gafkaloConfig.Kafkalo.SchemaDir = "" }()
+
+	data := `
+subjects:
+  - subject: com.example.Address
+    schema: schemas/schema-key.json
+    compatibility: FULL
+  - subject: com.example.User
+    schema: schemas/schema.json
+    schema_type: AVRO
+    references:
+      - name: com.example.Address
+        subject: com.example.Address
+        version: 1
+`
+	var input InputYaml
+	require.NoError(t, yaml.Unmarshal([]byte(data), &input))
+	require.NoError(t, input.resolveSchemas(""))
+	state := newTestDesiredState()
+	require.NoError(t, state.mergeInput(&input))
+	require.Len(t, state.Subjects, 2)
+
+	user := state.Subjects["com.example.User"]
+	require.Equal(t, "com.example.User", user.SubjectName)
+	require.NotEmpty(t, user.SchemaData)
+	require.Len(t, user.References, 1)
+	require.Equal(t, "com.example.Address", user.References[0].Subject)
+	require.Equal(t, 1, user.References[0].Version)
+	require.Equal(t, "FULL", state.Subjects["com.example.Address"].Compatibility)
+}
+
+func TestCheckSubjectConflicts(t *testing.T) {
+	state := newTestDesiredState()
+	state.Topics["users"] = Topic{Name: "users", Value: Schema{SubjectName: "users-value", SchemaData: "{}"}}
+	state.Subjects["com.example.Address"] = Schema{SubjectName: "com.example.Address"}
+	require.NoError(t, state.checkSubjectConflicts())
+
+	state.Subjects["users-value"] = Schema{SubjectName: "users-value"}
+	require.Error(t, state.checkSubjectConflicts())
+}
+
+func TestMergeInputSchemaRegistrySettings(t *testing.T) {
+	data := `
+schema_registry:
+  mode: readonly
+  contexts:
+    - name: .tenant
+      mode: READWRITE
+    - name: .other
+`
+	var input InputYaml
+	require.NoError(t, yaml.Unmarshal([]byte(data), &input))
+	state := newTestDesiredState()
+	require.NoError(t, state.mergeInput(&input))
+	require.Equal(t, "READONLY", state.SchemaRegistry.Mode)
+	require.Equal(t, []SchemaContext{{Name: ".tenant", Mode: "READWRITE"}, {Name: ".other"}}, state.SchemaRegistry.Contexts)
+
+	// The same global mode may be repeated across files
+	var again InputYaml
+	require.NoError(t, yaml.Unmarshal([]byte("schema_registry:\n  mode: READONLY\n"), &again))
+	require.NoError(t, state.mergeInput(&again))
+}
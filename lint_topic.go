@@ -4,7 +4,9 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
 )
 import _ "embed"
@@ -22,6 +24,7 @@ type LintResult struct {
 	Severity string //
 	Message  string // The problem
 	Topic    string // Which topic
+	Subject  string // Which subject, for schema rules
 	Hint     string // Propose a solution
 }
 
@@ -95,6 +98,63 @@ func LintRuleMinIsr(topic Topic) (*LintResult, bool) {
 	return res, false
 }
 
+/*
+Lint the data contract of a schema: every required tag must be declared, either as a metadata property
+(like owner) or as a tag on a field (like PII). Names are compared case insensitively
+*/
+func LintSchemaTags(schema Schema, required []string) (*LintResult, bool) {
+	res := &LintResult{Subject: schema.SubjectName}
+	declared := make(map[string]bool)
+	if schema.Metadata != nil {
+		for name := range schema.Metadata.Properties {
+			declared[strings.ToLower(name)] = true
+		}
+		for _, tags := range schema.Metadata.Tags {
+			for _, tag := range tags {
+				declared[strings.ToLower(tag)] = true
+			}
+		}
+	}
+	var missing []string
+	for _, tag := range required {
+		if !declared[strings.ToLower(tag)] {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) == 0 {
+		return res, false
+	}
+	res.Message = fmt.Sprintf("schema metadata is missing required tags: %s", strings.Join(missing, ","))
+	res.Severity = LINT_ERROR
+	res.Hint = "Declare them under metadata, as properties (owner) or as field tags (PII)"
+	return res, true
+}
+
+// Lint all schemas of the input: standalone subjects and topic schemas. Schemas without a schema file are not linted
+func LintSchemas(topics map[string]Topic, subjects map[string]Schema, requiredTags []string) []LintResult {
+	var results []LintResult
+	if len(requiredTags) == 0 {
+		return results
+	}
+	var schemas []Schema
+	for _, schema := range subjects {
+		schemas = append(schemas, schema)
+	}
+	for _, topic := range topics {
+		schemas = append(schemas, topic.Schemas()...)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].SubjectName < schemas[j].SubjectName })
+	for _, schema := range schemas {
+		if schema.SchemaData == "" {
+			continue
+		}
+		if res, hasRes := LintSchemaTags(schema, requiredTags); hasRes {
+			results = append(results, *res)
+		}
+	}
+	return results
+}
+
 type LintTemplateContext struct {
 	LintResults []LintResult
 }
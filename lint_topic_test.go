@@ -76,3 +76,24 @@ func TestLintRuleMinIsr(t *testing.T) {
 		t.Errorf("Found LintResult when it should not for topic [%v] res=[%v]", topic, res)
 	}
 }
+
+func TestLintSchemaTags(t *testing.T) {
+	schema := Schema{SubjectName: "orders-value", SchemaData: `"string"`, Metadata: &SchemaMetadata{
+		Properties: map[string]string{"Owner": "team-orders"},
+		Tags:       map[string][]string{"Order.email": {"PII"}},
+	}}
+	res, hasRes := LintSchemaTags(schema, []string{"owner", "pii"})
+	if hasRes {
+		t.Errorf("LintSchemaTags found Lint results [%v] when all tags are declared", res)
+	}
+	schema.Metadata.Tags = nil
+	res, hasRes = LintSchemaTags(schema, []string{"owner", "pii"})
+	if !hasRes || res.Severity != LINT_ERROR || !strings.Contains(res.Message, "pii") {
+		t.Errorf("LintSchemaTags should report the missing pii tag. Got [%v]", res)
+	}
+	topic := Topic{Name: "users", Value: Schema{SubjectName: "users-value", SchemaData: `"string"`}}
+	results := LintSchemas(map[string]Topic{"users": topic}, map[string]Schema{"orders-value": schema}, []string{"owner"})
+	if len(results) != 1 || results[0].Subject != "users-value" {
+		t.Errorf("LintSchemas should only report users-value. Got [%v]", results)
+	}
+}
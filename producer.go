@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/IBM/sarama"
+	"github.com/bufbuild/protocompile/linker"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	//"github.com/fatih/color"
 
@@ -15,10 +25,11 @@ import (
 type Producer struct {
 	Client   sarama.SyncProducer
 	SRClient *srclient.SchemaRegistryClient
+	brokers  []string
+	config   *sarama.Config // Used to create async producers for bulk input
 }
 
-func NewProducer(kConf KafkaConfig, srConf *SRConfig, acks sarama.RequiredAcks, idempotent bool) *Producer {
-	var newProducer Producer
+func newProducerConfig(kConf KafkaConfig, acks sarama.RequiredAcks, idempotent bool) *sarama.Config {
 	kafkaConf := SaramaConfigFromKafkaConfig(kConf)
 	kafkaConf.Producer.RequiredAcks = acks
 	kafkaConf.Producer.Partitioner = sarama.NewReferenceHashPartitioner // Use the reference partitioner that is compatible with Java partitioner implementation
@@ -27,6 +38,12 @@ func NewProducer(kConf KafkaConfig, srConf *SRConfig, acks sarama.RequiredAcks,
 	if idempotent {
 		kafkaConf.Net.MaxOpenRequests = 1
 	}
+	return kafkaConf
+}
+
+func NewProducer(kConf KafkaConfig, srConf *SRConfig, acks sarama.RequiredAcks, idempotent bool) *Producer {
+	var newProducer Producer
+	kafkaConf := newProducerConfig(kConf, acks, idempotent)
 	if srConf != nil {
 		newProducer.SRClient = srclient.CreateSchemaRegistryClient(srConf.Url)
 		if srConf.Username != "" && srConf.Password != "" {
@@ -38,29 +55,150 @@ func NewProducer(kConf KafkaConfig, srConf *SRConfig, acks sarama.RequiredAcks,
 		log.Fatal(err)
 	}
 	newProducer.Client = producer
+	newProducer.brokers = kConf.Brokers
+	// A config of its own, as async producers change the partitioner
+	newProducer.config = newProducerConfig(kConf, acks, idempotent)
 	return &newProducer
 }
 
-// From a given srclient Schema object, return a binary record to push to kafka.
-func makeBinaryRecordUsingSchema(data string, schema *srclient.Schema) []byte {
-	var resp []byte
+/*
+From a given srclient Schema object, return a binary record to push to kafka.
+Data is JSON: the Avro JSON encoding for AVRO schemas, the payload for JSON schemas and the Protobuf JSON mapping
+of the first message of the schema for PROTOBUF schemas. Data that doesn't match the schema is an error.
+References of PROTOBUF schemas are fetched with client.
+*/
+func makeBinaryRecordUsingSchema(client *srclient.SchemaRegistryClient, data string, schema *srclient.Schema) ([]byte, error) {
+	resp := wireFormatHeader(schema.ID())
 
-	schemaIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(schemaIDBytes, uint32(schema.ID()))
-	valueAvroNative, _, err := schema.Codec().NativeFromTextual([]byte(data))
+	schemaType := srclient.Avro
+	if schema.SchemaType() != nil && *schema.SchemaType() != "" {
+		schemaType = *schema.SchemaType()
+	}
+	switch schemaType {
+	case srclient.Json:
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, []byte(data)); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		return append(resp, compacted.Bytes()...), nil
+	case srclient.Protobuf:
+		file, err := producerProtobufFile(client, schema)
+		if err != nil {
+			return nil, err
+		}
+		return makeProtobufRecord(schema.ID(), file.Messages().Get(0), data)
+	}
+	codec := schema.Codec()
+	if codec == nil {
+		return nil, fmt.Errorf("schema ID [%d] is not a valid AVRO schema", schema.ID())
+	}
+	valueAvroNative, _, err := codec.NativeFromTextual([]byte(data))
 	if err != nil {
-		log.Fatalf("NativeFromTextual failed: %s\n", err)
+		return nil, fmt.Errorf("invalid AVRO JSON payload: %w", err)
 	}
 	// Get a byte array from the Avro native textual representation
-	valueBytes, err := schema.Codec().BinaryFromNative(nil, valueAvroNative)
+	valueBytes, err := codec.BinaryFromNative(nil, valueAvroNative)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	resp = append(resp, byte(0))
-	resp = append(resp, schemaIDBytes...)
 	resp = append(resp, valueBytes...)
-	return resp
+	return resp, nil
+}
+
+// The magic byte and schema ID that start the payloads of the schema registry wire format
+func wireFormatHeader(schemaID int) []byte {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(schemaID))
+	return header
+}
+
+// Serialize the Protobuf JSON mapping of a message of type descriptor, from the schema with ID schemaID
+func makeProtobufRecord(schemaID int, descriptor protoreflect.MessageDescriptor, data string) ([]byte, error) {
+	message := dynamicpb.NewMessage(descriptor)
+	if err := protojson.Unmarshal([]byte(data), message); err != nil {
+		return nil, fmt.Errorf("invalid Protobuf JSON payload: %w", err)
+	}
+	valueBytes, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	resp := append(wireFormatHeader(schemaID), protobufMessageIndexes(descriptor)...)
+	return append(resp, valueBytes...), nil
+}
+
+/*
+The message indexes of a message type: the path to it through the messages of its file and their nested messages,
+as readMessageIndexes reads them. The first message of the file is a single 0
+*/
+func protobufMessageIndexes(descriptor protoreflect.MessageDescriptor) []byte {
+	var path []int
+	for message, ok := descriptor, true; ok; message, ok = message.Parent().(protoreflect.MessageDescriptor) {
+		path = append([]int{message.Index()}, path...)
+	}
+	if len(path) == 1 && path[0] == 0 {
+		return []byte{0}
+	}
+	indexes := binary.AppendVarint(nil, int64(len(path)))
+	for _, index := range path {
+		indexes = binary.AppendVarint(indexes, int64(index))
+	}
+	return indexes
+}
+
+// A message of a Protobuf schema by its full name, or its name in the package of the schema. The first message if name is empty
+func protobufMessageByName(file linker.File, name string) (protoreflect.MessageDescriptor, error) {
+	if name == "" {
+		return file.Messages().Get(0), nil
+	}
+	fullName := protoreflect.FullName(name)
+	if file.Package() != "" && !strings.HasPrefix(name, string(file.Package())+".") {
+		fullName = protoreflect.FullName(string(file.Package()) + "." + name)
+	}
+	if descriptor := findProtobufMessage(file.Messages(), fullName); descriptor != nil {
+		return descriptor, nil
+	}
+	return nil, fmt.Errorf("message %s not found in schema %s", name, file.Path())
+}
+
+func findProtobufMessage(messages protoreflect.MessageDescriptors, fullName protoreflect.FullName) protoreflect.MessageDescriptor {
+	for i := 0; i < messages.Len(); i++ {
+		message := messages.Get(i)
+		if message.FullName() == fullName {
+			return message
+		}
+		if nested := findProtobufMessage(message.Messages(), fullName); nested != nil {
+			return nested
+		}
+	}
+	return nil
+}
+
+// Compiled Protobuf schemas by ID, to serialize records
+var producerProtobufFiles sync.Map
+
+// Compile a Protobuf schema once. References are fetched with client. Schemas without references don't need a client
+func producerProtobufFile(client *srclient.SchemaRegistryClient, schema *srclient.Schema) (linker.File, error) {
+	if file, exists := producerProtobufFiles.Load(schema.ID()); exists {
+		return file.(linker.File), nil
+	}
+	imports := make(map[string]string)
+	if len(schema.References()) > 0 {
+		if client == nil {
+			return nil, fmt.Errorf("schema ID [%d] has references that were not resolved", schema.ID())
+		}
+		if err := resolveSchemaReferences(client, schema.References(), imports); err != nil {
+			return nil, err
+		}
+	}
+	file, err := compileProtobufSchema(schema.Schema(), imports)
+	if err != nil {
+		return nil, err
+	}
+	if file.Messages().Len() == 0 {
+		return nil, fmt.Errorf("schema ID [%d] has no messages", schema.ID())
+	}
+	producerProtobufFiles.Store(schema.ID(), file)
+	return file, nil
 }
 
 func (c *Producer) GetOrCreateSchema(topic, schemaData string, format srclient.SchemaType, isKey bool) (*srclient.Schema, error) {
@@ -90,23 +228,20 @@ func (c *Producer) GetSerializedPayload(topic, data, schemaPath string, format s
 		if err != nil {
 			return resp, err
 		}
-		schema, err := c.GetOrCreateSchema(topic, string(schemaData), "AVRO", false)
-		if err != nil {
-			return resp, err
-		}
-		resp = makeBinaryRecordUsingSchema(data, schema)
-	} else {
-		schema, err := c.SRClient.GetLatestSchema(subject)
+		schema, err := c.GetOrCreateSchema(topic, string(schemaData), "AVRO", isKey)
 		if err != nil {
 			return resp, err
 		}
-		if schema == nil {
-			log.Fatalf("No schema registered for topic %s and no schema file provided\n", topic)
-			return resp, err
-		}
-		resp = makeBinaryRecordUsingSchema(data, schema)
+		return makeBinaryRecordUsingSchema(c.SRClient, data, schema)
 	}
-	return resp, nil
+	schema, err := c.SRClient.GetLatestSchema(subject)
+	if err != nil {
+		return resp, err
+	}
+	if schema == nil {
+		return resp, fmt.Errorf("no schema registered for topic %s and no schema file provided", topic)
+	}
+	return makeBinaryRecordUsingSchema(c.SRClient, data, schema)
 }
 
 // Takes care of generating a ProduceMessage.
@@ -129,7 +264,7 @@ func (c *Producer) makeProduceMsg(topic string, key, value *string, serialize bo
 		msg.Value = sarama.ByteEncoder(valuePayload)
 		// Key
 		if key != nil {
-			keyPayload, err = c.GetSerializedPayload(topic, string(*key), valSchemaPath, "AVRO", true)
+			keyPayload, err = c.GetSerializedPayload(topic, string(*key), keySchemaPath, "AVRO", true)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -0,0 +1,1251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/kmetaxas/srclient"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Synthetic records for load tests and demos. Values are random but valid for an Avro, Protobuf or JSON schema, and can be shaped
+with hints per field: ranges, enums, regular expressions, fake data generators and the number of distinct values.
+Fields are named by path like the consumer's --filter: customer.id. Elements of arrays and values of maps are path[], like items[].sku.
+*/
+
+// Nested records, arrays and maps deeper than this are left empty where the schema allows it. Stops recursive schemas
+const generateMaxDepth = 6
+
+// Path of the key in the pools of generated values
+const generatedKeyPath = "<key>"
+
+// How to generate the values of a field. At most one of Enum, Faker and Pattern is set
+type fieldHint struct {
+	Min         *float64      `yaml:"min"` // Numbers. Epoch milliseconds for timestamps
+	Max         *float64      `yaml:"max"`
+	MinLength   *int          `yaml:"min_length"` // Strings, bytes, arrays and maps
+	MaxLength   *int          `yaml:"max_length"`
+	Enum        []interface{} `yaml:"enum"`
+	Pattern     string        `yaml:"pattern"`
+	Faker       string        `yaml:"faker"`
+	Cardinality int           `yaml:"cardinality"` // Only this many distinct values, if set
+	NullRate    *float64      `yaml:"null_rate"`   // Share of nulls, for fields that can be null or missing
+}
+
+type keyHint struct {
+	fieldHint `yaml:",inline"`
+	Field     string `yaml:"field"` // Use the value of this field as the key
+}
+
+type generateSpec struct {
+	Fields  map[string]*fieldHint `yaml:"fields"`
+	Key     *keyHint              `yaml:"key"`     // Records have no key if not set
+	Message string                `yaml:"message"` // Protobuf message to generate. The first message of the schema if not set
+}
+
+func loadGenerateSpec(path string) (*generateSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec generateSpec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid generator spec %s: %w", path, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid generator spec %s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+func (s *generateSpec) validate() error {
+	for path, hint := range s.Fields {
+		if hint == nil {
+			return fmt.Errorf("field %s has no hints", path)
+		}
+		if err := hint.validate(); err != nil {
+			return fmt.Errorf("field %s: %w", path, err)
+		}
+	}
+	if s.Key == nil {
+		return nil
+	}
+	if err := s.Key.validate(); err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+	if s.Key.Field != "" && s.Key.Cardinality > 0 {
+		// The distinct keys are the distinct values of the field
+		if s.Fields == nil {
+			s.Fields = make(map[string]*fieldHint)
+		}
+		field, exists := s.Fields[s.Key.Field]
+		if !exists {
+			field = &fieldHint{}
+			s.Fields[s.Key.Field] = field
+		}
+		if field.Cardinality == 0 {
+			field.Cardinality = s.Key.Cardinality
+		}
+	}
+	return nil
+}
+
+func (h *fieldHint) validate() error {
+	generators := 0
+	for _, set := range []bool{len(h.Enum) > 0, h.Faker != "", h.Pattern != ""} {
+		if set {
+			generators++
+		}
+	}
+	if generators > 1 {
+		return errors.New("only one of enum, faker and pattern can be set")
+	}
+	if _, exists := fakers[h.Faker]; h.Faker != "" && !exists {
+		return fmt.Errorf("unknown faker %s. Expected one of %s", h.Faker, strings.Join(sortedKeys(fakers), ","))
+	}
+	if h.Pattern != "" {
+		if _, err := syntax.Parse(h.Pattern, syntax.Perl); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	if h.Min != nil && h.Max != nil && *h.Min > *h.Max {
+		return errors.New("min is larger than max")
+	}
+	if h.MinLength != nil && h.MaxLength != nil && *h.MinLength > *h.MaxLength {
+		return errors.New("min_length is larger than max_length")
+	}
+	if h.Cardinality < 0 {
+		return errors.New("cardinality can't be negative")
+	}
+	if h.NullRate != nil && (*h.NullRate < 0 || *h.NullRate > 1) {
+		return errors.New("null_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// Kinds of leaf values. Schema types map to these, and hints are converted to them
+type leafKind int
+
+const (
+	leafBool leafKind = iota
+	leafInt
+	leafFloat
+	leafString
+	leafBytes
+	leafTime
+	leafAny // Enum values of JSON schemas, used as they are
+)
+
+// Constraints of a leaf from its schema. Hints take precedence
+type leafBounds struct {
+	min, max             *float64
+	minLength, maxLength *int
+	enum                 []interface{}
+	pattern              string
+	faker                string
+}
+
+type recordGenerator struct {
+	client    *srclient.SchemaRegistryClient
+	schema    *srclient.Schema
+	spec      *generateSpec
+	random    *rand.Rand
+	pools     map[string][]interface{}       // Values of fields with a cardinality, by path
+	patterns  map[string]*syntax.Regexp      // Parsed patterns
+	generated map[string]interface{}         // Leaf values of the current record by path, for keys taken from a field
+	value     func() (string, error)         // Generates a value as the JSON serialize expects
+	now       time.Time                      // Timestamps are before it
+	jsonRoot  map[string]interface{}         // JSON schemas, to resolve $ref
+	protobuf  protoreflect.MessageDescriptor // The message to generate of Protobuf schemas
+}
+
+/*
+Create a generator of values for schema. Protobuf references are fetched with client.
+A seed of 0 picks a random one. Runs with the same seed, schema and spec generate the same records,
+apart from timestamps without a range, which are relative to now
+*/
+func newRecordGenerator(client *srclient.SchemaRegistryClient, schema *srclient.Schema, spec *generateSpec, seed int64) (*recordGenerator, error) {
+	if spec == nil {
+		spec = &generateSpec{}
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	g := &recordGenerator{
+		client:   client,
+		schema:   schema,
+		spec:     spec,
+		random:   rand.New(rand.NewSource(seed)),
+		pools:    make(map[string][]interface{}),
+		patterns: make(map[string]*syntax.Regexp),
+		now:      time.Now(),
+	}
+	schemaType := srclient.Avro
+	if schema.SchemaType() != nil && *schema.SchemaType() != "" {
+		schemaType = *schema.SchemaType()
+	}
+	if spec.Message != "" && schemaType != srclient.Protobuf {
+		return nil, fmt.Errorf("a message can only be chosen for PROTOBUF schemas, not %s", schemaType)
+	}
+	switch schemaType {
+	case srclient.Json:
+		if err := json.Unmarshal([]byte(schema.Schema()), &g.jsonRoot); err != nil {
+			return nil, fmt.Errorf("invalid JSON schema: %w", err)
+		}
+		g.value = func() (string, error) {
+			value, err := g.jsonValue(g.jsonRoot, "", 0)
+			if err != nil {
+				return "", err
+			}
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		}
+	case srclient.Protobuf:
+		file, err := producerProtobufFile(client, schema)
+		if err != nil {
+			return nil, err
+		}
+		g.protobuf, err = protobufMessageByName(file, spec.Message)
+		if err != nil {
+			return nil, err
+		}
+		g.value = func() (string, error) {
+			message, err := g.protoMessage(g.protobuf, "", 0)
+			if err != nil {
+				return "", err
+			}
+			encoded, err := protojson.Marshal(message)
+			return string(encoded), err
+		}
+	default:
+		parsed, err := parseAvroSchema(schema.Schema())
+		if err != nil {
+			return nil, err
+		}
+		codec := schema.Codec()
+		if codec == nil {
+			return nil, fmt.Errorf("invalid AVRO schema ID [%d]", schema.ID())
+		}
+		g.value = func() (string, error) {
+			native, err := g.avroValue(parsed, "", 0)
+			if err != nil {
+				return "", err
+			}
+			encoded, err := codec.TextualFromNative(nil, native)
+			return string(encoded), err
+		}
+	}
+	return g, nil
+}
+
+// Generate a record. The value is JSON for serialize. The key is nil if the spec has no key
+func (g *recordGenerator) Next() (*string, string, error) {
+	g.generated = make(map[string]interface{})
+	value, err := g.value()
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := g.key()
+	return key, value, err
+}
+
+// Serialize a generated value with the schema. Protobuf values are of the message the generator generates
+func (g *recordGenerator) serialize(value string) ([]byte, error) {
+	if g.protobuf != nil {
+		return makeProtobufRecord(g.schema.ID(), g.protobuf, value)
+	}
+	return makeBinaryRecordUsingSchema(g.client, value, g.schema)
+}
+
+func (g *recordGenerator) key() (*string, error) {
+	hint := g.spec.Key
+	if hint == nil {
+		return nil, nil
+	}
+	var value interface{}
+	if hint.Field != "" {
+		fieldValue, exists := g.generated[hint.Field]
+		if !exists {
+			return nil, fmt.Errorf("key field %s is not a field of the record, or was null", hint.Field)
+		}
+		value = fieldValue
+	} else {
+		var err error
+		value, err = g.leaf(generatedKeyPath, &hint.fieldHint, leafString, leafBounds{faker: "uuid"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err := convertLeaf(value, leafString)
+	if err != nil {
+		return nil, err
+	}
+	text := key.(string)
+	return &text, nil
+}
+
+func (g *recordGenerator) hint(path string) *fieldHint {
+	return g.spec.Fields[path]
+}
+
+// Should an optional field be null. Deep ones are, to stop recursive schemas
+func (g *recordGenerator) isNull(hint *fieldHint, depth int) bool {
+	if depth > generateMaxDepth {
+		return true
+	}
+	return hint != nil && hint.NullRate != nil && g.random.Float64() < *hint.NullRate
+}
+
+// Number of elements of an array or map
+func (g *recordGenerator) length(hint *fieldHint, depth int, minLength, maxLength *int) int {
+	if depth > generateMaxDepth {
+		return 0
+	}
+	low, high := 1, 3
+	if minLength != nil {
+		low = *minLength
+		high = max(high, low)
+	}
+	if maxLength != nil {
+		high = *maxLength
+		low = min(low, high)
+	}
+	if hint != nil && hint.MinLength != nil {
+		low = *hint.MinLength
+		high = max(high, low)
+	}
+	if hint != nil && hint.MaxLength != nil {
+		high = *hint.MaxLength
+		low = min(low, high)
+	}
+	return low + g.random.Intn(high-low+1)
+}
+
+// Generate a leaf value of kind at path. Values of fields with a cardinality are reused once there are enough
+func (g *recordGenerator) leaf(path string, hint *fieldHint, kind leafKind, bounds leafBounds) (interface{}, error) {
+	if hint != nil && hint.Cardinality > 0 && len(g.pools[path]) >= hint.Cardinality {
+		value := g.pools[path][g.random.Intn(len(g.pools[path]))]
+		g.generated[path] = value
+		return value, nil
+	}
+	value, err := g.newLeaf(hint, kind, bounds)
+	if err != nil {
+		return nil, fmt.Errorf("field %s: %w", path, err)
+	}
+	if hint != nil && hint.Cardinality > 0 {
+		g.pools[path] = append(g.pools[path], value)
+	}
+	g.generated[path] = value
+	return value, nil
+}
+
+func (g *recordGenerator) newLeaf(hint *fieldHint, kind leafKind, bounds leafBounds) (interface{}, error) {
+	if hint != nil {
+		if len(hint.Enum) > 0 || hint.Faker != "" || hint.Pattern != "" {
+			bounds.enum, bounds.faker, bounds.pattern = hint.Enum, hint.Faker, hint.Pattern
+		}
+		if hint.Min != nil {
+			bounds.min = hint.Min
+		}
+		if hint.Max != nil {
+			bounds.max = hint.Max
+		}
+		if hint.MinLength != nil {
+			bounds.minLength = hint.MinLength
+		}
+		if hint.MaxLength != nil {
+			bounds.maxLength = hint.MaxLength
+		}
+	}
+	switch {
+	case len(bounds.enum) > 0:
+		return convertLeaf(bounds.enum[g.random.Intn(len(bounds.enum))], kind)
+	case bounds.faker != "":
+		return convertLeaf(fakers[bounds.faker](g), kind)
+	case bounds.pattern != "":
+		text, err := g.patternString(bounds.pattern)
+		if err != nil {
+			return nil, err
+		}
+		return convertLeaf(text, kind)
+	}
+	switch kind {
+	case leafBool:
+		return g.random.Intn(2) == 1, nil
+	case leafInt:
+		low, high := numberRange(bounds, 0, 1000)
+		low, high = math.Ceil(low), math.Floor(high)
+		if high < low {
+			return nil, fmt.Errorf("no integer between %v and %v", low, high)
+		}
+		return int64(low) + g.random.Int63n(int64(high-low)+1), nil
+	case leafFloat:
+		low, high := numberRange(bounds, 0, 1000)
+		return math.Round((low+g.random.Float64()*(high-low))*100) / 100, nil
+	case leafBytes:
+		data := make([]byte, g.lengthBetween(bounds, 8, 16))
+		g.random.Read(data)
+		return data, nil
+	case leafTime:
+		// The last 30 days by default
+		nowMillis := float64(g.now.UnixMilli())
+		low, high := numberRange(bounds, nowMillis-30*24*3600*1000, nowMillis)
+		return time.UnixMilli(int64(low + g.random.Float64()*(high-low))).UTC(), nil
+	}
+	return g.randomWord(g.lengthBetween(bounds, 5, 12)), nil
+}
+
+// The min and max of bounds, with defaults for the ones not set
+func numberRange(bounds leafBounds, defaultMin, defaultMax float64) (float64, float64) {
+	low, high := defaultMin, defaultMax
+	if bounds.min != nil {
+		low = *bounds.min
+		high = max(high, low+defaultMax-defaultMin)
+	}
+	if bounds.max != nil {
+		high = *bounds.max
+		if bounds.min == nil {
+			low = min(low, high-(defaultMax-defaultMin))
+		}
+	}
+	return low, high
+}
+
+func (g *recordGenerator) lengthBetween(bounds leafBounds, defaultMin, defaultMax int) int {
+	low, high := defaultMin, defaultMax
+	if bounds.minLength != nil {
+		low = *bounds.minLength
+		high = max(high, low)
+	}
+	if bounds.maxLength != nil {
+		high = *bounds.maxLength
+		low = min(low, high)
+	}
+	return low + g.random.Intn(high-low+1)
+}
+
+func (g *recordGenerator) randomWord(length int) string {
+	letters := make([]byte, length)
+	for i := range letters {
+		letters[i] = byte('a' + g.random.Intn(26))
+	}
+	return string(letters)
+}
+
+// Convert a hint or generated value to kind
+func convertLeaf(value interface{}, kind leafKind) (interface{}, error) {
+	switch kind {
+	case leafAny:
+		return value, nil
+	case leafString:
+		switch typed := value.(type) {
+		case time.Time:
+			return typed.Format(time.RFC3339Nano), nil
+		case []byte:
+			return string(typed), nil
+		}
+		return fmt.Sprint(value), nil
+	case leafBytes:
+		text, _ := convertLeaf(value, leafString)
+		return []byte(text.(string)), nil
+	case leafBool:
+		switch typed := value.(type) {
+		case bool:
+			return typed, nil
+		case string:
+			return strconv.ParseBool(typed)
+		}
+	case leafInt:
+		number, err := leafNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if number != math.Trunc(number) {
+			return nil, fmt.Errorf("%v is not an integer", value)
+		}
+		return int64(number), nil
+	case leafFloat:
+		return leafNumber(value)
+	case leafTime:
+		switch typed := value.(type) {
+		case time.Time:
+			return typed, nil
+		case string:
+			return parseTimeArg(typed, time.Now())
+		}
+		millis, err := leafNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		return time.UnixMilli(int64(millis)).UTC(), nil
+	}
+	return nil, fmt.Errorf("can't use %v (%T) here", value, value)
+}
+
+func leafNumber(value interface{}) (float64, error) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), nil
+	case int64:
+		return float64(typed), nil
+	case float64:
+		return typed, nil
+	case string:
+		return strconv.ParseFloat(typed, 64)
+	case time.Time:
+		return float64(typed.UnixMilli()), nil
+	}
+	return 0, fmt.Errorf("%v (%T) is not a number", value, value)
+}
+
+// Extra repetitions of *, + and unbounded {n,} in patterns
+const patternMaxRepeat = 5
+
+// A random string matching pattern
+func (g *recordGenerator) patternString(pattern string) (string, error) {
+	parsed, exists := g.patterns[pattern]
+	if !exists {
+		var err error
+		parsed, err = syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return "", err
+		}
+		parsed = parsed.Simplify()
+		g.patterns[pattern] = parsed
+	}
+	var builder strings.Builder
+	g.writePattern(&builder, parsed)
+	return builder.String(), nil
+}
+
+func (g *recordGenerator) writePattern(builder *strings.Builder, node *syntax.Regexp) {
+	switch node.Op {
+	case syntax.OpLiteral:
+		builder.WriteString(string(node.Rune))
+	case syntax.OpCharClass:
+		builder.WriteRune(g.classRune(node.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		builder.WriteByte(byte('a' + g.random.Intn(26)))
+	case syntax.OpCapture:
+		g.writePattern(builder, node.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range node.Sub {
+			g.writePattern(builder, sub)
+		}
+	case syntax.OpAlternate:
+		g.writePattern(builder, node.Sub[g.random.Intn(len(node.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		low, high := 0, patternMaxRepeat
+		switch node.Op {
+		case syntax.OpPlus:
+			low, high = 1, 1+patternMaxRepeat
+		case syntax.OpQuest:
+			high = 1
+		case syntax.OpRepeat:
+			low, high = node.Min, node.Max
+			if high < 0 {
+				high = low + patternMaxRepeat
+			}
+		}
+		for i := low + g.random.Intn(high-low+1); i > 0; i-- {
+			g.writePattern(builder, node.Sub[0])
+		}
+	}
+	// Anchors and word boundaries match the empty string
+}
+
+// A random rune of a character class, given as pairs of ranges. Printable ASCII is preferred, as negated classes span all of unicode
+func (g *recordGenerator) classRune(ranges []rune) rune {
+	var printable []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		low, high := max(ranges[i], ' '), min(ranges[i+1], '~')
+		if low <= high {
+			printable = append(printable, low, high)
+		}
+	}
+	if len(printable) > 0 {
+		ranges = printable
+	}
+	total := 0
+	for i := 0; i+1 < len(ranges); i += 2 {
+		total += int(ranges[i+1]-ranges[i]) + 1
+	}
+	if total == 0 {
+		return '?'
+	}
+	pick := g.random.Intn(total)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		size := int(ranges[i+1]-ranges[i]) + 1
+		if pick < size {
+			return ranges[i] + rune(pick)
+		}
+		pick -= size
+	}
+	return ranges[0]
+}
+
+// The goavro name of a union branch
+func avroUnionName(schema *avroSchema) string {
+	switch schema.Type {
+	case "record", "enum", "fixed":
+		return schema.Name
+	}
+	switch schema.LogicalType {
+	case "timestamp-millis", "timestamp-micros", "date", "time-millis", "time-micros", "decimal", "uuid":
+		return schema.Type + "." + schema.LogicalType
+	}
+	return schema.Type
+}
+
+// Generate a goavro native value for an Avro schema
+func (g *recordGenerator) avroValue(schema *avroSchema, path string, depth int) (interface{}, error) {
+	hint := g.hint(path)
+	switch schema.Type {
+	case "null":
+		return nil, nil
+	case "union":
+		var branches []*avroSchema
+		nullable := false
+		for _, branch := range schema.Branches {
+			if branch.Type == "null" {
+				nullable = true
+			} else {
+				branches = append(branches, branch)
+			}
+		}
+		if len(branches) == 0 || (nullable && g.isNull(hint, depth)) {
+			return nil, nil
+		}
+		branch := branches[g.random.Intn(len(branches))]
+		value, err := g.avroValue(branch, path, depth)
+		if err != nil {
+			return nil, err
+		}
+		return goavro.Union(avroUnionName(branch), value), nil
+	case "record":
+		if schema.Fields == nil {
+			return nil, fmt.Errorf("type %s is defined in a schema reference, which the generator doesn't support", schema.Name)
+		}
+		record := make(map[string]interface{}, len(schema.Fields))
+		for _, field := range schema.Fields {
+			value, err := g.avroValue(field.Type, joinFieldPath(path, field.Name), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			record[field.Name] = value
+		}
+		return record, nil
+	case "enum":
+		symbols := make([]interface{}, len(schema.Symbols))
+		for i, symbol := range schema.Symbols {
+			symbols[i] = symbol
+		}
+		return g.leaf(path, hint, leafString, leafBounds{enum: symbols})
+	case "array":
+		items := make([]interface{}, g.length(hint, depth, nil, nil))
+		for i := range items {
+			item, err := g.avroValue(schema.Items, path+"[]", depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case "map":
+		values := make(map[string]interface{})
+		for i := g.length(hint, depth, nil, nil); i > 0; i-- {
+			value, err := g.avroValue(schema.Values, path+"[]", depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values[g.randomWord(6)] = value
+		}
+		return values, nil
+	case "fixed":
+		data := make([]byte, schema.Size)
+		g.random.Read(data)
+		return data, nil
+	case "boolean":
+		return g.leaf(path, hint, leafBool, leafBounds{})
+	case "int", "long":
+		switch schema.LogicalType {
+		case "date", "timestamp-millis", "timestamp-micros":
+			value, err := g.leaf(path, hint, leafTime, leafBounds{})
+			if err != nil || schema.LogicalType != "date" {
+				return value, err
+			}
+			return value.(time.Time).Truncate(24 * time.Hour), nil
+		case "time-millis", "time-micros":
+			day := float64(24*3600*1000 - 1)
+			value, err := g.leaf(path, hint, leafInt, leafBounds{min: new(float64), max: &day})
+			if err != nil {
+				return nil, err
+			}
+			return time.Duration(value.(int64)) * time.Millisecond, nil
+		}
+		value, err := g.leaf(path, hint, leafInt, leafBounds{})
+		if err != nil || schema.Type == "long" {
+			return value, err
+		}
+		number := value.(int64)
+		if number < math.MinInt32 || number > math.MaxInt32 {
+			return nil, fmt.Errorf("field %s: %d is out of the int range", path, number)
+		}
+		return int32(number), nil
+	case "float":
+		value, err := g.leaf(path, hint, leafFloat, leafBounds{})
+		if err != nil {
+			return nil, err
+		}
+		return float32(value.(float64)), nil
+	case "double":
+		return g.leaf(path, hint, leafFloat, leafBounds{})
+	case "bytes":
+		if schema.LogicalType == "decimal" {
+			value, err := g.leaf(path, hint, leafFloat, leafBounds{})
+			if err != nil {
+				return nil, err
+			}
+			return new(big.Rat).SetFloat64(value.(float64)), nil
+		}
+		return g.leaf(path, hint, leafBytes, leafBounds{})
+	case "string":
+		bounds := leafBounds{}
+		if schema.LogicalType == "uuid" {
+			bounds.faker = "uuid"
+		}
+		return g.leaf(path, hint, leafString, bounds)
+	}
+	return nil, fmt.Errorf("field %s: unsupported Avro type %s", path, schema)
+}
+
+// Resolve a local $ref like #/definitions/Address
+func (g *recordGenerator) jsonRef(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref %s is not local to the schema, which the generator doesn't support", ref)
+	}
+	var node interface{} = g.jsonRoot
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, isObject := node.(map[string]interface{})
+		if !isObject {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+		if node, isObject = object[part]; !isObject {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func jsonSchemaNumber(schema map[string]interface{}, name string) *float64 {
+	if number, isNumber := schema[name].(float64); isNumber {
+		return &number
+	}
+	return nil
+}
+
+func jsonSchemaLength(schema map[string]interface{}, name string) *int {
+	if number := jsonSchemaNumber(schema, name); number != nil {
+		length := int(*number)
+		return &length
+	}
+	return nil
+}
+
+// Fakers for JSON schema string formats
+var jsonFormatFakers = map[string]string{
+	"email":    "email",
+	"uuid":     "uuid",
+	"hostname": "hostname",
+	"ipv4":     "ipv4",
+	"uri":      "url",
+	"url":      "url",
+}
+
+// Generate a value for a JSON schema
+func (g *recordGenerator) jsonValue(node interface{}, path string, depth int) (interface{}, error) {
+	hint := g.hint(path)
+	schema, isObject := node.(map[string]interface{})
+	if !isObject {
+		// true, or an empty schema, accepts anything
+		return g.leaf(path, hint, leafString, leafBounds{})
+	}
+	if ref, isString := schema["$ref"].(string); isString {
+		if depth > generateMaxDepth*4 {
+			return nil, fmt.Errorf("field %s: $ref %s is too deeply nested", path, ref)
+		}
+		resolved, err := g.jsonRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		return g.jsonValue(resolved, path, depth+1)
+	}
+	if constant, exists := schema["const"]; exists {
+		return constant, nil
+	}
+	if enum, isArray := schema["enum"].([]interface{}); isArray && len(enum) > 0 {
+		return g.leaf(path, hint, leafAny, leafBounds{enum: enum})
+	}
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		if branches, isArray := schema[keyword].([]interface{}); isArray && len(branches) > 0 {
+			return g.jsonValue(branches[g.random.Intn(len(branches))], path, depth)
+		}
+	}
+	if branches, isArray := schema["allOf"].([]interface{}); isArray && len(branches) > 0 {
+		merged := make(map[string]interface{})
+		for _, branch := range branches {
+			value, err := g.jsonValue(branch, path, depth)
+			if err != nil {
+				return nil, err
+			}
+			object, isObject := value.(map[string]interface{})
+			if !isObject {
+				return value, nil
+			}
+			for name, fieldValue := range object {
+				merged[name] = fieldValue
+			}
+		}
+		return merged, nil
+	}
+
+	var types []string
+	switch typed := schema["type"].(type) {
+	case string:
+		types = []string{typed}
+	case []interface{}:
+		for _, name := range typed {
+			if text, isString := name.(string); isString {
+				types = append(types, text)
+			}
+		}
+	}
+	nullable := false
+	var nonNull []string
+	for _, name := range types {
+		if name == "null" {
+			nullable = true
+		} else {
+			nonNull = append(nonNull, name)
+		}
+	}
+	if nullable && (len(nonNull) == 0 || g.isNull(hint, depth)) {
+		return nil, nil
+	}
+	jsonType := ""
+	if len(nonNull) > 0 {
+		jsonType = nonNull[g.random.Intn(len(nonNull))]
+	} else if _, exists := schema["properties"]; exists {
+		jsonType = "object"
+	} else if _, exists := schema["items"]; exists {
+		jsonType = "array"
+	}
+
+	switch jsonType {
+	case "object":
+		object := make(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		required := make(map[string]bool)
+		if names, isArray := schema["required"].([]interface{}); isArray {
+			for _, name := range names {
+				if text, isString := name.(string); isString {
+					required[text] = true
+				}
+			}
+		}
+		for _, name := range sortedKeys(properties) {
+			fieldPath := joinFieldPath(path, name)
+			if !required[name] && g.isNull(g.hint(fieldPath), depth+1) {
+				continue
+			}
+			value, err := g.jsonValue(properties[name], fieldPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = value
+		}
+		return object, nil
+	case "array":
+		items := make([]interface{}, g.length(hint, depth, jsonSchemaLength(schema, "minItems"), jsonSchemaLength(schema, "maxItems")))
+		itemSchema := schema["items"]
+		if tuple, isArray := itemSchema.([]interface{}); isArray {
+			itemSchema = nil
+			if len(tuple) > 0 {
+				itemSchema = tuple[0]
+			}
+		}
+		for i := range items {
+			item, err := g.jsonValue(itemSchema, path+"[]", depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case "integer", "number":
+		bounds := leafBounds{min: jsonSchemaNumber(schema, "minimum"), max: jsonSchemaNumber(schema, "maximum")}
+		if exclusive := jsonSchemaNumber(schema, "exclusiveMinimum"); exclusive != nil {
+			*exclusive += 0.01
+			bounds.min = exclusive
+		}
+		if exclusive := jsonSchemaNumber(schema, "exclusiveMaximum"); exclusive != nil {
+			*exclusive -= 0.01
+			bounds.max = exclusive
+		}
+		if jsonType == "integer" {
+			return g.leaf(path, hint, leafInt, bounds)
+		}
+		return g.leaf(path, hint, leafFloat, bounds)
+	case "boolean":
+		return g.leaf(path, hint, leafBool, leafBounds{})
+	}
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date-time", "date", "time":
+		value, err := g.leaf(path, hint, leafTime, leafBounds{})
+		if err != nil {
+			return nil, err
+		}
+		layout := map[string]string{"date-time": time.RFC3339Nano, "date": time.DateOnly, "time": time.TimeOnly}[format]
+		return value.(time.Time).Format(layout), nil
+	}
+	bounds := leafBounds{minLength: jsonSchemaLength(schema, "minLength"), maxLength: jsonSchemaLength(schema, "maxLength"), faker: jsonFormatFakers[format]}
+	bounds.pattern, _ = schema["pattern"].(string)
+	return g.leaf(path, hint, leafString, bounds)
+}
+
+// Generate a message for a Protobuf message type. Oneofs get one of their fields
+func (g *recordGenerator) protoMessage(descriptor protoreflect.MessageDescriptor, path string, depth int) (*dynamicpb.Message, error) {
+	message := dynamicpb.NewMessage(descriptor)
+	if descriptor.FullName() == "google.protobuf.Timestamp" {
+		value, err := g.leaf(path, g.hint(path), leafTime, leafBounds{})
+		if err != nil {
+			return nil, err
+		}
+		timestamp := value.(time.Time)
+		message.Set(descriptor.Fields().ByName("seconds"), protoreflect.ValueOfInt64(timestamp.Unix()))
+		message.Set(descriptor.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(timestamp.Nanosecond())))
+		return message, nil
+	}
+	chosen := make(map[protoreflect.FullName]protoreflect.FieldNumber)
+	oneofs := descriptor.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		oneof := oneofs.Get(i)
+		if !oneof.IsSynthetic() {
+			chosen[oneof.FullName()] = oneof.Fields().Get(g.random.Intn(oneof.Fields().Len())).Number()
+		}
+	}
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if oneof := field.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() && chosen[oneof.FullName()] != field.Number() {
+			continue
+		}
+		fieldPath := joinFieldPath(path, string(field.Name()))
+		hint := g.hint(fieldPath)
+		switch {
+		case field.IsMap():
+			values := message.Mutable(field).Map()
+			for j := g.length(hint, depth+1, nil, nil); j > 0; j-- {
+				key, err := g.protoValue(field.MapKey(), "", depth+1)
+				if err != nil {
+					return nil, err
+				}
+				value, err := g.protoValue(field.MapValue(), fieldPath+"[]", depth+1)
+				if err != nil {
+					return nil, err
+				}
+				values.Set(key.MapKey(), value)
+			}
+		case field.IsList():
+			list := message.Mutable(field).List()
+			for j := g.length(hint, depth+1, nil, nil); j > 0; j-- {
+				value, err := g.protoValue(field, fieldPath+"[]", depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list.Append(value)
+			}
+		default:
+			if field.HasPresence() && g.isNull(hint, depth+1) {
+				continue
+			}
+			value, err := g.protoValue(field, fieldPath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			message.Set(field, value)
+		}
+	}
+	return message, nil
+}
+
+// Generate a single value of a field, or of an element of a repeated field
+func (g *recordGenerator) protoValue(field protoreflect.FieldDescriptor, path string, depth int) (protoreflect.Value, error) {
+	hint := g.hint(path)
+	var bounds leafBounds
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		value, err := g.leaf(path, hint, leafBool, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBool(value.(bool)), nil
+	case protoreflect.EnumKind:
+		values := field.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			bounds.enum = append(bounds.enum, string(values.Get(i).Name()))
+		}
+		value, err := g.leaf(path, hint, leafString, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		enumValue := values.ByName(protoreflect.Name(value.(string)))
+		if enumValue == nil {
+			return protoreflect.Value{}, fmt.Errorf("field %s: %s is not a value of %s", path, value, field.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(enumValue.Number()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		value, err := g.leaf(path, hint, leafInt, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		number := value.(int64)
+		switch field.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+			if number < math.MinInt32 || number > math.MaxInt32 {
+				return protoreflect.Value{}, fmt.Errorf("field %s: %d is out of the int32 range", path, number)
+			}
+			return protoreflect.ValueOfInt32(int32(number)), nil
+		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			return protoreflect.ValueOfInt64(number), nil
+		}
+		if number < 0 {
+			return protoreflect.Value{}, fmt.Errorf("field %s: %d is negative for an unsigned integer", path, number)
+		}
+		if field.Kind() == protoreflect.Uint32Kind || field.Kind() == protoreflect.Fixed32Kind {
+			if number > math.MaxUint32 {
+				return protoreflect.Value{}, fmt.Errorf("field %s: %d is out of the uint32 range", path, number)
+			}
+			return protoreflect.ValueOfUint32(uint32(number)), nil
+		}
+		return protoreflect.ValueOfUint64(uint64(number)), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		value, err := g.leaf(path, hint, leafFloat, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		if field.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(value.(float64))), nil
+		}
+		return protoreflect.ValueOfFloat64(value.(float64)), nil
+	case protoreflect.StringKind:
+		value, err := g.leaf(path, hint, leafString, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfString(value.(string)), nil
+	case protoreflect.BytesKind:
+		value, err := g.leaf(path, hint, leafBytes, bounds)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBytes(value.([]byte)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		message, err := g.protoMessage(field.Message(), path, depth)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(message), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("field %s: unsupported Protobuf kind %s", path, field.Kind())
+}
+
+/*
+Messages of generated records, serialized with the schema. Stops after count records, or when ctx is done if count is 0.
+Records are spread over time to produce rate records per second, if set
+*/
+func generatorSource(ctx context.Context, topic string, generator *recordGenerator, count int64, rate float64) messageSource {
+	start := time.Now()
+	var generated int64
+	return func() (*sarama.ProducerMessage, error) {
+		if count > 0 && generated >= count {
+			return nil, nil
+		}
+		if rate > 0 {
+			due := start.Add(time.Duration(float64(generated) / rate * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		key, value, err := generator.Next()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := generator.serialize(value)
+		if err != nil {
+			return nil, err
+		}
+		generated++
+		msg := &sarama.ProducerMessage{
+			Topic:    topic,
+			Value:    sarama.ByteEncoder(payload),
+			Metadata: &inputMessageMetadata{origin: fmt.Sprintf("generated record %d", generated)},
+		}
+		if key != nil {
+			msg.Key = sarama.StringEncoder(*key)
+		}
+		return msg, nil
+	}
+}
+
+// Schema types by schema file extension
+var schemaFileTypes = map[string]srclient.SchemaType{".avsc": srclient.Avro, ".proto": srclient.Protobuf, ".json": srclient.Json}
+
+/*
+The schema to generate values for: the schema file registered under the value subject of the topic, which gives the ID
+of the schema if it is registered already, or else the latest schema of the subject
+*/
+func (c *Producer) generatorSchema(topic, schemaPath string, schemaType srclient.SchemaType) (*srclient.Schema, error) {
+	subject := getSubjectForTopic(topic, false)
+	if schemaPath == "" {
+		schema, err := c.SRClient.GetLatestSchema(subject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the latest schema of %s: %w", subject, err)
+		}
+		return schema, nil
+	}
+	schemaData, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	if schemaType == "" {
+		for extension, fileType := range schemaFileTypes {
+			if strings.HasSuffix(schemaPath, extension) {
+				schemaType = fileType
+			}
+		}
+		if schemaType == "" {
+			return nil, fmt.Errorf("can't tell the type of %s from its extension. Set --schema-type", schemaPath)
+		}
+	}
+	schema, err := c.SRClient.CreateSchema(subject, string(schemaData), schemaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register %s under %s: %w", schemaPath, subject, err)
+	}
+	return schema, nil
+}
+
+// Produce count generated records to topic. See generatorSource
+func (c *Producer) ProduceGenerated(ctx context.Context, topic, schemaPath string, schemaType srclient.SchemaType, spec *generateSpec, seed, count int64, rate float64, progress io.Writer, interval time.Duration) error {
+	schema, err := c.generatorSchema(topic, schemaPath, schemaType)
+	if err != nil {
+		return err
+	}
+	generator, err := newRecordGenerator(c.SRClient, schema, spec, seed)
+	if err != nil {
+		return err
+	}
+	producer, err := c.newAsyncProducer()
+	if err != nil {
+		return err
+	}
+	return produceMessages(producer, topic, generatorSource(ctx, topic, generator, count, rate), progress, interval)
+}
+
+// Random data generators for the faker hint
+var fakers = map[string]func(g *recordGenerator) interface{}{
+	"first_name": func(g *recordGenerator) interface{} { return g.pick(fakeFirstNames) },
+	"last_name":  func(g *recordGenerator) interface{} { return g.pick(fakeLastNames) },
+	"name":       func(g *recordGenerator) interface{} { return g.pick(fakeFirstNames) + " " + g.pick(fakeLastNames) },
+	"username": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("%s%d", strings.ToLower(g.pick(fakeFirstNames)), g.random.Intn(1000))
+	},
+	"email": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("%s.%s@%s", strings.ToLower(g.pick(fakeFirstNames)), strings.ToLower(g.pick(fakeLastNames)), g.pick(fakeDomains))
+	},
+	"phone": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("+1-%03d-%03d-%04d", 200+g.random.Intn(800), g.random.Intn(1000), g.random.Intn(10000))
+	},
+	"company": func(g *recordGenerator) interface{} { return g.pick(fakeLastNames) + " " + g.pick(fakeCompanySuffixes) },
+	"street": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("%d %s %s", 1+g.random.Intn(999), g.pick(fakeLastNames), g.pick(fakeStreetSuffixes))
+	},
+	"city":         func(g *recordGenerator) interface{} { return g.pick(fakeCities) },
+	"country":      func(g *recordGenerator) interface{} { return g.pick(fakeCountries)[3:] },
+	"country_code": func(g *recordGenerator) interface{} { return g.pick(fakeCountries)[:2] },
+	"zip":          func(g *recordGenerator) interface{} { return fmt.Sprintf("%05d", g.random.Intn(100000)) },
+	"currency":     func(g *recordGenerator) interface{} { return g.pick(fakeCurrencies) },
+	"word":         func(g *recordGenerator) interface{} { return g.pick(fakeWords) },
+	"sentence": func(g *recordGenerator) interface{} {
+		words := make([]string, 4+g.random.Intn(6))
+		for i := range words {
+			words[i] = g.pick(fakeWords)
+		}
+		sentence := strings.Join(words, " ") + "."
+		return strings.ToUpper(sentence[:1]) + sentence[1:]
+	},
+	"uuid": func(g *recordGenerator) interface{} {
+		data := make([]byte, 16)
+		g.random.Read(data)
+		data[6] = data[6]&0x0f | 0x40 // Version 4
+		data[8] = data[8]&0x3f | 0x80 // RFC 4122 variant
+		uuid, _ := formatRawPayload(data, payloadFormatUUID)
+		return uuid
+	},
+	"ipv4": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("%d.%d.%d.%d", 1+g.random.Intn(223), g.random.Intn(256), g.random.Intn(256), 1+g.random.Intn(254))
+	},
+	"hostname": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("%s-%d.%s", g.pick(fakeWords), g.random.Intn(100), g.pick(fakeDomains))
+	},
+	"url": func(g *recordGenerator) interface{} {
+		return fmt.Sprintf("https://www.%s/%s/%s", g.pick(fakeDomains), g.pick(fakeWords), g.pick(fakeWords))
+	},
+	"timestamp": func(g *recordGenerator) interface{} {
+		return g.now.Add(-time.Duration(g.random.Int63n(int64(30 * 24 * time.Hour)))).UTC()
+	},
+}
+
+func (g *recordGenerator) pick(values []string) string {
+	return values[g.random.Intn(len(values))]
+}
+
+var fakeFirstNames = []string{"Ada", "Alan", "Grace", "Linus", "Margaret", "Dennis", "Barbara", "Ken", "Frances", "Edsger", "Radia", "Niklaus", "Katherine", "John", "Sophie", "Tim"}
+var fakeLastNames = []string{"Lovelace", "Turing", "Hopper", "Torvalds", "Hamilton", "Ritchie", "Liskov", "Thompson", "Allen", "Dijkstra", "Perlman", "Wirth", "Johnson", "Backus", "Wilson", "Berners"}
+var fakeDomains = []string{"example.com", "example.org", "example.net", "test.io", "mail.test"}
+var fakeCompanySuffixes = []string{"Inc", "Ltd", "Group", "Systems", "Labs", "Holdings"}
+var fakeStreetSuffixes = []string{"Street", "Avenue", "Road", "Lane", "Boulevard", "Way"}
+var fakeCities = []string{"Athens", "Berlin", "Lisbon", "Oslo", "Toronto", "Austin", "Osaka", "Nairobi", "Lima", "Melbourne", "Dublin", "Prague"}
+var fakeCountries = []string{"GR Greece", "DE Germany", "PT Portugal", "NO Norway", "CA Canada", "US United States", "JP Japan", "KE Kenya", "PE Peru", "AU Australia", "IE Ireland", "CZ Czechia"}
+var fakeCurrencies = []string{"EUR", "USD", "GBP", "JPY", "CHF", "CAD", "AUD", "NOK"}
+var fakeWords = []string{"alpha", "bravo", "delta", "echo", "kafka", "stream", "topic", "record", "broker", "schema", "orbit", "harbor", "amber", "cedar", "summit", "river", "pixel", "vector"}
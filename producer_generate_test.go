@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testGenerateAvro = `{"type":"record","name":"Order","namespace":"shop","fields":[
+  {"name":"id","type":"string"},
+  {"name":"status","type":{"type":"enum","name":"Status","symbols":["NEW","PAID","SHIPPED"]}},
+  {"name":"quantity","type":"int"},
+  {"name":"total","type":"double"},
+  {"name":"created","type":{"type":"long","logicalType":"timestamp-millis"}},
+  {"name":"note","type":["null","string"],"default":null},
+  {"name":"customer","type":{"type":"record","name":"Customer","fields":[
+    {"name":"email","type":"string"},
+    {"name":"since","type":["null",{"type":"int","logicalType":"date"}]}]}},
+  {"name":"items","type":{"type":"array","items":{"type":"record","name":"Item","fields":[
+    {"name":"sku","type":"string"},{"name":"price","type":{"type":"bytes","logicalType":"decimal","precision":9,"scale":2}}]}}},
+  {"name":"tags","type":{"type":"map","values":"string"}},
+  {"name":"checksum","type":{"type":"fixed","name":"MD5","size":16}},
+  {"name":"next","type":["null","Order"],"default":null}
+]}`
+
+func newGenerateTestSpec(t *testing.T, text string) *generateSpec {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(text), 0o600))
+	spec, err := loadGenerateSpec(path)
+	require.NoError(t, err)
+	return spec
+}
+
+func TestLoadGenerateSpec(t *testing.T) {
+	spec := newGenerateTestSpec(t, `
+fields:
+  quantity: {min: 1, max: 5}
+  customer.email: {faker: email}
+key:
+  field: id
+  cardinality: 3
+`)
+	require.Equal(t, 1.0, *spec.Fields["quantity"].Min)
+	require.Equal(t, "email", spec.Fields["customer.email"].Faker)
+	// The cardinality of the key applies to the field it comes from
+	require.Equal(t, 3, spec.Fields["id"].Cardinality)
+
+	for _, text := range []string{
+		"fields:\n  a: {faker: nope}\n",
+		"fields:\n  a: {min: 5, max: 1}\n",
+		"fields:\n  a: {enum: [x], pattern: y}\n",
+		"fields:\n  a: {pattern: '('}\n",
+		"fields:\n  a: {null_rate: 2}\n",
+		"fields:\n  a: {minimum: 1}\n",
+	} {
+		path := filepath.Join(t.TempDir(), "spec.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(text), 0o600))
+		_, err := loadGenerateSpec(path)
+		require.Error(t, err, text)
+	}
+}
+
+func TestGeneratePattern(t *testing.T) {
+	generator := &recordGenerator{random: rand.New(rand.NewSource(1)), patterns: make(map[string]*syntax.Regexp)}
+	for _, pattern := range []string{`ORD-[0-9]{6}`, `[A-Z]{2}\d+`, `(foo|bar)-[^a-z]?x*`, `^\w{3,5}$`} {
+		matcher := regexp.MustCompile(`^(?:` + pattern + `)$`)
+		for i := 0; i < 50; i++ {
+			value, err := generator.patternString(pattern)
+			require.NoError(t, err)
+			require.Regexp(t, matcher, value, pattern)
+		}
+	}
+}
+
+func TestGenerateAvro(t *testing.T) {
+	schema, err := srclient.NewSchema(901, testGenerateAvro, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	spec := newGenerateTestSpec(t, `
+fields:
+  id: {pattern: 'ORD-[0-9]{4}'}
+  quantity: {min: 1, max: 5}
+  total: {min: 10, max: 20}
+  note: {null_rate: 1}
+  customer.email: {faker: email}
+  items: {min_length: 2, max_length: 2}
+  items[].sku: {enum: [A1, B2]}
+  created: {min: 1700000000000, max: 1700000001000}
+key:
+  field: id
+`)
+	generator, err := newRecordGenerator(nil, schema, spec, 42)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		key, value, err := generator.Next()
+		require.NoError(t, err)
+		// The value is valid for the schema
+		payload, err := generator.serialize(value)
+		require.NoError(t, err)
+		native, _, err := schema.Codec().NativeFromBinary(payload[5:])
+		require.NoError(t, err)
+		record := native.(map[string]interface{})
+		require.Regexp(t, `^ORD-[0-9]{4}$`, record["id"])
+		require.Equal(t, record["id"], *key)
+		require.Contains(t, []string{"NEW", "PAID", "SHIPPED"}, record["status"])
+		require.GreaterOrEqual(t, record["quantity"], int32(1))
+		require.LessOrEqual(t, record["quantity"], int32(5))
+		require.GreaterOrEqual(t, record["total"], 10.0)
+		require.LessOrEqual(t, record["total"], 20.0)
+		require.Nil(t, record["note"])
+		created := record["created"].(time.Time)
+		require.False(t, created.Before(time.UnixMilli(1700000000000)), created)
+		require.False(t, created.After(time.UnixMilli(1700000001000)), created)
+		require.Contains(t, record["customer"].(map[string]interface{})["email"], "@")
+		items := record["items"].([]interface{})
+		require.Len(t, items, 2)
+		require.Contains(t, []string{"A1", "B2"}, items[0].(map[string]interface{})["sku"])
+		require.Len(t, record["checksum"], 16)
+	}
+
+	// The same seed generates the same records
+	first, err := newRecordGenerator(nil, schema, spec, 7)
+	require.NoError(t, err)
+	second, err := newRecordGenerator(nil, schema, spec, 7)
+	require.NoError(t, err)
+	second.now = first.now
+	for i := 0; i < 5; i++ {
+		_, firstValue, err := first.Next()
+		require.NoError(t, err)
+		_, secondValue, err := second.Next()
+		require.NoError(t, err)
+		require.JSONEq(t, firstValue, secondValue)
+	}
+}
+
+func TestGenerateCardinality(t *testing.T) {
+	schema, err := srclient.NewSchema(901, testGenerateAvro, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	spec := newGenerateTestSpec(t, "fields:\n  customer.email: {faker: email, cardinality: 4}\nkey:\n  cardinality: 3\n")
+	generator, err := newRecordGenerator(nil, schema, spec, 1)
+	require.NoError(t, err)
+	keys := make(map[string]bool)
+	emails := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		key, value, err := generator.Next()
+		require.NoError(t, err)
+		keys[*key] = true
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(value), &record))
+		emails[record["customer"].(map[string]interface{})["email"].(string)] = true
+	}
+	// Keys are UUIDs unless a generator is given
+	require.Len(t, keys, 3)
+	for key := range keys {
+		require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, key)
+	}
+	require.Len(t, emails, 4)
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	text := `{
+  "type": "object",
+  "required": ["id", "email", "kind", "amount", "created", "lines"],
+  "properties": {
+    "id": {"type": "string", "pattern": "^U[0-9]{3}$"},
+    "email": {"type": "string", "format": "email"},
+    "kind": {"enum": ["a", "b", 3]},
+    "amount": {"type": "number", "minimum": 1, "exclusiveMaximum": 2},
+    "count": {"type": ["integer", "null"], "minimum": -5, "maximum": -1},
+    "created": {"type": "string", "format": "date-time"},
+    "lines": {"type": "array", "minItems": 1, "maxItems": 3, "items": {"$ref": "#/definitions/line"}},
+    "choice": {"oneOf": [{"type": "boolean"}, {"type": "string", "maxLength": 2}]}
+  },
+  "definitions": {
+    "line": {"type": "object", "required": ["sku"], "properties": {"sku": {"type": "string", "minLength": 3, "maxLength": 3}}}
+  }
+}`
+	schema, err := srclient.NewSchema(4, text, srclient.Json, 1, nil, nil, nil)
+	require.NoError(t, err)
+	spec := newGenerateTestSpec(t, "fields:\n  lines[].sku: {cardinality: 2}\n  choice: {null_rate: 0}\n")
+	generator, err := newRecordGenerator(nil, schema, spec, 3)
+	require.NoError(t, err)
+	skus := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		key, value, err := generator.Next()
+		require.NoError(t, err)
+		require.Nil(t, key)
+		var record interface{}
+		require.NoError(t, json.Unmarshal([]byte(value), &record))
+		require.NoError(t, schema.JsonSchema().Validate(record), value)
+		for _, line := range record.(map[string]interface{})["lines"].([]interface{}) {
+			skus[line.(map[string]interface{})["sku"].(string)] = true
+		}
+	}
+	require.Len(t, skus, 2)
+}
+
+func TestGenerateProtobuf(t *testing.T) {
+	text := `syntax = "proto3";
+package shop;
+import "google/protobuf/timestamp.proto";
+message Order {
+  enum Status { NEW = 0; PAID = 1; }
+  string id = 1;
+  Status status = 2;
+  uint32 quantity = 3;
+  repeated string tags = 4;
+  map<string, double> prices = 5;
+  oneof payment { string card = 6; string iban = 7; }
+  google.protobuf.Timestamp created = 8;
+  optional string note = 9;
+}
+`
+	schema, err := srclient.NewSchema(902, text, srclient.Protobuf, 1, nil, nil, nil)
+	require.NoError(t, err)
+	spec := newGenerateTestSpec(t, "fields:\n  status: {enum: [PAID]}\n  quantity: {min: 3, max: 3}\n  note: {null_rate: 1}\n  tags[]: {faker: word}\n")
+	generator, err := newRecordGenerator(nil, schema, spec, 5)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, value, err := generator.Next()
+		require.NoError(t, err)
+		payload, err := generator.serialize(value)
+		require.NoError(t, err)
+		require.Equal(t, byte(0), payload[5])
+		message := dynamicpb.NewMessage(generator.protobuf)
+		require.NoError(t, proto.Unmarshal(payload[6:], message))
+		var record map[string]interface{}
+		encoded, err := protojson.Marshal(message)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(encoded, &record))
+		require.Equal(t, "PAID", record["status"])
+		require.Equal(t, 3.0, record["quantity"])
+		require.NotContains(t, record, "note")
+		_, hasCard := record["card"]
+		_, hasIban := record["iban"]
+		require.True(t, hasCard != hasIban, value)
+		require.Contains(t, fakeWords, record["tags"].([]interface{})[0])
+	}
+
+	// Referenced types are fetched from the registry. Header is the first message of schema ID 2
+	consumer := newDeserializeTestConsumer(t)
+	schema, err = consumer.SRClient.GetSchema(2)
+	require.NoError(t, err)
+	generator, err = newRecordGenerator(consumer.SRClient, schema, nil, 5)
+	require.NoError(t, err)
+	_, value, err := generator.Next()
+	require.NoError(t, err)
+	require.Contains(t, value, `"id"`)
+}
+
+func TestGenerateProtobufMessage(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	schema, err := consumer.SRClient.GetSchema(2)
+	require.NoError(t, err)
+	// References are fetched with the client, not taken from schemas compiled before
+	producerProtobufFiles.Delete(schema.ID())
+	for name, indexes := range map[string][]byte{
+		"":                  {0},
+		"Order":             {2, 2},
+		"orders.Order":      {2, 2},
+		"Order.Line":        {4, 2, 0},
+		"orders.Order.Line": {4, 2, 0},
+	} {
+		spec := &generateSpec{Message: name}
+		generator, err := newRecordGenerator(consumer.SRClient, schema, spec, 7)
+		require.NoError(t, err, name)
+		_, value, err := generator.Next()
+		require.NoError(t, err, name)
+		payload, err := generator.serialize(value)
+		require.NoError(t, err, name)
+		require.Equal(t, indexes, payload[5:5+len(indexes)], name)
+		// The consumer reads the record back as the same message
+		deserialized, schemaID, err := consumer.DeserializePayload(payload)
+		require.NoError(t, err, name)
+		require.Equal(t, 2, schemaID)
+		require.JSONEq(t, value, deserialized, name)
+	}
+
+	_, err = newRecordGenerator(consumer.SRClient, schema, &generateSpec{Message: "Missing"}, 7)
+	require.ErrorContains(t, err, "message Missing not found")
+	avro, err := srclient.NewSchema(901, testGenerateAvro, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = newRecordGenerator(nil, avro, &generateSpec{Message: "Order"}, 7)
+	require.Error(t, err)
+}
+
+func TestGenerateKeyFieldMissing(t *testing.T) {
+	schema, err := srclient.NewSchema(901, testGenerateAvro, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	spec := newGenerateTestSpec(t, "key:\n  field: customer.phone\n")
+	generator, err := newRecordGenerator(nil, schema, spec, 1)
+	require.NoError(t, err)
+	_, _, err = generator.Next()
+	require.ErrorContains(t, err, "customer.phone")
+}
+
+func TestGeneratorSource(t *testing.T) {
+	schema, err := srclient.NewSchema(901, testGenerateAvro, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	generator, err := newRecordGenerator(nil, schema, newGenerateTestSpec(t, "key:\n  faker: city\n"), 1)
+	require.NoError(t, err)
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, config)
+	for i := 0; i < 3; i++ {
+		mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			key, err := msg.Key.Encode()
+			require.NoError(t, err)
+			require.Contains(t, fakeCities, string(key))
+			value, err := msg.Value.Encode()
+			require.NoError(t, err)
+			schemaID, err := wireFormatSchemaID(value)
+			require.NoError(t, err)
+			require.Equal(t, 901, schemaID)
+			return nil
+		})
+	}
+	var progress strings.Builder
+	source := generatorSource(context.Background(), "orders", generator, 3, 100)
+	start := time.Now()
+	require.NoError(t, produceMessages(mockProducer, "orders", source, &progress, 0))
+	// Three records at 100 per second take at least 20ms
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	require.Equal(t, "Produced to orders: 3 records queued, 3 acknowledged, 0 failed\n", progress.String())
+
+	// Without a count, records are generated until the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	source = generatorSource(ctx, "orders", generator, 0, 0)
+	for i := 0; i < 100; i++ {
+		msg, err := source()
+		require.NoError(t, err)
+		require.NotNil(t, msg)
+	}
+	cancel()
+	msg, err := source()
+	require.NoError(t, err)
+	require.Nil(t, msg)
+}
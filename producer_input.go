@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Bulk produce records from JSON lines, as the consumer's json output format prints them, so consumed records can be replayed.
+Fields other than key, value, their encodings, headers, partition, timestamp and the schema IDs are ignored. Records go to the topic
+given on the command line, not the one in the record.
+*/
+
+// A line of input. Key and value are JSON strings, other JSON (objects, numbers..) or null
+type inputRecord struct {
+	Key           json.RawMessage   `json:"key"`
+	Value         json.RawMessage   `json:"value"`
+	KeyEncoding   string            `json:"key_encoding"`   // base64 if the key string holds bytes that are not text
+	ValueEncoding string            `json:"value_encoding"` // base64 if the value string holds bytes that are not text
+	Headers       map[string]string `json:"headers"`
+	Partition     *int32            `json:"partition"`
+	Timestamp     *time.Time        `json:"timestamp"`
+	KeySchemaID   int               `json:"key_schema_id"`
+	ValSchemaID   int               `json:"value_schema_id"`
+}
+
+// Where a message came from, to report failures. Set as the message Metadata
+type inputMessageMetadata struct {
+	origin            string // Like "the record on line 12"
+	explicitPartition bool
+}
+
+func parseInputRecord(line []byte) (*inputRecord, error) {
+	var record inputRecord
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	if record.Partition != nil && *record.Partition < 0 {
+		return nil, fmt.Errorf("invalid partition %d", *record.Partition)
+	}
+	return &record, nil
+}
+
+/*
+The payload of a key or value. Strings are sent as they are and other JSON as JSON. Missing and null
+payloads are nil, and so is the value the consumer prints for tombstones. Strings with the base64 encoding
+are decoded to their bytes
+*/
+func inputPayload(raw json.RawMessage, encoding string, isValue bool) (*string, error) {
+	if encoding != "" && encoding != payloadEncodingBase64 {
+		return nil, fmt.Errorf("unknown encoding %s. Expected %s", encoding, payloadEncodingBase64)
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, err
+		}
+		if encoding == payloadEncodingBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, err
+			}
+			text = string(decoded)
+			return &text, nil
+		}
+		if isValue && text == tombstoneText {
+			return nil, nil
+		}
+		return &text, nil
+	}
+	if encoding != "" {
+		return nil, fmt.Errorf("a %s encoded payload must be a string", encoding)
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, raw); err != nil {
+		return nil, err
+	}
+	text = compacted.String()
+	return &text, nil
+}
+
+/*
+Create the message of an input record. With serialize set, values are serialized with their value_schema_id, or else with
+the value schema file or the latest schema of the topic, like the interactive producer. Keys are only serialized if they
+have a key_schema_id or a key schema file is given
+*/
+func (c *Producer) makeInputMessage(topic string, record *inputRecord, serialize bool, valSchemaPath, keySchemaPath string) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{Topic: topic}
+	key, err := inputPayload(record.Key, record.KeyEncoding, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	value, err := inputPayload(record.Value, record.ValueEncoding, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	if key != nil {
+		if serialize && (record.KeySchemaID > 0 || keySchemaPath != "") {
+			payload, err := c.serializeInputPayload(topic, *key, record.KeySchemaID, keySchemaPath, true)
+			if err != nil {
+				return nil, err
+			}
+			msg.Key = sarama.ByteEncoder(payload)
+		} else {
+			msg.Key = sarama.StringEncoder(*key)
+		}
+	}
+	if value != nil {
+		if serialize {
+			payload, err := c.serializeInputPayload(topic, *value, record.ValSchemaID, valSchemaPath, false)
+			if err != nil {
+				return nil, err
+			}
+			msg.Value = sarama.ByteEncoder(payload)
+		} else {
+			msg.Value = sarama.StringEncoder(*value)
+		}
+	}
+	for name, headerValue := range record.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(headerValue)})
+	}
+	if record.Partition != nil {
+		msg.Partition = *record.Partition
+	}
+	if record.Timestamp != nil {
+		msg.Timestamp = *record.Timestamp
+	}
+	return msg, nil
+}
+
+func (c *Producer) serializeInputPayload(topic, data string, schemaID int, schemaPath string, isKey bool) ([]byte, error) {
+	if schemaID == 0 {
+		return c.GetSerializedPayload(topic, data, schemaPath, "AVRO", isKey)
+	}
+	schema, err := c.SRClient.GetSchema(schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema ID [%d]: %w", schemaID, err)
+	}
+	return makeBinaryRecordUsingSchema(c.SRClient, data, schema)
+}
+
+// Sends records to the partition they have, and hashes the key of the others like the interactive producer
+type inputPartitioner struct {
+	hash sarama.Partitioner
+}
+
+func newInputPartitioner(topic string) sarama.Partitioner {
+	return &inputPartitioner{hash: sarama.NewReferenceHashPartitioner(topic)}
+}
+
+func (p *inputPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if metadata, ok := message.Metadata.(*inputMessageMetadata); ok && metadata.explicitPartition {
+		if message.Partition >= numPartitions {
+			return -1, fmt.Errorf("partition %d doesn't exist. The topic has %d partitions", message.Partition, numPartitions)
+		}
+		return message.Partition, nil
+	}
+	return p.hash.Partition(message, numPartitions)
+}
+
+func (p *inputPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// Counts of a bulk produce
+type inputProduceStats struct {
+	Queued       int // Records given to the producer
+	Acknowledged int
+	Failed       int
+	mutex        sync.Mutex
+}
+
+func (s *inputProduceStats) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fmt.Sprintf("%d records queued, %d acknowledged, %d failed", s.Queued, s.Acknowledged, s.Failed)
+}
+
+// Produce the JSON lines of input to topic with an async producer, printing progress to progress every interval
+func (c *Producer) ProduceInput(topic string, input io.Reader, serialize bool, valSchemaPath, keySchemaPath string, progress io.Writer, interval time.Duration) error {
+	producer, err := c.newAsyncProducer()
+	if err != nil {
+		return err
+	}
+	return c.produceInput(producer, topic, input, serialize, valSchemaPath, keySchemaPath, progress, interval)
+}
+
+func (c *Producer) produceInput(producer sarama.AsyncProducer, topic string, input io.Reader, serialize bool, valSchemaPath, keySchemaPath string, progress io.Writer, interval time.Duration) error {
+	return produceMessages(producer, topic, c.inputSource(topic, input, serialize, valSchemaPath, keySchemaPath), progress, interval)
+}
+
+// An async producer for produceMessages. Records go to their explicit partition, or by the hash of their key
+func (c *Producer) newAsyncProducer() (sarama.AsyncProducer, error) {
+	c.config.Producer.Partitioner = newInputPartitioner
+	c.config.Producer.Return.Successes = true
+	c.config.Producer.Return.Errors = true
+	return sarama.NewAsyncProducer(c.brokers, c.config)
+}
+
+// Gives the next message to produce, or nil when there are no more
+type messageSource func() (*sarama.ProducerMessage, error)
+
+/*
+Produce the messages of source with an async producer, printing progress to progress every interval.
+Messages that fail are logged. Stops at the first error of source, once the queued messages are sent
+*/
+func produceMessages(producer sarama.AsyncProducer, topic string, source messageSource, progress io.Writer, interval time.Duration) error {
+	var stats inputProduceStats
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range producer.Successes() {
+			stats.mutex.Lock()
+			stats.Acknowledged++
+			stats.mutex.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for produceErr := range producer.Errors() {
+			origin := "a record"
+			if metadata, ok := produceErr.Msg.Metadata.(*inputMessageMetadata); ok {
+				origin = metadata.origin
+			}
+			log.Errorf("Failed to produce %s: %s", origin, produceErr.Err)
+			stats.mutex.Lock()
+			stats.Failed++
+			stats.mutex.Unlock()
+		}
+	}()
+	done := make(chan struct{})
+	if progress != nil && interval > 0 {
+		ticker := time.NewTicker(interval)
+		go func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					fmt.Fprintf(progress, "Producing: %s\n", &stats)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	var sourceErr error
+	for {
+		var msg *sarama.ProducerMessage
+		msg, sourceErr = source()
+		if msg == nil || sourceErr != nil {
+			break
+		}
+		producer.Input() <- msg
+		stats.mutex.Lock()
+		stats.Queued++
+		stats.mutex.Unlock()
+	}
+	// Close waits for the buffered records to be sent and closes Successes and Errors
+	producer.AsyncClose()
+	wg.Wait()
+	close(done)
+	if progress != nil {
+		fmt.Fprintf(progress, "Produced to %s: %s\n", topic, &stats)
+	}
+	if sourceErr != nil {
+		return sourceErr
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("failed to produce %d records", stats.Failed)
+	}
+	return nil
+}
+
+// Messages of the records of the input. Stops at the first line that is not a valid record
+func (c *Producer) inputSource(topic string, input io.Reader, serialize bool, valSchemaPath, keySchemaPath string) messageSource {
+	reader := bufio.NewReader(input)
+	lineNumber := 0
+	return func() (*sarama.ProducerMessage, error) {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if len(line) > 0 {
+				lineNumber++
+			}
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+				record, parseErr := parseInputRecord(trimmed)
+				if parseErr != nil {
+					return nil, fmt.Errorf("invalid record on line %d: %w", lineNumber, parseErr)
+				}
+				msg, msgErr := c.makeInputMessage(topic, record, serialize, valSchemaPath, keySchemaPath)
+				if msgErr != nil {
+					return nil, fmt.Errorf("invalid record on line %d: %w", lineNumber, msgErr)
+				}
+				msg.Metadata = &inputMessageMetadata{origin: fmt.Sprintf("the record on line %d", lineNumber), explicitPartition: record.Partition != nil}
+				return msg, nil
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/kmetaxas/srclient"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeInputMessage(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 14, 5, 0, 0, time.UTC)
+	headers := []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("abc")}}
+	// The consumer's json output is accepted as it is
+	message := &sarama.ConsumerMessage{Topic: "orders", Key: []byte("1042"), Value: []byte(`{"id": 1, "total": 9.5}`), Timestamp: timestamp, Partition: 3, Offset: 120, Headers: headers}
+	line, err := jsonRecord(&sinkRecord{Message: message, Key: string(message.Key), Value: string(message.Value)})
+	require.NoError(t, err)
+	record, err := parseInputRecord(line)
+	require.NoError(t, err)
+	producer := &Producer{}
+	msg, err := producer.makeInputMessage("orders-replay", record, false, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "orders-replay", msg.Topic)
+	require.Equal(t, sarama.StringEncoder("1042"), msg.Key)
+	require.Equal(t, sarama.StringEncoder(`{"id":1,"total":9.5}`), msg.Value)
+	require.Equal(t, int32(3), msg.Partition)
+	require.Equal(t, timestamp, msg.Timestamp)
+	require.Equal(t, []sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("abc")}}, msg.Headers)
+
+	// Strings are sent without quotes. Null and the consumer's tombstone value are tombstones
+	for _, line := range []string{`{"key":"a b","value":null}`, `{"key":"a b","value":"Null (Tombstone?)"}`, `{"key":"a b"}`} {
+		record, err := parseInputRecord([]byte(line))
+		require.NoError(t, err)
+		msg, err := producer.makeInputMessage("orders", record, false, "", "")
+		require.NoError(t, err)
+		require.Equal(t, sarama.StringEncoder("a b"), msg.Key, line)
+		require.Nil(t, msg.Value, line)
+		require.Equal(t, int32(0), msg.Partition, line)
+		require.True(t, msg.Timestamp.IsZero(), line)
+	}
+
+	for _, line := range []string{`{"key":`, `{"partition":-1}`, `{"timestamp":"yesterday"}`, `["a"]`} {
+		_, err := parseInputRecord([]byte(line))
+		require.Error(t, err, line)
+	}
+}
+
+// Records printed by the consumer's json output are produced with the bytes they were consumed with
+func TestConsumeProduceRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		[]byte(`"abc"`),
+		[]byte("null"),
+		[]byte("12345678901234567890"),
+		[]byte(`{"id":9007199254740993,"b":1,"a":2}`),
+		[]byte("plain text"),
+		{0x00, 0xff, '\\', 'x', '0', '0'},
+		[]byte("\x00"),
+		{},
+		nil,
+	}
+	var lines [][]byte
+	// Keys starting with the magic byte are looked up in the registry and then printed as strings
+	consumer := newDeserializeTestConsumer(t)
+	require.NoError(t, consumer.SetPayloadFormats(payloadFormatAuto, payloadFormatString))
+	consumer.SetRecordPrinterFunc(func(record *sinkRecord) {
+		line, err := jsonRecord(record)
+		require.NoError(t, err)
+		lines = append(lines, line)
+	})
+	for offset, payload := range payloads {
+		consumer.processMessage(&sarama.ConsumerMessage{Topic: "orders", Offset: int64(offset), Key: payload, Value: payload})
+	}
+	require.Len(t, lines, len(payloads))
+	producer := &Producer{}
+	for i, line := range lines {
+		record, err := parseInputRecord(line)
+		require.NoError(t, err)
+		msg, err := producer.makeInputMessage("orders-replay", record, false, "", "")
+		require.NoError(t, err, string(line))
+		if payloads[i] == nil {
+			require.Nil(t, msg.Key, string(line))
+			require.Nil(t, msg.Value, string(line))
+			continue
+		}
+		key, err := msg.Key.Encode()
+		require.NoError(t, err)
+		require.Equal(t, payloads[i], key, string(line))
+		value, err := msg.Value.Encode()
+		require.NoError(t, err)
+		require.Equal(t, payloads[i], value, string(line))
+	}
+
+	for _, line := range []string{`{"value":"AAE","value_encoding":"base64"}`, `{"value":{"a":1},"value_encoding":"base64"}`, `{"value":"a","value_encoding":"hex"}`} {
+		record, err := parseInputRecord([]byte(line))
+		require.NoError(t, err)
+		_, err = producer.makeInputMessage("orders", record, false, "", "")
+		require.Error(t, err, line)
+	}
+}
+
+func TestMakeInputMessageSerialize(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	producer := &Producer{SRClient: consumer.SRClient}
+	record, err := parseInputRecord([]byte(`{"key":"u1","value":{"name":"jane"},"key_schema_id":0,"value_schema_id":1}`))
+	require.NoError(t, err)
+	msg, err := producer.makeInputMessage("users", record, true, "", "")
+	require.NoError(t, err)
+	// Keys without a schema are not serialized
+	require.Equal(t, sarama.StringEncoder("u1"), msg.Key)
+	value, err := msg.Value.Encode()
+	require.NoError(t, err)
+	schemaID, err := wireFormatSchemaID(value)
+	require.NoError(t, err)
+	require.Equal(t, 1, schemaID)
+	codec, err := goavro.NewCodec(`{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`)
+	require.NoError(t, err)
+	native, _, err := codec.NativeFromBinary(value[5:])
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"name": "jane"}, native)
+
+	// References of Protobuf schemas are fetched with the producer's client
+	producerProtobufFiles.Delete(2)
+	record, err = parseInputRecord([]byte(`{"value":{"id":"h-1"},"value_schema_id":2}`))
+	require.NoError(t, err)
+	msg, err = producer.makeInputMessage("orders", record, true, "", "")
+	require.NoError(t, err)
+	value, err = msg.Value.Encode()
+	require.NoError(t, err)
+	deserialized, _, err := consumer.DeserializePayload(value)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"h-1"}`, deserialized)
+
+	// Values that don't match their schema are errors, not a crash
+	for _, line := range []string{`{"value":{"age":1},"value_schema_id":1}`, `{"value":"{","value_schema_id":4}`} {
+		record, err := parseInputRecord([]byte(line))
+		require.NoError(t, err)
+		_, err = producer.makeInputMessage("users", record, true, "", "")
+		require.Error(t, err, line)
+	}
+	// Schemas that are not AVRO, without a schema type
+	schema, err := srclient.NewSchema(9, `{"type":"object"}`, srclient.Avro, 1, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = makeBinaryRecordUsingSchema(nil, `{}`, schema)
+	require.ErrorContains(t, err, "not a valid AVRO schema")
+}
+
+func TestProduceInput(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Partitioner = newInputPartitioner
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, config)
+	mockProducer.TopicConfig.SetDefaultPartitions(4)
+	var partitions []int32
+	checkPartition := func(msg *sarama.ProducerMessage) error {
+		partitions = append(partitions, msg.Partition)
+		return nil
+	}
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(checkPartition)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(checkPartition)
+	mockProducer.ExpectInputAndFail(errors.New("broker down"))
+
+	input := strings.Join([]string{
+		`{"key":"a","value":"1","partition":2}`,
+		``,
+		`{"key":"a","value":"2"}`,
+		`{"key":"b","value":"3","partition":1}`,
+	}, "\n")
+	var progress bytes.Buffer
+	err := (&Producer{}).produceInput(mockProducer, "orders", strings.NewReader(input), false, "", "", &progress, 0)
+	require.EqualError(t, err, "failed to produce 1 records")
+	require.Equal(t, int32(2), partitions[0])
+	require.Len(t, partitions, 2)
+	require.Equal(t, "Produced to orders: 3 records queued, 2 acknowledged, 1 failed\n", progress.String())
+}
+
+func TestProduceInputInvalidLine(t *testing.T) {
+	mockProducer := mocks.NewAsyncProducer(t, mocks.NewTestConfig())
+	mockProducer.ExpectInputAndSucceed()
+	input := "{\"value\":\"1\"}\n{\"value\":\n"
+	err := (&Producer{}).produceInput(mockProducer, "orders", strings.NewReader(input), false, "", "", nil, 0)
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestProduceInputInvalidPayload(t *testing.T) {
+	consumer := newDeserializeTestConsumer(t)
+	producer := &Producer{SRClient: consumer.SRClient}
+	mockProducer := mocks.NewAsyncProducer(t, mocks.NewTestConfig())
+	mockProducer.ExpectInputAndSucceed()
+	input := "{\"value\":{\"name\":\"jane\"},\"value_schema_id\":1}\n{\"value\":{\"name\":1},\"value_schema_id\":1}\n"
+	err := producer.produceInput(mockProducer, "users", strings.NewReader(input), true, "", "", nil, 0)
+	require.ErrorContains(t, err, "invalid record on line 2")
+}
+
+func TestInputPartitioner(t *testing.T) {
+	partitioner := newInputPartitioner("orders")
+	msg := &sarama.ProducerMessage{Partition: 5, Metadata: &inputMessageMetadata{explicitPartition: true}}
+	_, err := partitioner.Partition(msg, 3)
+	require.Error(t, err)
+	partition, err := partitioner.Partition(msg, 6)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), partition)
+	// Others are hashed by key like the Java producer
+	msg = &sarama.ProducerMessage{Key: sarama.StringEncoder("a"), Metadata: &inputMessageMetadata{}}
+	expected, err := sarama.NewReferenceHashPartitioner("orders").Partition(msg, 6)
+	require.NoError(t, err)
+	partition, err = partitioner.Partition(msg, 6)
+	require.NoError(t, err)
+	require.Equal(t, expected, partition)
+}
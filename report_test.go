@@ -36,6 +36,6 @@ func TestNewReport(t *testing.T) {
 	connect_results = append(connect_results, connect_res1)
 
 	var clusterlink_results []ClusterLinkResult
-	NewReport(topic_results, schema_results, client_results, connect_results, clusterlink_results, false)
-	NewReport(topic_results, schema_results, client_results, connect_results, clusterlink_results, true)
+	NewReport(topic_results, schema_results, nil, client_results, connect_results, clusterlink_results, false)
+	NewReport(topic_results, schema_results, nil, client_results, connect_results, clusterlink_results, true)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/IBM/sarama"
 )
 
@@ -10,6 +13,7 @@ type Results struct {
 	Clients          []ClientResult
 	Connectors       []ConnectorResult
 	ClusterLinks     []ClusterLinkResult
+	SchemaExporters  []SchemaExporterResult
 	IsPlan           bool
 	ExtraContextKeys map[string]string // Used to pass extra context keys for use by templates
 }
@@ -23,6 +27,19 @@ type ClusterLinkResult struct {
 	Error      error
 }
 
+type SchemaExporterResult struct {
+	Name       string
+	Status     string                   // "Created", "Updated", "Deleted", "NoChange", "Error"
+	Changes    []ChangedConnectorConfig // Definition and config changes. Sensitive values are masked
+	NewState   string                   // Set when the exporter will be paused (PAUSED) or resumed (RUNNING)
+	State      string                   // State reported by the registry. Empty for new exporters
+	Offset     int64                    // Offset of _schemas exported so far
+	LagRecords int64                    // Records of _schemas not exported yet. -1 when unknown
+	LastExport time.Time                // Time of the last export
+	Trace      string                   // Error reported by the registry
+	Error      error
+}
+
 type TopicResult struct {
 	Name                 string
 	NewPartitions        int32 // can be compared with OldPartitions to see if changed
@@ -45,6 +62,13 @@ type SchemaResult struct {
 	IsCompatible         bool     // Result of compatibility check
 	CompatibilityLevel   string   // Compatibility level used for the check
 	CompatibilityErrors  []string // Detailed reasons for incompatibility
+	NewMode              string   // Will be set if the mode changed. SubjectName is empty for the global mode and :.ctx: for a context
+	NewContext           bool     // The context (SubjectName :.ctx:) does not exist in the registry yet
+	DeletedVersions      []int    // Versions pruned by retain_versions
+	DeletedSubject       bool     // The whole subject was deleted as an orphan
+	PermanentDelete      bool     // Deletes are hard deletes
+	RetentionSkipped     string   // Why versions beyond retain_versions were kept
+	ContractChanges      []string // Changes to the metadata and rules of the subject
 }
 
 type ClientResult struct {
@@ -138,6 +162,46 @@ func (res *SchemaResult) HasNewCompatibility() bool {
 	return res.NewCompat != ""
 }
 
+// Check if result has a new mode set
+func (res *SchemaResult) HasNewMode() bool {
+	return res.NewMode != ""
+}
+
+func (res *SchemaResult) HasDeletedVersions() bool {
+	return len(res.DeletedVersions) > 0
+}
+
+func (res *SchemaResult) HasRetentionSkipped() bool {
+	return res.RetentionSkipped != ""
+}
+
+func (res *SchemaResult) HasContractChanges() bool {
+	return len(res.ContractChanges) > 0
+}
+
+// Soft or hard, for display
+func (res *SchemaResult) DeleteKind() string {
+	if res.PermanentDelete {
+		return "permanently deleted"
+	}
+	return "soft deleted"
+}
+
+// Is this the result of a subject, rather than the global or a context setting
+func (res *SchemaResult) IsSubject() bool {
+	context, subject := splitQualifiedSubject(res.SubjectName)
+	return subject != "" || (res.SubjectName != "" && context == defaultSchemaContext)
+}
+
+// What a non-subject result applies to, for display
+func (res *SchemaResult) SettingTarget() string {
+	if res.SubjectName == "" {
+		return "Global"
+	}
+	context, _ := splitQualifiedSubject(res.SubjectName)
+	return fmt.Sprintf("Context %s", context)
+}
+
 func (res *SchemaResult) HasNewVersion() bool {
 	if res.NewVersion != 0 {
 		return true
@@ -242,3 +306,15 @@ func (clr *ClusterLinkResult) HasChangedConfigs() bool {
 	}
 	return false
 }
+
+func (er *SchemaExporterResult) HasStatus() bool {
+	return er.State != ""
+}
+
+func (er *SchemaExporterResult) HasLag() bool {
+	return er.LagRecords >= 0
+}
+
+func (er *SchemaExporterResult) HasLastExport() bool {
+	return !er.LastExport.IsZero()
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,23 +20,45 @@ import (
 
 // Schema object to keep track of desired/requested state
 type Schema struct {
-	SubjectName   string
-	SchemaPath    string `yaml:"schema"`
-	Compatibility string `yaml:"compatibility"`
-	SchemaData    string
-	SchemaType    srclient.SchemaType `yaml:"schema_type"`
+	SubjectName    string       `yaml:"subject"` // Only read for standalone subjects. Topic schemas derive it from the topic
+	SchemaPath     string       `yaml:"schema"`
+	SchemaInline   inlineSchema `yaml:"schema_inline"` // Schema embedded in the YAML, instead of a file
+	Compatibility  string       `yaml:"compatibility"`
+	SchemaData     string
+	SchemaType     srclient.SchemaType  `yaml:"schema_type"`
+	References     []srclient.Reference `yaml:"references"`
+	Mode           string               `yaml:"mode"`            // READWRITE, READONLY, READONLY_OVERRIDE or IMPORT. Empty follows the context/global mode
+	RetainVersions int                  `yaml:"retain_versions"` // Prune older versions beyond this many. Overrides the global retain_versions
+	Metadata       *SchemaMetadata      `yaml:"metadata"`        // Data contract metadata. Nil leaves the registered metadata as it is
+	RuleSet        *SchemaRuleSet       `yaml:"rules"`           // Data contract rules. Nil leaves the registered rules as they are
+}
+
+// Is anything defined for this schema. An undefined schema (for example a topic without a key) is not reconciled
+func (s *Schema) IsDefined() bool {
+	return s.SubjectName != "" || s.SchemaPath != "" || s.SchemaInline.Data != "" || s.Compatibility != "" || s.SchemaData != "" || s.SchemaType != "" || len(s.References) > 0 || s.Mode != "" || s.RetainVersions > 0 || s.HasContract()
 }
 
 // Compare the schema text of the two objects and return a tuple.
 // with a boolean if they are equal and a string with changes
-// Assumes JSON.
+// Both texts are assumed to be of this schema's type. Equality uses the canonical form of the schemas,
+// so formatting differences don't count as changes.
 func (s *Schema) SchemaDiff(other string) (bool, string) {
 	var isEqual bool
 	var diffString string
 	var cmpRes jsondiff.Difference
+	canonicalEqual, err := canonicalSchemaEquals(s.SchemaType, s.SchemaData, other)
+	if err != nil {
+		log.Debugf("Canonical comparison of %s failed, comparing as JSON: %s", s.SubjectName, err)
+	}
+	if s.SchemaType == srclient.Protobuf {
+		if canonicalEqual {
+			return true, ""
+		}
+		return false, textDiff(s.SchemaData, other)
+	}
 	opts := jsondiff.DefaultConsoleOptions()
 	cmpRes, diffString = jsondiff.Compare([]byte(s.SchemaData), []byte(other), &opts)
-	isEqual = (cmpRes == jsondiff.FullMatch)
+	isEqual = (cmpRes == jsondiff.FullMatch) || canonicalEqual
 
 	return isEqual, diffString
 }
@@ -42,13 +66,17 @@ func (s *Schema) SchemaDiff(other string) (bool, string) {
 // Create a new Schema instance. Handles defaults etc
 // Does not register it
 func CreateSchema(SubjectName string, SchemaPath string, Compatibility string, SchemaType srclient.SchemaType) (Schema, error) {
+	return createSchemaFromFile(SubjectName, SchemaPath, normalizeSchemaPath(SchemaPath), Compatibility, SchemaType)
+}
+
+// Create a new Schema reading the schema data from pathToSchemaFile. SchemaPath is kept as declared
+func createSchemaFromFile(SubjectName string, SchemaPath string, pathToSchemaFile string, Compatibility string, SchemaType srclient.SchemaType) (Schema, error) {
 	var newSchema Schema
 	newSchema.SubjectName = SubjectName
 	newSchema.SchemaPath = SchemaPath
 	newSchema.Compatibility = Compatibility
 
 	// Load the schema data
-	pathToSchemaFile := normalizeSchemaPath(SchemaPath)
 	if pathToSchemaFile != "" && SchemaPath != "" {
 		data, err := os.ReadFile(pathToSchemaFile)
 		if err != nil {
@@ -70,11 +98,54 @@ func (s *Schema) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
-	var err error
-	*s, err = CreateSchema(raw.SubjectName, raw.SchemaPath, raw.Compatibility, raw.SchemaType)
+	if raw.SchemaPath != "" && raw.SchemaInline.Data != "" {
+		return fmt.Errorf("subject %s sets both schema and schema_inline", raw.SubjectName)
+	}
+	// The schema file is read by loadSchemaFile, once the directory of the input is known
+	*s = Schema{SubjectName: raw.SubjectName, SchemaPath: raw.SchemaPath, Compatibility: raw.Compatibility}
+	if raw.SchemaPath != "" {
+		s.SchemaType = raw.SchemaType
+		if s.SchemaType == "" {
+			s.SchemaType = srclient.Avro
+		}
+	}
+	if raw.SchemaInline.Data != "" {
+		if raw.SchemaInline.Structured && raw.SchemaType == srclient.Protobuf {
+			return fmt.Errorf("subject %s: an inline PROTOBUF schema must be text", raw.SubjectName)
+		}
+		s.SchemaInline = raw.SchemaInline
+		s.SchemaData = raw.SchemaInline.Data
+		s.SchemaType = raw.SchemaType
+		if s.SchemaType == "" {
+			s.SchemaType = srclient.Avro
+		}
+	}
+	s.References = raw.References
+	if raw.Mode != "" && !isValidSchemaMode(raw.Mode) {
+		return fmt.Errorf("subject %s has unknown mode %s", raw.SubjectName, raw.Mode)
+	}
+	s.Mode = strings.ToUpper(raw.Mode)
+	s.RetainVersions = raw.RetainVersions
+	if raw.RuleSet != nil {
+		if err := raw.RuleSet.validate(); err != nil {
+			return fmt.Errorf("subject %s: %w", raw.SubjectName, err)
+		}
+	}
+	s.Metadata = raw.Metadata
+	s.RuleSet = raw.RuleSet
+	return nil
+}
+
+// Read the schema file of a schema from an input YAML in directory inputDir. See resolveSchemaPath
+func (s *Schema) loadSchemaFile(inputDir string) error {
+	if s.SchemaPath == "" || s.SchemaData != "" {
+		return nil
+	}
+	data, err := os.ReadFile(resolveSchemaPath(inputDir, s.SchemaPath))
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to read schema of %s: %w", s.SubjectName, err)
 	}
+	s.SchemaData = string(data)
 	return nil
 }
 
@@ -90,6 +161,12 @@ type SRAdmin struct {
 	UseSRCache         bool // Use schema registy cache for requests
 	SRCache            *SchemaRegistryCache
 	CheckCompatibility bool // Check compatibility before registration
+	Normalize          bool // Ask the registry to normalize schemas on register and lookup
+	// Reads the schema IDs in the records of a topic, so pruning keeps the versions in use. Nil without Kafka
+	scanTopicSchemaIDs func(topic string) (map[int]bool, error)
+	topicSchemaIDs     map[string]map[int]bool // Topics read so far
+	// The high watermark of _schemas, for exporter lag without the cache. Nil without Kafka
+	schemasHighWaterMark func() (int64, error)
 }
 
 // Create a new SRAdmin
@@ -119,11 +196,20 @@ func NewSRAdmin(config *Configuration) SRAdmin {
 	}
 
 	sradmin := SRAdmin{Client: *srClient, user: config.Connections.Schemaregistry.Username, pass: config.Connections.Schemaregistry.Password}
-	sradmin.url = config.Connections.Schemaregistry.Url
+	sradmin.url = strings.TrimSuffix(config.Connections.Schemaregistry.Url, "/")
 	sradmin.CheckCompatibility = config.Connections.Schemaregistry.CheckCompatibility
+	sradmin.Normalize = config.Connections.Schemaregistry.Normalize
 	if config.Connections.Schemaregistry.SkipRestForReads {
 		sradmin.UseSRCache = true
 	}
+	if len(config.Connections.Kafka.Brokers) > 0 {
+		sradmin.scanTopicSchemaIDs = func(topic string) (map[int]bool, error) {
+			return scanTopicSchemaIDs(config, topic)
+		}
+		sradmin.schemasHighWaterMark = func() (int64, error) {
+			return schemasHighWaterMark(config)
+		}
+	}
 	if sradmin.UseSRCache {
 		srCache, err := NewSchemaRegistryCache(config)
 		if err != nil {
@@ -151,8 +237,11 @@ func (admin *SRAdmin) IsUsuable() bool {
 }
 
 func (admin *SRAdmin) RegisterSubject(schema Schema) (int, error) {
-	// Create a value subject (isKey = false)
-	newSchema, err := admin.Client.CreateSchema(schema.SubjectName, schema.SchemaData, schema.SchemaType)
+	// srclient drops references for AVRO schemas and can't pass normalize or data contracts, so register those through the REST API ourselves
+	if admin.Normalize || schema.HasContract() || (len(schema.References) > 0 && schema.SchemaType == srclient.Avro) {
+		return admin.registerSubjectRest(schema)
+	}
+	newSchema, err := admin.Client.CreateSchema(schema.SubjectName, schema.SchemaData, schema.SchemaType, schema.References...)
 	if err != nil {
 		return 0, err
 	}
@@ -160,10 +249,58 @@ func (admin *SRAdmin) RegisterSubject(schema Schema) (int, error) {
 	return newSchema.Version(), nil
 }
 
+// Register a schema using the REST API and return the new version
+func (admin *SRAdmin) registerSubjectRest(schema Schema) (int, error) {
+	type Request struct {
+		Schema     string               `json:"schema"`
+		SchemaType string               `json:"schemaType,omitempty"`
+		References []srclient.Reference `json:"references,omitempty"`
+		Metadata   *SchemaMetadata      `json:"metadata,omitempty"`
+		RuleSet    *SchemaRuleSet       `json:"ruleSet,omitempty"`
+	}
+	type Response struct {
+		Id      int `json:"id"`
+		Version int `json:"version"` // Only returned by newer registries
+	}
+	reqObj := Request{Schema: schema.SchemaData, References: schema.References, Metadata: schema.Metadata, RuleSet: schema.RuleSet}
+	if schema.SchemaType != srclient.Avro {
+		reqObj.SchemaType = string(schema.SchemaType)
+	}
+	request, err := json.Marshal(reqObj)
+	if err != nil {
+		return 0, err
+	}
+	respBody, err := admin.makeRestCall("POST", fmt.Sprintf("%s/subjects/%s/versions%s", admin.url, srSubjectPath(schema.SubjectName), admin.normalizeQuery()), bytes.NewBuffer(request))
+	if err != nil {
+		return 0, err
+	}
+	var respObj Response
+	if err = json.Unmarshal(respBody, &respObj); err != nil {
+		return 0, fmt.Errorf("failed unmarshaling response from register: %w", err)
+	}
+	if respObj.Id == 0 {
+		return 0, fmt.Errorf("failed to register %s: %s", schema.SubjectName, respBody)
+	}
+	if respObj.Version != 0 {
+		return respObj.Version, nil
+	}
+	// Older registries only return the ID. Lookup the version it got
+	_, version, err := admin.LookupSchema(schema)
+	return version, err
+}
+
 func (admin *SRAdmin) IsRegistered(schema Schema) error {
 	return nil
 }
 
+// Query string to append to register/lookup calls so the registry normalizes the schema, if enabled
+func (admin *SRAdmin) normalizeQuery() string {
+	if admin.Normalize {
+		return "?normalize=true"
+	}
+	return ""
+}
+
 // Facilitate Rest calls to Schema registry as some calls are not offered by srclient library yet.
 func (admin *SRAdmin) makeRestCall(method string, uri string, payload io.Reader) ([]byte, error) {
 	hClient := http.Client{}
@@ -186,13 +323,29 @@ func (admin *SRAdmin) makeRestCall(method string, uri string, payload io.Reader)
 	return respBody, nil
 }
 
+// makeRestCall does not look at HTTP status codes. Turn a registry error body into an error
+func restResponseError(respBody []byte) error {
+	type ErrorResponse struct {
+		ErrorCode int    `json:"error_code"`
+		Message   string `json:"message"`
+	}
+	var respObj ErrorResponse
+	if err := json.Unmarshal(respBody, &respObj); err != nil {
+		return nil
+	}
+	if respObj.ErrorCode != 0 {
+		return fmt.Errorf("schema registry error %d: %s", respObj.ErrorCode, respObj.Message)
+	}
+	return nil
+}
+
 // Lookup a Schema object in the Registry.
 // If it exists it will return the ID and the version for that subject
 func (admin *SRAdmin) LookupSchema(schema Schema) (int, int, error) {
 	var existingID, existingVersion int
 	var err error
 	if admin.UseSRCache {
-		existingID, existingVersion, err = admin.SRCache.LookupSchemaForSubject(schema.SubjectName, schema.SchemaData)
+		existingID, existingVersion, err = admin.SRCache.LookupSchemaForSubject(schema)
 	} else {
 		type Response struct {
 			Subject string `json:"subject"`
@@ -201,7 +354,8 @@ func (admin *SRAdmin) LookupSchema(schema Schema) (int, int, error) {
 			Schema  string `json:"schema"`
 		}
 		type Request struct {
-			Schema string `json:"schema"`
+			Schema     string               `json:"schema"`
+			References []srclient.Reference `json:"references,omitempty"`
 		}
 		type RequestNonAvro struct {
 			Request
@@ -211,14 +365,14 @@ func (admin *SRAdmin) LookupSchema(schema Schema) (int, int, error) {
 		var err error
 		// field schemaType was introduced in confluent 5.5 along with protobuf/jsonschema support. Even though its in the docs, it raises an HTTP 422. So only pass it when schema type is not AVRO
 		if schema.SchemaType != "AVRO" {
-			request, err = json.Marshal(RequestNonAvro{Request: Request{Schema: string(schema.SchemaData)}, SchemaType: string(schema.SchemaType)})
+			request, err = json.Marshal(RequestNonAvro{Request: Request{Schema: string(schema.SchemaData), References: schema.References}, SchemaType: string(schema.SchemaType)})
 		} else {
-			request, err = json.Marshal(Request{Schema: string(schema.SchemaData)})
+			request, err = json.Marshal(Request{Schema: string(schema.SchemaData), References: schema.References})
 		}
 		if err != nil {
 			log.Fatalf("Failed to construct request for LookupSchema call: %s\n", err)
 		}
-		respBody, err := admin.makeRestCall("POST", fmt.Sprintf("%s/subjects/%s", admin.url, schema.SubjectName), bytes.NewBuffer(request))
+		respBody, err := admin.makeRestCall("POST", fmt.Sprintf("%s/subjects/%s%s", admin.url, srSubjectPath(schema.SubjectName), admin.normalizeQuery()), bytes.NewBuffer(request))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -247,16 +401,17 @@ func (admin *SRAdmin) SetCompatibility(schema Schema, compatibility string) erro
 	if err != nil {
 		log.Fatal(err)
 	}
-	respBody, err := admin.makeRestCall("PUT", fmt.Sprintf("%s/config/%s", admin.url, schema.SubjectName), bytes.NewBuffer(request))
+	respBody, err := admin.makeRestCall("PUT", fmt.Sprintf("%s/config/%s", admin.url, srSubjectPath(schema.SubjectName)), bytes.NewBuffer(request))
 	if err != nil {
-		log.Fatalf("Failed alter compatibility for schema %s with error: %s", schema.SubjectName, err)
+		return fmt.Errorf("failed to alter compatibility of %s: %w", schema.SubjectName, err)
+	}
+	if err := restResponseError(respBody); err != nil {
+		return fmt.Errorf("failed to alter compatibility of %s: %w", schema.SubjectName, err)
 	}
 	var respObj RequestResponse
-	err = json.Unmarshal(respBody, &respObj)
-	if err != nil {
-		log.Fatalf("Failed unmarshaling response from POST: %s\n", err)
+	if err := json.Unmarshal(respBody, &respObj); err != nil {
+		return fmt.Errorf("failed to unmarshal the compatibility of %s: %w", schema.SubjectName, err)
 	}
-
 	return nil
 }
 
@@ -271,7 +426,7 @@ func (admin *SRAdmin) GetCompatibility(schema Schema) (string, error) {
 		// Confluent docs say the return field is `compatibility` but the example (and reality) is `compatibilityLevel`
 		Compatibility string `json:"compatibilityLevel"`
 	}
-	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/config/%s", admin.url, schema.SubjectName), bytes.NewBuffer(nil))
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/config/%s", admin.url, srSubjectPath(schema.SubjectName)), bytes.NewBuffer(nil))
 	if err != nil {
 		log.Printf("Failed to get compat:%s\n", err)
 		return "", err
@@ -314,8 +469,9 @@ func (admin *SRAdmin) GetCompatibilityGlobal() (string, error) {
 // Returns compatibility status, compatibility level used, and detailed error messages
 func (admin *SRAdmin) DoCompatibilityTest(schema Schema) (bool, string, []string, error) {
 	type CompatibilityRequest struct {
-		Schema     string              `json:"schema"`
-		SchemaType srclient.SchemaType `json:"schemaType,omitempty"`
+		Schema     string               `json:"schema"`
+		SchemaType srclient.SchemaType  `json:"schemaType,omitempty"`
+		References []srclient.Reference `json:"references,omitempty"`
 	}
 	type CompatibilityResponse struct {
 		IsCompatible bool     `json:"is_compatible"`
@@ -331,7 +487,8 @@ func (admin *SRAdmin) DoCompatibilityTest(schema Schema) (bool, string, []string
 	}
 
 	reqObj := CompatibilityRequest{
-		Schema: schema.SchemaData,
+		Schema:     schema.SchemaData,
+		References: schema.References,
 	}
 	if schema.SchemaType != "" && schema.SchemaType != "AVRO" {
 		reqObj.SchemaType = schema.SchemaType
@@ -344,7 +501,7 @@ func (admin *SRAdmin) DoCompatibilityTest(schema Schema) (bool, string, []string
 
 	// Make the REST call with verbose=true to get detailed error messages
 	// Note: This endpoint is available in Confluent Platform 7.0+
-	uri := fmt.Sprintf("%s/compatibility/subjects/%s/versions?verbose=true", admin.url, schema.SubjectName)
+	uri := fmt.Sprintf("%s/compatibility/subjects/%s/versions?verbose=true", admin.url, srSubjectPath(schema.SubjectName))
 	respBody, err := admin.makeRestCall("POST", uri, bytes.NewBuffer(request))
 	if err != nil {
 		return false, compatLevel, nil, fmt.Errorf("compatibility check API call failed: %w", err)
@@ -368,6 +525,30 @@ func (admin *SRAdmin) ReconcileSchema(schema Schema, dryRun bool) *SchemaResult
 	if err != nil {
 		log.Fatal(err)
 	}
+	// ---- Mode. A subject that is made writable must be so before registering, otherwise it's changed afterwards
+	var modeChanged bool
+	if schema.Mode != "" {
+		curMode, err := admin.GetMode(schema.SubjectName)
+		if err != nil {
+			log.Fatalf("Failed to get mode of %s: %s", schema.SubjectName, err)
+		}
+		if !strings.EqualFold(curMode, schema.Mode) {
+			result.NewMode = schema.Mode
+		}
+	}
+	setMode := func() {
+		if dryRun || result.NewMode == "" || modeChanged {
+			return
+		}
+		log.Debugf("Setting mode for subject %s to %s", schema.SubjectName, schema.Mode)
+		if err := admin.SetMode(schema.SubjectName, schema.Mode); err != nil {
+			log.Fatalf("Failed to set mode of %s to %s: %s", schema.SubjectName, schema.Mode, err)
+		}
+		modeChanged = true
+	}
+	if result.NewMode == "READWRITE" {
+		setMode()
+	}
 	// Only go through the whole schema check/update thing if SchemaData is not empty
 	var mustRegister bool = false
 	if schema.SchemaData != "" {
@@ -379,6 +560,17 @@ func (admin *SRAdmin) ReconcileSchema(schema Schema, dryRun bool) *SchemaResult
 		if existingID == 0 {
 			mustRegister = true
 		}
+		// Only the metadata or rules changed. The registry registers a new version for those too
+		if schema.HasContract() {
+			changes, err := admin.contractChanges(schema)
+			if err != nil {
+				log.Fatalf("Failed to get the data contract of %s: %s", schema.SubjectName, err)
+			}
+			result.ContractChanges = changes
+			if len(changes) > 0 {
+				mustRegister = true
+			}
+		}
 		if mustRegister {
 			log.Debugf("Must register schema %v , (existingID:%d) dryRun:%v", schema, existingID, dryRun)
 
@@ -464,33 +656,191 @@ func (admin *SRAdmin) ReconcileSchema(schema Schema, dryRun bool) *SchemaResult
 		}
 		if !dryRun && newCompat != "" {
 			log.Debugf("Setting compatibility for subject %s to %s", schema.SubjectName, schema.Compatibility)
-			admin.SetCompatibility(schema, schema.Compatibility)
+			if err := admin.SetCompatibility(schema, schema.Compatibility); err != nil {
+				log.Fatal(err)
+			}
 			compatChanged = true
 		}
 	}
+	setMode()
 	result.NewCompat = newCompat
-	result.Changed = mustRegister || compatChanged
+	result.Changed = mustRegister || compatChanged || modeChanged
 	return &result
 }
 
-// Get the list of topics and reconcile all subjects
-func (admin *SRAdmin) Reconcile(topics map[string]Topic, dryRun bool) []SchemaResult {
+// Get the list of topics and standalone subjects and reconcile all subjects
+// Standalone subjects go first, as they are typically the shared types referenced by topic schemas.
+// Global and context modes that allow writes are set before the subjects, the rest after them
+func (admin *SRAdmin) Reconcile(topics map[string]Topic, subjects map[string]Schema, settings SchemaRegistrySettings, dryRun bool) []SchemaResult {
 	var schemaResults []SchemaResult
+	var desiredSubjects []string
+	for name := range subjects {
+		desiredSubjects = append(desiredSubjects, name)
+	}
 	for _, topic := range topics {
-		if (Schema{} != topic.Value) {
-			res := admin.ReconcileSchema(topic.Value, dryRun)
-			schemaResults = append(schemaResults, *res)
+		for _, schema := range topic.Schemas() {
+			desiredSubjects = append(desiredSubjects, schema.SubjectName)
+		}
+	}
+	schemaResults = append(schemaResults, admin.newContexts(settings, desiredSubjects)...)
+	var writableModes, otherModes []schemaModeChange
+	for _, change := range admin.planModeChanges(settings) {
+		if change.AllowsWrites() {
+			writableModes = append(writableModes, change)
+		} else {
+			otherModes = append(otherModes, change)
 		}
-		if (Schema{} != topic.Key) {
-			res := admin.ReconcileSchema(topic.Key, dryRun)
+	}
+	schemaResults = append(schemaResults, admin.applyModeChanges(writableModes, dryRun)...)
+	for _, schema := range orderSubjectsByReferences(subjects) {
+		res := admin.ReconcileSchema(schema, dryRun)
+		admin.applyRetention(res, schema, topics, settings, dryRun && res.Changed, dryRun)
+		schemaResults = append(schemaResults, *res)
+	}
+	// With RecordNameStrategy the same subject can be used by many topics. Only reconcile it once
+	seen := make(map[string]Schema)
+	for _, topic := range topics {
+		for _, schema := range topic.Schemas() {
+			if previous, exists := seen[schema.SubjectName]; exists {
+				if previous.SchemaData != schema.SchemaData {
+					log.Warnf("Subject %s is defined with different schemas across topics. Only one will be reconciled", schema.SubjectName)
+				}
+				continue
+			}
+			seen[schema.SubjectName] = schema
+			res := admin.ReconcileSchema(schema, dryRun)
+			admin.applyRetention(res, schema, topics, settings, dryRun && res.Changed, dryRun)
 			schemaResults = append(schemaResults, *res)
 		}
-
 	}
+	schemaResults = append(schemaResults, admin.reconcileOrphans(settings.OrphanSubjects, topics, desiredSubjects, dryRun)...)
+	schemaResults = append(schemaResults, admin.applyModeChanges(otherModes, dryRun)...)
 
 	return schemaResults
 }
 
+// Order subjects so that any subject referenced by another one comes before it.
+// References to subjects outside the given set are assumed to exist already. Ties are broken by name
+func orderSubjectsByReferences(subjects map[string]Schema) []Schema {
+	var names []string
+	for name := range subjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ordered []Schema
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		schema, exists := subjects[name]
+		if !exists || visited[name] {
+			return
+		}
+		// Mark before descending so that a reference cycle can't recurse forever
+		visited[name] = true
+		for _, ref := range schema.References {
+			visit(ref.Subject)
+		}
+		ordered = append(ordered, schema)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return ordered
+}
+
+// Subject name strategies, named after their Confluent serializer counterparts
+const (
+	TopicNameStrategy       = "TopicNameStrategy"
+	RecordNameStrategy      = "RecordNameStrategy"
+	TopicRecordNameStrategy = "TopicRecordNameStrategy"
+)
+
+// Check if the strategy name is one we know how to derive subjects for
+func isValidSubjectStrategy(strategy string) bool {
+	switch strategy {
+	case TopicNameStrategy, RecordNameStrategy, TopicRecordNameStrategy:
+		return true
+	}
+	return false
+}
+
+// The strategy to use when a topic does not set one. Comes from the kafkalo config section and defaults to TopicNameStrategy
+func defaultSubjectStrategy() string {
+	if gafkaloConfig.Kafkalo.SubjectStrategy != "" {
+		return gafkaloConfig.Kafkalo.SubjectStrategy
+	}
+	return TopicNameStrategy
+}
+
+var (
+	protoCommentsRegex = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?\*/`)
+	protoPackageRegex  = regexp.MustCompile(`\bpackage\s+([\w.]+)\s*;`)
+	protoMessageRegex  = regexp.MustCompile(`\bmessage\s+(\w+)\s*\{`)
+)
+
+// Return the fully qualified record name of the schema.
+// For Avro this is namespace + name of the top level record, for Protobuf the package + first message
+// and for JSON Schema the `title` of the schema.
+func (s *Schema) RecordName() (string, error) {
+	switch s.SchemaType {
+	case srclient.Protobuf:
+		text := protoCommentsRegex.ReplaceAllString(s.SchemaData, "")
+		message := protoMessageRegex.FindStringSubmatch(text)
+		if message == nil {
+			return "", fmt.Errorf("no message found in protobuf schema %s", s.SchemaPath)
+		}
+		if pkg := protoPackageRegex.FindStringSubmatch(text); pkg != nil {
+			return pkg[1] + "." + message[1], nil
+		}
+		return message[1], nil
+	case srclient.Json:
+		var jsonSchema struct {
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal([]byte(s.SchemaData), &jsonSchema); err != nil {
+			return "", err
+		}
+		if jsonSchema.Title == "" {
+			return "", fmt.Errorf("json schema %s has no title to derive a record name from", s.SchemaPath)
+		}
+		return jsonSchema.Title, nil
+	default:
+		var avroSchema struct {
+			Type      interface{} `json:"type"`
+			Name      string      `json:"name"`
+			Namespace string      `json:"namespace"`
+		}
+		if err := json.Unmarshal([]byte(s.SchemaData), &avroSchema); err != nil {
+			return "", err
+		}
+		if avroSchema.Type != "record" || avroSchema.Name == "" {
+			return "", fmt.Errorf("avro schema %s is not a named record", s.SchemaPath)
+		}
+		// A name containing dots is already a full name and the namespace is ignored
+		if avroSchema.Namespace == "" || strings.Contains(avroSchema.Name, ".") {
+			return avroSchema.Name, nil
+		}
+		return avroSchema.Namespace + "." + avroSchema.Name, nil
+	}
+}
+
+// Derive the subject name of a schema used on topic, according to the subject name strategy
+func getSubjectForStrategy(strategy string, topic string, schema *Schema, isKey bool) (string, error) {
+	switch strategy {
+	case "", TopicNameStrategy:
+		return getSubjectForTopic(topic, isKey), nil
+	case RecordNameStrategy:
+		return schema.RecordName()
+	case TopicRecordNameStrategy:
+		recordName, err := schema.RecordName()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s-%s", topic, recordName), nil
+	}
+	return "", fmt.Errorf("unknown subject name strategy %s", strategy)
+}
+
 func getSubjectForTopic(topic string, isKey bool) string {
 	var subject_suffix string
 	if isKey {
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+A parsed Avro schema. Used where gafkalo needs to reason about Avro schemas itself
+(compatibility checks, field level diffs) instead of comparing texts.
+*/
+type avroSchema struct {
+	Type        string // Primitive name, or record, enum, fixed, array, map, union
+	Name        string // Full name for named types
+	Aliases     []string
+	Doc         string
+	LogicalType string
+	Fields      []avroField // record
+	Symbols     []string    // enum
+	EnumDefault string      // enum, empty when there is no default
+	Size        int         // fixed
+	Items       *avroSchema // array
+	Values      *avroSchema // map
+	Branches    []*avroSchema
+}
+
+type avroField struct {
+	Name       string
+	Aliases    []string
+	Doc        string
+	Type       *avroSchema
+	HasDefault bool
+	Default    interface{}
+}
+
+// The short name of a named type
+func (s *avroSchema) ShortName() string {
+	if idx := strings.LastIndex(s.Name, "."); idx >= 0 {
+		return s.Name[idx+1:]
+	}
+	return s.Name
+}
+
+// Human readable name of the type, as used in messages
+func (s *avroSchema) String() string {
+	switch s.Type {
+	case "record", "enum", "fixed":
+		return s.Name
+	case "array":
+		return fmt.Sprintf("array<%s>", s.Items)
+	case "map":
+		return fmt.Sprintf("map<%s>", s.Values)
+	case "union":
+		names := make([]string, len(s.Branches))
+		for i, branch := range s.Branches {
+			names[i] = branch.String()
+		}
+		return "[" + strings.Join(names, ",") + "]"
+	}
+	if s.LogicalType != "" {
+		return fmt.Sprintf("%s(%s)", s.Type, s.LogicalType)
+	}
+	return s.Type
+}
+
+// Find a record field by name. Returns nil if there is none
+func (s *avroSchema) Field(name string) *avroField {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Parse an Avro schema text. Named types that are not defined in the text (for example types coming
+// from schema references) are kept as records with only a name.
+func parseAvroSchema(text string) (*avroSchema, error) {
+	var parsed interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	parser := avroParser{names: make(map[string]*avroSchema)}
+	return parser.parse(parsed, "")
+}
+
+type avroParser struct {
+	names map[string]*avroSchema // Named types seen so far, by full name
+}
+
+func (p *avroParser) parse(node interface{}, namespace string) (*avroSchema, error) {
+	switch value := node.(type) {
+	case string:
+		if avroPrimitiveTypes[value] {
+			return &avroSchema{Type: value}, nil
+		}
+		fullName := avroFullName(value, namespace)
+		if named, exists := p.names[fullName]; exists {
+			return named, nil
+		}
+		// Not defined here, so it must come from a reference
+		named := &avroSchema{Type: "record", Name: fullName}
+		p.names[fullName] = named
+		return named, nil
+	case []interface{}:
+		union := &avroSchema{Type: "union"}
+		for _, member := range value {
+			branch, err := p.parse(member, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.Branches = append(union.Branches, branch)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(value, namespace)
+	}
+	return nil, fmt.Errorf("invalid avro schema node %v", node)
+}
+
+func (p *avroParser) parseComplex(value map[string]interface{}, namespace string) (*avroSchema, error) {
+	typeName, isString := value["type"].(string)
+	if !isString {
+		// {"type": {...}} or {"type": [...]}
+		return p.parse(value["type"], namespace)
+	}
+	schema := &avroSchema{Type: typeName}
+	schema.Doc, _ = value["doc"].(string)
+	schema.LogicalType, _ = value["logicalType"].(string)
+	var err error
+	switch typeName {
+	case "record", "error", "enum", "fixed":
+		schema.Type = typeName
+		if typeName == "error" {
+			schema.Type = "record"
+		}
+		name, _ := value["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("avro %s without a name", typeName)
+		}
+		if ns, exists := value["namespace"].(string); exists {
+			namespace = ns
+		}
+		schema.Name = avroFullName(name, namespace)
+		if idx := strings.LastIndex(schema.Name, "."); idx >= 0 {
+			namespace = schema.Name[:idx]
+		} else {
+			namespace = ""
+		}
+		schema.Aliases = avroAliases(value["aliases"], namespace)
+		// Register before the fields so that recursive types resolve to this schema
+		if placeholder, exists := p.names[schema.Name]; exists && placeholder.Fields == nil {
+			*placeholder = *schema
+			schema = placeholder
+		}
+		p.names[schema.Name] = schema
+	case "array":
+		schema.Items, err = p.parse(value["items"], namespace)
+		return schema, err
+	case "map":
+		schema.Values, err = p.parse(value["values"], namespace)
+		return schema, err
+	default:
+		if !avroPrimitiveTypes[typeName] {
+			return p.parse(typeName, namespace)
+		}
+		return schema, nil
+	}
+	switch schema.Type {
+	case "record":
+		fields, _ := value["fields"].([]interface{})
+		schema.Fields = make([]avroField, 0, len(fields))
+		for _, field := range fields {
+			fieldMap, isMap := field.(map[string]interface{})
+			if !isMap {
+				return nil, fmt.Errorf("invalid field in record %s", schema.Name)
+			}
+			parsedField := avroField{}
+			parsedField.Name, _ = fieldMap["name"].(string)
+			parsedField.Doc, _ = fieldMap["doc"].(string)
+			parsedField.Aliases = avroAliases(fieldMap["aliases"], "")
+			parsedField.Default, parsedField.HasDefault = fieldMap["default"]
+			parsedField.Type, err = p.parse(fieldMap["type"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			schema.Fields = append(schema.Fields, parsedField)
+		}
+	case "enum":
+		symbols, _ := value["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			if symbolName, isString := symbol.(string); isString {
+				schema.Symbols = append(schema.Symbols, symbolName)
+			}
+		}
+		schema.EnumDefault, _ = value["default"].(string)
+	case "fixed":
+		size, _ := value["size"].(json.Number)
+		sizeInt, err := size.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for fixed %s", schema.Name)
+		}
+		schema.Size = int(sizeInt)
+	}
+	return schema, nil
+}
+
+// Read the aliases attribute. Aliases of named types are resolved to full names
+func avroAliases(node interface{}, namespace string) []string {
+	var aliases []string
+	values, _ := node.([]interface{})
+	for _, value := range values {
+		if alias, isString := value.(string); isString {
+			aliases = append(aliases, avroFullName(alias, namespace))
+		}
+	}
+	return aliases
+}
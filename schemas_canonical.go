@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kmetaxas/srclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+/*
+Schema text as written in files rarely matches what the registry returns byte for byte.
+Whitespace, ordering of JSON properties, optional namespaces and Protobuf formatting don't change the
+schema, but a naive comparison would see a new version. The functions here reduce a schema to a
+canonical form so two texts of the same schema compare equal.
+*/
+
+var avroPrimitiveTypes = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true, "float": true, "double": true, "bytes": true, "string": true,
+}
+
+// Return the canonical form of a schema text of the given type
+func canonicalSchema(schemaType srclient.SchemaType, text string) (string, error) {
+	switch schemaType {
+	case srclient.Protobuf:
+		return canonicalProtobuf(text)
+	case srclient.Json:
+		return canonicalJSON(text)
+	default:
+		return canonicalAvro(text)
+	}
+}
+
+// Compare two schema texts of the same type using their canonical forms
+func canonicalSchemaEquals(schemaType srclient.SchemaType, first, second string) (bool, error) {
+	firstCanonical, err := canonicalSchema(schemaType, first)
+	if err != nil {
+		return false, err
+	}
+	secondCanonical, err := canonicalSchema(schemaType, second)
+	if err != nil {
+		return false, err
+	}
+	return firstCanonical == secondCanonical, nil
+}
+
+/*
+Canonical form for Avro. Follows the Parsing Canonical Form rules that matter for comparing texts
+(full names instead of namespaces, primitives in their short form, sorted and whitespace free JSON)
+but keeps defaults, aliases, doc, order and logical types. The registry registers a new version
+when any of those change, so dropping them like the real Parsing Canonical Form does would hide changes.
+*/
+func canonicalAvro(text string) (string, error) {
+	var parsed interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return "", fmt.Errorf("invalid avro schema: %w", err)
+	}
+	canonical, err := json.Marshal(normalizeAvroNode(parsed, ""))
+	if err != nil {
+		return "", err
+	}
+	return string(canonical), nil
+}
+
+// Resolve a (possibly short) Avro name to its full name
+func avroFullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// Recursively normalize a decoded Avro schema node. namespace is the enclosing namespace
+func normalizeAvroNode(node interface{}, namespace string) interface{} {
+	switch value := node.(type) {
+	case string:
+		if avroPrimitiveTypes[value] {
+			return value
+		}
+		return avroFullName(value, namespace)
+	case []interface{}:
+		// Unions
+		normalized := make([]interface{}, len(value))
+		for i, member := range value {
+			normalized[i] = normalizeAvroNode(member, namespace)
+		}
+		return normalized
+	case map[string]interface{}:
+		typeName, _ := value["type"].(string)
+		switch typeName {
+		case "record", "error", "enum", "fixed":
+			return normalizeAvroNamedType(value, namespace)
+		}
+		// {"type": "string"} is the same as "string"
+		if len(value) == 1 && typeName != "" {
+			return normalizeAvroNode(typeName, namespace)
+		}
+		normalized := make(map[string]interface{}, len(value))
+		for key, attribute := range value {
+			switch key {
+			case "type", "items", "values":
+				normalized[key] = normalizeAvroNode(attribute, namespace)
+			default:
+				normalized[key] = attribute
+			}
+		}
+		return normalized
+	}
+	return node
+}
+
+// Normalize a record, enum or fixed. The namespace is folded into the full name
+func normalizeAvroNamedType(value map[string]interface{}, namespace string) interface{} {
+	name, _ := value["name"].(string)
+	if ns, exists := value["namespace"].(string); exists {
+		namespace = ns
+	}
+	fullName := avroFullName(name, namespace)
+	// Named types defined inside this one inherit the namespace of its full name
+	var childNamespace string
+	if idx := strings.LastIndex(fullName, "."); idx >= 0 {
+		childNamespace = fullName[:idx]
+	}
+	normalized := make(map[string]interface{}, len(value))
+	for key, attribute := range value {
+		switch key {
+		case "namespace":
+		case "name":
+			normalized[key] = fullName
+		case "fields":
+			fields, _ := attribute.([]interface{})
+			normalizedFields := make([]interface{}, 0, len(fields))
+			for _, field := range fields {
+				fieldMap, isMap := field.(map[string]interface{})
+				if !isMap {
+					normalizedFields = append(normalizedFields, field)
+					continue
+				}
+				normalizedField := make(map[string]interface{}, len(fieldMap))
+				for fieldKey, fieldAttribute := range fieldMap {
+					if fieldKey == "type" {
+						normalizedField[fieldKey] = normalizeAvroNode(fieldAttribute, childNamespace)
+					} else {
+						normalizedField[fieldKey] = fieldAttribute
+					}
+				}
+				normalizedFields = append(normalizedFields, normalizedField)
+			}
+			normalized[key] = normalizedFields
+		default:
+			normalized[key] = attribute
+		}
+	}
+	return normalized
+}
+
+// Canonical form for JSON Schema: sorted properties, no insignificant whitespace
+func canonicalJSON(text string) (string, error) {
+	var parsed interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return "", fmt.Errorf("invalid json schema: %w", err)
+	}
+	canonical, err := json.Marshal(parsed)
+	if err != nil {
+		return "", err
+	}
+	return string(canonical), nil
+}
+
+var (
+	protoWhitespaceRegex  = regexp.MustCompile(`\s+`)
+	protoPunctuationRegex = regexp.MustCompile(`\s*([{}\[\]();=,<>])\s*`)
+)
+
+/*
+Canonical form for Protobuf. The schema is compiled and the resulting file descriptor (without source info)
+is serialized deterministically. If it can't be compiled (for example it imports a file we don't have)
+we fall back to the text with comments and formatting removed.
+*/
+func canonicalProtobuf(text string) (string, error) {
+	file, err := compileProtobufSchema(text, nil)
+	if err != nil {
+		return normalizeProtobufText(text), nil
+	}
+	descriptor := protodesc.ToFileDescriptorProto(file)
+	descriptor.SourceCodeInfo = nil
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(descriptor)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Strip comments and formatting from a Protobuf schema text
+func normalizeProtobufText(text string) string {
+	text = protoCommentsRegex.ReplaceAllString(text, "")
+	text = protoWhitespaceRegex.ReplaceAllString(text, " ")
+	text = protoPunctuationRegex.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(text)
+}
+
+// A line based diff of two texts. Good enough to show what changed in non JSON schemas
+func textDiff(before, after string) string {
+	var buf bytes.Buffer
+	beforeLines := strings.Split(before, "\n")
+	afterLines := strings.Split(after, "\n")
+	// Longest common subsequence table
+	lcs := make([][]int, len(beforeLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(afterLines)+1)
+	}
+	for i := len(beforeLines) - 1; i >= 0; i-- {
+		for j := len(afterLines) - 1; j >= 0; j-- {
+			if beforeLines[i] == afterLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(beforeLines) && j < len(afterLines) {
+		switch {
+		case beforeLines[i] == afterLines[j]:
+			fmt.Fprintf(&buf, "  %s\n", beforeLines[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&buf, "- %s\n", beforeLines[i])
+			i++
+		default:
+			fmt.Fprintf(&buf, "+ %s\n", afterLines[j])
+			j++
+		}
+	}
+	for ; i < len(beforeLines); i++ {
+		fmt.Fprintf(&buf, "- %s\n", beforeLines[i])
+	}
+	for ; j < len(afterLines); j++ {
+		fmt.Fprintf(&buf, "+ %s\n", afterLines[j])
+	}
+	return buf.String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalAvroEquivalence(t *testing.T) {
+	written := `{
+  "type": "record",
+  "name": "Order",
+  "namespace": "com.example",
+  "fields": [
+    {"name": "id", "type": {"type": "string"}},
+    {"name": "line", "type": {"type": "record", "name": "Line", "fields": [{"name": "sku", "type": "string"}]}}
+  ]
+}`
+	registered := `{"fields":[{"name":"id","type":"string"},{"name":"line","type":{"fields":[{"name":"sku","type":"string"}],"name":"com.example.Line","type":"record"}}],"name":"com.example.Order","type":"record"}`
+	equal, err := canonicalSchemaEquals(srclient.Avro, written, registered)
+	require.NoError(t, err)
+	require.True(t, equal)
+
+	withDefault := strings.Replace(registered, `{"name":"id","type":"string"}`, `{"name":"id","type":"string","default":"none"}`, 1)
+	equal, err = canonicalSchemaEquals(srclient.Avro, written, withDefault)
+	require.NoError(t, err)
+	require.False(t, equal, "a changed default is a different schema")
+
+	_, err = canonicalSchemaEquals(srclient.Avro, written, "{not json")
+	require.Error(t, err)
+}
+
+func TestCanonicalJSONEquivalence(t *testing.T) {
+	equal, err := canonicalSchemaEquals(srclient.Json,
+		`{"type": "object", "properties": {"a": {"type": "string"}}}`,
+		`{"properties":{"a":{"type":"string"}},"type":"object"}`)
+	require.NoError(t, err)
+	require.True(t, equal)
+}
+
+func TestCanonicalProtobufEquivalence(t *testing.T) {
+	written := `syntax = "proto3";
+package com.example;
+
+// An order
+message Order {
+  string id = 1; // the id
+  int64 amount = 2;
+}
+`
+	registered := `syntax = "proto3";
+package com.example;
+
+message Order {
+  string id = 1;
+  int64 amount = 2;
+}
+`
+	equal, err := canonicalSchemaEquals(srclient.Protobuf, written, registered)
+	require.NoError(t, err)
+	require.True(t, equal)
+
+	changed := strings.Replace(registered, "int64 amount = 2", "int32 amount = 2", 1)
+	equal, err = canonicalSchemaEquals(srclient.Protobuf, written, changed)
+	require.NoError(t, err)
+	require.False(t, equal)
+
+	schema := Schema{SubjectName: "orders-value", SchemaType: srclient.Protobuf, SchemaData: registered}
+	isEqual, diff := schema.SchemaDiff(changed)
+	require.False(t, isEqual)
+	require.Contains(t, diff, "-   int64 amount = 2;")
+	require.Contains(t, diff, "+   int32 amount = 2;")
+}
+
+func TestSchemaDiffNormalizes(t *testing.T) {
+	schema := Schema{SubjectName: "orders-value", SchemaType: srclient.Avro, SchemaData: `{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"}]}`}
+	isEqual, _ := schema.SchemaDiff(`{"type":"record","name":"com.example.Order","fields":[{"name":"id","type":{"type":"string"}}]}`)
+	require.True(t, isEqual)
+}
+
+func TestTextDiff(t *testing.T) {
+	diff := textDiff("a\nb\nc", "a\nc\nd")
+	require.Equal(t, "  a\n- b\n  c\n+ d\n", diff)
+}
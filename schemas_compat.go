@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kmetaxas/srclient"
+)
+
+/*
+Offline compatibility checks.
+
+The registry checks compatibility when registering, but that needs a live registry.
+Here we implement the Avro schema resolution rules so a schema can be checked against
+previous versions taken from the _schemas cache, a directory of exported schemas or a git revision.
+Messages follow the format of the registry's verbose compatibility output.
+*/
+
+// A registered (or previously committed) version of a subject
+type schemaVersion struct {
+	Version int
+	ID      int
+	Schema  Schema
+}
+
+// One incompatibility between a reader and a writer schema
+type avroIncompatibility struct {
+	ErrorType      string
+	Path           string
+	AdditionalInfo string
+	Field          string // Field name for READER_FIELD_MISSING_DEFAULT_VALUE
+	Symbols        string // Missing symbols for MISSING_ENUM_SYMBOLS
+}
+
+// Format like the registry does. reader and writer are "new" or "old" depending on the direction of the check
+func (i *avroIncompatibility) Message(reader, writer string) string {
+	var description string
+	switch i.ErrorType {
+	case "NAME_MISMATCH":
+		description = fmt.Sprintf("The name of the schema has changed (path '%s')", i.Path)
+	case "FIXED_SIZE_MISMATCH":
+		description = fmt.Sprintf("The size of FIXED type field at path '%s' in the %s schema does not match with the %s schema", i.Path, reader, writer)
+	case "READER_FIELD_MISSING_DEFAULT_VALUE":
+		description = fmt.Sprintf("The field '%s' at path '%s' in the %s schema has no default value and is missing in the %s schema", i.Field, i.Path, reader, writer)
+	case "MISSING_ENUM_SYMBOLS":
+		description = fmt.Sprintf("The %s schema is missing enum symbols '%s' at path '%s' in the %s schema", reader, i.Symbols, i.Path, writer)
+	case "MISSING_UNION_BRANCH":
+		description = fmt.Sprintf("The %s schema is missing a type inside a union field at path '%s' in the %s schema", reader, i.Path, writer)
+	default:
+		description = fmt.Sprintf("The type (path '%s') of a field in the %s schema does not match with the %s schema", i.Path, reader, writer)
+	}
+	return fmt.Sprintf("{errorType:'%s', description:'%s', additionalInfo:'%s'}", i.ErrorType, description, i.AdditionalInfo)
+}
+
+// Checks if data written with writer can be read with reader, following the Avro schema resolution rules
+type avroCompatChecker struct {
+	inProgress map[[2]*avroSchema]bool // Pairs being checked, so recursive types terminate
+}
+
+func newAvroCompatChecker() *avroCompatChecker {
+	return &avroCompatChecker{inProgress: make(map[[2]*avroSchema]bool)}
+}
+
+// Names match if the short names are equal or the writer's full name is one of the reader's aliases
+func avroNamesMatch(reader, writer *avroSchema) bool {
+	if reader.ShortName() == writer.ShortName() {
+		return true
+	}
+	for _, alias := range reader.Aliases {
+		if alias == writer.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer types that a reader type can be promoted from
+var avroPromotions = map[string][]string{
+	"long":   {"int"},
+	"float":  {"int", "long"},
+	"double": {"int", "long", "float"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+func (c *avroCompatChecker) check(reader, writer *avroSchema, path string) []avroIncompatibility {
+	pair := [2]*avroSchema{reader, writer}
+	if c.inProgress[pair] {
+		return nil
+	}
+	c.inProgress[pair] = true
+	defer delete(c.inProgress, pair)
+
+	if reader.Type == writer.Type {
+		return c.checkSameType(reader, writer, path)
+	}
+	if writer.Type == "union" {
+		// Every type the writer may have used must be readable
+		var res []avroIncompatibility
+		for i, branch := range writer.Branches {
+			res = append(res, c.check(reader, branch, fmt.Sprintf("%s/%d", path, i))...)
+		}
+		return res
+	}
+	if reader.Type == "union" {
+		for _, branch := range reader.Branches {
+			if len(c.check(branch, writer, path)) == 0 {
+				return nil
+			}
+		}
+		return []avroIncompatibility{{ErrorType: "MISSING_UNION_BRANCH", Path: path, AdditionalInfo: fmt.Sprintf("reader union lacking writer type: %s", strings.ToUpper(writer.Type))}}
+	}
+	for _, promotable := range avroPromotions[reader.Type] {
+		if promotable == writer.Type {
+			return nil
+		}
+	}
+	return []avroIncompatibility{{ErrorType: "TYPE_MISMATCH", Path: path, AdditionalInfo: fmt.Sprintf("reader type: %s not compatible with writer type: %s", strings.ToUpper(reader.Type), strings.ToUpper(writer.Type))}}
+}
+
+func (c *avroCompatChecker) checkSameType(reader, writer *avroSchema, path string) []avroIncompatibility {
+	var res []avroIncompatibility
+	switch reader.Type {
+	case "array":
+		return c.check(reader.Items, writer.Items, path+"/items")
+	case "map":
+		return c.check(reader.Values, writer.Values, path+"/values")
+	case "union":
+		for i, writerBranch := range writer.Branches {
+			found := false
+			for _, readerBranch := range reader.Branches {
+				if len(c.check(readerBranch, writerBranch, fmt.Sprintf("%s/%d", path, i))) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				res = append(res, avroIncompatibility{ErrorType: "MISSING_UNION_BRANCH", Path: fmt.Sprintf("%s/%d", path, i), AdditionalInfo: fmt.Sprintf("reader union lacking writer type: %s", strings.ToUpper(writerBranch.Type))})
+			}
+		}
+		return res
+	case "fixed":
+		if !avroNamesMatch(reader, writer) {
+			res = append(res, avroIncompatibility{ErrorType: "NAME_MISMATCH", Path: path + "/name", AdditionalInfo: fmt.Sprintf("expected: %s", writer.Name)})
+		}
+		if reader.Size != writer.Size {
+			res = append(res, avroIncompatibility{ErrorType: "FIXED_SIZE_MISMATCH", Path: path + "/size", AdditionalInfo: fmt.Sprintf("expected: %d, found: %d", writer.Size, reader.Size)})
+		}
+		return res
+	case "enum":
+		if !avroNamesMatch(reader, writer) {
+			res = append(res, avroIncompatibility{ErrorType: "NAME_MISMATCH", Path: path + "/name", AdditionalInfo: fmt.Sprintf("expected: %s", writer.Name)})
+		}
+		if reader.EnumDefault != "" {
+			return res
+		}
+		readerSymbols := make(map[string]bool, len(reader.Symbols))
+		for _, symbol := range reader.Symbols {
+			readerSymbols[symbol] = true
+		}
+		var missing []string
+		for _, symbol := range writer.Symbols {
+			if !readerSymbols[symbol] {
+				missing = append(missing, symbol)
+			}
+		}
+		if len(missing) > 0 {
+			symbols := "[" + strings.Join(missing, ", ") + "]"
+			res = append(res, avroIncompatibility{ErrorType: "MISSING_ENUM_SYMBOLS", Path: path + "/symbols", Symbols: symbols, AdditionalInfo: symbols})
+		}
+		return res
+	case "record":
+		if !avroNamesMatch(reader, writer) {
+			res = append(res, avroIncompatibility{ErrorType: "NAME_MISMATCH", Path: path + "/name", AdditionalInfo: fmt.Sprintf("expected: %s", writer.Name)})
+		}
+		// A record without fields is a type from a reference we could not resolve. Nothing more to check
+		if reader.Fields == nil || writer.Fields == nil {
+			return res
+		}
+		for i, readerField := range reader.Fields {
+			writerField := writer.Field(readerField.Name)
+			for _, alias := range readerField.Aliases {
+				if writerField != nil {
+					break
+				}
+				writerField = writer.Field(alias)
+			}
+			if writerField == nil {
+				if !readerField.HasDefault {
+					res = append(res, avroIncompatibility{ErrorType: "READER_FIELD_MISSING_DEFAULT_VALUE", Path: fmt.Sprintf("%s/fields/%d", path, i), Field: readerField.Name, AdditionalInfo: readerField.Name})
+				}
+				continue
+			}
+			res = append(res, c.check(readerField.Type, writerField.Type, fmt.Sprintf("%s/fields/%d/type", path, i))...)
+		}
+		return res
+	}
+	// Same primitive type
+	return nil
+}
+
+// Check that data written with writer can be read with reader. Returns registry style messages
+func checkAvroCompatibility(reader, writer string, readerDesc, writerDesc string) ([]string, error) {
+	readerSchema, err := parseAvroSchema(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s schema: %w", readerDesc, err)
+	}
+	writerSchema, err := parseAvroSchema(writer)
+	if err != nil {
+		return nil, fmt.Errorf("%s schema: %w", writerDesc, err)
+	}
+	var messages []string
+	for _, incompatibility := range newAvroCompatChecker().check(readerSchema, writerSchema, "") {
+		if incompatibility.Path == "" {
+			incompatibility.Path = "/"
+		}
+		messages = append(messages, incompatibility.Message(readerDesc, writerDesc))
+	}
+	return messages, nil
+}
+
+// Check a schema against previous versions (in any order) with the given compatibility level.
+// Returns whether it is compatible and the messages explaining why not
+func checkSchemaCompatibility(schema Schema, previous []schemaVersion, compatibility string) (bool, []string, error) {
+	compatibility = strings.ToUpper(compatibility)
+	if compatibility == "NONE" || len(previous) == 0 {
+		return true, nil, nil
+	}
+	if schema.SchemaType != "" && schema.SchemaType != srclient.Avro {
+		return false, nil, fmt.Errorf("offline compatibility checks are only supported for AVRO schemas, not %s", schema.SchemaType)
+	}
+	sort.Slice(previous, func(i, j int) bool { return previous[i].Version < previous[j].Version })
+	// Non transitive levels only look at the latest version
+	if !strings.HasSuffix(compatibility, "_TRANSITIVE") {
+		previous = previous[len(previous)-1:]
+	}
+	level := strings.TrimSuffix(compatibility, "_TRANSITIVE")
+	if level != "BACKWARD" && level != "FORWARD" && level != "FULL" {
+		return false, nil, fmt.Errorf("unknown compatibility level %s", compatibility)
+	}
+	var messages []string
+	// Newest first, like the registry
+	for i := len(previous) - 1; i >= 0; i-- {
+		old := previous[i]
+		var versionMessages []string
+		if level == "BACKWARD" || level == "FULL" {
+			res, err := checkAvroCompatibility(schema.SchemaData, old.Schema.SchemaData, "new", "old")
+			if err != nil {
+				return false, nil, err
+			}
+			versionMessages = append(versionMessages, res...)
+		}
+		if level == "FORWARD" || level == "FULL" {
+			res, err := checkAvroCompatibility(old.Schema.SchemaData, schema.SchemaData, "old", "new")
+			if err != nil {
+				return false, nil, err
+			}
+			versionMessages = append(versionMessages, res...)
+		}
+		if len(versionMessages) > 0 {
+			messages = append(messages, versionMessages...)
+			messages = append(messages, fmt.Sprintf("{oldSchemaVersion: %d}", old.Version))
+			messages = append(messages, fmt.Sprintf("{oldSchema: '%s'}", old.Schema.SchemaData))
+			messages = append(messages, fmt.Sprintf("{compatibility: '%s'}", compatibility))
+			// The registry stops at the first incompatible version
+			break
+		}
+	}
+	return len(messages) == 0, messages, nil
+}
+
+// File extension used for exported schemas of each type
+func schemaFileExtension(schemaType srclient.SchemaType) string {
+	switch schemaType {
+	case srclient.Protobuf:
+		return ".proto"
+	case srclient.Json:
+		return ".json"
+	default:
+		return ".avsc"
+	}
+}
+
+/*
+Read previous versions of a subject from a directory of exported schemas.
+The layout is DIR/<subject>/<version>.<avsc|proto|json>
+*/
+func readSchemaVersionsFromDir(dir string, subject string, schemaType srclient.SchemaType) ([]schemaVersion, error) {
+	var versions []schemaVersion
+	subjectDir := filepath.Join(dir, subjectDirName(subject))
+	entries, err := os.ReadDir(subjectDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions of %s from %s: %w", subject, dir, err)
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || ext != schemaFileExtension(schemaType) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ext))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(subjectDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, schemaVersion{Version: version, Schema: Schema{SubjectName: subject, SchemaData: string(data), SchemaType: schemaType}})
+	}
+	return versions, nil
+}
+
+// Read the previous version of a schema file from a git revision. The version number is reported as 0
+func readSchemaVersionFromGit(revision string, schemaPath string, subject string, schemaType srclient.SchemaType) ([]schemaVersion, error) {
+	dir, file := filepath.Split(schemaPath)
+	cmd := exec.Command("git", "show", fmt.Sprintf("%s:./%s", revision, file))
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, isExitErr := err.(*exec.ExitError); isExitErr {
+			stderr := string(exitErr.Stderr)
+			// A new file has no previous version to be compatible with
+			if strings.Contains(stderr, "does not exist in") || strings.Contains(stderr, "exists on disk, but not in") {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("git show %s:%s failed: %s", revision, schemaPath, strings.TrimSpace(stderr))
+		}
+		return nil, err
+	}
+	return []schemaVersion{{Version: 0, Schema: Schema{SubjectName: subject, SchemaData: string(output), SchemaType: schemaType}}}, nil
+}
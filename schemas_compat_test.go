@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+const compatOrderV1 = `{"type":"record","name":"Order","namespace":"com.example","fields":[
+	{"name":"id","type":"string"},
+	{"name":"amount","type":"int"},
+	{"name":"status","type":{"type":"enum","name":"Status","symbols":["NEW","DONE"]}}
+]}`
+
+func avroVersions(schemas ...string) []schemaVersion {
+	var versions []schemaVersion
+	for i, text := range schemas {
+		versions = append(versions, schemaVersion{Version: i + 1, Schema: Schema{SubjectName: "orders-value", SchemaData: text, SchemaType: srclient.Avro}})
+	}
+	return versions
+}
+
+func TestParseAvroSchemaRecursive(t *testing.T) {
+	schema, err := parseAvroSchema(`{"type":"record","name":"Node","namespace":"com.example","fields":[
+		{"name":"value","type":"long"},
+		{"name":"next","type":["null","Node"],"default":null}
+	]}`)
+	require.NoError(t, err)
+	require.Equal(t, "com.example.Node", schema.Name)
+	next := schema.Field("next")
+	require.NotNil(t, next)
+	require.True(t, next.HasDefault)
+	require.Same(t, schema, next.Type.Branches[1])
+
+	// A recursive type must not loop forever when checked against itself
+	messages, err := checkAvroCompatibility(`{"type":"record","name":"Node","fields":[{"name":"next","type":["null","Node"]}]}`,
+		`{"type":"record","name":"Node","fields":[{"name":"next","type":["null","Node"]}]}`, "new", "old")
+	require.NoError(t, err)
+	require.Empty(t, messages)
+}
+
+func TestCheckAvroCompatibility(t *testing.T) {
+	// Adding a field with a default and promoting int to long is backward compatible
+	compatible := strings.Replace(compatOrderV1, `{"name":"amount","type":"int"}`, `{"name":"amount","type":"long"},{"name":"note","type":["null","string"],"default":null}`, 1)
+	messages, err := checkAvroCompatibility(compatible, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Empty(t, messages)
+
+	// Adding a field without a default is not
+	noDefault := strings.Replace(compatOrderV1, `{"name":"id","type":"string"}`, `{"name":"id","type":"string"},{"name":"customer","type":"string"}`, 1)
+	messages, err = checkAvroCompatibility(noDefault, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Equal(t, []string{"{errorType:'READER_FIELD_MISSING_DEFAULT_VALUE', description:'The field 'customer' at path '/fields/1' in the new schema has no default value and is missing in the old schema', additionalInfo:'customer'}"}, messages)
+
+	// Narrowing a type
+	narrowed := strings.Replace(compatOrderV1, `{"name":"id","type":"string"}`, `{"name":"id","type":"int"}`, 1)
+	messages, err = checkAvroCompatibility(narrowed, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Contains(t, messages[0], "errorType:'TYPE_MISMATCH'")
+	require.Contains(t, messages[0], "path '/fields/0/type'")
+
+	// Removing an enum symbol
+	lessSymbols := strings.Replace(compatOrderV1, `["NEW","DONE"]`, `["NEW"]`, 1)
+	messages, err = checkAvroCompatibility(lessSymbols, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Contains(t, messages[0], "errorType:'MISSING_ENUM_SYMBOLS'")
+	require.Contains(t, messages[0], "'[DONE]' at path '/fields/2/type/symbols'")
+
+	// Renaming the record
+	renamed := strings.Replace(compatOrderV1, `"name":"Order"`, `"name":"Purchase"`, 1)
+	messages, err = checkAvroCompatibility(renamed, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Contains(t, messages[0], "errorType:'NAME_MISMATCH'")
+
+	// Unless the old name is an alias
+	aliased := strings.Replace(compatOrderV1, `"name":"Order"`, `"name":"Purchase","aliases":["Order"]`, 1)
+	messages, err = checkAvroCompatibility(aliased, compatOrderV1, "new", "old")
+	require.NoError(t, err)
+	require.Empty(t, messages)
+}
+
+func TestCheckSchemaCompatibilityLevels(t *testing.T) {
+	// v2 drops amount, v3 adds it back without a default
+	v2 := strings.Replace(compatOrderV1, `{"name":"amount","type":"int"},`, ``, 1)
+	v3 := compatOrderV1
+	newSchema := Schema{SubjectName: "orders-value", SchemaData: v3, SchemaType: srclient.Avro}
+
+	// Against the latest only, re-adding amount breaks BACKWARD
+	isCompatible, messages, err := checkSchemaCompatibility(newSchema, avroVersions(compatOrderV1, v2), "BACKWARD")
+	require.NoError(t, err)
+	require.False(t, isCompatible)
+	require.Contains(t, messages, "{oldSchemaVersion: 2}")
+	require.Contains(t, messages, "{compatibility: 'BACKWARD'}")
+
+	// FORWARD: old readers (v2) ignore the new field
+	isCompatible, _, err = checkSchemaCompatibility(newSchema, avroVersions(compatOrderV1, v2), "FORWARD")
+	require.NoError(t, err)
+	require.True(t, isCompatible)
+
+	// Dropping a field without default is forward compatible with the latest, but not transitively
+	v4 := strings.Replace(compatOrderV1, `{"name":"id","type":"string"},`, ``, 1)
+	latestOnly := Schema{SubjectName: "orders-value", SchemaData: v4, SchemaType: srclient.Avro}
+	isCompatible, _, err = checkSchemaCompatibility(latestOnly, avroVersions(v4), "FORWARD")
+	require.NoError(t, err)
+	require.True(t, isCompatible)
+	isCompatible, messages, err = checkSchemaCompatibility(latestOnly, avroVersions(compatOrderV1, v4), "FULL_TRANSITIVE")
+	require.NoError(t, err)
+	require.False(t, isCompatible)
+	require.Contains(t, messages, "{oldSchemaVersion: 1}")
+
+	isCompatible, _, err = checkSchemaCompatibility(latestOnly, avroVersions(compatOrderV1), "NONE")
+	require.NoError(t, err)
+	require.True(t, isCompatible)
+
+	_, _, err = checkSchemaCompatibility(Schema{SchemaType: srclient.Protobuf}, avroVersions(compatOrderV1), "BACKWARD")
+	require.Error(t, err)
+}
+
+func TestReadSchemaVersionsFromDir(t *testing.T) {
+	dir := t.TempDir()
+	subjectDir := filepath.Join(dir, "orders-value")
+	require.NoError(t, os.MkdirAll(subjectDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(subjectDir, "1.avsc"), []byte(compatOrderV1), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(subjectDir, "2.avsc"), []byte(compatOrderV1), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(subjectDir, "index.json"), []byte("{}"), 0644))
+
+	versions, err := readSchemaVersionsFromDir(dir, "orders-value", srclient.Avro)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	_, err = readSchemaVersionsFromDir(dir, "missing-value", srclient.Avro)
+	require.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Schema Registry contexts and modes.
+
+Contexts group subjects (and their schema IDs) in a registry. A subject in a context other than the default
+is written qualified, as :.ctx:subject. A context on its own is written :.ctx: which is also how the registry
+takes settings, like the mode, for a whole context.
+*/
+
+const defaultSchemaContext = "."
+
+var validSchemaModes = map[string]bool{
+	"READWRITE":         true,
+	"READONLY":          true,
+	"READONLY_OVERRIDE": true,
+	"IMPORT":            true,
+}
+
+// Registry wide settings, from the schema_registry section of the input
+type SchemaRegistrySettings struct {
+	Mode           string               `yaml:"mode"`
+	Contexts       []SchemaContext      `yaml:"contexts"`
+	RetainVersions int                  `yaml:"retain_versions"` // Prune versions of declared subjects beyond this many
+	HardDelete     bool                 `yaml:"hard_delete"`     // Permanently delete pruned versions after soft deleting them
+	OrphanSubjects OrphanSubjectsPolicy `yaml:"orphan_subjects"`
+}
+
+type SchemaContext struct {
+	Name string `yaml:"name"`
+	Mode string `yaml:"mode"`
+}
+
+func isValidSchemaMode(mode string) bool {
+	return validSchemaModes[strings.ToUpper(mode)]
+}
+
+// Context names start with a dot and can't contain the separator
+func isValidSchemaContext(context string) bool {
+	return len(context) > 1 && strings.HasPrefix(context, ".") && !strings.ContainsAny(context, ":/*")
+}
+
+// Split a (possibly) context qualified subject into its context and subject name
+func splitQualifiedSubject(name string) (string, string) {
+	if !strings.HasPrefix(name, ":.") {
+		return defaultSchemaContext, name
+	}
+	rest := name[1:]
+	idx := strings.Index(rest, ":")
+	if idx < 0 {
+		return rest, ""
+	}
+	return rest[:idx], rest[idx+1:]
+}
+
+// Qualify a subject with a context. Subjects in the default context, or already qualified, are returned as is
+func qualifySubject(context, subject string) string {
+	if context == "" || context == defaultSchemaContext || strings.HasPrefix(subject, ":.") {
+		return subject
+	}
+	return fmt.Sprintf(":%s:%s", context, subject)
+}
+
+// The subject name as a URL path segment. Qualified names and names with slashes must survive in REST calls
+func srSubjectPath(subject string) string {
+	return url.PathEscape(subject)
+}
+
+// Set the mode of a subject (or a context, as :.ctx:), or the global mode if subject is empty
+func (admin *SRAdmin) SetMode(subject string, mode string) error {
+	type Request struct {
+		Mode string `json:"mode"`
+	}
+	request, err := json.Marshal(Request{Mode: mode})
+	if err != nil {
+		return err
+	}
+	uri := fmt.Sprintf("%s/mode", admin.url)
+	if subject != "" {
+		uri = fmt.Sprintf("%s/mode/%s", admin.url, srSubjectPath(subject))
+	}
+	respBody, err := admin.makeRestCall("PUT", uri, bytes.NewBuffer(request))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Get the mode of a subject (or a context, as :.ctx:), or the global mode if subject is empty.
+// Returns empty if the subject doesn't set its own mode
+func (admin *SRAdmin) GetMode(subject string) (string, error) {
+	if admin.UseSRCache {
+		if subject == "" {
+			return admin.SRCache.GetGlobalMode(), nil
+		}
+		return admin.SRCache.GetModeForSubject(subject), nil
+	}
+	type Response struct {
+		Mode      string `json:"mode"`
+		ErrorCode int    `json:"error_code"`
+		Message   string `json:"message"`
+	}
+	uri := fmt.Sprintf("%s/mode", admin.url)
+	if subject != "" {
+		uri = fmt.Sprintf("%s/mode/%s?defaultToGlobal=false", admin.url, srSubjectPath(subject))
+	}
+	respBody, err := admin.makeRestCall("GET", uri, bytes.NewBuffer(nil))
+	if err != nil {
+		return "", err
+	}
+	var respObj Response
+	if err := json.Unmarshal(respBody, &respObj); err != nil {
+		return "", err
+	}
+	// 40409: the subject has no mode of its own
+	if respObj.ErrorCode == 40409 {
+		return "", nil
+	}
+	if respObj.ErrorCode != 0 {
+		return "", fmt.Errorf("schema registry error %d: %s", respObj.ErrorCode, respObj.Message)
+	}
+	return respObj.Mode, nil
+}
+
+// List the contexts in the registry
+func (admin *SRAdmin) GetContexts() ([]string, error) {
+	if admin.UseSRCache {
+		return admin.SRCache.GetContexts(), nil
+	}
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/contexts", admin.url), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, err
+	}
+	var contexts []string
+	if err := json.Unmarshal(respBody, &contexts); err != nil {
+		return nil, err
+	}
+	return contexts, nil
+}
+
+// A pending mode change for a subject, context or (with an empty subject) the whole registry
+type schemaModeChange struct {
+	Subject string
+	Mode    string
+}
+
+// Only READWRITE allows registering new schemas. Changes to it must be applied before registering, the rest after
+func (change *schemaModeChange) AllowsWrites() bool {
+	return strings.EqualFold(change.Mode, "READWRITE")
+}
+
+// Work out the global and context mode changes needed for settings
+func (admin *SRAdmin) planModeChanges(settings SchemaRegistrySettings) []schemaModeChange {
+	var changes []schemaModeChange
+	if settings.Mode != "" {
+		current, err := admin.GetMode("")
+		if err != nil {
+			log.Fatalf("Failed to get global mode: %s", err)
+		}
+		if !strings.EqualFold(current, settings.Mode) {
+			changes = append(changes, schemaModeChange{Subject: "", Mode: strings.ToUpper(settings.Mode)})
+		}
+	}
+	for _, context := range settings.Contexts {
+		if context.Mode == "" {
+			continue
+		}
+		subject := qualifySubject(context.Name, "")
+		current, err := admin.GetMode(subject)
+		if err != nil {
+			log.Fatalf("Failed to get mode of context %s: %s", context.Name, err)
+		}
+		if !strings.EqualFold(current, context.Mode) {
+			changes = append(changes, schemaModeChange{Subject: subject, Mode: strings.ToUpper(context.Mode)})
+		}
+	}
+	return changes
+}
+
+// Apply mode changes. Returns a result per change
+func (admin *SRAdmin) applyModeChanges(changes []schemaModeChange, dryRun bool) []SchemaResult {
+	var results []SchemaResult
+	for _, change := range changes {
+		if !dryRun {
+			if err := admin.SetMode(change.Subject, change.Mode); err != nil {
+				log.Fatalf("Failed to set mode of %s to %s: %s", change.Subject, change.Mode, err)
+			}
+		}
+		results = append(results, SchemaResult{SubjectName: change.Subject, NewMode: change.Mode, Changed: !dryRun})
+	}
+	return results
+}
+
+// Contexts used by the desired subjects or settings that don't exist in the registry yet.
+// The registry creates a context with the first subject registered in it, so these are only reported
+func (admin *SRAdmin) newContexts(settings SchemaRegistrySettings, subjects []string) []SchemaResult {
+	wanted := make(map[string]bool)
+	for _, context := range settings.Contexts {
+		wanted[context.Name] = true
+	}
+	for _, subject := range subjects {
+		if context, _ := splitQualifiedSubject(subject); context != defaultSchemaContext {
+			wanted[context] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	existing, err := admin.GetContexts()
+	if err != nil {
+		log.Warnf("Failed to list schema registry contexts: %s", err)
+		return nil
+	}
+	for _, context := range existing {
+		delete(wanted, context)
+	}
+	var results []SchemaResult
+	for context := range wanted {
+		results = append(results, SchemaResult{SubjectName: qualifySubject(context, ""), NewContext: true})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].SubjectName < results[j].SubjectName })
+	return results
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQualifiedSubjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		context string
+		subject string
+	}{
+		{"orders-value", ".", "orders-value"},
+		{":.tenant:orders-value", ".tenant", "orders-value"},
+		{":.tenant:", ".tenant", ""},
+		{":.tenant", ".tenant", ""},
+		{"", ".", ""},
+	}
+	for _, test := range tests {
+		context, subject := splitQualifiedSubject(test.name)
+		require.Equal(t, test.context, context, test.name)
+		require.Equal(t, test.subject, subject, test.name)
+	}
+	require.Equal(t, ":.tenant:orders-value", qualifySubject(".tenant", "orders-value"))
+	require.Equal(t, ":.tenant:", qualifySubject(".tenant", ""))
+	require.Equal(t, "orders-value", qualifySubject(".", "orders-value"))
+	require.Equal(t, "orders-value", qualifySubject("", "orders-value"))
+	require.Equal(t, ":.other:orders-value", qualifySubject(".tenant", ":.other:orders-value"))
+
+	require.True(t, isValidSchemaContext(".tenant"))
+	require.False(t, isValidSchemaContext("tenant"))
+	require.False(t, isValidSchemaContext("."))
+	require.False(t, isValidSchemaContext(".ten:ant"))
+	require.True(t, isValidSchemaMode("readonly"))
+	require.False(t, isValidSchemaMode("WRITEONLY"))
+}
+
+func TestGetMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mode":
+			w.Write([]byte(`{"mode":"READWRITE"}`))
+		case "/mode/:.tenant:orders-value":
+			require.Equal(t, "false", r.URL.Query().Get("defaultToGlobal"))
+			w.Write([]byte(`{"mode":"READONLY"}`))
+		default:
+			w.Write([]byte(`{"error_code":40409,"message":"Subject mode not found"}`))
+		}
+	}))
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	mode, err := admin.GetMode("")
+	require.NoError(t, err)
+	require.Equal(t, "READWRITE", mode)
+	mode, err = admin.GetMode(":.tenant:orders-value")
+	require.NoError(t, err)
+	require.Equal(t, "READONLY", mode)
+	mode, err = admin.GetMode("users-value")
+	require.NoError(t, err)
+	require.Equal(t, "", mode)
+}
+
+func TestReconcileModesAndContexts(t *testing.T) {
+	var mutex sync.Mutex
+	var puts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == "PUT":
+			puts = append(puts, r.URL.Path+" "+string(body))
+			w.Write(body)
+		case r.URL.Path == "/mode":
+			w.Write([]byte(`{"mode":"READWRITE"}`))
+		case r.URL.Path == "/contexts":
+			w.Write([]byte(`["."]`))
+		case r.URL.Path == "/config":
+			w.Write([]byte(`{"compatibilityLevel":"BACKWARD"}`))
+		case strings.HasPrefix(r.URL.Path, "/mode/"):
+			w.Write([]byte(`{"error_code":40409,"message":"Subject mode not found"}`))
+		default:
+			w.Write([]byte(`{"error_code":40408,"message":"Subject not found"}`))
+		}
+	}))
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	settings := SchemaRegistrySettings{Mode: "READONLY", Contexts: []SchemaContext{{Name: ".tenant", Mode: "READWRITE"}}}
+	subjects := map[string]Schema{":.tenant:orders-value": {SubjectName: ":.tenant:orders-value", Mode: "READONLY"}}
+
+	// Plan doesn't change anything
+	results := admin.Reconcile(map[string]Topic{}, subjects, settings, true)
+	require.Empty(t, puts)
+	require.Len(t, results, 4)
+	require.True(t, results[0].NewContext)
+	require.Equal(t, "Context .tenant", results[0].SettingTarget())
+	require.Equal(t, "READWRITE", results[1].NewMode)
+	require.False(t, results[1].IsSubject())
+	require.Equal(t, "READONLY", results[2].NewMode)
+	require.True(t, results[2].IsSubject())
+	require.Equal(t, "Global", results[3].SettingTarget())
+
+	// Writable modes are set before subjects, restrictive ones after
+	admin.Reconcile(map[string]Topic{}, subjects, settings, false)
+	require.Equal(t, []string{
+		`/mode/:.tenant: {"mode":"READWRITE"}`,
+		`/mode/:.tenant:orders-value {"mode":"READONLY"}`,
+		`/mode {"mode":"READONLY"}`,
+	}, puts)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+Data contracts: metadata and rule sets attached to a schema version.
+
+The registry registers a new version when only the metadata or rule set of a schema changes, and a version
+registered without them inherits those of the previous version. So they are compared against the latest version
+of the subject, and only when declared: a schema without a metadata (or rules) block leaves it as it is.
+*/
+
+// Metadata of a schema version
+type SchemaMetadata struct {
+	Tags       map[string][]string `yaml:"tags" json:"tags,omitempty"`             // Tags per field path, like "Customer.ssn": ["PII"]
+	Properties map[string]string   `yaml:"properties" json:"properties,omitempty"` // Free form properties, like owner
+	Sensitive  []string            `yaml:"sensitive" json:"sensitive,omitempty"`   // Properties whose values are sensitive
+}
+
+// A rule of a rule set
+type SchemaRule struct {
+	Name      string            `yaml:"name" json:"name"`
+	Doc       string            `yaml:"doc" json:"doc,omitempty"`
+	Kind      string            `yaml:"kind" json:"kind"` // CONDITION or TRANSFORM
+	Mode      string            `yaml:"mode" json:"mode"` // UPGRADE, DOWNGRADE, UPDOWN for migration rules. WRITE, READ, WRITEREAD for domain rules
+	Type      string            `yaml:"type" json:"type"` // Rule executor, like CEL or JSONATA
+	Tags      []string          `yaml:"tags" json:"tags,omitempty"`
+	Params    map[string]string `yaml:"params" json:"params,omitempty"`
+	Expr      string            `yaml:"expr" json:"expr,omitempty"`
+	OnSuccess string            `yaml:"on_success" json:"onSuccess,omitempty"`
+	OnFailure string            `yaml:"on_failure" json:"onFailure,omitempty"`
+	Disabled  bool              `yaml:"disabled" json:"disabled,omitempty"`
+}
+
+// Rules of a schema version
+type SchemaRuleSet struct {
+	MigrationRules []SchemaRule `yaml:"migration_rules" json:"migrationRules,omitempty"`
+	DomainRules    []SchemaRule `yaml:"domain_rules" json:"domainRules,omitempty"`
+}
+
+var (
+	migrationRuleModes = []string{"UPGRADE", "DOWNGRADE", "UPDOWN"}
+	domainRuleModes    = []string{"WRITE", "READ", "WRITEREAD"}
+	schemaRuleKinds    = []string{"CONDITION", "TRANSFORM"}
+)
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate the rule set and uppercase kinds and modes
+func (rs *SchemaRuleSet) validate() error {
+	names := make(map[string]bool)
+	check := func(rules []SchemaRule, modes []string, what string) error {
+		for i := range rules {
+			rule := &rules[i]
+			if rule.Name == "" {
+				return fmt.Errorf("%s rule without a name", what)
+			}
+			if names[rule.Name] {
+				return fmt.Errorf("rule %s is defined more than once", rule.Name)
+			}
+			names[rule.Name] = true
+			rule.Kind = strings.ToUpper(rule.Kind)
+			if !containsString(schemaRuleKinds, rule.Kind) {
+				return fmt.Errorf("rule %s has kind %q. Expected one of %s", rule.Name, rule.Kind, strings.Join(schemaRuleKinds, ","))
+			}
+			rule.Mode = strings.ToUpper(rule.Mode)
+			if !containsString(modes, rule.Mode) {
+				return fmt.Errorf("%s rule %s has mode %q. Expected one of %s", what, rule.Name, rule.Mode, strings.Join(modes, ","))
+			}
+			if rule.Type == "" {
+				return fmt.Errorf("rule %s has no type", rule.Name)
+			}
+		}
+		return nil
+	}
+	if err := check(rs.MigrationRules, migrationRuleModes, "migration"); err != nil {
+		return err
+	}
+	return check(rs.DomainRules, domainRuleModes, "domain")
+}
+
+// Does the schema declare a data contract
+func (s *Schema) HasContract() bool {
+	return s.Metadata != nil || s.RuleSet != nil
+}
+
+// Metadata and rule set of the latest version of a subject. Nil if the subject has no versions or they have none
+func (admin *SRAdmin) GetSubjectContract(subject string) (*SchemaMetadata, *SchemaRuleSet, error) {
+	if admin.UseSRCache {
+		metadata, ruleSet := admin.SRCache.GetSubjectContract(subject)
+		return metadata, ruleSet, nil
+	}
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/subjects/%s/versions/latest", admin.url, srSubjectPath(subject)), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	type Response struct {
+		ErrorCode int             `json:"error_code"`
+		Metadata  *SchemaMetadata `json:"metadata"`
+		RuleSet   *SchemaRuleSet  `json:"ruleSet"`
+	}
+	var respObj Response
+	if err := json.Unmarshal(respBody, &respObj); err != nil {
+		return nil, nil, err
+	}
+	// 40401 subject not found. Nothing registered yet
+	if respObj.ErrorCode == 40401 {
+		return nil, nil, nil
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, nil, err
+	}
+	return respObj.Metadata, respObj.RuleSet, nil
+}
+
+// Metadata and rule set of the latest version of a subject in the cache
+func (c *SchemaRegistryCache) GetSubjectContract(subject string) (*SchemaMetadata, *SchemaRuleSet) {
+	latest := 0
+	for version := range c.subjects[subject] {
+		if version > latest {
+			latest = version
+		}
+	}
+	if latest == 0 {
+		return nil, nil
+	}
+	cached := c.schemaForSubject(subject, c.subjects[subject][latest])
+	return cached.Metadata, cached.RuleSet
+}
+
+// Changes to the data contract of a subject, as readable lines. Only declared parts of the contract are compared
+func (admin *SRAdmin) contractChanges(schema Schema) ([]string, error) {
+	metadata, ruleSet, err := admin.GetSubjectContract(schema.SubjectName)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	if schema.Metadata != nil {
+		changes = append(changes, metadataChanges(metadata, schema.Metadata)...)
+	}
+	if schema.RuleSet != nil {
+		changes = append(changes, ruleSetChanges(ruleSet, schema.RuleSet)...)
+	}
+	return changes, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Differences between two metadata blocks. Order of tags and sensitive properties doesn't matter
+func metadataChanges(current, desired *SchemaMetadata) []string {
+	if current == nil {
+		current = &SchemaMetadata{}
+	}
+	var changes []string
+	for _, name := range sortedKeys(desired.Properties) {
+		old, exists := current.Properties[name]
+		value := desired.Properties[name]
+		// Don't print values of sensitive properties
+		if containsString(current.Sensitive, name) || containsString(desired.Sensitive, name) {
+			old, value = maskExporterValue(old), maskExporterValue(value)
+		}
+		if !exists {
+			changes = append(changes, fmt.Sprintf("property %s: %s (added)", name, value))
+		} else if current.Properties[name] != desired.Properties[name] {
+			changes = append(changes, fmt.Sprintf("property %s: %s (was: %s)", name, value, old))
+		}
+	}
+	for _, name := range sortedKeys(current.Properties) {
+		if _, exists := desired.Properties[name]; !exists {
+			changes = append(changes, fmt.Sprintf("property %s removed", name))
+		}
+	}
+	paths := make(map[string]bool)
+	for path := range current.Tags {
+		paths[path] = true
+	}
+	for path := range desired.Tags {
+		paths[path] = true
+	}
+	for _, path := range sortedKeys(paths) {
+		added, removed := stringSetDiff(current.Tags[path], desired.Tags[path])
+		for _, tag := range added {
+			changes = append(changes, fmt.Sprintf("tag %s on %s added", tag, path))
+		}
+		for _, tag := range removed {
+			changes = append(changes, fmt.Sprintf("tag %s on %s removed", tag, path))
+		}
+	}
+	added, removed := stringSetDiff(current.Sensitive, desired.Sensitive)
+	for _, name := range added {
+		changes = append(changes, fmt.Sprintf("property %s marked sensitive", name))
+	}
+	for _, name := range removed {
+		changes = append(changes, fmt.Sprintf("property %s no longer sensitive", name))
+	}
+	return changes
+}
+
+// Values only in desired and values only in current, sorted
+func stringSetDiff(current, desired []string) ([]string, []string) {
+	var added, removed []string
+	for _, value := range desired {
+		if !containsString(current, value) {
+			added = append(added, value)
+		}
+	}
+	for _, value := range current {
+		if !containsString(desired, value) {
+			removed = append(removed, value)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Differences between two rule sets, by rule name. Rules run in the order they are declared, so order changes count too
+func ruleSetChanges(current, desired *SchemaRuleSet) []string {
+	if current == nil {
+		current = &SchemaRuleSet{}
+	}
+	var changes []string
+	compare := func(current, desired []SchemaRule, what string) {
+		before := len(changes)
+		existing := make(map[string]SchemaRule)
+		var currentOrder, desiredOrder []string
+		for _, rule := range current {
+			existing[rule.Name] = rule
+			currentOrder = append(currentOrder, rule.Name)
+		}
+		for _, rule := range desired {
+			desiredOrder = append(desiredOrder, rule.Name)
+			old, exists := existing[rule.Name]
+			if !exists {
+				changes = append(changes, fmt.Sprintf("%s rule %s added", what, rule.Name))
+			} else if !reflect.DeepEqual(normalizedRule(old), normalizedRule(rule)) {
+				changes = append(changes, fmt.Sprintf("%s rule %s changed", what, rule.Name))
+			}
+			delete(existing, rule.Name)
+		}
+		for _, name := range sortedKeys(existing) {
+			changes = append(changes, fmt.Sprintf("%s rule %s removed", what, name))
+		}
+		if len(changes) == before && !reflect.DeepEqual(currentOrder, desiredOrder) {
+			changes = append(changes, fmt.Sprintf("%s rules reordered", what))
+		}
+	}
+	compare(current.MigrationRules, desired.MigrationRules, "migration")
+	compare(current.DomainRules, desired.DomainRules, "domain")
+	return changes
+}
+
+// Empty and missing collections are the same to the registry
+func normalizedRule(rule SchemaRule) SchemaRule {
+	if len(rule.Tags) == 0 {
+		rule.Tags = nil
+	}
+	if len(rule.Params) == 0 {
+		rule.Params = nil
+	}
+	return rule
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSchemaContractUnmarshal(t *testing.T) {
+	var schema Schema
+	data := `
+subject: orders-value
+metadata:
+  properties:
+    owner: team-orders
+  tags:
+    Order.email: ["PII"]
+rules:
+  domain_rules:
+    - name: checkEmail
+      kind: condition
+      mode: write
+      type: CEL
+      expr: "message.email != ''"
+`
+	require.NoError(t, yaml.Unmarshal([]byte(data), &schema))
+	require.True(t, schema.HasContract())
+	require.True(t, schema.IsDefined())
+	require.Equal(t, "team-orders", schema.Metadata.Properties["owner"])
+	require.Equal(t, "CONDITION", schema.RuleSet.DomainRules[0].Kind)
+	require.Equal(t, "WRITE", schema.RuleSet.DomainRules[0].Mode)
+
+	// Domain rules can't use migration modes
+	require.Error(t, yaml.Unmarshal([]byte("rules:\n  domain_rules:\n    - {name: a, kind: CONDITION, mode: UPGRADE, type: CEL}\n"), &schema))
+	require.Error(t, yaml.Unmarshal([]byte("rules:\n  migration_rules:\n    - {name: a, kind: CONDITION, mode: UPGRADE, type: JSONATA}\n    - {name: a, kind: TRANSFORM, mode: DOWNGRADE, type: JSONATA}\n"), &schema))
+	require.Error(t, yaml.Unmarshal([]byte("rules:\n  domain_rules:\n    - {name: a, kind: CHECK, mode: READ, type: CEL}\n"), &schema))
+}
+
+func TestMetadataChanges(t *testing.T) {
+	current := &SchemaMetadata{
+		Properties: map[string]string{"owner": "team-a", "tier": "1", "token": "old"},
+		Tags:       map[string][]string{"Order.email": {"PII"}},
+		Sensitive:  []string{"token"},
+	}
+	desired := &SchemaMetadata{
+		Properties: map[string]string{"owner": "team-b", "domain": "sales", "token": "new"},
+		Tags:       map[string][]string{"Order.email": {"PII"}, "Order.ssn": {"PII", "SECRET"}},
+		Sensitive:  []string{"token"},
+	}
+	require.Equal(t, []string{
+		"property domain: sales (added)",
+		"property owner: team-b (was: team-a)",
+		"property token: ***** (was: *****)",
+		"property tier removed",
+		"tag PII on Order.ssn added",
+		"tag SECRET on Order.ssn added",
+	}, metadataChanges(current, desired))
+	require.Empty(t, metadataChanges(desired, desired))
+	require.Len(t, metadataChanges(nil, desired), 7)
+}
+
+func TestRuleSetChanges(t *testing.T) {
+	checkEmail := SchemaRule{Name: "checkEmail", Kind: "CONDITION", Mode: "WRITE", Type: "CEL", Expr: "message.email != ''"}
+	checkTotal := SchemaRule{Name: "checkTotal", Kind: "CONDITION", Mode: "WRITE", Type: "CEL", Expr: "message.total > 0"}
+	current := &SchemaRuleSet{DomainRules: []SchemaRule{checkEmail, checkTotal}}
+
+	changed := checkTotal
+	changed.Expr = "message.total >= 0"
+	upgrade := SchemaRule{Name: "upgrade", Kind: "TRANSFORM", Mode: "UPGRADE", Type: "JSONATA", Expr: "$merge([$, {'total': price}])"}
+	desired := &SchemaRuleSet{DomainRules: []SchemaRule{changed}, MigrationRules: []SchemaRule{upgrade}}
+	require.Equal(t, []string{"migration rule upgrade added", "domain rule checkTotal changed", "domain rule checkEmail removed"}, ruleSetChanges(current, desired))
+
+	// Empty and missing collections are equal, order is not
+	withEmptyTags := checkEmail
+	withEmptyTags.Tags = []string{}
+	require.Empty(t, ruleSetChanges(current, &SchemaRuleSet{DomainRules: []SchemaRule{withEmptyTags, checkTotal}}))
+	require.Equal(t, []string{"domain rules reordered"}, ruleSetChanges(current, &SchemaRuleSet{DomainRules: []SchemaRule{checkTotal, checkEmail}}))
+}
+
+func TestReconcileSchemaContract(t *testing.T) {
+	var mutex sync.Mutex
+	var registered []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/config":
+			w.Write([]byte(`{"compatibilityLevel":"BACKWARD"}`))
+		case r.Method == "POST" && r.URL.Path == "/subjects/orders-value":
+			// The schema text is registered already
+			w.Write([]byte(`{"subject":"orders-value","id":1,"version":1}`))
+		case r.URL.Path == "/subjects/orders-value/versions/latest":
+			w.Write([]byte(`{"subject":"orders-value","id":1,"version":1,"schema":"\"string\"","metadata":{"properties":{"owner":"team-a"}}}`))
+		case r.Method == "POST" && r.URL.Path == "/subjects/orders-value/versions":
+			var request map[string]interface{}
+			json.Unmarshal(body, &request)
+			registered = append(registered, request)
+			w.Write([]byte(`{"id":2,"version":2}`))
+		default:
+			w.Write([]byte(`{"error_code":40408,"message":"Subject not found"}`))
+		}
+	}))
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	schema := Schema{SubjectName: "orders-value", SchemaData: `"string"`, SchemaType: srclient.Avro, Metadata: &SchemaMetadata{Properties: map[string]string{"owner": "team-b"}}}
+
+	result := admin.ReconcileSchema(schema, true)
+	require.True(t, result.HasContractChanges())
+	require.Equal(t, []string{"property owner: team-b (was: team-a)"}, result.ContractChanges)
+	require.Empty(t, registered)
+
+	report := NewReport(nil, []SchemaResult{*result}, nil, nil, nil, nil, true)
+	var output bytes.Buffer
+	report.Render(&output)
+	require.Contains(t, output.String(), "Data contract:\n    - property owner: team-b (was: team-a)\n")
+
+	result = admin.ReconcileSchema(schema, false)
+	require.Equal(t, 2, result.NewVersion)
+	require.Len(t, registered, 1)
+	require.Equal(t, map[string]interface{}{"properties": map[string]interface{}{"owner": "team-b"}}, registered[0]["metadata"])
+
+	// Without a metadata block the registered metadata is left alone
+	schema.Metadata = nil
+	result = admin.ReconcileSchema(schema, false)
+	require.False(t, result.Changed)
+	require.Len(t, registered, 1)
+}
+
+func TestSchemaRegistryCacheSubjectContract(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	require.NoError(t, cache.processSchemaValue(&SRKey{Subject: "orders-value", Version: 1}, []byte(`{"subject":"orders-value","version":1,"id":1,"schema":"\"string\""}`)))
+	require.NoError(t, cache.processSchemaValue(&SRKey{Subject: "orders-value", Version: 2}, []byte(`{"subject":"orders-value","version":2,"id":2,"schema":"\"string\"","metadata":{"properties":{"owner":"team-a"}},"ruleSet":{"domainRules":[{"name":"r","kind":"CONDITION","mode":"WRITE","type":"CEL"}]}}`)))
+	metadata, ruleSet := cache.GetSubjectContract("orders-value")
+	require.Equal(t, "team-a", metadata.Properties["owner"])
+	require.Equal(t, "r", ruleSet.DomainRules[0].Name)
+	metadata, ruleSet = cache.GetSubjectContract("users-value")
+	require.Nil(t, metadata)
+	require.Nil(t, ruleSet)
+}
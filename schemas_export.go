@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kmetaxas/srclient"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Export subjects to a directory and import them back.
+
+The layout is DIR/<subject>/<version>.<avsc|proto|json> with an index.json at the top that records
+IDs, schema types, references and compatibility. The same layout is read by `schema check --from-dir`.
+*/
+
+const schemaExportIndexFile = "index.json"
+
+// Index written next to the exported schemas
+type schemaExportIndex struct {
+	ExportedAt          time.Time             `json:"exportedAt"`
+	GlobalCompatibility string                `json:"globalCompatibility,omitempty"`
+	Subjects            []schemaExportSubject `json:"subjects"`
+}
+
+type schemaExportSubject struct {
+	Subject       string                `json:"subject"`
+	Compatibility string                `json:"compatibility,omitempty"`
+	Versions      []schemaExportVersion `json:"versions"`
+}
+
+type schemaExportVersion struct {
+	Version    int                  `json:"version"`
+	ID         int                  `json:"id"`
+	SchemaType srclient.SchemaType  `json:"schemaType"`
+	References []srclient.Reference `json:"references,omitempty"`
+	File       string               `json:"file"` // Relative to the export directory
+}
+
+// Subjects and their versions, as read from a registry or an export directory
+type schemaExport struct {
+	GlobalCompatibility string
+	Compatibility       map[string]string // Subject level compatibility, where set
+	Versions            map[string][]schemaVersion
+}
+
+func newSchemaExport() *schemaExport {
+	return &schemaExport{Compatibility: make(map[string]string), Versions: make(map[string][]schemaVersion)}
+}
+
+// Subjects in the export, sorted
+func (e *schemaExport) Subjects() []string {
+	var subjects []string
+	for subject := range e.Versions {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// All versions in the order they should be imported. Ordering by ID keeps referenced schemas before the schemas using them
+func (e *schemaExport) ImportOrder() []schemaVersion {
+	var versions []schemaVersion
+	for _, subject := range e.Subjects() {
+		versions = append(versions, e.Versions[subject]...)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].ID != versions[j].ID {
+			return versions[i].ID < versions[j].ID
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions
+}
+
+// Directory name for a subject. Subjects may contain characters that are not safe in paths
+func subjectDirName(subject string) string {
+	return url.PathEscape(subject)
+}
+
+// Read all subjects from the registry REST API. If latestOnly is set, only the latest version of each subject is read
+func (admin *SRAdmin) ReadSchemaExport(latestOnly bool) (*schemaExport, error) {
+	export := newSchemaExport()
+	// We only need the schema text, and codecs can't be created for non AVRO schemas
+	admin.Client.CodecCreationEnabled(false)
+	subjects, err := admin.Client.GetSubjects()
+	if err != nil {
+		return nil, err
+	}
+	export.GlobalCompatibility, err = admin.GetCompatibilityGlobal()
+	if err != nil {
+		return nil, err
+	}
+	for _, subject := range subjects {
+		versions, err := admin.Client.GetSchemaVersions(subject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get versions of %s: %w", subject, err)
+		}
+		if latestOnly && len(versions) > 0 {
+			versions = versions[len(versions)-1:]
+		}
+		for _, version := range versions {
+			registered, err := admin.Client.GetSchemaByVersion(subject, version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get version %d of %s: %w", version, subject, err)
+			}
+			schemaType := srclient.Avro
+			if registered.SchemaType() != nil {
+				schemaType = *registered.SchemaType()
+			}
+			schema := Schema{SubjectName: subject, SchemaData: registered.Schema(), SchemaType: schemaType, References: registered.References()}
+			export.Versions[subject] = append(export.Versions[subject], schemaVersion{Version: version, ID: registered.ID(), Schema: schema})
+		}
+		compatibility, err := admin.GetCompatibility(Schema{SubjectName: subject})
+		if err == nil && compatibility != "" {
+			export.Compatibility[subject] = compatibility
+		}
+	}
+	return export, nil
+}
+
+// Read all subjects from the cache. If latestOnly is set, only the latest version of each subject is read
+func (c *SchemaRegistryCache) ReadSchemaExport(latestOnly bool) *schemaExport {
+	export := newSchemaExport()
+	export.GlobalCompatibility = c.GetGlobalCompatibility()
+	for _, subject := range c.GetSubjects() {
+		versions := c.GetSchemaVersions(subject)
+		if latestOnly && len(versions) > 0 {
+			versions = versions[len(versions)-1:]
+		}
+		for i := range versions {
+			if versions[i].Schema.SchemaType == "" {
+				versions[i].Schema.SchemaType = srclient.Avro
+			}
+		}
+		export.Versions[subject] = versions
+		if compatibility := c.GetCompatibilityForSubject(subject); compatibility != "" {
+			export.Compatibility[subject] = compatibility
+		}
+	}
+	return export
+}
+
+// Write the export to dir. Returns the index that was written
+func writeSchemaExport(dir string, export *schemaExport) (*schemaExportIndex, error) {
+	index := schemaExportIndex{ExportedAt: time.Now().UTC(), GlobalCompatibility: export.GlobalCompatibility}
+	for _, subject := range export.Subjects() {
+		subjectDir := filepath.Join(dir, subjectDirName(subject))
+		if err := os.MkdirAll(subjectDir, 0755); err != nil {
+			return nil, err
+		}
+		exported := schemaExportSubject{Subject: subject, Compatibility: export.Compatibility[subject]}
+		for _, version := range export.Versions[subject] {
+			file := filepath.Join(subjectDirName(subject), fmt.Sprintf("%d%s", version.Version, schemaFileExtension(version.Schema.SchemaType)))
+			if err := os.WriteFile(filepath.Join(dir, file), []byte(version.Schema.SchemaData), 0644); err != nil {
+				return nil, err
+			}
+			exported.Versions = append(exported.Versions, schemaExportVersion{
+				Version:    version.Version,
+				ID:         version.ID,
+				SchemaType: version.Schema.SchemaType,
+				References: version.Schema.References,
+				File:       file,
+			})
+		}
+		index.Subjects = append(index.Subjects, exported)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &index, os.WriteFile(filepath.Join(dir, schemaExportIndexFile), data, 0644)
+}
+
+// Read an export directory written by writeSchemaExport
+func readSchemaExport(dir string) (*schemaExport, error) {
+	data, err := os.ReadFile(filepath.Join(dir, schemaExportIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	var index schemaExportIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid export index: %w", err)
+	}
+	export := newSchemaExport()
+	export.GlobalCompatibility = index.GlobalCompatibility
+	for _, subject := range index.Subjects {
+		if subject.Compatibility != "" {
+			export.Compatibility[subject.Subject] = subject.Compatibility
+		}
+		for _, version := range subject.Versions {
+			schemaData, err := os.ReadFile(filepath.Join(dir, version.File))
+			if err != nil {
+				return nil, err
+			}
+			schema := Schema{SubjectName: subject.Subject, SchemaData: string(schemaData), SchemaType: version.SchemaType, References: version.References}
+			export.Versions[subject.Subject] = append(export.Versions[subject.Subject], schemaVersion{Version: version.Version, ID: version.ID, Schema: schema})
+		}
+	}
+	return export, nil
+}
+
+// Set the GLOBAL compatibility setting
+func (admin *SRAdmin) SetCompatibilityGlobal(compatibility string) error {
+	type Request struct {
+		Compatibility string `json:"compatibility"`
+	}
+	request, err := json.Marshal(Request{Compatibility: compatibility})
+	if err != nil {
+		return err
+	}
+	respBody, err := admin.makeRestCall("PUT", fmt.Sprintf("%s/config", admin.url), bytes.NewBuffer(request))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Register a schema with a given ID and version. The registry (or subject) must be in IMPORT mode
+func (admin *SRAdmin) importSchemaVersion(version schemaVersion) error {
+	type Request struct {
+		Schema     string               `json:"schema"`
+		SchemaType string               `json:"schemaType,omitempty"`
+		References []srclient.Reference `json:"references,omitempty"`
+		Id         int                  `json:"id"`
+		Version    int                  `json:"version"`
+	}
+	reqObj := Request{Schema: version.Schema.SchemaData, References: version.Schema.References, Id: version.ID, Version: version.Version}
+	if version.Schema.SchemaType != srclient.Avro {
+		reqObj.SchemaType = string(version.Schema.SchemaType)
+	}
+	request, err := json.Marshal(reqObj)
+	if err != nil {
+		return err
+	}
+	respBody, err := admin.makeRestCall("POST", fmt.Sprintf("%s/subjects/%s/versions", admin.url, srSubjectPath(version.Schema.SubjectName)), bytes.NewBuffer(request))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+/*
+Import an export into an empty registry, keeping schema IDs and versions.
+The registry is put in IMPORT mode for the duration of the import and back to READWRITE afterwards.
+*/
+func (admin *SRAdmin) ImportSchemas(export *schemaExport) (int, error) {
+	var imported int
+	subjects, err := admin.Client.GetSubjects()
+	if err != nil {
+		return 0, err
+	}
+	if len(subjects) > 0 {
+		return 0, fmt.Errorf("schema registry is not empty (%d subjects). Import needs an empty registry", len(subjects))
+	}
+	if err := admin.SetMode("", "IMPORT"); err != nil {
+		return 0, fmt.Errorf("failed to set IMPORT mode: %w", err)
+	}
+	defer func() {
+		if err := admin.SetMode("", "READWRITE"); err != nil {
+			log.Errorf("Failed to set READWRITE mode after import: %s", err)
+		}
+	}()
+	for _, version := range export.ImportOrder() {
+		log.Debugf("Importing %s version %d with ID %d", version.Schema.SubjectName, version.Version, version.ID)
+		if err := admin.importSchemaVersion(version); err != nil {
+			return imported, fmt.Errorf("failed to import %s version %d: %w", version.Schema.SubjectName, version.Version, err)
+		}
+		imported++
+	}
+	for _, subject := range export.Subjects() {
+		if compatibility, exists := export.Compatibility[subject]; exists {
+			if err := admin.SetCompatibility(Schema{SubjectName: subject}, compatibility); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if export.GlobalCompatibility != "" {
+		if err := admin.SetCompatibilityGlobal(export.GlobalCompatibility); err != nil {
+			return imported, fmt.Errorf("failed to set global compatibility: %w", err)
+		}
+	}
+	return imported, nil
+}
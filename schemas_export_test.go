@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSchemaExport() *schemaExport {
+	export := newSchemaExport()
+	export.GlobalCompatibility = "BACKWARD"
+	export.Compatibility["orders-value"] = "FULL"
+	export.Versions["common.Money"] = []schemaVersion{
+		{Version: 1, ID: 3, Schema: Schema{SubjectName: "common.Money", SchemaData: `{"type":"record","name":"Money","fields":[]}`, SchemaType: srclient.Avro}},
+	}
+	export.Versions["orders-value"] = []schemaVersion{
+		{Version: 1, ID: 1, Schema: Schema{SubjectName: "orders-value", SchemaData: `{"type":"string"}`, SchemaType: srclient.Avro}},
+		{Version: 2, ID: 4, Schema: Schema{SubjectName: "orders-value", SchemaData: `{"type":"record","name":"Order","fields":[{"name":"total","type":"Money"}]}`, SchemaType: srclient.Avro,
+			References: []srclient.Reference{{Name: "Money", Subject: "common.Money", Version: 1}}}},
+	}
+	export.Versions["payments:v1/value"] = []schemaVersion{
+		{Version: 1, ID: 2, Schema: Schema{SubjectName: "payments:v1/value", SchemaData: "syntax = \"proto3\";\nmessage Payment {}\n", SchemaType: srclient.Protobuf}},
+	}
+	return export
+}
+
+func TestSchemaExportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	export := newTestSchemaExport()
+	index, err := writeSchemaExport(dir, export)
+	require.NoError(t, err)
+	require.Len(t, index.Subjects, 3)
+
+	// Subjects with unsafe characters are escaped and files get the extension of their type
+	require.FileExists(t, filepath.Join(dir, "payments:v1%2Fvalue", "1.proto"))
+	require.FileExists(t, filepath.Join(dir, "orders-value", "2.avsc"))
+	require.FileExists(t, filepath.Join(dir, schemaExportIndexFile))
+
+	read, err := readSchemaExport(dir)
+	require.NoError(t, err)
+	require.Equal(t, export.GlobalCompatibility, read.GlobalCompatibility)
+	require.Equal(t, export.Compatibility, read.Compatibility)
+	require.Equal(t, export.Versions, read.Versions)
+
+	// The export can be used as a source for offline checks
+	versions, err := readSchemaVersionsFromDir(dir, "orders-value", srclient.Avro)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+}
+
+func TestSchemaExportImportOrder(t *testing.T) {
+	var order []int
+	for _, version := range newTestSchemaExport().ImportOrder() {
+		order = append(order, version.ID)
+	}
+	require.Equal(t, []int{1, 2, 3, 4}, order)
+}
+
+func TestSchemaRegistryCacheReadSchemaExport(t *testing.T) {
+	cache := &SchemaRegistryCache{
+		schemas:          map[string]map[int]srCachedSchema{".": {1: {Schema: `"string"`}, 2: {Schema: `"long"`}}},
+		subjects:         map[string]map[int]int{"orders-value": {1: 1, 2: 2}},
+		compatPerSubject: map[string]string{"orders-value": "NONE"},
+		globalCompat:     "BACKWARD",
+	}
+	export := cache.ReadSchemaExport(false)
+	require.Len(t, export.Versions["orders-value"], 2)
+	require.Equal(t, "NONE", export.Compatibility["orders-value"])
+	require.Equal(t, srclient.Avro, export.Versions["orders-value"][0].Schema.SchemaType)
+
+	latest := cache.ReadSchemaExport(true)
+	require.Len(t, latest.Versions["orders-value"], 1)
+	require.Equal(t, 2, latest.Versions["orders-value"][0].ID)
+}
+
+func TestImportSchemas(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+	var registered []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+string(body))
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/subjects":
+			w.Write([]byte(`[]`))
+		case r.Method == "POST":
+			var request map[string]interface{}
+			json.Unmarshal(body, &request)
+			registered = append(registered, request)
+			w.Write([]byte(`{"id":1}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	imported, err := admin.ImportSchemas(newTestSchemaExport())
+	require.NoError(t, err)
+	require.Equal(t, 4, imported)
+	require.Equal(t, `PUT /mode {"mode":"IMPORT"}`, calls[1])
+	require.Equal(t, `PUT /mode {"mode":"READWRITE"}`, calls[len(calls)-1])
+	require.Len(t, registered, 4)
+	require.Equal(t, float64(2), registered[1]["id"])
+	require.Equal(t, "PROTOBUF", registered[1]["schemaType"])
+	require.Nil(t, registered[0]["schemaType"])
+	require.Contains(t, calls, "PUT /config/orders-value {\n\t\"compatibility\": \"FULL\"\n}")
+	require.Contains(t, calls, `PUT /config {"compatibility":"BACKWARD"}`)
+}
+
+func TestImportSchemasCompatibilityFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/subjects":
+			w.Write([]byte(`[]`))
+		case r.Method == "PUT" && r.URL.Path == "/config/orders-value":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"error_code":42203,"message":"Invalid compatibility level"}`))
+		default:
+			w.Write([]byte(`{"id":1}`))
+		}
+	}))
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	_, err := admin.ImportSchemas(newTestSchemaExport())
+	require.ErrorContains(t, err, "failed to alter compatibility of orders-value")
+}
+
+func TestImportSchemasNeedsEmptyRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["existing-value"]`))
+	}))
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	_, err := admin.ImportSchemas(newTestSchemaExport())
+	require.Error(t, err)
+}
+
+func TestRestResponseError(t *testing.T) {
+	require.NoError(t, restResponseError([]byte(`{"id":1}`)))
+	require.NoError(t, restResponseError([]byte(`[]`)))
+	err := restResponseError([]byte(`{"error_code":42205,"message":"Subject is not in import mode"}`))
+	require.EqualError(t, err, "schema registry error 42205: Subject is not in import mode")
+}
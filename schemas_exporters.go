@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Schema Linking exporters, which copy subjects from this registry to another one.
+Managed through the /exporters API of the (source) schema registry.
+*/
+
+// Exporter states, as reported by /exporters/{name}/status
+const (
+	ExporterRunning = "RUNNING"
+	ExporterPaused  = "PAUSED"
+)
+
+// Configs that hold credentials. Their values are never shown in plans
+var exporterSensitiveConfigRegex = regexp.MustCompile(`(?i)(password|user\.info|secret|key\.password|token)`)
+
+// A schema exporter as declared in the input YAML
+type SchemaExporter struct {
+	Name                string            `yaml:"name" json:"name"`
+	ContextType         string            `yaml:"context_type" json:"contextType,omitempty"` // AUTO (default), CUSTOM or NONE
+	Context             string            `yaml:"context" json:"context,omitempty"`          // Destination context for CUSTOM
+	Subjects            []string          `yaml:"subjects" json:"subjects"`
+	SubjectRenameFormat string            `yaml:"subject_rename_format" json:"subjectRenameFormat,omitempty"`
+	Config              map[string]string `yaml:"config" json:"config,omitempty"` // Destination registry config, like schema.registry.url
+	State               string            `yaml:"state" json:"-"`                 // RUNNING (default) or PAUSED
+	Delete              bool              `yaml:"delete" json:"-"`                // Delete the exporter instead of reconciling it
+}
+
+func (e *SchemaExporter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawExporter SchemaExporter
+	raw := rawExporter{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if raw.Name == "" {
+		return fmt.Errorf("schema exporter without a name")
+	}
+	raw.ContextType = strings.ToUpper(raw.ContextType)
+	if raw.ContextType == "" {
+		raw.ContextType = "AUTO"
+	}
+	switch raw.ContextType {
+	case "AUTO", "NONE":
+	case "CUSTOM":
+		if raw.Context == "" {
+			return fmt.Errorf("schema exporter %s has context_type CUSTOM but no context", raw.Name)
+		}
+	default:
+		return fmt.Errorf("schema exporter %s has unknown context_type %s", raw.Name, raw.ContextType)
+	}
+	raw.State = strings.ToUpper(raw.State)
+	if raw.State == "" {
+		raw.State = ExporterRunning
+	}
+	if raw.State != ExporterRunning && raw.State != ExporterPaused {
+		return fmt.Errorf("schema exporter %s has unknown state %s", raw.Name, raw.State)
+	}
+	if len(raw.Subjects) == 0 && !raw.Delete {
+		return fmt.Errorf("schema exporter %s has no subjects", raw.Name)
+	}
+	*e = SchemaExporter(raw)
+	return nil
+}
+
+// Status of an exporter
+type SchemaExporterStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`  // STARTING, RUNNING, PAUSED or ERROR
+	Offset int64  `json:"offset"` // Offset of _schemas exported so far
+	Ts     int64  `json:"ts"`     // Time of the last export, in ms
+	Trace  string `json:"trace"`  // Error, if the state is ERROR
+}
+
+// List the names of exporters
+func (admin *SRAdmin) ListExporters() ([]string, error) {
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/exporters", admin.url), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal(respBody, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Get an exporter's definition, including its config
+func (admin *SRAdmin) GetExporter(name string) (*SchemaExporter, error) {
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/exporters/%s", admin.url, srSubjectPath(name)), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, err
+	}
+	var exporter SchemaExporter
+	if err := json.Unmarshal(respBody, &exporter); err != nil {
+		return nil, err
+	}
+	respBody, err = admin.makeRestCall("GET", fmt.Sprintf("%s/exporters/%s/config", admin.url, srSubjectPath(name)), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(respBody, &exporter.Config); err != nil {
+		return nil, err
+	}
+	return &exporter, nil
+}
+
+// Get the status of an exporter
+func (admin *SRAdmin) GetExporterStatus(name string) (*SchemaExporterStatus, error) {
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/exporters/%s/status", admin.url, srSubjectPath(name)), bytes.NewBuffer(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return nil, err
+	}
+	var status SchemaExporterStatus
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Create an exporter. It starts running right away
+func (admin *SRAdmin) CreateExporter(exporter *SchemaExporter) error {
+	request, err := json.Marshal(exporter)
+	if err != nil {
+		return err
+	}
+	respBody, err := admin.makeRestCall("POST", fmt.Sprintf("%s/exporters", admin.url), bytes.NewBuffer(request))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Update an exporter's definition and config. The registry needs it paused for that
+func (admin *SRAdmin) UpdateExporter(exporter *SchemaExporter) error {
+	type Request struct {
+		ContextType         string            `json:"contextType,omitempty"`
+		Context             string            `json:"context,omitempty"`
+		Subjects            []string          `json:"subjects"`
+		SubjectRenameFormat string            `json:"subjectRenameFormat,omitempty"`
+		Config              map[string]string `json:"config,omitempty"`
+	}
+	request, err := json.Marshal(Request{
+		ContextType:         exporter.ContextType,
+		Context:             exporter.Context,
+		Subjects:            exporter.Subjects,
+		SubjectRenameFormat: exporter.SubjectRenameFormat,
+		Config:              exporter.Config,
+	})
+	if err != nil {
+		return err
+	}
+	respBody, err := admin.makeRestCall("PUT", fmt.Sprintf("%s/exporters/%s", admin.url, srSubjectPath(exporter.Name)), bytes.NewBuffer(request))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Pause, resume or reset an exporter
+func (admin *SRAdmin) exporterAction(name, action string) error {
+	respBody, err := admin.makeRestCall("PUT", fmt.Sprintf("%s/exporters/%s/%s", admin.url, srSubjectPath(name), action), bytes.NewBuffer(nil))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Delete an exporter. The registry needs it paused first
+func (admin *SRAdmin) DeleteExporter(name string) error {
+	respBody, err := admin.makeRestCall("DELETE", fmt.Sprintf("%s/exporters/%s", admin.url, srSubjectPath(name)), bytes.NewBuffer(nil))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Config changes between the current and desired exporter. Both sides of sensitive configs are masked
+func exporterConfigChanges(current, desired *SchemaExporter) []ChangedConnectorConfig {
+	var changes []ChangedConnectorConfig
+	add := func(name, oldVal, newVal string) {
+		isSensitive := exporterSensitiveConfigRegex.MatchString(name)
+		if isSensitive {
+			oldVal, newVal = maskExporterValue(oldVal), maskExporterValue(newVal)
+		}
+		changes = append(changes, ChangedConnectorConfig{Name: name, OldVal: oldVal, NewVal: newVal, IsSensitive: isSensitive})
+	}
+	if current.ContextType != desired.ContextType {
+		add("contextType", current.ContextType, desired.ContextType)
+	}
+	if current.Context != desired.Context && desired.ContextType == "CUSTOM" {
+		add("context", current.Context, desired.Context)
+	}
+	currentSubjects := append([]string(nil), current.Subjects...)
+	desiredSubjects := append([]string(nil), desired.Subjects...)
+	sort.Strings(currentSubjects)
+	sort.Strings(desiredSubjects)
+	if !reflect.DeepEqual(currentSubjects, desiredSubjects) {
+		add("subjects", strings.Join(currentSubjects, ","), strings.Join(desiredSubjects, ","))
+	}
+	if current.SubjectRenameFormat != desired.SubjectRenameFormat {
+		add("subjectRenameFormat", current.SubjectRenameFormat, desired.SubjectRenameFormat)
+	}
+	for name, value := range desired.Config {
+		// The registry may not return sensitive values, so only their presence is compared
+		oldValue, exists := current.Config[name]
+		if !exists || (oldValue != value && !exporterSensitiveConfigRegex.MatchString(name)) {
+			add(name, oldValue, value)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+func maskExporterValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "*****"
+}
+
+/*
+Reconcile exporters: create missing ones, update definitions and configs, pause or resume
+them to the declared state, and delete those marked for deletion.
+The status (state, offset and lag) of every declared exporter is reported.
+*/
+func (admin *SRAdmin) ReconcileExporters(exporters map[string]SchemaExporter, dryRun bool) []SchemaExporterResult {
+	var results []SchemaExporterResult
+	if len(exporters) == 0 {
+		return results
+	}
+	existingNames, err := admin.ListExporters()
+	if err != nil {
+		return []SchemaExporterResult{{Name: "SCHEMA_EXPORTER_LIST_ERROR", Status: "Error", Error: fmt.Errorf("failed to list schema exporters: %w", err)}}
+	}
+	existing := make(map[string]bool)
+	for _, name := range existingNames {
+		existing[name] = true
+	}
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		desired := exporters[name]
+		result := admin.reconcileExporter(&desired, existing[name], dryRun)
+		results = append(results, result)
+	}
+	return results
+}
+
+func (admin *SRAdmin) reconcileExporter(desired *SchemaExporter, exists bool, dryRun bool) SchemaExporterResult {
+	result := SchemaExporterResult{Name: desired.Name, Status: "NoChange"}
+	fail := func(err error) SchemaExporterResult {
+		log.Errorf("Schema exporter %s: %s", desired.Name, err)
+		result.Status = "Error"
+		result.Error = err
+		return result
+	}
+	if desired.Delete {
+		if !exists {
+			return result
+		}
+		result.Status = "Deleted"
+		if !dryRun {
+			if err := admin.exporterAction(desired.Name, "pause"); err != nil {
+				return fail(fmt.Errorf("failed to pause before delete: %w", err))
+			}
+			if err := admin.DeleteExporter(desired.Name); err != nil {
+				return fail(fmt.Errorf("failed to delete: %w", err))
+			}
+		}
+		return result
+	}
+	if !exists {
+		result.Status = "Created"
+		result.Changes = exporterConfigChanges(&SchemaExporter{}, desired)
+		result.NewState = desired.State
+		if !dryRun {
+			if err := admin.CreateExporter(desired); err != nil {
+				return fail(fmt.Errorf("failed to create: %w", err))
+			}
+			if desired.State == ExporterPaused {
+				if err := admin.exporterAction(desired.Name, "pause"); err != nil {
+					return fail(fmt.Errorf("failed to pause: %w", err))
+				}
+			}
+		}
+		return result
+	}
+	current, err := admin.GetExporter(desired.Name)
+	if err != nil {
+		return fail(fmt.Errorf("failed to describe: %w", err))
+	}
+	status, err := admin.GetExporterStatus(desired.Name)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get status: %w", err))
+	}
+	result.fillStatus(status, admin.schemasEndOffset())
+	result.Changes = exporterConfigChanges(current, desired)
+	currentlyPaused := status.State == ExporterPaused
+	wantPaused := desired.State == ExporterPaused
+	if currentlyPaused != wantPaused {
+		result.NewState = desired.State
+	}
+	if len(result.Changes) > 0 || result.NewState != "" {
+		result.Status = "Updated"
+	}
+	if dryRun || result.Status == "NoChange" {
+		return result
+	}
+	// Updates need the exporter paused. Resume it afterwards unless it should stay paused
+	if len(result.Changes) > 0 {
+		if !currentlyPaused {
+			if err := admin.exporterAction(desired.Name, "pause"); err != nil {
+				return fail(fmt.Errorf("failed to pause for update: %w", err))
+			}
+		}
+		if err := admin.UpdateExporter(desired); err != nil {
+			return fail(fmt.Errorf("failed to update: %w", err))
+		}
+		currentlyPaused = true
+	}
+	if currentlyPaused && !wantPaused {
+		if err := admin.exporterAction(desired.Name, "resume"); err != nil {
+			return fail(fmt.Errorf("failed to resume: %w", err))
+		}
+	} else if !currentlyPaused && wantPaused {
+		if err := admin.exporterAction(desired.Name, "pause"); err != nil {
+			return fail(fmt.Errorf("failed to pause: %w", err))
+		}
+	}
+	return result
+}
+
+// The last offset of _schemas, from the cache or else from Kafka. -1 if it's not known
+func (admin *SRAdmin) schemasEndOffset() int64 {
+	if admin.UseSRCache && admin.SRCache != nil {
+		return admin.SRCache.offset - 1
+	}
+	if admin.schemasHighWaterMark == nil {
+		return -1
+	}
+	highWaterMark, err := admin.schemasHighWaterMark()
+	if err != nil {
+		log.Warnf("Failed to get the end of _schemas, exporter lag is not known: %s", err)
+		return -1
+	}
+	return highWaterMark - 1
+}
+
+// The newest offset (high watermark) of the single partition of _schemas
+func schemasHighWaterMark(config *Configuration) (int64, error) {
+	client, err := sarama.NewClient(config.Connections.Kafka.Brokers, SaramaConfigFromKafkaConfig(config.Connections.Kafka))
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	return client.GetOffset("_schemas", 0, sarama.OffsetNewest)
+}
+
+// Fill in the status of the exporter. Lag in records is only known when the end of _schemas is
+func (res *SchemaExporterResult) fillStatus(status *SchemaExporterStatus, schemasEndOffset int64) {
+	res.State = status.State
+	res.Offset = status.Offset
+	res.Trace = status.Trace
+	res.LagRecords = -1
+	if schemasEndOffset >= 0 {
+		res.LagRecords = schemasEndOffset - status.Offset
+		if res.LagRecords < 0 {
+			res.LagRecords = 0
+		}
+	}
+	if status.Ts > 0 {
+		res.LastExport = time.UnixMilli(status.Ts).UTC()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSchemaExporterUnmarshal(t *testing.T) {
+	var exporter SchemaExporter
+	require.NoError(t, yaml.Unmarshal([]byte("name: dr\nsubjects: [\"orders-value\"]\nconfig:\n  schema.registry.url: http://dr:8081\n"), &exporter))
+	require.Equal(t, "AUTO", exporter.ContextType)
+	require.Equal(t, ExporterRunning, exporter.State)
+
+	require.Error(t, yaml.Unmarshal([]byte("name: dr\nsubjects: [a]\ncontext_type: custom\n"), &exporter))
+	require.Error(t, yaml.Unmarshal([]byte("name: dr\nsubjects: [a]\nstate: STOPPED\n"), &exporter))
+	require.Error(t, yaml.Unmarshal([]byte("name: dr\n"), &exporter))
+	require.NoError(t, yaml.Unmarshal([]byte("name: dr\ndelete: true\n"), &exporter))
+}
+
+func TestExporterConfigChanges(t *testing.T) {
+	current := &SchemaExporter{ContextType: "AUTO", Subjects: []string{"b", "a"}, Config: map[string]string{"schema.registry.url": "http://old", "basic.auth.user.info": "user:old"}}
+	desired := &SchemaExporter{ContextType: "AUTO", Subjects: []string{"a", "b"}, Config: map[string]string{"schema.registry.url": "http://new", "basic.auth.user.info": "user:new"}}
+	// Sensitive values can't be compared, as the registry may not return them
+	require.Equal(t, []ChangedConnectorConfig{{Name: "schema.registry.url", OldVal: "http://old", NewVal: "http://new"}}, exporterConfigChanges(current, desired))
+
+	changes := exporterConfigChanges(&SchemaExporter{}, desired)
+	require.Len(t, changes, 4)
+	require.Equal(t, ChangedConnectorConfig{Name: "basic.auth.user.info", NewVal: "*****", IsSensitive: true}, changes[0])
+}
+
+// A registry with a running exporter "existing" and a paused exporter "paused"
+func newExporterTestServer(calls *[]string) *httptest.Server {
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "GET" {
+			*calls = append(*calls, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+			w.Write([]byte(`{}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/exporters":
+			w.Write([]byte(`["existing","paused"]`))
+		case "/exporters/existing", "/exporters/paused":
+			w.Write([]byte(`{"name":"x","contextType":"AUTO","subjects":["orders-value"]}`))
+		case "/exporters/existing/config", "/exporters/paused/config":
+			w.Write([]byte(`{"schema.registry.url":"http://dr:8081"}`))
+		case "/exporters/existing/status":
+			w.Write([]byte(`{"name":"existing","state":"RUNNING","offset":40,"ts":1700000000000}`))
+		case "/exporters/paused/status":
+			w.Write([]byte(`{"name":"paused","state":"PAUSED","offset":10,"ts":0}`))
+		default:
+			w.Write([]byte(`{"error_code":40450,"message":"Exporter not found"}`))
+		}
+	}))
+}
+
+func TestReconcileExporters(t *testing.T) {
+	var calls []string
+	server := newExporterTestServer(&calls)
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+	admin.UseSRCache = true
+	admin.SRCache = newEmptySchemaRegistryCache()
+	admin.SRCache.offset = 51
+	config := map[string]string{"schema.registry.url": "http://dr:8081"}
+	exporters := map[string]SchemaExporter{
+		"existing": {Name: "existing", ContextType: "AUTO", Subjects: []string{"orders-value", "users-value"}, Config: config, State: ExporterRunning},
+		"paused":   {Name: "paused", ContextType: "AUTO", Subjects: []string{"orders-value"}, Config: config, State: ExporterRunning},
+		"new":      {Name: "new", ContextType: "CUSTOM", Context: ".dr", Subjects: []string{"*"}, Config: config, State: ExporterPaused},
+		"old":      {Name: "old", Delete: true},
+	}
+
+	results := admin.ReconcileExporters(exporters, true)
+	require.Empty(t, calls)
+	require.Len(t, results, 4)
+	byName := make(map[string]SchemaExporterResult)
+	for _, result := range results {
+		byName[result.Name] = result
+	}
+	require.Equal(t, "Updated", byName["existing"].Status)
+	require.Equal(t, "RUNNING", byName["existing"].State)
+	require.Equal(t, int64(10), byName["existing"].LagRecords)
+	require.Equal(t, "Updated", byName["paused"].Status)
+	require.Equal(t, ExporterRunning, byName["paused"].NewState)
+	require.Empty(t, byName["paused"].Changes)
+	require.Equal(t, "Created", byName["new"].Status)
+	// Not declared in the registry, so nothing to delete
+	require.Equal(t, "NoChange", byName["old"].Status)
+
+	report := NewReport(nil, nil, results, nil, nil, nil, true)
+	var output bytes.Buffer
+	report.Render(&output)
+	require.Contains(t, output.String(), "[PLAN] Will update schema exporter existing.\n  - subjects: orders-value,users-value (was: orders-value)")
+	require.Contains(t, output.String(), "Schema exporter existing: RUNNING, offset 40, lag 10 records, last export 2023-11-14T22:13:20Z")
+	require.Contains(t, output.String(), "[PLAN] Will create schema exporter new.")
+
+	admin.ReconcileExporters(exporters, false)
+	require.Equal(t, []string{
+		`PUT /exporters/existing/pause`,
+		`PUT /exporters/existing {"contextType":"AUTO","subjects":["orders-value","users-value"],"config":{"schema.registry.url":"http://dr:8081"}}`,
+		`PUT /exporters/existing/resume`,
+		`POST /exporters {"name":"new","contextType":"CUSTOM","context":".dr","subjects":["*"],"config":{"schema.registry.url":"http://dr:8081"}}`,
+		`PUT /exporters/new/pause`,
+		`PUT /exporters/paused/resume`,
+	}, calls)
+
+	// Without the cache the end of _schemas comes from Kafka
+	admin.UseSRCache = false
+	admin.schemasHighWaterMark = func() (int64, error) { return 46, nil }
+	fromKafka := admin.ReconcileExporters(map[string]SchemaExporter{"existing": exporters["existing"]}, true)
+	require.Equal(t, int64(5), fromKafka[0].LagRecords)
+	admin.schemasHighWaterMark = func() (int64, error) { return 0, errors.New("no brokers") }
+	fromKafka = admin.ReconcileExporters(map[string]SchemaExporter{"existing": exporters["existing"]}, true)
+	require.Equal(t, int64(-1), fromKafka[0].LagRecords)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kmetaxas/srclient"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+Version history of a subject and field level evolution diffs between versions.
+
+History comes from the _schemas topic, as it's the only place that records the order of registrations
+and the compatibility level that was in effect when each version was registered.
+*/
+
+// A version of a subject, as listed by `schema history`
+type schemaHistoryEntry struct {
+	Version       int                 `json:"version"`
+	ID            int                 `json:"id"`
+	SchemaType    srclient.SchemaType `json:"schemaType"`
+	Order         int                 `json:"order"`         // Position among the subject's registrations, starting at 1
+	Offset        int64               `json:"offset"`        // Offset of the registration in _schemas
+	Compatibility string              `json:"compatibility"` // Effective compatibility when registered
+	Deleted       bool                `json:"deleted"`       // Soft deleted
+	Changes       []schemaFieldChange `json:"changes,omitempty"`
+	Diff          string              `json:"diff,omitempty"` // Text diff, for schema types without field level diffs
+}
+
+// Kinds of field changes
+const (
+	fieldAdded          = "added"
+	fieldRemoved        = "removed"
+	fieldRenamed        = "renamed"
+	fieldTypePromoted   = "type_promoted"
+	fieldTypeChanged    = "type_changed"
+	fieldDefaultChanged = "default_changed"
+	fieldNumberChanged  = "number_changed"
+	fieldLabelChanged   = "label_changed"
+)
+
+// A change of a single field between two versions
+type schemaFieldChange struct {
+	Change string `json:"change"`
+	Field  string `json:"field"` // Dotted path of the field, starting at the top level record or message
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+func (c schemaFieldChange) String() string {
+	switch c.Change {
+	case fieldAdded:
+		return fmt.Sprintf("+ %s (%s)", c.Field, c.New)
+	case fieldRemoved:
+		return fmt.Sprintf("- %s (%s)", c.Field, c.Old)
+	case fieldRenamed:
+		return fmt.Sprintf("~ %s renamed from %s", c.Field, c.Old)
+	}
+	return fmt.Sprintf("~ %s %s: %s -> %s", c.Field, strings.ReplaceAll(c.Change, "_", " "), c.Old, c.New)
+}
+
+// The schema of a subject version, including soft deleted versions
+func (c *SchemaRegistryCache) getSchemaForHistory(subject string, version int) (*Schema, bool, error) {
+	if _, exists := c.subjects[subject][version]; exists {
+		schema, err := c.GetSchemaForSubjectVersion(subject, version)
+		return schema, false, err
+	}
+	schemaID, exists := c.deletedSubjects[subject][version]
+	if !exists {
+		return nil, false, fmt.Errorf("version %d of subject %s not found", version, subject)
+	}
+	cached := c.schemaForSubject(subject, schemaID)
+	return &Schema{SubjectName: subject, SchemaData: cached.Schema, SchemaType: cached.SchemaType, References: cached.References}, true, nil
+}
+
+// A version of a subject, soft deleted ones included, like the registry client returns it. Resolves references offline
+func (c *SchemaRegistryCache) GetSchemaByVersion(subject string, version int) (*srclient.Schema, error) {
+	schema, _, err := c.getSchemaForHistory(subject, version)
+	if err != nil {
+		return nil, err
+	}
+	return srclient.NewSchema(0, schema.SchemaData, schema.SchemaType, version, schema.References, nil, nil)
+}
+
+// All versions of a subject, soft deleted ones included, in registration order
+func (c *SchemaRegistryCache) GetSchemaHistory(subject string) []schemaHistoryEntry {
+	var entries []schemaHistoryEntry
+	add := func(versions map[int]int, deleted bool) {
+		for version, id := range versions {
+			history := c.versionHistory[subject][version]
+			cached := c.schemaForSubject(subject, id)
+			entries = append(entries, schemaHistoryEntry{
+				Version:       version,
+				ID:            id,
+				SchemaType:    cached.SchemaType,
+				Offset:        history.Offset,
+				Compatibility: history.Compatibility,
+				Deleted:       deleted,
+			})
+		}
+	}
+	add(c.subjects[subject], false)
+	add(c.deletedSubjects[subject], true)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Offset != entries[j].Offset {
+			return entries[i].Offset < entries[j].Offset
+		}
+		return entries[i].Version < entries[j].Version
+	})
+	for i := range entries {
+		entries[i].Order = i + 1
+	}
+	return entries
+}
+
+/*
+Field level changes from oldText to newText. Only AVRO and PROTOBUF schemas are understood.
+Imports are the resolved references of PROTOBUF schemas, by import path
+*/
+func diffSchemaFields(schemaType srclient.SchemaType, oldText, newText string, oldImports, newImports map[string]string) ([]schemaFieldChange, error) {
+	switch schemaType {
+	case "", srclient.Avro:
+		oldSchema, err := parseAvroSchema(oldText)
+		if err != nil {
+			return nil, err
+		}
+		newSchema, err := parseAvroSchema(newText)
+		if err != nil {
+			return nil, err
+		}
+		differ := avroFieldDiffer{seen: make(map[string]bool)}
+		differ.diff(oldSchema, newSchema, "")
+		return differ.changes, nil
+	case srclient.Protobuf:
+		oldFile, err := compileProtobufSchema(oldText, oldImports)
+		if err != nil {
+			return nil, err
+		}
+		newFile, err := compileProtobufSchema(newText, newImports)
+		if err != nil {
+			return nil, err
+		}
+		return diffProtobufMessages(oldFile.Messages(), newFile.Messages()), nil
+	}
+	return nil, fmt.Errorf("field level diffs are not supported for %s schemas", schemaType)
+}
+
+type avroFieldDiffer struct {
+	changes []schemaFieldChange
+	seen    map[string]bool // Records being compared on the current path, so recursive types end
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (d *avroFieldDiffer) diff(oldSchema, newSchema *avroSchema, path string) {
+	if oldSchema.Type != "record" || newSchema.Type != "record" {
+		if oldSchema.String() != newSchema.String() {
+			d.changes = append(d.changes, schemaFieldChange{Change: avroTypeChange(oldSchema, newSchema), Field: path, Old: oldSchema.String(), New: newSchema.String()})
+		}
+		return
+	}
+	if d.seen[newSchema.Name] {
+		return
+	}
+	// Only records of the enclosing fields are skipped. The same record under another field is compared again
+	d.seen[newSchema.Name] = true
+	defer delete(d.seen, newSchema.Name)
+	matched := make(map[string]bool)
+	for _, newField := range newSchema.Fields {
+		fieldPath := joinFieldPath(path, newField.Name)
+		oldField := oldSchema.Field(newField.Name)
+		if oldField == nil {
+			// A field that has the old name as alias was renamed
+			for _, alias := range newField.Aliases {
+				if oldField = oldSchema.Field(alias); oldField != nil {
+					d.changes = append(d.changes, schemaFieldChange{Change: fieldRenamed, Field: fieldPath, Old: alias, New: newField.Name})
+					break
+				}
+			}
+		}
+		if oldField == nil {
+			d.changes = append(d.changes, schemaFieldChange{Change: fieldAdded, Field: fieldPath, New: newField.Type.String()})
+			continue
+		}
+		matched[oldField.Name] = true
+		d.diffField(oldField, &newField, fieldPath)
+	}
+	for _, oldField := range oldSchema.Fields {
+		if !matched[oldField.Name] {
+			d.changes = append(d.changes, schemaFieldChange{Change: fieldRemoved, Field: joinFieldPath(path, oldField.Name), Old: oldField.Type.String()})
+		}
+	}
+}
+
+func (d *avroFieldDiffer) diffField(oldField, newField *avroField, path string) {
+	if oldField.Type.String() != newField.Type.String() {
+		d.changes = append(d.changes, schemaFieldChange{Change: avroTypeChange(oldField.Type, newField.Type), Field: path, Old: oldField.Type.String(), New: newField.Type.String()})
+	}
+	oldDefault, newDefault := avroDefaultString(oldField), avroDefaultString(newField)
+	if oldDefault != newDefault {
+		d.changes = append(d.changes, schemaFieldChange{Change: fieldDefaultChanged, Field: path, Old: oldDefault, New: newDefault})
+	}
+	// Nested records with the same name are compared field by field
+	oldRecords := avroNestedRecords(oldField.Type)
+	for _, newRecord := range avroNestedRecords(newField.Type) {
+		for _, oldRecord := range oldRecords {
+			if oldRecord.Name == newRecord.Name {
+				d.diff(oldRecord, newRecord, path)
+			}
+		}
+	}
+}
+
+// A type change is a promotion when data of the old type can be read as the new type
+func avroTypeChange(oldType, newType *avroSchema) string {
+	for _, promotable := range avroPromotions[newType.Type] {
+		if promotable == oldType.Type {
+			return fieldTypePromoted
+		}
+	}
+	// Adding branches to a union (or making a type a union that includes it) also keeps old data readable
+	if newType.Type == "union" {
+		for _, branch := range newType.Branches {
+			if branch.String() == oldType.String() {
+				return fieldTypePromoted
+			}
+		}
+		if oldType.Type == "union" {
+			for _, oldBranch := range oldType.Branches {
+				found := false
+				for _, branch := range newType.Branches {
+					found = found || branch.String() == oldBranch.String()
+				}
+				if !found {
+					return fieldTypeChanged
+				}
+			}
+			return fieldTypePromoted
+		}
+	}
+	return fieldTypeChanged
+}
+
+func avroDefaultString(field *avroField) string {
+	if !field.HasDefault {
+		return "none"
+	}
+	data, err := json.Marshal(field.Default)
+	if err != nil {
+		return fmt.Sprint(field.Default)
+	}
+	return string(data)
+}
+
+// Records directly used by a type: the type itself, union branches, array items and map values
+func avroNestedRecords(schema *avroSchema) []*avroSchema {
+	switch schema.Type {
+	case "record":
+		return []*avroSchema{schema}
+	case "union":
+		var records []*avroSchema
+		for _, branch := range schema.Branches {
+			records = append(records, avroNestedRecords(branch)...)
+		}
+		return records
+	case "array":
+		return avroNestedRecords(schema.Items)
+	case "map":
+		return avroNestedRecords(schema.Values)
+	}
+	return nil
+}
+
+// Protobuf kinds that share a wire encoding, so changing between them keeps old data readable
+var protobufCompatibleKinds = [][]protoreflect.Kind{
+	{protoreflect.Int32Kind, protoreflect.Uint32Kind, protoreflect.Int64Kind, protoreflect.Uint64Kind, protoreflect.BoolKind},
+	{protoreflect.Sint32Kind, protoreflect.Sint64Kind},
+	{protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind},
+	{protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind},
+	{protoreflect.StringKind, protoreflect.BytesKind},
+}
+
+func protobufKindsCompatible(oldKind, newKind protoreflect.Kind) bool {
+	for _, group := range protobufCompatibleKinds {
+		var hasOld, hasNew bool
+		for _, kind := range group {
+			hasOld = hasOld || kind == oldKind
+			hasNew = hasNew || kind == newKind
+		}
+		if hasOld && hasNew {
+			return true
+		}
+	}
+	return false
+}
+
+// Human readable type of a Protobuf field
+func protobufFieldType(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() {
+		return fmt.Sprintf("map<%s,%s>", protobufFieldType(field.MapKey()), protobufFieldType(field.MapValue()))
+	}
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(field.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(field.Enum().FullName())
+	}
+	return field.Kind().String()
+}
+
+func protobufFieldLabel(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() {
+		return "map"
+	}
+	if field.Cardinality() == protoreflect.Repeated {
+		return "repeated"
+	}
+	if field.HasOptionalKeyword() {
+		return "optional"
+	}
+	return field.Cardinality().String()
+}
+
+func protobufDefaultString(field protoreflect.FieldDescriptor) string {
+	if !field.HasDefault() {
+		return "none"
+	}
+	if field.Kind() == protoreflect.EnumKind {
+		return string(field.DefaultEnumValue().Name())
+	}
+	return field.Default().String()
+}
+
+// Compare messages (and their nested messages) with the same full name
+func diffProtobufMessages(oldMessages, newMessages protoreflect.MessageDescriptors) []schemaFieldChange {
+	var changes []schemaFieldChange
+	for i := 0; i < newMessages.Len(); i++ {
+		newMessage := newMessages.Get(i)
+		if newMessage.IsMapEntry() {
+			continue
+		}
+		oldMessage := oldMessages.ByName(newMessage.Name())
+		if oldMessage == nil {
+			changes = append(changes, schemaFieldChange{Change: fieldAdded, Field: string(newMessage.FullName()), New: "message"})
+			continue
+		}
+		changes = append(changes, diffProtobufFields(oldMessage, newMessage)...)
+		changes = append(changes, diffProtobufMessages(oldMessage.Messages(), newMessage.Messages())...)
+	}
+	for i := 0; i < oldMessages.Len(); i++ {
+		oldMessage := oldMessages.Get(i)
+		if !oldMessage.IsMapEntry() && newMessages.ByName(oldMessage.Name()) == nil {
+			changes = append(changes, schemaFieldChange{Change: fieldRemoved, Field: string(oldMessage.FullName()), Old: "message"})
+		}
+	}
+	return changes
+}
+
+func diffProtobufFields(oldMessage, newMessage protoreflect.MessageDescriptor) []schemaFieldChange {
+	var changes []schemaFieldChange
+	path := string(newMessage.FullName())
+	newFields := newMessage.Fields()
+	oldFields := oldMessage.Fields()
+	for i := 0; i < newFields.Len(); i++ {
+		newField := newFields.Get(i)
+		fieldPath := joinFieldPath(path, string(newField.Name()))
+		oldField := oldFields.ByName(newField.Name())
+		if oldField == nil {
+			// Same number under a different name is a rename, which is compatible on the wire
+			if oldField = oldFields.ByNumber(newField.Number()); oldField != nil && newFields.ByName(oldField.Name()) == nil {
+				changes = append(changes, schemaFieldChange{Change: fieldRenamed, Field: fieldPath, Old: string(oldField.Name()), New: string(newField.Name())})
+			} else {
+				changes = append(changes, schemaFieldChange{Change: fieldAdded, Field: fieldPath, New: fmt.Sprintf("%s = %d", protobufFieldType(newField), newField.Number())})
+				continue
+			}
+		}
+		if oldField.Number() != newField.Number() {
+			changes = append(changes, schemaFieldChange{Change: fieldNumberChanged, Field: fieldPath, Old: fmt.Sprint(oldField.Number()), New: fmt.Sprint(newField.Number())})
+		}
+		if oldType, newType := protobufFieldType(oldField), protobufFieldType(newField); oldType != newType {
+			change := fieldTypeChanged
+			if protobufKindsCompatible(oldField.Kind(), newField.Kind()) {
+				change = fieldTypePromoted
+			}
+			changes = append(changes, schemaFieldChange{Change: change, Field: fieldPath, Old: oldType, New: newType})
+		}
+		if oldLabel, newLabel := protobufFieldLabel(oldField), protobufFieldLabel(newField); oldLabel != newLabel {
+			changes = append(changes, schemaFieldChange{Change: fieldLabelChanged, Field: fieldPath, Old: oldLabel, New: newLabel})
+		}
+		if oldDefault, newDefault := protobufDefaultString(oldField), protobufDefaultString(newField); oldDefault != newDefault {
+			changes = append(changes, schemaFieldChange{Change: fieldDefaultChanged, Field: fieldPath, Old: oldDefault, New: newDefault})
+		}
+	}
+	for i := 0; i < oldFields.Len(); i++ {
+		oldField := oldFields.Get(i)
+		if newFields.ByName(oldField.Name()) != nil {
+			continue
+		}
+		// Renamed fields were reported above
+		if renamed := newFields.ByNumber(oldField.Number()); renamed != nil && oldFields.ByName(renamed.Name()) == nil {
+			continue
+		}
+		changes = append(changes, schemaFieldChange{Change: fieldRemoved, Field: joinFieldPath(path, string(oldField.Name())), Old: fmt.Sprintf("%s = %d", protobufFieldType(oldField), oldField.Number())})
+	}
+	return changes
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSchemaFieldsAvro(t *testing.T) {
+	v1 := `{"type":"record","name":"Order","fields":[
+		{"name":"id","type":"string"},
+		{"name":"amount","type":"int"},
+		{"name":"note","type":"string","default":""},
+		{"name":"customer","type":{"type":"record","name":"Customer","fields":[{"name":"name","type":"string"}]}},
+		{"name":"legacy","type":"string"}
+	]}`
+	v2 := `{"type":"record","name":"Order","fields":[
+		{"name":"order_id","type":"string","aliases":["id"]},
+		{"name":"amount","type":"long"},
+		{"name":"note","type":["null","string"],"default":null},
+		{"name":"customer","type":{"type":"record","name":"Customer","fields":[{"name":"name","type":"string"},{"name":"email","type":["null","string"],"default":null}]}},
+		{"name":"status","type":"string","default":"NEW"}
+	]}`
+	changes, err := diffSchemaFields(srclient.Avro, v1, v2, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []schemaFieldChange{
+		{Change: fieldRenamed, Field: "order_id", Old: "id", New: "order_id"},
+		{Change: fieldTypePromoted, Field: "amount", Old: "int", New: "long"},
+		{Change: fieldTypePromoted, Field: "note", Old: "string", New: "[null,string]"},
+		{Change: fieldDefaultChanged, Field: "note", Old: `""`, New: "null"},
+		{Change: fieldAdded, Field: "customer.email", New: "[null,string]"},
+		{Change: fieldAdded, Field: "status", New: "string"},
+		{Change: fieldRemoved, Field: "legacy", Old: "string"},
+	}, changes)
+	require.Equal(t, "~ amount type promoted: int -> long", changes[1].String())
+
+	// Narrowing is not a promotion
+	changes, err = diffSchemaFields(srclient.Avro, `{"type":"record","name":"A","fields":[{"name":"n","type":"long"}]}`, `{"type":"record","name":"A","fields":[{"name":"n","type":"int"}]}`, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, fieldTypeChanged, changes[0].Change)
+
+	changes, err = diffSchemaFields(srclient.Avro, v1, v1, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	_, err = diffSchemaFields(srclient.Json, `{}`, `{}`, nil, nil)
+	require.Error(t, err)
+
+	// A record used by two fields is compared under both
+	address := func(fields string) string {
+		return `{"type":"record","name":"Order","fields":[
+			{"name":"billing","type":{"type":"record","name":"Address","fields":[` + fields + `]}},
+			{"name":"shipping","type":"Address"}
+		]}`
+	}
+	changes, err = diffSchemaFields(srclient.Avro, address(`{"name":"city","type":"string"}`), address(`{"name":"city","type":"string"},{"name":"zip","type":["null","string"],"default":null}`), nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []schemaFieldChange{
+		{Change: fieldAdded, Field: "billing.zip", New: "[null,string]"},
+		{Change: fieldAdded, Field: "shipping.zip", New: "[null,string]"},
+	}, changes)
+
+	// Recursive records end
+	linked := func(fields string) string {
+		return `{"type":"record","name":"Node","fields":[{"name":"next","type":["null","Node"],"default":null}` + fields + `]}`
+	}
+	changes, err = diffSchemaFields(srclient.Avro, linked(""), linked(`,{"name":"n","type":"int","default":0}`), nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []schemaFieldChange{{Change: fieldAdded, Field: "n", New: "int"}}, changes)
+}
+
+func TestDiffSchemaFieldsProtobuf(t *testing.T) {
+	v1 := `syntax = "proto3";
+package shop;
+message Order {
+  string id = 1;
+  int32 amount = 2;
+  string note = 3;
+  message Line { string sku = 1; }
+  repeated Line lines = 4;
+}
+message Legacy {}
+`
+	v2 := `syntax = "proto3";
+package shop;
+message Order {
+  string order_id = 1;
+  int64 amount = 2;
+  repeated string note = 5;
+  message Line { string sku = 1; int32 quantity = 2; }
+  repeated Line lines = 4;
+  double total = 6;
+}
+`
+	changes, err := diffSchemaFields(srclient.Protobuf, v1, v2, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []schemaFieldChange{
+		{Change: fieldRenamed, Field: "shop.Order.order_id", Old: "id", New: "order_id"},
+		{Change: fieldTypePromoted, Field: "shop.Order.amount", Old: "int32", New: "int64"},
+		{Change: fieldNumberChanged, Field: "shop.Order.note", Old: "3", New: "5"},
+		{Change: fieldLabelChanged, Field: "shop.Order.note", Old: "optional", New: "repeated"},
+		{Change: fieldAdded, Field: "shop.Order.total", New: "double = 6"},
+		{Change: fieldAdded, Field: "shop.Order.Line.quantity", New: "int32 = 2"},
+		{Change: fieldRemoved, Field: "shop.Legacy", Old: "message"},
+	}, changes)
+}
+
+func TestSchemaRegistryCacheHistory(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	record := func(offset int64, key, value string) {
+		cache.offset = offset
+		processTestRecords(t, cache, [2]string{key, value})
+	}
+	record(0, `{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`, `{"subject":"orders-value","version":1,"id":10,"schema":"{\"type\":\"record\",\"name\":\"Order\",\"fields\":[{\"name\":\"id\",\"type\":\"string\"}]}"}`)
+	record(1, `{"keytype":"CONFIG","subject":"orders-value","magic":0}`, `{"compatibilityLevel":"FULL"}`)
+	record(2, `{"keytype":"SCHEMA","subject":"orders-value","version":2,"magic":1}`, `{"subject":"orders-value","version":2,"id":11,"schema":"{\"type\":\"record\",\"name\":\"Order\",\"fields\":[{\"name\":\"id\",\"type\":\"string\"},{\"name\":\"n\",\"type\":\"int\",\"default\":0}]}"}`)
+	// Soft deleting version 1 doesn't change when it was registered
+	record(3, `{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`, `{"subject":"orders-value","version":1,"id":10,"schema":"{\"type\":\"record\",\"name\":\"Order\",\"fields\":[{\"name\":\"id\",\"type\":\"string\"}]}","deleted":true}`)
+
+	entries := cache.GetSchemaHistory("orders-value")
+	require.Len(t, entries, 2)
+	require.Equal(t, schemaHistoryEntry{Version: 1, ID: 10, SchemaType: srclient.Avro, Order: 1, Offset: 0, Compatibility: "BACKWARD", Deleted: true}, entries[0])
+	require.Equal(t, schemaHistoryEntry{Version: 2, ID: 11, SchemaType: srclient.Avro, Order: 2, Offset: 2, Compatibility: "FULL"}, entries[1])
+
+	changes, fallback, err := diffSubjectVersions(cache, "orders-value", 1, 2)
+	require.NoError(t, err)
+	require.Empty(t, fallback)
+	require.Equal(t, []schemaFieldChange{{Change: fieldAdded, Field: "n", New: "int"}}, changes)
+
+	_, _, err = diffSubjectVersions(cache, "orders-value", 1, 3)
+	require.Error(t, err)
+
+	// JSON schemas get a text diff
+	record(4, `{"keytype":"SCHEMA","subject":"events-value","version":1,"magic":1}`, `{"subject":"events-value","version":1,"id":12,"schemaType":"JSON","schema":"{\"type\":\"object\"}"}`)
+	record(5, `{"keytype":"SCHEMA","subject":"events-value","version":2,"magic":1}`, `{"subject":"events-value","version":2,"id":13,"schemaType":"JSON","schema":"{\"type\":\"string\"}"}`)
+	changes, fallback, err = diffSubjectVersions(cache, "events-value", 1, 2)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+	require.True(t, strings.Contains(fallback, "string"))
+
+	// Protobuf versions are compiled with their references, from the cache
+	protoRecord := func(offset int64, subject string, version, id int, text string, references string) {
+		value, err := json.Marshal(map[string]interface{}{"subject": subject, "version": version, "id": id, "schemaType": "PROTOBUF", "schema": text, "references": json.RawMessage(references)})
+		require.NoError(t, err)
+		record(offset, fmt.Sprintf(`{"keytype":"SCHEMA","subject":"%s","version":%d,"magic":1}`, subject, version), string(value))
+	}
+	references := `[{"name":"common/money.proto","subject":"common-money","version":1}]`
+	protoRecord(6, "common-money", 1, 20, testCommonProto, "[]")
+	protoRecord(7, "orders-proto-value", 1, 21, testOrderProto, references)
+	protoRecord(8, "orders-proto-value", 2, 22, strings.Replace(testOrderProto, "string id = 1;\n", "string id = 1;\n  common.Money total = 3;\n", 1), references)
+	changes, _, err = diffSubjectVersions(cache, "orders-proto-value", 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []schemaFieldChange{{Change: fieldAdded, Field: "orders.Order.total", New: "common.Money = 3"}}, changes)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+A schema embedded in the input YAML with schema_inline. It can be text (any schema type), or YAML/JSON
+structure for AVRO and JSON schemas, which is converted to JSON keeping the order of the keys.
+*/
+type inlineSchema struct {
+	Data       string // Schema text
+	Structured bool   // Declared as a YAML mapping or sequence, not as text
+}
+
+func (i *inlineSchema) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		i.Data = text
+		return nil
+	}
+	// Decoding into a MapSlice keeps the order of keys, at every level
+	var structured interface{}
+	var mapping yaml.MapSlice
+	if err := unmarshal(&mapping); err == nil {
+		structured = mapping
+	} else {
+		// Like a top level AVRO union. Mappings in a sequence are decoded unordered
+		var sequence []interface{}
+		if err := unmarshal(&sequence); err != nil {
+			return fmt.Errorf("schema_inline must be text, a mapping or a list: %w", err)
+		}
+		structured = sequence
+	}
+	var buf bytes.Buffer
+	if err := writeYAMLAsJSON(&buf, structured); err != nil {
+		return err
+	}
+	i.Data = buf.String()
+	i.Structured = true
+	return nil
+}
+
+// Write a decoded YAML value as JSON. yaml.MapSlice keys are written in order
+func writeYAMLAsJSON(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		buf.WriteByte('{')
+		for n, item := range v {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeYAMLAsJSON(buf, item.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for n, item := range v {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeYAMLAsJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[interface{}]interface{}:
+		// Mappings inside a top level sequence. encoding/json sorts the keys
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var itemBuf bytes.Buffer
+			if err := writeYAMLAsJSON(&itemBuf, item); err != nil {
+				return err
+			}
+			converted[fmt.Sprint(key)] = json.RawMessage(itemBuf.Bytes())
+		}
+		data, err := json.Marshal(converted)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInlineSchema(t *testing.T) {
+	data := `
+topics:
+  - name: orders
+    partitions: 1
+    replication_factor: 1
+    key:
+      schema_inline: {"type": "string"}
+    value:
+      schema_inline:
+        type: record
+        name: Order
+        fields:
+          - {name: id, type: string}
+          - {name: total, type: ["null", double], default: null}
+  - name: payments
+    partitions: 1
+    replication_factor: 1
+    key:
+      schema_inline: '"long"'
+    value:
+      schema_type: PROTOBUF
+      schema_inline: |
+        syntax = "proto3";
+        message Payment { string id = 1; }
+`
+	var input InputYaml
+	require.NoError(t, yaml.Unmarshal([]byte(data), &input))
+	orders := input.Topics[0]
+	require.Equal(t, `{"type":"string"}`, orders.Key.SchemaData)
+	require.Equal(t, srclient.Avro, orders.Key.SchemaType)
+	// Order of keys is kept
+	require.Equal(t, `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":["null","double"],"default":null}]}`, orders.Value.SchemaData)
+	payments := input.Topics[1]
+	require.Equal(t, `"long"`, payments.Key.SchemaData)
+	require.Equal(t, "syntax = \"proto3\";\nmessage Payment { string id = 1; }\n", payments.Value.SchemaData)
+	require.Equal(t, srclient.Protobuf, payments.Value.SchemaType)
+
+	var schema Schema
+	require.Error(t, yaml.Unmarshal([]byte("schema: schemas/schema.json\nschema_inline: '\"string\"'\n"), &schema))
+	require.Error(t, yaml.Unmarshal([]byte("schema_type: PROTOBUF\nschema_inline: {syntax: proto3}\n"), &schema))
+	require.NoError(t, yaml.Unmarshal([]byte("schema_inline: [\"null\", \"string\"]\n"), &schema))
+	require.Equal(t, `["null","string"]`, schema.SchemaData)
+}
+
+func TestSchemaPathRelativeToInput(t *testing.T) {
+	dir := t.TempDir()
+	teamDir := filepath.Join(dir, "team-a")
+	require.NoError(t, os.MkdirAll(filepath.Join(teamDir, "schemas"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "shared"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(teamDir, "schemas", "order.avsc"), []byte(`"string"`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shared", "money.avsc"), []byte(`"double"`), 0644))
+	input := `
+subjects:
+  - subject: orders-value
+    schema: schemas/order.avsc
+  - subject: money-value
+    schema: shared/money.avsc
+`
+	inputFile := filepath.Join(teamDir, "input.yaml")
+	require.NoError(t, os.WriteFile(inputFile, []byte(input), 0644))
+	// Files that are not next to the input are still found through schema_dir
+	gafkaloConfig.Kafkalo.SchemaDir = dir
+	defer func() { gafkaloConfig.Kafkalo.SchemaDir = "" }()
+
+	state := Parse([]string{inputFile})
+	require.Equal(t, `"string"`, state.Subjects["orders-value"].SchemaData)
+	require.Equal(t, "schemas/order.avsc", state.Subjects["orders-value"].SchemaPath)
+	require.Equal(t, `"double"`, state.Subjects["money-value"].SchemaData)
+
+	require.Equal(t, "/abs/order.avsc", resolveSchemaPath(teamDir, "/abs/order.avsc"))
+}
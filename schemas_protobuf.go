@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/bufbuild/protocompile/reporter"
+)
+
+// Name given to the root file when compiling a Protobuf schema from a string
+const protobufRootFile = "schema.proto"
+
+/*
+Compile the text of a Protobuf schema into a linked file descriptor.
+imports maps import paths (the reference names used in the schema registry) to their schema text.
+Well known types (google/protobuf/*.proto) are always available.
+*/
+func compileProtobufSchema(text string, imports map[string]string) (linker.File, error) {
+	sources := map[string]string{protobufRootFile: text}
+	for name, importText := range imports {
+		sources[name] = importText
+	}
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(sources),
+		}),
+		Reporter: reporter.NewReporter(nil, nil),
+	}
+	files, err := compiler.Compile(context.Background(), protobufRootFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile protobuf schema: %w", err)
+	}
+	return files[0], nil
+}
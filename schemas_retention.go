@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Pruning of old subject versions and of subjects whose topic is no longer declared.
+
+Versions are soft deleted, and permanently deleted too when hard_delete is set.
+Versions referenced by other schemas are always kept, as are all versions of subjects with a
+*_TRANSITIVE compatibility: new versions are checked against every previous one and removing
+versions would silently weaken that check for data still written with them.
+Versions whose schema ID is still in a record of the subject's topics are kept too. The topics are read from
+the oldest record to the newest before anything is deleted. They are the declared topics using the subject and
+the topic the subject is named after. Subjects without such a topic, like shared types, are only soft deleted,
+as their records can't be checked.
+*/
+
+// Orphan subject policies
+const (
+	OrphanKeep       = "keep"
+	OrphanSoftDelete = "soft_delete"
+	OrphanHardDelete = "hard_delete"
+)
+
+// What to do with subjects of topics that are no longer declared
+type OrphanSubjectsPolicy struct {
+	Policy   string   `yaml:"policy"`   // keep (default), soft_delete or hard_delete
+	Prefixes []string `yaml:"prefixes"` // Only subjects starting with one of these are considered. Required unless the policy is keep
+}
+
+func isValidOrphanPolicy(policy string) bool {
+	switch policy {
+	case "", OrphanKeep, OrphanSoftDelete, OrphanHardDelete:
+		return true
+	}
+	return false
+}
+
+// Versions of a subject that are not soft deleted, sorted
+func (admin *SRAdmin) GetSubjectVersions(subject string) ([]int, error) {
+	if admin.UseSRCache {
+		var versions []int
+		for _, version := range admin.SRCache.GetSchemaVersions(subject) {
+			versions = append(versions, version.Version)
+		}
+		return versions, nil
+	}
+	return admin.fetchSubjectVersions(subject)
+}
+
+// Versions of a subject from the REST API, skipping the cache
+func (admin *SRAdmin) fetchSubjectVersions(subject string) ([]int, error) {
+	versions, err := admin.Client.GetSchemaVersions(subject)
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// Is the subject version used as a reference by any other schema
+func (admin *SRAdmin) IsVersionReferenced(subject string, version int) (bool, error) {
+	if admin.UseSRCache {
+		return admin.SRCache.IsVersionReferenced(subject, version), nil
+	}
+	respBody, err := admin.makeRestCall("GET", fmt.Sprintf("%s/subjects/%s/versions/%d/referencedby", admin.url, srSubjectPath(subject), version), bytes.NewBuffer(nil))
+	if err != nil {
+		return false, err
+	}
+	if err := restResponseError(respBody); err != nil {
+		return false, err
+	}
+	var ids []int
+	if err := json.Unmarshal(respBody, &ids); err != nil {
+		return false, err
+	}
+	return len(ids) > 0, nil
+}
+
+// Is the subject version used as a reference by any schema in the cache
+func (c *SchemaRegistryCache) IsVersionReferenced(subject string, version int) bool {
+	for name := range c.subjects {
+		for _, id := range c.subjects[name] {
+			for _, ref := range c.schemaForSubject(name, id).References {
+				if ref.Subject == subject && ref.Version == version {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// The schema ID of a subject version
+func (admin *SRAdmin) versionSchemaID(subject string, version int) (int, error) {
+	if admin.UseSRCache {
+		for _, cached := range admin.SRCache.GetSchemaVersions(subject) {
+			if cached.Version == version {
+				return cached.ID, nil
+			}
+		}
+	}
+	schema, err := admin.Client.GetSchemaByVersion(subject, version)
+	if err != nil {
+		return 0, err
+	}
+	return schema.ID(), nil
+}
+
+// Topics whose records may carry the subject's schema IDs: declared topics using it and the topic it is named after
+func subjectTopics(subject string, topics map[string]Topic) []string {
+	found := make(map[string]bool)
+	for _, topic := range topics {
+		for _, schema := range topic.Schemas() {
+			if schema.SubjectName == subject {
+				found[topic.Name] = true
+			}
+		}
+	}
+	_, name := splitQualifiedSubject(subject)
+	if topic := topicForSubject(name); topic != "" {
+		found[topic] = true
+	}
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Schema IDs in the keys and values of the records of the topics. Each topic is only read once
+func (admin *SRAdmin) schemaIDsInTopics(topics []string) (map[int]bool, error) {
+	if admin.scanTopicSchemaIDs == nil {
+		return nil, errors.New("topics can't be read without a Kafka connection")
+	}
+	if admin.topicSchemaIDs == nil {
+		admin.topicSchemaIDs = make(map[string]map[int]bool)
+	}
+	ids := make(map[int]bool)
+	for _, topic := range topics {
+		topicIDs, scanned := admin.topicSchemaIDs[topic]
+		if !scanned {
+			var err error
+			topicIDs, err = admin.scanTopicSchemaIDs(topic)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the schema IDs of %s: %w", topic, err)
+			}
+			admin.topicSchemaIDs[topic] = topicIDs
+		}
+		for id := range topicIDs {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+/*
+Read the schema IDs of all records of a topic, with the scan of topic stats. A topic that doesn't exist has none.
+Used by SRAdmin to keep the versions records were written with
+*/
+func scanTopicSchemaIDs(config *Configuration, topic string) (map[int]bool, error) {
+	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, []string{topic}, "", true, nil, false, false, false, true, "", nil)
+	existing, err := consumer.Client.Topics()
+	if err != nil {
+		consumer.Client.Close()
+		return nil, err
+	}
+	ids := make(map[int]bool)
+	if !slices.Contains(existing, topic) {
+		consumer.Client.Close()
+		return ids, nil
+	}
+	if err := consumer.SetExitAtEnd(); err != nil {
+		consumer.Client.Close()
+		return nil, err
+	}
+	stats := NewTopicStats(topic)
+	consumer.SetSink(stats)
+	if err := consumer.Consume(0); err != nil {
+		return nil, err
+	}
+	stats.Finish()
+	for _, usage := range stats.Schemas {
+		ids[usage.ID] = true
+	}
+	log.Debugf("Records of %s use %d schema IDs", topic, len(ids))
+	return ids, nil
+}
+
+// Delete a subject version. A permanent delete needs the version to be soft deleted first
+func (admin *SRAdmin) DeleteSubjectVersion(subject string, version int, permanent bool) error {
+	uri := fmt.Sprintf("%s/subjects/%s/versions/%d", admin.url, srSubjectPath(subject), version)
+	if permanent {
+		uri += "?permanent=true"
+	}
+	respBody, err := admin.makeRestCall("DELETE", uri, bytes.NewBuffer(nil))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// Delete a whole subject. A permanent delete needs the subject to be soft deleted first
+func (admin *SRAdmin) DeleteSubject(subject string, permanent bool) error {
+	uri := fmt.Sprintf("%s/subjects/%s", admin.url, srSubjectPath(subject))
+	if permanent {
+		uri += "?permanent=true"
+	}
+	respBody, err := admin.makeRestCall("DELETE", uri, bytes.NewBuffer(nil))
+	if err != nil {
+		return err
+	}
+	return restResponseError(respBody)
+}
+
+// The compatibility level a subject is checked with
+func (admin *SRAdmin) effectiveCompatibility(schema Schema) string {
+	compatibility, err := admin.GetCompatibility(schema)
+	if err != nil || compatibility == "" {
+		compatibility, _ = admin.GetCompatibilityGlobal()
+	}
+	return strings.ToUpper(compatibility)
+}
+
+/*
+Prune versions of the subject in result beyond its retention. The subject's retain_versions wins over the global one.
+pendingVersion is set when a version will be registered but isn't yet (a plan), so one less existing version is kept.
+*/
+func (admin *SRAdmin) applyRetention(result *SchemaResult, schema Schema, topics map[string]Topic, settings SchemaRegistrySettings, pendingVersion bool, dryRun bool) {
+	retain := settings.RetainVersions
+	if schema.RetainVersions > 0 {
+		retain = schema.RetainVersions
+	}
+	if retain <= 0 {
+		return
+	}
+	getVersions := admin.GetSubjectVersions
+	if !dryRun && result.Changed {
+		// The cache was read before the changes and misses a version registered just now
+		getVersions = admin.fetchSubjectVersions
+	}
+	versions, err := getVersions(schema.SubjectName)
+	if err != nil {
+		log.Warnf("Failed to get versions of %s for pruning: %s", schema.SubjectName, err)
+		return
+	}
+	keep := retain
+	if pendingVersion {
+		keep--
+	}
+	if len(versions) <= keep {
+		return
+	}
+	if compatibility := admin.effectiveCompatibility(schema); strings.HasSuffix(compatibility, "_TRANSITIVE") {
+		result.RetentionSkipped = fmt.Sprintf("compatibility is %s, all versions are kept", compatibility)
+		return
+	}
+	boundTopics := subjectTopics(schema.SubjectName, topics)
+	inUse, err := admin.schemaIDsInTopics(boundTopics)
+	if err != nil {
+		result.RetentionSkipped = fmt.Sprintf("the schema IDs in its topics are unknown (%s), all versions are kept", err)
+		return
+	}
+	// Without a topic nothing tells which versions records still use. Soft deletes can be undone
+	permanent := settings.HardDelete && len(boundTopics) > 0
+	for _, version := range versions[:len(versions)-keep] {
+		referenced, err := admin.IsVersionReferenced(schema.SubjectName, version)
+		if err != nil {
+			log.Warnf("Failed to check references to %s version %d. Not deleting it: %s", schema.SubjectName, version, err)
+			continue
+		}
+		if referenced {
+			log.Debugf("Keeping %s version %d as other schemas reference it", schema.SubjectName, version)
+			continue
+		}
+		id, err := admin.versionSchemaID(schema.SubjectName, version)
+		if err != nil {
+			log.Warnf("Failed to get the schema ID of %s version %d. Not deleting it: %s", schema.SubjectName, version, err)
+			continue
+		}
+		if inUse[id] {
+			log.Debugf("Keeping %s version %d as records in %v use its schema ID %d", schema.SubjectName, version, boundTopics, id)
+			continue
+		}
+		if !dryRun {
+			if err := admin.DeleteSubjectVersion(schema.SubjectName, version, false); err != nil {
+				log.Fatalf("Failed to delete %s version %d: %s", schema.SubjectName, version, err)
+			}
+			if permanent {
+				if err := admin.DeleteSubjectVersion(schema.SubjectName, version, true); err != nil {
+					log.Fatalf("Failed to permanently delete %s version %d: %s", schema.SubjectName, version, err)
+				}
+			}
+		}
+		result.DeletedVersions = append(result.DeletedVersions, version)
+	}
+	result.PermanentDelete = permanent && len(result.DeletedVersions) > 0
+}
+
+// Find the topic a subject belongs to with the TopicNameStrategy. Empty if the subject isn't named after a topic
+func topicForSubject(subject string) string {
+	for _, suffix := range []string{"-key", "-value"} {
+		if strings.HasSuffix(subject, suffix) {
+			return strings.TrimSuffix(subject, suffix)
+		}
+	}
+	return ""
+}
+
+/*
+Delete subjects named after a topic (<topic>-key, <topic>-value) when neither the topic nor the subject is declared.
+Only subjects matching the policy prefixes are considered, as a registry is usually shared with subjects gafkalo doesn't manage.
+Subjects whose schema IDs are still in records of the topic, if it exists, are kept.
+*/
+func (admin *SRAdmin) reconcileOrphans(policy OrphanSubjectsPolicy, topics map[string]Topic, desiredSubjects []string, dryRun bool) []SchemaResult {
+	if policy.Policy == "" || policy.Policy == OrphanKeep {
+		return nil
+	}
+	declaredSubjects := make(map[string]bool)
+	for _, subject := range desiredSubjects {
+		declaredSubjects[subject] = true
+	}
+	declaredTopics := make(map[string]bool)
+	for _, topic := range topics {
+		declaredTopics[qualifySubject(topic.SchemaContext, topic.Name)] = true
+	}
+	var orphans []string
+	for _, subject := range admin.SubjectCache {
+		topic := topicForSubject(subject)
+		if topic == "" || declaredSubjects[subject] || declaredTopics[topic] {
+			continue
+		}
+		for _, prefix := range policy.Prefixes {
+			if strings.HasPrefix(subject, prefix) {
+				orphans = append(orphans, subject)
+				break
+			}
+		}
+	}
+	sort.Strings(orphans)
+	var results []SchemaResult
+	permanent := policy.Policy == OrphanHardDelete
+	for _, subject := range orphans {
+		if admin.isSubjectReferenced(subject) {
+			log.Warnf("Subject %s is orphaned but other schemas reference it. Not deleting it", subject)
+			continue
+		}
+		if admin.isSubjectInRecords(subject) {
+			log.Warnf("Subject %s is orphaned but records of its topic use it. Not deleting it", subject)
+			continue
+		}
+		if !dryRun {
+			if err := admin.DeleteSubject(subject, false); err != nil {
+				log.Fatalf("Failed to delete subject %s: %s", subject, err)
+			}
+			if permanent {
+				if err := admin.DeleteSubject(subject, true); err != nil {
+					log.Fatalf("Failed to permanently delete subject %s: %s", subject, err)
+				}
+			}
+		}
+		results = append(results, SchemaResult{SubjectName: subject, DeletedSubject: true, PermanentDelete: permanent, Changed: !dryRun})
+	}
+	return results
+}
+
+// Is any version of the subject referenced by other schemas. Errors count as referenced, so nothing is deleted by mistake
+func (admin *SRAdmin) isSubjectReferenced(subject string) bool {
+	versions, err := admin.GetSubjectVersions(subject)
+	if err != nil {
+		log.Warnf("Failed to get versions of %s: %s", subject, err)
+		return true
+	}
+	for _, version := range versions {
+		referenced, err := admin.IsVersionReferenced(subject, version)
+		if err != nil || referenced {
+			return true
+		}
+	}
+	return false
+}
+
+// Are schema IDs of the subject in records of the topic it is named after. Errors count as in use
+func (admin *SRAdmin) isSubjectInRecords(subject string) bool {
+	_, name := splitQualifiedSubject(subject)
+	inUse, err := admin.schemaIDsInTopics([]string{topicForSubject(name)})
+	if err != nil {
+		log.Warnf("Failed to check the records of %s: %s", subject, err)
+		return true
+	}
+	versions, err := admin.GetSubjectVersions(subject)
+	if err != nil {
+		log.Warnf("Failed to get versions of %s: %s", subject, err)
+		return true
+	}
+	for _, version := range versions {
+		id, err := admin.versionSchemaID(subject, version)
+		if err != nil || inUse[id] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+// A registry where orders-value has versions 1-5, version 2 is referenced and team.ref-value is referenced.
+// team.Shared has versions 1-2 and each version N has schema ID 10*N
+func newRetentionTestServer(compatibility string, deletes *[]string) *httptest.Server {
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		path := r.URL.Path
+		switch {
+		case r.Method == "DELETE":
+			*deletes = append(*deletes, r.URL.RequestURI())
+			w.Write([]byte(`1`))
+		case strings.HasSuffix(path, "/referencedby"):
+			if path == "/subjects/orders-value/versions/2/referencedby" || strings.HasPrefix(path, "/subjects/team.ref-value/") {
+				w.Write([]byte(`[42]`))
+			} else {
+				w.Write([]byte(`[]`))
+			}
+		case path == "/subjects/orders-value/versions":
+			w.Write([]byte(`[1,2,3,4,5]`))
+		case path == "/subjects/team.Shared/versions":
+			w.Write([]byte(`[1,2]`))
+		case strings.Contains(path, "/versions/"):
+			version, _ := strconv.Atoi(path[strings.LastIndex(path, "/")+1:])
+			fmt.Fprintf(w, `{"version":%d,"id":%d,"schema":"\"string\""}`, version, 10*version)
+		case strings.HasSuffix(path, "/versions"):
+			w.Write([]byte(`[1]`))
+		case path == "/config/orders-value":
+			w.Write([]byte(`{"compatibilityLevel":"` + compatibility + `"}`))
+		default:
+			w.Write([]byte(`{"error_code":40401,"message":"not found"}`))
+		}
+	}))
+}
+
+// Records of each topic use the schema IDs in inUse
+func topicScanner(inUse map[string][]int) func(topic string) (map[int]bool, error) {
+	return func(topic string) (map[int]bool, error) {
+		ids := make(map[int]bool)
+		for _, id := range inUse[topic] {
+			ids[id] = true
+		}
+		return ids, nil
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	var deletes []string
+	server := newRetentionTestServer("BACKWARD", &deletes)
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL, scanTopicSchemaIDs: topicScanner(nil)}
+	schema := Schema{SubjectName: "orders-value"}
+
+	// A plan with a pending version keeps one less existing version. Version 2 is referenced
+	result := SchemaResult{SubjectName: "orders-value"}
+	admin.applyRetention(&result, schema, nil, SchemaRegistrySettings{RetainVersions: 2}, true, true)
+	require.Equal(t, []int{1, 3, 4}, result.DeletedVersions)
+	require.False(t, result.PermanentDelete)
+	require.Empty(t, deletes)
+
+	// The subject setting wins and hard deletes follow the soft deletes
+	result = SchemaResult{SubjectName: "orders-value"}
+	schema.RetainVersions = 3
+	admin.applyRetention(&result, schema, nil, SchemaRegistrySettings{RetainVersions: 1, HardDelete: true}, false, false)
+	require.Equal(t, []int{1}, result.DeletedVersions)
+	require.True(t, result.PermanentDelete)
+	require.Equal(t, []string{"/subjects/orders-value/versions/1", "/subjects/orders-value/versions/1?permanent=true"}, deletes)
+
+	// Nothing to prune
+	result = SchemaResult{SubjectName: "orders-value"}
+	admin.applyRetention(&result, Schema{SubjectName: "orders-value"}, nil, SchemaRegistrySettings{RetainVersions: 10}, false, true)
+	require.Empty(t, result.DeletedVersions)
+}
+
+func TestApplyRetentionAfterRegistering(t *testing.T) {
+	var deletes []string
+	server := newRetentionTestServer("BACKWARD", &deletes)
+	defer server.Close()
+	// The cache was read before version 5 was registered
+	cache := &SchemaRegistryCache{
+		schemas:          map[string]map[int]srCachedSchema{".": {1: {Schema: `"string"`}, 2: {Schema: `"long"`}, 3: {Schema: `"int"`}, 4: {Schema: `"bytes"`}}},
+		subjects:         map[string]map[int]int{"orders-value": {1: 1, 2: 2, 3: 3, 4: 4}},
+		compatPerSubject: map[string]string{"orders-value": "BACKWARD"},
+	}
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL, SRCache: cache, UseSRCache: true, scanTopicSchemaIDs: topicScanner(nil)}
+
+	result := SchemaResult{SubjectName: "orders-value", Changed: true}
+	admin.applyRetention(&result, Schema{SubjectName: "orders-value"}, nil, SchemaRegistrySettings{RetainVersions: 2}, false, false)
+	require.Equal(t, []int{1, 2, 3}, result.DeletedVersions)
+	require.Equal(t, []string{"/subjects/orders-value/versions/1", "/subjects/orders-value/versions/2", "/subjects/orders-value/versions/3"}, deletes)
+}
+
+func TestApplyRetentionKeepsTransitiveChains(t *testing.T) {
+	var deletes []string
+	server := newRetentionTestServer("FULL_TRANSITIVE", &deletes)
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+
+	result := SchemaResult{SubjectName: "orders-value"}
+	admin.applyRetention(&result, Schema{SubjectName: "orders-value"}, nil, SchemaRegistrySettings{RetainVersions: 1}, false, false)
+	require.Empty(t, result.DeletedVersions)
+	require.Contains(t, result.RetentionSkipped, "FULL_TRANSITIVE")
+	require.Empty(t, deletes)
+}
+
+func TestApplyRetentionKeepsVersionsInRecords(t *testing.T) {
+	var deletes []string
+	server := newRetentionTestServer("BACKWARD", &deletes)
+	defer server.Close()
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL}
+
+	// Nothing is deleted when the topics can't be read
+	result := SchemaResult{SubjectName: "orders-value"}
+	admin.applyRetention(&result, Schema{SubjectName: "orders-value"}, nil, SchemaRegistrySettings{RetainVersions: 1}, false, false)
+	require.Empty(t, result.DeletedVersions)
+	require.Contains(t, result.RetentionSkipped, "Kafka")
+	require.Empty(t, deletes)
+
+	// Records of the declared topic use version 3 (ID 30), and records of the topic named after the subject version 1
+	var scanned []string
+	scan := topicScanner(map[string][]int{"orders": {10}, "orders.v2": {30}})
+	admin.scanTopicSchemaIDs = func(topic string) (map[int]bool, error) {
+		scanned = append(scanned, topic)
+		return scan(topic)
+	}
+	topics := map[string]Topic{"orders.v2": {Name: "orders.v2", Value: Schema{SubjectName: "orders-value", SchemaData: `"string"`}}}
+	result = SchemaResult{SubjectName: "orders-value"}
+	admin.applyRetention(&result, Schema{SubjectName: "orders-value"}, topics, SchemaRegistrySettings{RetainVersions: 1}, false, true)
+	require.Equal(t, []int{4}, result.DeletedVersions)
+	require.Equal(t, []string{"orders", "orders.v2"}, scanned)
+
+	// Topics are only read once
+	admin.applyRetention(&SchemaResult{}, Schema{SubjectName: "orders-value"}, topics, SchemaRegistrySettings{RetainVersions: 1}, false, true)
+	require.Len(t, scanned, 2)
+
+	// A subject without a topic can't be checked, so it is not permanently deleted
+	result = SchemaResult{SubjectName: "team.Shared"}
+	admin.applyRetention(&result, Schema{SubjectName: "team.Shared"}, topics, SchemaRegistrySettings{RetainVersions: 1, HardDelete: true}, false, false)
+	require.Equal(t, []int{1}, result.DeletedVersions)
+	require.False(t, result.PermanentDelete)
+	require.Equal(t, []string{"/subjects/team.Shared/versions/1"}, deletes)
+}
+
+func TestReconcileOrphans(t *testing.T) {
+	var deletes []string
+	server := newRetentionTestServer("BACKWARD", &deletes)
+	defer server.Close()
+	// Records of team.gone still use version 1 of team.gone-value
+	admin := SRAdmin{Client: *srclient.CreateSchemaRegistryClient(server.URL), url: server.URL, scanTopicSchemaIDs: topicScanner(map[string][]int{"team.gone": {10}})}
+	admin.SubjectCache = []string{"team.old-value", "team.orders-value", "team.orders-key", "team.ref-value", "team.Shared", "other.gone-value", "team.declared-value", "team.gone-value"}
+	topics := map[string]Topic{"team.orders": {Name: "team.orders"}}
+	policy := OrphanSubjectsPolicy{Policy: OrphanHardDelete, Prefixes: []string{"team."}}
+
+	results := admin.reconcileOrphans(policy, topics, []string{"team.declared-value"}, true)
+	require.Len(t, results, 1)
+	require.Equal(t, "team.old-value", results[0].SubjectName)
+	require.True(t, results[0].DeletedSubject)
+	require.Equal(t, "permanently deleted", results[0].DeleteKind())
+	require.Empty(t, deletes)
+
+	admin.reconcileOrphans(policy, topics, []string{"team.declared-value"}, false)
+	require.Equal(t, []string{"/subjects/team.old-value", "/subjects/team.old-value?permanent=true"}, deletes)
+
+	require.Empty(t, admin.reconcileOrphans(OrphanSubjectsPolicy{Policy: OrphanKeep}, topics, nil, false))
+}
+
+func TestSchemaRegistryCacheIsVersionReferenced(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"SCHEMA","subject":"common.Money","version":1,"magic":1}`, `{"subject":"common.Money","version":1,"id":1,"schema":"{}"}`},
+		[2]string{`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`, `{"subject":"orders-value","version":1,"id":2,"schema":"{}","references":[{"name":"Money","subject":"common.Money","version":1}]}`},
+	)
+	require.True(t, cache.IsVersionReferenced("common.Money", 1))
+	require.False(t, cache.IsVersionReferenced("common.Money", 2))
+	require.False(t, cache.IsVersionReferenced("orders-value", 1))
+}
@@ -24,6 +24,74 @@ func TestGetSubjectForTopic(t *testing.T) {
 	}
 }
 
+func TestSchemaRecordName(t *testing.T) {
+	avro := Schema{SchemaType: srclient.Avro, SchemaData: `{"type": "record", "name": "Login", "namespace": "com.example", "fields": []}`}
+	name, err := avro.RecordName()
+	require.NoError(t, err)
+	require.Equal(t, "com.example.Login", name)
+
+	fullName := Schema{SchemaType: srclient.Avro, SchemaData: `{"type": "record", "name": "other.Login", "namespace": "com.example", "fields": []}`}
+	name, err = fullName.RecordName()
+	require.NoError(t, err)
+	require.Equal(t, "other.Login", name)
+
+	notRecord := Schema{SchemaType: srclient.Avro, SchemaData: `{"type": "string"}`}
+	_, err = notRecord.RecordName()
+	require.Error(t, err)
+
+	proto := Schema{SchemaType: srclient.Protobuf, SchemaData: "syntax = \"proto3\";\n// message Commented {}\npackage com.example;\nmessage Login {\n  string id = 1;\n}\nmessage Other {}\n"}
+	name, err = proto.RecordName()
+	require.NoError(t, err)
+	require.Equal(t, "com.example.Login", name)
+
+	jsonSchema := Schema{SchemaType: srclient.Json, SchemaData: `{"title": "Login", "type": "object"}`}
+	name, err = jsonSchema.RecordName()
+	require.NoError(t, err)
+	require.Equal(t, "Login", name)
+}
+
+func TestGetSubjectForStrategy(t *testing.T) {
+	schema := Schema{SchemaType: srclient.Avro, SchemaData: `{"type": "record", "name": "Login", "namespace": "com.example", "fields": []}`}
+	tests := []struct {
+		strategy string
+		isKey    bool
+		expected string
+	}{
+		{TopicNameStrategy, false, "events-value"},
+		{TopicNameStrategy, true, "events-key"},
+		{RecordNameStrategy, false, "com.example.Login"},
+		{RecordNameStrategy, true, "com.example.Login"},
+		{TopicRecordNameStrategy, false, "events-com.example.Login"},
+	}
+	for _, test := range tests {
+		subject, err := getSubjectForStrategy(test.strategy, "events", &schema, test.isKey)
+		require.NoError(t, err)
+		require.Equal(t, test.expected, subject, "strategy %s", test.strategy)
+	}
+	_, err := getSubjectForStrategy("NoSuchStrategy", "events", &schema, false)
+	require.Error(t, err)
+}
+
+func TestOrderSubjectsByReferences(t *testing.T) {
+	subjects := map[string]Schema{
+		"a-order":   {SubjectName: "a-order", References: []srclient.Reference{{Name: "customer", Subject: "customer", Version: 1}, {Name: "external", Subject: "not-managed", Version: 3}}},
+		"customer":  {SubjectName: "customer", References: []srclient.Reference{{Name: "z-address", Subject: "z-address", Version: 1}}},
+		"z-address": {SubjectName: "z-address"},
+		"b-other":   {SubjectName: "b-other"},
+	}
+	var names []string
+	for _, schema := range orderSubjectsByReferences(subjects) {
+		names = append(names, schema.SubjectName)
+	}
+	require.Equal(t, []string{"z-address", "customer", "a-order", "b-other"}, names)
+}
+
+func TestSchemaIsDefined(t *testing.T) {
+	require.False(t, (&Schema{}).IsDefined())
+	require.True(t, (&Schema{Compatibility: "FULL"}).IsDefined())
+	require.True(t, (&Schema{References: []srclient.Reference{{Subject: "x"}}}).IsDefined())
+}
+
 func TestCreateSchemaFields(t *testing.T) {
 	schema, err := CreateSchema("test-subject", "", "BACKWARD", srclient.Avro)
 	if err != nil {
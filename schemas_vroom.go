@@ -3,8 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/kmetaxas/srclient"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,19 +23,51 @@ This *can* require a lot of RAM in the case of huge amount of subjects and big s
 */
 
 type SchemaRegistryCache struct {
-	// Schema IDs
-	schemas      map[int]string
-	subjects     map[string]map[int]int
-	globalCompat string
+	// Schema IDs per context. IDs are only unique within a context
+	schemas   map[string]map[int]srCachedSchema
+	canonical map[srSchemaKey]string // Canonical form of schemas, computed lazily on lookups
+	// Versions of each subject, mapped to schema IDs. Soft deleted versions are kept separately, as the REST API hides them by default
+	subjects        map[string]map[int]int
+	deletedSubjects map[string]map[int]int
+	globalCompat    string
 	// Compatibility level per subject. Since this can be set using a CONFIG and does not exist at Subject create time, we set a separate field here for fast lookups/updates
-	compatPerSubject   map[string]string
-	consumer           *Consumer
-	lastKnownOffsetEnd int64 // Last end of topic offset that we know of
+	compatPerSubject map[string]string
+	globalMode       string
+	modePerSubject   map[string]string
+	// When each subject version was registered, and the compatibility in effect then. Kept for soft deleted versions too
+	versionHistory map[string]map[int]srVersionHistory
+	client         sarama.Client
+	brokers        []string // Bootstrap brokers, which identify the snapshot file
+	offset         int64    // Next offset of the topic to read
+	clusterID      string   // Cluster and topic the cache was read from, so a recreated topic invalidates the snapshot
+	topicID        string
+	snapshotDir    string // Where to persist the cache between runs. Empty disables persistence
 }
 
-// keytype SCHEMA in _schemas topic Key
+// A schema as registered under an ID
+type srCachedSchema struct {
+	Schema     string               `json:"schema"`
+	SchemaType srclient.SchemaType  `json:"schemaType,omitempty"`
+	References []srclient.Reference `json:"references,omitempty"`
+	Metadata   *SchemaMetadata      `json:"metadata,omitempty"`
+	RuleSet    *SchemaRuleSet       `json:"ruleSet,omitempty"`
+}
+
+// Registration details of a subject version
+type srVersionHistory struct {
+	Offset        int64  `json:"offset"`        // Offset of the record that registered the version. Orders registrations
+	Compatibility string `json:"compatibility"` // Effective compatibility when it was registered
+}
+
+// Identifies a schema ID within its context
+type srSchemaKey struct {
+	Context string
+	ID      int
+}
+
+// Key of records in the _schemas topic
 type SRKey struct {
-	Keytype string `json:"keytype"` // SCHEMA , CONFIG, NOOP
+	Keytype string `json:"keytype"` // SCHEMA, CONFIG, MODE, DELETE_SUBJECT, CLEAR_SUBJECT, NOOP
 	Subject string `json:"subject"`
 	Version int    `json:"version"`
 	Magic   int    `json:"magic"`
@@ -41,54 +78,237 @@ type SRValueConfig struct {
 	CompatibilityLevel string `json:"compatibilityLevel"`
 }
 
-// Value for SCHEMA type
-type SRValueSchema struct {
+// Value for MODE type
+type SRValueMode struct {
+	Mode string `json:"mode"`
+}
+
+// Value for DELETE_SUBJECT type. All versions up to Version are soft deleted
+type SRValueDeleteSubject struct {
 	Subject string `json:"subject"`
 	Version int    `json:"version"`
-	Id      int    `json:"id"`
-	Schema  string `json:"schema"`
-	Deleted bool   `json:"deleted"`
+}
+
+// Value for SCHEMA type
+type SRValueSchema struct {
+	Subject    string               `json:"subject"`
+	Version    int                  `json:"version"`
+	Id         int                  `json:"id"`
+	Schema     string               `json:"schema"`
+	SchemaType srclient.SchemaType  `json:"schemaType"`
+	References []srclient.Reference `json:"references"`
+	Metadata   *SchemaMetadata      `json:"metadata"`
+	RuleSet    *SchemaRuleSet       `json:"ruleSet"`
+	Deleted    bool                 `json:"deleted"`
 }
 
 func NewSchemaRegistryCache(config *Configuration) (*SchemaRegistryCache, error) {
-	var err error
-	topics := []string{"_schemas"}
+	srCache := newEmptySchemaRegistryCache()
+	client, err := sarama.NewClient(config.Connections.Kafka.Brokers, SaramaConfigFromKafkaConfig(config.Connections.Kafka))
+	if err != nil {
+		return nil, err
+	}
+	srCache.client = client
+	srCache.brokers = config.Connections.Kafka.Brokers
+	srCache.snapshotDir = config.Connections.Schemaregistry.CacheDir
+	if srCache.snapshotDir == "" {
+		if userCacheDir, err := os.UserCacheDir(); err == nil {
+			srCache.snapshotDir = filepath.Join(userCacheDir, "gafkalo")
+		}
+	}
+	return srCache, nil
+}
+
+func newEmptySchemaRegistryCache() *SchemaRegistryCache {
 	var srCache SchemaRegistryCache
-	consumer := NewConsumer(config.Connections.Kafka, &config.Connections.Schemaregistry, topics, fmt.Sprintf("gafkalo-sr-%s", RandomString(4)), nil, false, false, false, true, "", &srCache)
-	srCache.consumer = consumer
-	srCache.schemas = make(map[int]string)
-	srCache.subjects = make(map[string]map[int]int)
-	srCache.compatPerSubject = make(map[string]string)
-	srCache.globalCompat = "BACKWARD" // default is BACKWARD.
-	return &srCache, err
+	srCache.reset()
+	return &srCache
+}
+
+// Forget everything read so far
+func (c *SchemaRegistryCache) reset() {
+	c.schemas = make(map[string]map[int]srCachedSchema)
+	c.canonical = make(map[srSchemaKey]string)
+	c.subjects = make(map[string]map[int]int)
+	c.deletedSubjects = make(map[string]map[int]int)
+	c.compatPerSubject = make(map[string]string)
+	c.globalCompat = "BACKWARD" // default is BACKWARD.
+	c.modePerSubject = make(map[string]string)
+	c.globalMode = "READWRITE"
+	c.versionHistory = make(map[string]map[int]srVersionHistory)
+	c.offset = 0
 }
 
+/*
+Bring the cache up to date with the topic.
+The cache is restored from the last snapshot (if any) and only records after the snapshot offset are read.
+The partition is assigned directly, so no consumer group is created.
+*/
 func (c *SchemaRegistryCache) ReadSchemaTopic(topic string) {
-	// Find current end offset and out it in lastKnownOffsetEnd so that
-	// ConsumeClaim will stop consuming at that point
-	last_offset, err := c.consumer.Client.GetOffset("_schemas", 0, sarama.OffsetNewest)
-	c.lastKnownOffsetEnd = last_offset - 1
+	snapshotPath := c.snapshotPath(topic)
+	if snapshotPath != "" {
+		if err := c.loadSnapshot(snapshotPath); err != nil {
+			log.Debugf("Not using snapshot %s: %s", snapshotPath, err)
+			c.reset()
+		}
+	}
+	clusterID, topicID := c.topicIdentity(topic)
+	oldest, err := c.client.GetOffset(topic, 0, sarama.OffsetOldest)
 	if err != nil {
-		log.Fatalf("Failed to fetch latest offset for _schemas with: %s", err)
+		log.Fatalf("Failed to fetch oldest offset for %s with: %s", topic, err)
 	}
-
-	err = c.consumer.Consume(-1)
+	newest, err := c.client.GetOffset(topic, 0, sarama.OffsetNewest)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Failed to fetch latest offset for %s with: %s", topic, err)
+	}
+	if c.offset > 0 && snapshotIsStale(c.clusterID, c.topicID, c.offset, clusterID, topicID, oldest, newest) {
+		log.Infof("Snapshot of %s does not match the topic (recreated?). Reading it from the beginning", topic)
+		c.reset()
+	}
+	c.clusterID, c.topicID = clusterID, topicID
+	if c.offset < oldest {
+		c.offset = oldest
+	}
+	log.Debugf("Reading %s from offset %d to %d", topic, c.offset, newest)
+	if c.offset < newest {
+		if err := c.consumePartition(topic, newest); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if snapshotPath != "" {
+		if err := c.saveSnapshot(snapshotPath); err != nil {
+			log.Warnf("Failed to save schema registry cache snapshot to %s: %s", snapshotPath, err)
+		}
 	}
 	log.Print("Reading _schemas topic complete")
 }
 
+// Read partition 0 of the topic from the current offset until the end offset
+func (c *SchemaRegistryCache) consumePartition(topic string, endOffset int64) error {
+	consumer, err := sarama.NewConsumerFromClient(c.client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	partitionConsumer, err := consumer.ConsumePartition(topic, 0, c.offset)
+	if err != nil {
+		return err
+	}
+	defer partitionConsumer.Close()
+	return c.readPartition(topic, partitionConsumer, endOffset)
+}
+
+/*
+Process the records of a partition consumer until the end offset. Compaction and transaction markers leave offsets
+that are never delivered, so a partition that got no message for endCheckInterval is at its end once the high
+watermark reached it, like the consumer's idleAtEnd
+*/
+func (c *SchemaRegistryCache) readPartition(topic string, partitionConsumer sarama.PartitionConsumer, endOffset int64) error {
+	idle := time.NewTicker(endCheckInterval)
+	defer idle.Stop()
+	received := false
+	for c.offset < endOffset {
+		select {
+		case message := <-partitionConsumer.Messages():
+			received = true
+			// Records see the offset they are at, so registrations can be ordered
+			c.offset = message.Offset
+			if err := c.processRecord(message.Key, message.Value); err != nil {
+				log.Warnf("Skipping record at offset %d of %s: %s", message.Offset, topic, err)
+			}
+			c.offset = message.Offset + 1
+		case err := <-partitionConsumer.Errors():
+			return err
+		case <-idle.C:
+			if !received && partitionConsumer.HighWaterMarkOffset() >= endOffset {
+				log.Debugf("Offsets %d to %d of %s are not records", c.offset, endOffset-1, topic)
+				c.offset = endOffset
+			}
+			received = false
+		}
+	}
+	return nil
+}
+
+// Find the cluster ID and topic ID, used to detect a recreated topic. Topic IDs need Kafka 2.8+, older clusters return empty
+func (c *SchemaRegistryCache) topicIdentity(topic string) (string, string) {
+	broker := c.client.LeastLoadedBroker()
+	if broker == nil {
+		return "", ""
+	}
+	metadataVersion := sarama.V2_1_0_0
+	apiVersions, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err == nil {
+		for _, api := range apiVersions.ApiKeys {
+			// Metadata v10 (Kafka 2.8) is the first to return topic IDs
+			if api.ApiKey == 3 && api.MaxVersion >= 10 {
+				metadataVersion = sarama.V2_8_0_0
+			}
+		}
+	}
+	metadata, err := broker.GetMetadata(sarama.NewMetadataRequest(metadataVersion, []string{topic}))
+	if err != nil {
+		log.Debugf("Failed to get metadata for %s: %s", topic, err)
+		return "", ""
+	}
+	var clusterID, topicID string
+	if metadata.ClusterID != nil {
+		clusterID = *metadata.ClusterID
+	}
+	for _, topicMetadata := range metadata.Topics {
+		if topicMetadata.Name == topic && topicMetadata.Uuid != (sarama.Uuid{}) {
+			topicID = topicMetadata.Uuid.String()
+		}
+	}
+	return clusterID, topicID
+}
+
+// Process a record of the topic
+func (c *SchemaRegistryCache) processRecord(key, value []byte) error {
+	var recordKey SRKey
+	err := json.Unmarshal(key, &recordKey)
+	if err != nil {
+		return err
+	}
+	switch recordKey.Keytype {
+	case "CONFIG":
+		return c.processConfigValue(&recordKey, value)
+	case "SCHEMA":
+		return c.processSchemaValue(&recordKey, value)
+	case "MODE":
+		return c.processModeValue(&recordKey, value)
+	case "DELETE_SUBJECT":
+		return c.processDeleteSubjectValue(&recordKey, value)
+	case "CLEAR_SUBJECT":
+		return c.processClearSubjectValue(&recordKey, value)
+	}
+	return nil
+}
+
 // Get the schema for the provided subject
 func (c *SchemaRegistryCache) GetSchemaForSubjectVersion(name string, version int) (*Schema, error) {
 	var schema Schema
 	var err error
-	schemaID := c.subjects[name][version]
-	schemaText := c.schemas[schemaID]
-	schema = Schema{SubjectName: name, SchemaData: schemaText}
+	schemaID, exists := c.subjects[name][version]
+	if !exists {
+		return nil, fmt.Errorf("version %d of subject %s not found", version, name)
+	}
+	cached := c.schemaForSubject(name, schemaID)
+	schema = Schema{SubjectName: name, SchemaData: cached.Schema, SchemaType: cached.SchemaType, References: cached.References}
 	return &schema, err
 }
 
+// All versions of a subject in the cache
+func (c *SchemaRegistryCache) GetSchemaVersions(subject string) []schemaVersion {
+	var versions []schemaVersion
+	for version := range c.subjects[subject] {
+		schema, _ := c.GetSchemaForSubjectVersion(subject, version)
+		versions = append(versions, schemaVersion{Version: version, ID: c.subjects[subject][version], Schema: *schema})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions
+}
+
 // Fetch the compatibility setting for given Subject.
 func (c *SchemaRegistryCache) GetCompatibilityForSubject(subject string) string {
 	return c.compatPerSubject[subject]
@@ -99,14 +319,40 @@ func (c *SchemaRegistryCache) GetGlobalCompatibility() string {
 	return c.globalCompat
 }
 
+// Fetch the mode set for given Subject. Empty if it follows the global mode
+func (c *SchemaRegistryCache) GetModeForSubject(subject string) string {
+	return c.modePerSubject[subject]
+}
+
+// Retrieve the global mode
+func (c *SchemaRegistryCache) GetGlobalMode() string {
+	return c.globalMode
+}
+
 // Lookup schema text under a subject. Return version and ID if found. Zeros if not.
-func (c *SchemaRegistryCache) LookupSchemaForSubject(subject, schema string) (int, int, error) {
+// Schemas are compared in canonical form, so formatting differences are not seen as a new schema
+func (c *SchemaRegistryCache) LookupSchemaForSubject(schema Schema) (int, int, error) {
+	subject := schema.SubjectName
+	wanted, err := canonicalSchema(schema.SchemaType, schema.SchemaData)
+	if err != nil {
+		log.Debugf("Can't get canonical form of %s, falling back to JSON comparison: %s", subject, err)
+	}
+	schemaType := schema.SchemaType
+	if schemaType == "" {
+		schemaType = srclient.Avro
+	}
 	// Compare all schema versions registered
 	for version, schema_id := range c.subjects[subject] {
 		log.Debugf("Comparing version %d of subject %s", version, subject)
-		existing_schema := c.schemas[schema_id]
-		existingschemaObj := Schema{SubjectName: subject, SchemaData: existing_schema}
-		equals, diff := existingschemaObj.SchemaDiff(schema)
+		cached := c.schemaForSubject(subject, schema_id)
+		if cached.SchemaType != schemaType {
+			continue
+		}
+		if err == nil && c.canonicalSchema(subject, schema_id) == wanted {
+			return schema_id, version, nil
+		}
+		existingschemaObj := Schema{SubjectName: subject, SchemaData: cached.Schema, SchemaType: schema.SchemaType}
+		equals, diff := existingschemaObj.SchemaDiff(schema.SchemaData)
 		log.Debugf("Subject %s equals=%v ", subject, equals)
 		if equals {
 			return schema_id, version, nil
@@ -118,42 +364,26 @@ func (c *SchemaRegistryCache) LookupSchemaForSubject(subject, schema string) (in
 	return 0, 0, nil
 }
 
-// Implement sarama consumer ConsumerGroupHandler interface
-func (r *SchemaRegistryCache) Setup(session sarama.ConsumerGroupSession) error {
-	close(r.consumer.ready)
-	return nil
-}
-
-func (r *SchemaRegistryCache) Cleanup(session sarama.ConsumerGroupSession) error {
-	return nil
+// The schema registered under an ID, in the context of subject
+func (c *SchemaRegistryCache) schemaForSubject(subject string, id int) srCachedSchema {
+	context, _ := splitQualifiedSubject(subject)
+	return c.schemas[context][id]
 }
 
-func (r *SchemaRegistryCache) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for {
-		select {
-		case message := <-claim.Messages():
-			var recordKey SRKey
-			err := json.Unmarshal(message.Key, &recordKey)
-			if err != nil {
-				return err
-			}
-			switch recordKey.Keytype {
-			case "CONFIG":
-				r.processConfigValue(&recordKey, message.Value)
-			case "SCHEMA":
-				r.processSchemaValue(&recordKey, message.Value)
-			}
-			session.MarkMessage(message, "")
-			// If that was the last message we should read, stop consuming
-			if message.Offset == r.lastKnownOffsetEnd {
-				log.Debugf("Stopping at end of topic %v", message.Offset)
-				r.consumer.cancel()
-				break
-			}
-		case <-session.Context().Done():
-			return nil
-		}
+// Canonical form of a cached schema. Computed once per schema ID as the same schema is often compared many times
+func (c *SchemaRegistryCache) canonicalSchema(subject string, id int) string {
+	context, _ := splitQualifiedSubject(subject)
+	key := srSchemaKey{Context: context, ID: id}
+	if canonical, exists := c.canonical[key]; exists {
+		return canonical
+	}
+	cached := c.schemas[context][id]
+	canonical, err := canonicalSchema(cached.SchemaType, cached.Schema)
+	if err != nil {
+		log.Debugf("Can't get canonical form of schema ID %d in context %s: %s", id, context, err)
 	}
+	c.canonical[key] = canonical
+	return canonical
 }
 
 func (r *SchemaRegistryCache) processConfigValue(key *SRKey, data []byte) error {
@@ -162,7 +392,11 @@ func (r *SchemaRegistryCache) processConfigValue(key *SRKey, data []byte) error
 	// If subject == nil this must be a global compatibility config
 	if data == nil {
 		// Tombstone. Deleting compatibility only supported in CP 7.0 schemaregistry
-		delete(r.compatPerSubject, subject)
+		if subject == "" {
+			r.globalCompat = "BACKWARD"
+		} else {
+			delete(r.compatPerSubject, subject)
+		}
 		return nil
 	}
 	err := json.Unmarshal(data, &value)
@@ -179,13 +413,26 @@ func (r *SchemaRegistryCache) processConfigValue(key *SRKey, data []byte) error
 	return nil
 }
 
-// Add a subject to known subjects. Handled nested maps properly
-func (r *SchemaRegistryCache) addSubject(name string, version, id int) {
-	_, exists := r.subjects[name]
+// Add a subject version to a map of subjects. Handles nested maps properly
+func addSubjectVersion(subjects map[string]map[int]int, name string, version, id int) {
+	_, exists := subjects[name]
 	if !exists {
-		r.subjects[name] = make(map[int]int)
+		subjects[name] = make(map[int]int)
+	}
+	subjects[name][version] = id
+}
+
+// Remove a subject version from a map of subjects. Subjects without versions are removed
+func removeSubjectVersion(subjects map[string]map[int]int, name string, version int) {
+	delete(subjects[name], version)
+	if len(subjects[name]) == 0 {
+		delete(subjects, name)
 	}
-	r.subjects[name][version] = id
+}
+
+// Add a subject to known subjects. Handled nested maps properly
+func (r *SchemaRegistryCache) addSubject(name string, version, id int) {
+	addSubjectVersion(r.subjects, name, version, id)
 }
 
 // Process the Kafka record with a keytype=SCHEMA. ACcepts the binary value and needs to be unmarshalled and processed
@@ -193,33 +440,108 @@ func (r *SchemaRegistryCache) processSchemaValue(key *SRKey, data []byte) error
 	var value SRValueSchema
 	subject := key.Subject
 	if data == nil {
-		// Tombstone. Deleting compatibility only supported in CP 7.0 schemaregistry
-		if data == nil {
-			log.Debugf("Tombstone. Dropping version %d of subject %s", key.Version, subject)
+		// Tombstone. The version was permanently deleted
+		log.Debugf("Tombstone. Dropping version %d of subject %s", key.Version, subject)
+		removeSubjectVersion(r.subjects, subject, key.Version)
+		removeSubjectVersion(r.deletedSubjects, subject, key.Version)
+		delete(r.versionHistory[subject], key.Version)
+		if len(r.versionHistory[subject]) == 0 {
+			delete(r.versionHistory, subject)
 		}
-
-		delete(r.subjects[subject], key.Version)
 		return nil
 	}
 	err := json.Unmarshal(data, &value)
 	if err != nil {
 		return err
 	}
+	if value.SchemaType == "" {
+		value.SchemaType = srclient.Avro
+	}
+	// We don't check if it exists already as any event replaces the previous one.
+	// Since this is a compacted topic, if a key exists then we create a map entry.
+	context, _ := splitQualifiedSubject(value.Subject)
+	if _, exists := r.schemas[context]; !exists {
+		r.schemas[context] = make(map[int]srCachedSchema)
+	}
+	r.schemas[context][value.Id] = srCachedSchema{Schema: value.Schema, SchemaType: value.SchemaType, References: value.References, Metadata: value.Metadata, RuleSet: value.RuleSet}
+	delete(r.canonical, srSchemaKey{Context: context, ID: value.Id})
+	// The first record of a version is its registration. Later ones (like soft deletes) don't change that
+	if _, exists := r.versionHistory[value.Subject][value.Version]; !exists {
+		if _, exists := r.versionHistory[value.Subject]; !exists {
+			r.versionHistory[value.Subject] = make(map[int]srVersionHistory)
+		}
+		compatibility := r.compatPerSubject[value.Subject]
+		if compatibility == "" {
+			compatibility = r.globalCompat
+		}
+		r.versionHistory[value.Subject][value.Version] = srVersionHistory{Offset: r.offset, Compatibility: compatibility}
+	}
 	// Also check for soft deleted subject versions (delete flag has been set)
 	if value.Deleted {
-		if value.Deleted {
-			log.Debugf("Delete flag seen. Dropping version %d of subject %s", key.Version, subject)
-		}
-		delete(r.subjects[subject], key.Version)
+		log.Debugf("Delete flag seen. Soft deleting version %d of subject %s", value.Version, value.Subject)
+		removeSubjectVersion(r.subjects, value.Subject, value.Version)
+		addSubjectVersion(r.deletedSubjects, value.Subject, value.Version, value.Id)
 		return nil
 	}
-	// We don't check if it exists already as any event replaces the previous one.
-	// Since this is a compacted topic, if a key exists then we create a map entry.
-	r.schemas[value.Id] = value.Schema
+	removeSubjectVersion(r.deletedSubjects, value.Subject, value.Version)
 	r.addSubject(value.Subject, value.Version, value.Id)
 	return nil
 }
 
+// Process a record with keytype=MODE. A tombstone removes the mode of the subject (or resets the global one)
+func (r *SchemaRegistryCache) processModeValue(key *SRKey, data []byte) error {
+	if data == nil {
+		if key.Subject == "" {
+			r.globalMode = "READWRITE"
+		} else {
+			delete(r.modePerSubject, key.Subject)
+		}
+		return nil
+	}
+	var value SRValueMode
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if key.Subject == "" {
+		log.Debugf("Global mode update seen (%s)", value.Mode)
+		r.globalMode = value.Mode
+	} else {
+		r.modePerSubject[key.Subject] = value.Mode
+	}
+	return nil
+}
+
+// Process a record with keytype=DELETE_SUBJECT. All versions up to the one in the value are soft deleted
+func (r *SchemaRegistryCache) processDeleteSubjectValue(key *SRKey, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	var value SRValueDeleteSubject
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	for version, id := range r.subjects[key.Subject] {
+		if version <= value.Version {
+			removeSubjectVersion(r.subjects, key.Subject, version)
+			addSubjectVersion(r.deletedSubjects, key.Subject, version, id)
+		}
+	}
+	return nil
+}
+
+// Process a record with keytype=CLEAR_SUBJECT. The subject was permanently deleted, so it's removed completely
+func (r *SchemaRegistryCache) processClearSubjectValue(key *SRKey, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	delete(r.subjects, key.Subject)
+	delete(r.deletedSubjects, key.Subject)
+	delete(r.compatPerSubject, key.Subject)
+	delete(r.modePerSubject, key.Subject)
+	delete(r.versionHistory, key.Subject)
+	return nil
+}
+
 // Get a list of registered subjects
 func (c *SchemaRegistryCache) GetSubjects() []string {
 	var subjectResponse []string
@@ -229,6 +551,32 @@ func (c *SchemaRegistryCache) GetSubjects() []string {
 	return subjectResponse
 }
 
+// Get a list of subjects, including those whose versions are all soft deleted
+func (c *SchemaRegistryCache) GetSubjectsIncludingDeleted() []string {
+	subjectResponse := c.GetSubjects()
+	for subject := range c.deletedSubjects {
+		if _, exists := c.subjects[subject]; !exists {
+			subjectResponse = append(subjectResponse, subject)
+		}
+	}
+	return subjectResponse
+}
+
+// Get the contexts subjects are registered in. The default context is always there
+func (c *SchemaRegistryCache) GetContexts() []string {
+	seen := map[string]bool{defaultSchemaContext: true}
+	for _, subject := range c.GetSubjectsIncludingDeleted() {
+		context, _ := splitQualifiedSubject(subject)
+		seen[context] = true
+	}
+	var contexts []string
+	for context := range seen {
+		contexts = append(contexts, context)
+	}
+	sort.Strings(contexts)
+	return contexts
+}
+
 // This is a debugging method. TODO remove when not needed
 func (c *SchemaRegistryCache) ListSubjects() {
 	for key, value := range c.subjects {
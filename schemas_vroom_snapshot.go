@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+The SchemaRegistryCache is persisted to a snapshot file after each read, together with the offset it was read up to.
+The next run restores the snapshot and only reads the records written since, instead of the whole topic.
+*/
+
+// Bump when the snapshot content changes, so that older snapshots are discarded
+const srCacheSnapshotFormat = 5
+
+type srCacheSnapshot struct {
+	Format           int                                 `json:"format"`
+	ClusterID        string                              `json:"clusterId"`
+	TopicID          string                              `json:"topicId"`
+	Offset           int64                               `json:"offset"` // Next offset to read
+	Schemas          map[string]map[int]srCachedSchema   `json:"schemas"`
+	Subjects         map[string]map[int]int              `json:"subjects"`
+	DeletedSubjects  map[string]map[int]int              `json:"deletedSubjects"`
+	GlobalCompat     string                              `json:"globalCompat"`
+	CompatPerSubject map[string]string                   `json:"compatPerSubject"`
+	GlobalMode       string                              `json:"globalMode"`
+	ModePerSubject   map[string]string                   `json:"modePerSubject"`
+	VersionHistory   map[string]map[int]srVersionHistory `json:"versionHistory"`
+}
+
+// Path of the snapshot for this cluster and topic. Empty if persistence is disabled
+func (c *SchemaRegistryCache) snapshotPath(topic string) string {
+	if c.snapshotDir == "" || len(c.brokers) == 0 {
+		return ""
+	}
+	return srCacheSnapshotFile(c.snapshotDir, c.brokers, topic)
+}
+
+// Snapshot files are named after the brokers and topic, so different clusters don't share one
+func srCacheSnapshotFile(dir string, brokers []string, topic string) string {
+	brokerList := append([]string(nil), brokers...)
+	// Order of brokers doesn't matter
+	sort.Strings(brokerList)
+	hash := sha256.Sum256([]byte(strings.Join(brokerList, ",") + "/" + topic))
+	return filepath.Join(dir, fmt.Sprintf("sr-cache-%x.json", hash[:8]))
+}
+
+// A snapshot can't be used if the cluster or topic changed, or its offset is outside the topic's current range
+func snapshotIsStale(snapshotClusterID, snapshotTopicID string, snapshotOffset int64, clusterID, topicID string, oldest, newest int64) bool {
+	if snapshotClusterID != "" && clusterID != "" && snapshotClusterID != clusterID {
+		return true
+	}
+	if snapshotTopicID != "" && topicID != "" && snapshotTopicID != topicID {
+		return true
+	}
+	return snapshotOffset > newest || snapshotOffset < oldest
+}
+
+// Restore the cache from a snapshot file
+func (c *SchemaRegistryCache) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot srCacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	if snapshot.Format != srCacheSnapshotFormat {
+		return fmt.Errorf("snapshot format %d is not supported", snapshot.Format)
+	}
+	c.reset()
+	c.offset = snapshot.Offset
+	c.clusterID = snapshot.ClusterID
+	c.topicID = snapshot.TopicID
+	if snapshot.Schemas != nil {
+		c.schemas = snapshot.Schemas
+	}
+	if snapshot.Subjects != nil {
+		c.subjects = snapshot.Subjects
+	}
+	if snapshot.DeletedSubjects != nil {
+		c.deletedSubjects = snapshot.DeletedSubjects
+	}
+	if snapshot.CompatPerSubject != nil {
+		c.compatPerSubject = snapshot.CompatPerSubject
+	}
+	if snapshot.GlobalCompat != "" {
+		c.globalCompat = snapshot.GlobalCompat
+	}
+	if snapshot.ModePerSubject != nil {
+		c.modePerSubject = snapshot.ModePerSubject
+	}
+	if snapshot.GlobalMode != "" {
+		c.globalMode = snapshot.GlobalMode
+	}
+	if snapshot.VersionHistory != nil {
+		c.versionHistory = snapshot.VersionHistory
+	}
+	return nil
+}
+
+// Write the cache to a snapshot file. The file is replaced atomically so an interrupted run can't leave a broken snapshot
+func (c *SchemaRegistryCache) saveSnapshot(path string) error {
+	snapshot := srCacheSnapshot{
+		Format:           srCacheSnapshotFormat,
+		ClusterID:        c.clusterID,
+		TopicID:          c.topicID,
+		Offset:           c.offset,
+		Schemas:          c.schemas,
+		Subjects:         c.subjects,
+		DeletedSubjects:  c.deletedSubjects,
+		GlobalCompat:     c.globalCompat,
+		CompatPerSubject: c.compatPerSubject,
+		GlobalMode:       c.globalMode,
+		ModePerSubject:   c.modePerSubject,
+		VersionHistory:   c.versionHistory,
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
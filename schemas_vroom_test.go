@@ -0,0 +1,204 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/kmetaxas/srclient"
+	"github.com/stretchr/testify/require"
+)
+
+// Feed records to the cache, as key/value JSON pairs. An empty value is a tombstone
+func processTestRecords(t *testing.T, cache *SchemaRegistryCache, records ...[2]string) {
+	for _, record := range records {
+		var value []byte
+		if record[1] != "" {
+			value = []byte(record[1])
+		}
+		require.NoError(t, cache.processRecord([]byte(record[0]), value))
+	}
+}
+
+func TestSchemaRegistryCacheProcessRecord(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	require.NoError(t, cache.processRecord([]byte(`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`),
+		[]byte(`{"subject":"orders-value","version":1,"id":7,"schema":"\"string\"","deleted":false}`)))
+	require.NoError(t, cache.processRecord([]byte(`{"keytype":"CONFIG","subject":"orders-value","magic":0}`),
+		[]byte(`{"compatibilityLevel":"FULL"}`)))
+	require.NoError(t, cache.processRecord([]byte(`{"keytype":"NOOP","magic":0}`), nil))
+	require.Error(t, cache.processRecord([]byte(`not json`), nil))
+
+	require.Equal(t, []string{"orders-value"}, cache.GetSubjects())
+	require.Equal(t, "FULL", cache.GetCompatibilityForSubject("orders-value"))
+	schema, err := cache.GetSchemaForSubjectVersion("orders-value", 1)
+	require.NoError(t, err)
+	require.Equal(t, `"string"`, schema.SchemaData)
+}
+
+func TestSchemaRegistryCacheSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "snapshot.json")
+	cache := newEmptySchemaRegistryCache()
+	cache.processRecord([]byte(`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`),
+		[]byte(`{"subject":"orders-value","version":1,"id":7,"schema":"\"string\"","deleted":false}`))
+	cache.globalCompat = "NONE"
+	cache.offset = 42
+	cache.clusterID = "cluster"
+	cache.topicID = "topic"
+	require.NoError(t, cache.saveSnapshot(path))
+
+	restored := newEmptySchemaRegistryCache()
+	require.NoError(t, restored.loadSnapshot(path))
+	require.Equal(t, int64(42), restored.offset)
+	require.Equal(t, "cluster", restored.clusterID)
+	require.Equal(t, "topic", restored.topicID)
+	require.Equal(t, "NONE", restored.GetGlobalCompatibility())
+	require.Equal(t, cache.subjects, restored.subjects)
+	require.Equal(t, cache.schemas, restored.schemas)
+
+	require.Error(t, restored.loadSnapshot(filepath.Join(t.TempDir(), "missing.json")))
+}
+
+func TestSnapshotIsStale(t *testing.T) {
+	require.False(t, snapshotIsStale("c1", "t1", 10, "c1", "t1", 0, 20))
+	require.False(t, snapshotIsStale("c1", "t1", 20, "c1", "t1", 0, 20), "up to date snapshot")
+	// Unknown topic IDs (older clusters) fall back to offsets
+	require.False(t, snapshotIsStale("c1", "", 10, "c1", "t1", 0, 20))
+	require.True(t, snapshotIsStale("c1", "t1", 10, "c1", "t2", 0, 20), "topic recreated")
+	require.True(t, snapshotIsStale("c1", "t1", 10, "c2", "t1", 0, 20), "other cluster")
+	require.True(t, snapshotIsStale("c1", "", 30, "c1", "", 0, 20), "offset past the end")
+	require.True(t, snapshotIsStale("c1", "", 5, "c1", "", 8, 20), "records removed before being read")
+}
+
+func TestSrCacheSnapshotFile(t *testing.T) {
+	first := srCacheSnapshotFile("/tmp", []string{"b:9092", "a:9092"}, "_schemas")
+	require.Equal(t, first, srCacheSnapshotFile("/tmp", []string{"a:9092", "b:9092"}, "_schemas"))
+	require.NotEqual(t, first, srCacheSnapshotFile("/tmp", []string{"a:9092", "b:9092"}, "_schemas2"))
+}
+
+func TestSchemaRegistryCacheSchemaTypesAndReferences(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"SCHEMA","subject":"payments-value","version":1,"magic":1}`,
+			`{"subject":"payments-value","version":1,"id":3,"schemaType":"PROTOBUF","schema":"syntax = \"proto3\";\nmessage Payment {}","references":[{"name":"money.proto","subject":"money","version":2}],"deleted":false}`},
+	)
+	schema, err := cache.GetSchemaForSubjectVersion("payments-value", 1)
+	require.NoError(t, err)
+	require.Equal(t, srclient.Protobuf, schema.SchemaType)
+	require.Equal(t, []srclient.Reference{{Name: "money.proto", Subject: "money", Version: 2}}, schema.References)
+
+	// Lookups compare with the registered type, so Protobuf is not compared as JSON
+	id, version, err := cache.LookupSchemaForSubject(Schema{SubjectName: "payments-value", SchemaType: srclient.Protobuf, SchemaData: "syntax = \"proto3\";\n\n// A payment\nmessage Payment {\n}\n"})
+	require.NoError(t, err)
+	require.Equal(t, 3, id)
+	require.Equal(t, 1, version)
+	id, _, _ = cache.LookupSchemaForSubject(Schema{SubjectName: "payments-value", SchemaType: srclient.Avro, SchemaData: `"string"`})
+	require.Equal(t, 0, id)
+
+	_, err = cache.GetSchemaForSubjectVersion("payments-value", 2)
+	require.Error(t, err)
+}
+
+func TestSchemaRegistryCacheDeletes(t *testing.T) {
+	schemaRecord := func(version, id int, deleted bool) [2]string {
+		return [2]string{
+			`{"keytype":"SCHEMA","subject":"orders-value","version":` + strconv.Itoa(version) + `,"magic":1}`,
+			`{"subject":"orders-value","version":` + strconv.Itoa(version) + `,"id":` + strconv.Itoa(id) + `,"schema":"\"string\"","deleted":` + map[bool]string{true: "true", false: "false"}[deleted] + `}`,
+		}
+	}
+	cache := newEmptySchemaRegistryCache()
+	processTestRecords(t, cache, schemaRecord(1, 1, false), schemaRecord(2, 2, false), schemaRecord(3, 3, false))
+
+	// Soft delete of a single version
+	processTestRecords(t, cache, schemaRecord(3, 3, true))
+	require.Len(t, cache.GetSchemaVersions("orders-value"), 2)
+
+	// Deleting the subject soft deletes up to the given version
+	processTestRecords(t, cache, [2]string{`{"keytype":"DELETE_SUBJECT","subject":"orders-value","magic":0}`, `{"subject":"orders-value","version":2}`})
+	require.Empty(t, cache.GetSubjects())
+	require.Equal(t, []string{"orders-value"}, cache.GetSubjectsIncludingDeleted())
+
+	// Hard delete of a version is a tombstone
+	processTestRecords(t, cache, [2]string{`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`, ""})
+	require.Len(t, cache.deletedSubjects["orders-value"], 2)
+
+	// Clearing removes the subject completely
+	processTestRecords(t, cache, [2]string{`{"keytype":"CLEAR_SUBJECT","subject":"orders-value","magic":0}`, `{"subject":"orders-value"}`})
+	require.Empty(t, cache.GetSubjectsIncludingDeleted())
+
+	// Registering again brings the subject back
+	processTestRecords(t, cache, schemaRecord(4, 1, false))
+	require.Equal(t, []string{"orders-value"}, cache.GetSubjects())
+}
+
+func TestSchemaRegistryCacheConfigAndMode(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"CONFIG","subject":null,"magic":0}`, `{"compatibilityLevel":"FULL"}`},
+		[2]string{`{"keytype":"CONFIG","subject":"orders-value","magic":0}`, `{"compatibilityLevel":"NONE"}`},
+		[2]string{`{"keytype":"MODE","subject":null,"magic":0}`, `{"mode":"READONLY"}`},
+		[2]string{`{"keytype":"MODE","subject":"orders-value","magic":0}`, `{"mode":"IMPORT"}`},
+	)
+	require.Equal(t, "FULL", cache.GetGlobalCompatibility())
+	require.Equal(t, "NONE", cache.GetCompatibilityForSubject("orders-value"))
+	require.Equal(t, "READONLY", cache.GetGlobalMode())
+	require.Equal(t, "IMPORT", cache.GetModeForSubject("orders-value"))
+
+	// Subject tombstones remove the subject setting, global tombstones reset to the default
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"CONFIG","subject":"orders-value","magic":0}`, ""},
+		[2]string{`{"keytype":"MODE","subject":"orders-value","magic":0}`, ""},
+	)
+	require.Equal(t, "", cache.GetCompatibilityForSubject("orders-value"))
+	require.Equal(t, "", cache.GetModeForSubject("orders-value"))
+	require.Equal(t, "FULL", cache.GetGlobalCompatibility())
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"CONFIG","subject":null,"magic":0}`, ""},
+		[2]string{`{"keytype":"MODE","subject":null,"magic":0}`, ""},
+	)
+	require.Equal(t, "BACKWARD", cache.GetGlobalCompatibility())
+	require.Equal(t, "READWRITE", cache.GetGlobalMode())
+}
+
+func TestSchemaRegistryCacheContexts(t *testing.T) {
+	cache := newEmptySchemaRegistryCache()
+	// The same ID means different schemas in different contexts
+	processTestRecords(t, cache,
+		[2]string{`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`, `{"subject":"orders-value","version":1,"id":1,"schema":"\"string\""}`},
+		[2]string{`{"keytype":"SCHEMA","subject":":.tenant:orders-value","version":1,"magic":1}`, `{"subject":":.tenant:orders-value","version":1,"id":1,"schema":"\"long\""}`},
+		[2]string{`{"keytype":"MODE","subject":":.tenant:","magic":0}`, `{"mode":"READONLY"}`},
+	)
+	schema, err := cache.GetSchemaForSubjectVersion(":.tenant:orders-value", 1)
+	require.NoError(t, err)
+	require.Equal(t, `"long"`, schema.SchemaData)
+	schema, err = cache.GetSchemaForSubjectVersion("orders-value", 1)
+	require.NoError(t, err)
+	require.Equal(t, `"string"`, schema.SchemaData)
+
+	id, _, err := cache.LookupSchemaForSubject(Schema{SubjectName: ":.tenant:orders-value", SchemaData: `"string"`, SchemaType: srclient.Avro})
+	require.NoError(t, err)
+	require.Equal(t, 0, id)
+	id, _, err = cache.LookupSchemaForSubject(Schema{SubjectName: ":.tenant:orders-value", SchemaData: `"long"`, SchemaType: srclient.Avro})
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+
+	require.Equal(t, []string{".", ".tenant"}, cache.GetContexts())
+	require.Equal(t, "READONLY", cache.GetModeForSubject(":.tenant:"))
+}
+
+func TestSchemaRegistryCacheReadPartitionWithGap(t *testing.T) {
+	defer func(interval time.Duration) { endCheckInterval = interval }(endCheckInterval)
+	endCheckInterval = 10 * time.Millisecond
+
+	cache := newEmptySchemaRegistryCache()
+	// Offsets 1 and 2 were removed by compaction
+	partitionConsumer := &gapPartitionConsumer{messages: make(chan *sarama.ConsumerMessage, 1), highWaterMark: 3}
+	partitionConsumer.messages <- &sarama.ConsumerMessage{Offset: 0,
+		Key:   []byte(`{"keytype":"SCHEMA","subject":"orders-value","version":1,"magic":1}`),
+		Value: []byte(`{"subject":"orders-value","version":1,"id":7,"schema":"\"string\"","deleted":false}`)}
+	require.NoError(t, cache.readPartition("_schemas", partitionConsumer, 3))
+	require.Equal(t, int64(3), cache.offset)
+	require.Equal(t, []string{"orders-value"}, cache.GetSubjects())
+}
@@ -21,9 +21,24 @@ Partitions {{ if $.IsPlan }}will be {{end}}changed to {{.NewPartitions}} from {{
 {{- end }}
 ## Schemas
 {{ range .Schemas -}}
-{{ if or .Changed .HasNewCompatibility .HasCompatibilityCheck -}} 
+{{ if .NewContext -}}
+{{ if $.IsPlan }}[PLAN] -  {{ end }}{{ .SettingTarget }} does not exist yet. The registry creates it with its first subject.
+{{ else if not .IsSubject -}}
+{{ if $.IsPlan }}[PLAN] -  {{ .SettingTarget }} mode will be set to {{ .NewMode }}{{ else }}{{ .SettingTarget }}: Changed mode to {{ .NewMode }}.{{ end }}
+{{ else if .DeletedSubject -}}
+{{ if $.IsPlan }}[PLAN] -  Subject {{ .SubjectName }} is orphaned (its topic is not declared) and will be {{ .DeleteKind }}.{{ else }}Subject {{ .SubjectName }}: Orphaned subject {{ .DeleteKind }}.{{ end }}
+{{ else if or .Changed .HasNewCompatibility .HasNewMode .HasCompatibilityCheck .HasDeletedVersions .HasRetentionSkipped .HasContractChanges -}} 
 {{ if $.IsPlan -}}
-[PLAN] -  Subject {{ .SubjectName }} {{ if .Changed }}will be registered with a new version.{{- end }} {{ if .HasNewCompatibility }} Compatibility will be set to {{ .NewCompat }}{{- end }}
+[PLAN] -  Subject {{ .SubjectName }} {{ if .Changed }}will be registered with a new version.{{- end }} {{ if .HasNewCompatibility }} Compatibility will be set to {{ .NewCompat }}{{- end }}{{ if .HasNewMode }} Mode will be set to {{ .NewMode }}{{- end }}{{ if .HasDeletedVersions }} Versions {{ .DeletedVersions }} will be {{ .DeleteKind }}.{{- end }}
+{{ if .HasRetentionSkipped -}}
+  Retention: {{ .RetentionSkipped }}
+{{ end -}}
+{{ if .HasContractChanges -}}
+  Data contract:
+{{- range .ContractChanges }}
+    - {{ . }}
+{{- end }}
+{{ end -}}
 {{ if .HasCompatibilityCheck -}}
   Compatibility Check ({{ .CompatibilityLevel }}): {{ if .IsSchemaCompatible }}PASS - Schema is compatible{{else}}FAIL - Schema is NOT compatible{{end}}
 {{ if .HasCompatibilityErrors }}  Reasons:
@@ -36,6 +51,17 @@ Partitions {{ if $.IsPlan }}will be {{end}}changed to {{.NewPartitions}} from {{
 Subject {{ .SubjectName }}: 
 {{- if .HasNewVersion -}} Registered with new version {{ .NewVersion }}. {{- end -}}
 {{if .HasNewCompatibility }} Changed Compatibility to {{ .NewCompat }}.{{end}}
+{{- if .HasNewMode }} Changed Mode to {{ .NewMode }}.{{end}}
+{{- if .HasDeletedVersions }} Versions {{ .DeletedVersions }} {{ .DeleteKind }}.{{end}}
+{{ if .HasRetentionSkipped -}}
+  Retention: {{ .RetentionSkipped }}
+{{ end -}}
+{{ if .HasContractChanges -}}
+  Data contract:
+{{- range .ContractChanges }}
+    - {{ . }}
+{{- end }}
+{{ end -}}
 {{ if .HasCompatibilityCheck -}}
   Compatibility Check ({{ .CompatibilityLevel }}): {{ if .IsSchemaCompatible }}PASS - Schema is compatible{{else}}FAIL - Schema is NOT compatible{{end}}
 {{ if .HasCompatibilityErrors }}  Reasons:
@@ -46,7 +72,29 @@ Subject {{ .SubjectName }}:
 {{- end }}
 {{ end -}}
 {{- end -}}
-{{- end }}{{/* .Changed .HasNewCompatibility .HasCompatibilityCheck */}}
+{{- end }}{{/* .NewContext, not .IsSubject, .DeletedSubject, .Changed .HasNewCompatibility .HasNewMode .HasCompatibilityCheck .HasDeletedVersions .HasRetentionSkipped .HasContractChanges */}}
+## Schema Exporters
+{{ range .SchemaExporters -}}
+{{- if .Error -}}
+[ERROR] Failed to process schema exporter {{ .Name }}: {{ .Error }}
+{{ else -}}
+{{ if ne .Status "NoChange" -}}
+{{ if $.IsPlan }}[PLAN] Will {{ if eq .Status "Created" }}create{{ else if eq .Status "Deleted" }}delete{{ else }}update{{ end }}{{ else }}{{ .Status }}{{ end }} schema exporter {{ .Name }}.
+{{- range .Changes }}
+  - {{ .Name }}: {{ .NewVal }}{{ if ne .OldVal "" }} (was: {{ .OldVal }}){{ end }}
+{{- end }}
+{{- if .NewState }}
+  {{ if $.IsPlan }}Will be {{ else }}Now {{ end }}{{ if eq .NewState "PAUSED" }}paused{{ else }}running{{ end }}
+{{- end }}
+{{ end -}}
+{{ if .HasStatus -}}
+Schema exporter {{ .Name }}: {{ .State }}, offset {{ .Offset }}{{ if .HasLag }}, lag {{ .LagRecords }} records{{ end }}{{ if .HasLastExport }}, last export {{ .LastExport.Format "2006-01-02T15:04:05Z07:00" }}{{ end }}
+{{- if .Trace }}
+  Error: {{ .Trace }}
+{{- end }}
+{{ end -}}
+{{- end -}}
+{{- end -}}
 ## Roles and Clients
 {{ range .Clients -}}
 {{ if $.IsPlan }}[PLAN]. Will add{{ else }} Added {{ end }} role {{ .Role }} to principal {{ .Principal }} for {{ .ResourceType }}:{{ .ResourceName }} with type {{ .PatternType }}
@@ -1,3 +1,3 @@
 {{ range .LintResults }}
-{{ .Topic }} has {{.Severity }}: {{ .Message }} (Hint: {{.Hint}})
+{{ if .Subject }}Subject {{ .Subject }}{{ else }}{{ .Topic }}{{ end }} has {{.Severity }}: {{ .Message }} (Hint: {{.Hint}})
 {{- end }}
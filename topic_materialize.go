@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+/*
+Materializing a compacted topic: the latest value of every key, as the topic holds it after compaction.
+Keys are compared as raw bytes, like the log cleaner does. Keys whose latest record is a tombstone are dropped,
+and records without a key are skipped since compaction doesn't allow them.
+*/
+
+var materializeFormats = []string{"table", "json", "csv"}
+
+// Latest record per key. Keys are the raw record keys
+type materializeStore interface {
+	Put(id string, record *sinkRecord) error
+	Delete(id string)
+	Get(id string) (*sinkRecord, error)
+	// Keys ordered by the deserialized key
+	Keys() []string
+	Len() int
+	// Release the store. Files are removed
+	Close() error
+}
+
+type memoryStore struct {
+	records map[string]*sinkRecord
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]*sinkRecord)}
+}
+
+func (s *memoryStore) Put(id string, record *sinkRecord) error {
+	s.records[id] = record
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) {
+	delete(s.records, id)
+}
+
+func (s *memoryStore) Get(id string) (*sinkRecord, error) {
+	return s.records[id], nil
+}
+
+func (s *memoryStore) Keys() []string {
+	var ids []string
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return lessKey(s.records[ids[i]].Key, ids[i], s.records[ids[j]].Key, ids[j])
+	})
+	return ids
+}
+
+func (s *memoryStore) Len() int {
+	return len(s.records)
+}
+
+func (s *memoryStore) Close() error {
+	s.records = nil
+	return nil
+}
+
+// Order by deserialized key, then raw key for keys that deserialize the same
+func lessKey(key1, id1, key2, id2 string) bool {
+	if key1 != key2 {
+		return key1 < key2
+	}
+	return id1 < id2
+}
+
+// Where the latest record of a key is in the data file
+type diskRecordRef struct {
+	key    string // Deserialized key, to order keys without reading the records
+	offset int64
+	length int
+}
+
+/*
+Records in an append-only data file, with an index of the latest one per key in memory.
+Only keys are kept in memory, so topics with values larger than memory can be materialized.
+Replaced and deleted records stay in the file until it is removed
+*/
+type diskStore struct {
+	file  *os.File
+	index map[string]diskRecordRef
+	size  int64
+}
+
+// Create a disk store in dir. The system temporary directory if dir is empty
+func newDiskStore(dir string) (*diskStore, error) {
+	file, err := os.CreateTemp(dir, "gafkalo-materialize-*.data")
+	if err != nil {
+		return nil, err
+	}
+	return &diskStore{file: file, index: make(map[string]diskRecordRef)}, nil
+}
+
+func (s *diskStore) Put(id string, record *sinkRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return err
+	}
+	s.index[id] = diskRecordRef{key: record.Key, offset: s.size, length: len(data)}
+	s.size += int64(len(data))
+	return nil
+}
+
+func (s *diskStore) Delete(id string) {
+	delete(s.index, id)
+}
+
+func (s *diskStore) Get(id string) (*sinkRecord, error) {
+	ref, exists := s.index[id]
+	if !exists {
+		return nil, nil
+	}
+	data := make([]byte, ref.length)
+	if _, err := s.file.ReadAt(data, ref.offset); err != nil {
+		return nil, err
+	}
+	var record sinkRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *diskStore) Keys() []string {
+	var ids []string
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return lessKey(s.index[ids[i]].key, ids[i], s.index[ids[j]].key, ids[j])
+	})
+	return ids
+}
+
+func (s *diskStore) Len() int {
+	return len(s.index)
+}
+
+func (s *diskStore) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// Keeps the latest record per key of the consumed records. Used as the consumer's sink
+type Materializer struct {
+	store      materializeStore
+	keyPattern *regexp.Regexp // Only keys matching it are kept, if set
+	records    int            // Records read
+	tombstones int            // Tombstones read
+	nullKeys   int            // Records without a key
+	mutex      sync.Mutex
+}
+
+func NewMaterializer(store materializeStore, keyPattern *regexp.Regexp) *Materializer {
+	return &Materializer{store: store, keyPattern: keyPattern}
+}
+
+func (m *Materializer) Write(record *sinkRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	message := record.Message
+	m.records++
+	if message.Value == nil {
+		m.tombstones++
+	}
+	if message.Key == nil {
+		m.nullKeys++
+		return nil
+	}
+	if m.keyPattern != nil && !m.keyPattern.MatchString(record.Key) {
+		return nil
+	}
+	id := string(message.Key)
+	if message.Value == nil {
+		m.store.Delete(id)
+		return nil
+	}
+	return m.store.Put(id, record)
+}
+
+// The store is kept to render it. It is released by Release
+func (m *Materializer) Close() error {
+	return nil
+}
+
+func (m *Materializer) Release() error {
+	return m.store.Close()
+}
+
+// Summary of what was read, for stderr
+func (m *Materializer) Summary() string {
+	summary := fmt.Sprintf("%d keys from %d records (%d tombstones)", m.store.Len(), m.records, m.tombstones)
+	if m.nullKeys > 0 {
+		summary = fmt.Sprintf("%s. Skipped %d records without a key", summary, m.nullKeys)
+	}
+	return summary
+}
+
+// Write the latest record per key, ordered by key. CSV columns are as in the consumer's csv format
+func (m *Materializer) Render(w io.Writer, format string, csvColumns []string) error {
+	switch format {
+	case "table":
+		tb := table.NewWriter()
+		tb.SetStyle(table.StyleLight)
+		tb.SetOutputMirror(w)
+		tb.AppendHeader(table.Row{"Key", "Value", "Partition", "Offset", "Timestamp"})
+		for _, id := range m.store.Keys() {
+			record, err := m.store.Get(id)
+			if err != nil {
+				return err
+			}
+			tb.AppendRow(table.Row{record.Key, record.Value, record.Message.Partition, record.Message.Offset, record.Message.Timestamp})
+		}
+		tb.Render()
+		return nil
+	case "json", "csv":
+		sink, err := NewRecordSink("", format, csvColumns, 0, 0, nil)
+		if err != nil {
+			return err
+		}
+		sink.writeTo(w)
+		for _, id := range m.store.Keys() {
+			record, err := m.store.Get(id)
+			if err != nil {
+				return err
+			}
+			if err := sink.Write(record); err != nil {
+				return err
+			}
+		}
+		return sink.Close()
+	}
+	return fmt.Errorf("unknown output format %s. Expected one of %s", format, strings.Join(materializeFormats, ","))
+}
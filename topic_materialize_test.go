@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func materializeTestRecords() []*sinkRecord {
+	records := []*sinkRecord{
+		testSinkRecord(0, "user-2", `{"name":"john"}`),
+		testSinkRecord(1, "user-1", `{"name":"jane"}`),
+		testSinkRecord(2, "user-3", `{"name":"anna"}`),
+		testSinkRecord(3, "user-2", `{"name":"johnny"}`),
+		testSinkRecord(4, "user-3", ""),
+		testSinkRecord(5, "", `{"name":"nobody"}`),
+		testSinkRecord(6, "admin-1", `{"name":"root"}`),
+	}
+	records[4].Message.Value = nil
+	records[5].Message.Key = nil
+	return records
+}
+
+func TestMaterializer(t *testing.T) {
+	newStores := map[string]func() materializeStore{
+		"memory": func() materializeStore { return newMemoryStore() },
+		"disk": func() materializeStore {
+			store, err := newDiskStore(t.TempDir())
+			require.NoError(t, err)
+			return store
+		},
+	}
+	for name, newStore := range newStores {
+		t.Run(name, func(t *testing.T) {
+			materializer := NewMaterializer(newStore(), regexp.MustCompile("^user-"))
+			for _, record := range materializeTestRecords() {
+				require.NoError(t, materializer.Write(record))
+			}
+			require.NoError(t, materializer.Close())
+
+			var out bytes.Buffer
+			require.NoError(t, materializer.Render(&out, "csv", []string{"key", "value.name", "offset"}))
+			require.Equal(t, "key,value.name,offset\nuser-1,jane,1\nuser-2,johnny,3\n", out.String())
+			require.Equal(t, "2 keys from 7 records (1 tombstones). Skipped 1 records without a key", materializer.Summary())
+
+			out.Reset()
+			require.NoError(t, materializer.Render(&out, "json", nil))
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			require.Len(t, lines, 2)
+			require.Contains(t, lines[1], `"value":{"name":"johnny"}`)
+
+			out.Reset()
+			require.NoError(t, materializer.Render(&out, "table", nil))
+			require.Contains(t, out.String(), "johnny")
+			require.NotContains(t, out.String(), "anna")
+
+			require.Error(t, materializer.Render(&out, "xml", nil))
+			require.NoError(t, materializer.Release())
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/kmetaxas/srclient"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Statistics of the data in a topic, to find hot partitions and keys and the schemas in use.
+Keys are counted by a 64 bit hash of their bytes, so cardinality doesn't need the keys in memory.
+*/
+
+// Upper bounds of the size histogram buckets, in bytes. The last bucket is everything larger
+var sizeBuckets = []int64{0, 64, 256, 1024, 4 * 1024, 16 * 1024, 64 * 1024, 256 * 1024, 1024 * 1024}
+
+type SizeHistogram struct {
+	Buckets []SizeBucket `json:"buckets"`
+	Min     int64        `json:"min"`
+	Max     int64        `json:"max"`
+	Total   int64        `json:"total"`
+	Count   int64        `json:"count"`
+}
+
+type SizeBucket struct {
+	Label string `json:"label"`
+	Max   int64  `json:"max"` // -1 for the last bucket
+	Count int64  `json:"count"`
+}
+
+func newSizeHistogram() *SizeHistogram {
+	histogram := &SizeHistogram{Min: -1}
+	var min int64
+	for _, max := range sizeBuckets {
+		label := fmt.Sprintf("%s - %s", formatBytes(min), formatBytes(max))
+		if max == 0 {
+			label = formatBytes(0)
+		}
+		histogram.Buckets = append(histogram.Buckets, SizeBucket{Label: label, Max: max})
+		min = max + 1
+	}
+	histogram.Buckets = append(histogram.Buckets, SizeBucket{Label: fmt.Sprintf("> %s", formatBytes(min-1)), Max: -1})
+	return histogram
+}
+
+func (h *SizeHistogram) add(size int64) {
+	for i := range h.Buckets {
+		if h.Buckets[i].Max < 0 || size <= h.Buckets[i].Max {
+			h.Buckets[i].Count++
+			break
+		}
+	}
+	if h.Min < 0 || size < h.Min {
+		h.Min = size
+	}
+	if size > h.Max {
+		h.Max = size
+	}
+	h.Total += size
+	h.Count++
+}
+
+func (h *SizeHistogram) Average() int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / h.Count
+}
+
+// Bytes in B, KiB or MiB
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return fmt.Sprintf("%d MiB", size/(1024*1024))
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1024*1024))
+	case size >= 1024 && size%1024 == 0:
+		return fmt.Sprintf("%d KiB", size/1024)
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+type PartitionStats struct {
+	Partition     int32  `json:"partition"`
+	Records       int64  `json:"records"`
+	Bytes         int64  `json:"bytes"` // Keys and values
+	Tombstones    int64  `json:"tombstones"`
+	DistinctKeys  int64  `json:"distinct_keys"`
+	TopKey        string `json:"top_key"`                   // The key with the most records
+	TopKeyEscaped bool   `json:"top_key_escaped,omitempty"` // The top key is not text and was escaped
+	TopKeyRecords int64  `json:"top_key_records"`
+	keyCounts     map[uint64]int64
+}
+
+// Records using a schema ID, as a key or value schema
+type SchemaUsage struct {
+	ID             int      `json:"id"`
+	KeyRecords     int64    `json:"key_records"`
+	ValueRecords   int64    `json:"value_records"`
+	DecodeFailures int64    `json:"decode_failures,omitempty"` // Payloads that failed to deserialize
+	Subjects       []string `json:"subjects,omitempty"`        // subject:version pairs registered with the ID
+}
+
+type TopicStats struct {
+	Topic          string            `json:"topic"`
+	Records        int64             `json:"records"`
+	Bytes          int64             `json:"bytes"`
+	Tombstones     int64             `json:"tombstones"`
+	NullKeys       int64             `json:"null_keys"`
+	DistinctKeys   int64             `json:"distinct_keys"`
+	FirstTimestamp *time.Time        `json:"first_timestamp,omitempty"`
+	LastTimestamp  *time.Time        `json:"last_timestamp,omitempty"`
+	Partitions     []*PartitionStats `json:"partitions"`
+	KeySizes       *SizeHistogram    `json:"key_sizes"`
+	ValueSizes     *SizeHistogram    `json:"value_sizes"`
+	Headers        map[string]int64  `json:"headers"` // Records with each header key
+	Schemas        []*SchemaUsage    `json:"schemas"`
+	NoSchemaValues int64             `json:"no_schema_values"` // Values not in the registry wire format
+
+	partitions map[int32]*PartitionStats
+	schemas    map[int]*SchemaUsage
+	keyHashes  map[uint64]bool
+	// Deserializes wire format payloads to check them, if set. Like Consumer.DeserializePayload
+	deserialize func(payload []byte) (string, int, error)
+	mutex       sync.Mutex
+}
+
+func NewTopicStats(topic string) *TopicStats {
+	return &TopicStats{
+		Topic:      topic,
+		KeySizes:   newSizeHistogram(),
+		ValueSizes: newSizeHistogram(),
+		Headers:    make(map[string]int64),
+		partitions: make(map[int32]*PartitionStats),
+		schemas:    make(map[int]*SchemaUsage),
+		keyHashes:  make(map[uint64]bool),
+	}
+}
+
+func hashKey(key []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(key)
+	return hash.Sum64()
+}
+
+// Add a record. Used as the consumer's sink
+func (s *TopicStats) Write(record *sinkRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	message := record.Message
+	partition, exists := s.partitions[message.Partition]
+	if !exists {
+		partition = &PartitionStats{Partition: message.Partition, keyCounts: make(map[uint64]int64)}
+		s.partitions[message.Partition] = partition
+	}
+	size := int64(len(message.Key) + len(message.Value))
+	s.Records++
+	s.Bytes += size
+	partition.Records++
+	partition.Bytes += size
+	s.KeySizes.add(int64(len(message.Key)))
+	if message.Value == nil {
+		s.Tombstones++
+		partition.Tombstones++
+	} else {
+		s.ValueSizes.add(int64(len(message.Value)))
+	}
+	if message.Key == nil {
+		s.NullKeys++
+	} else {
+		hash := hashKey(message.Key)
+		s.keyHashes[hash] = true
+		partition.keyCounts[hash]++
+		if partition.keyCounts[hash] > partition.TopKeyRecords {
+			partition.TopKeyRecords = partition.keyCounts[hash]
+			partition.TopKey = safePayloadString(message.Key)
+			partition.TopKeyEscaped = !isSafeText(message.Key)
+		}
+	}
+	if !message.Timestamp.IsZero() {
+		timestamp := message.Timestamp
+		if s.FirstTimestamp == nil || timestamp.Before(*s.FirstTimestamp) {
+			s.FirstTimestamp = &timestamp
+		}
+		if s.LastTimestamp == nil || timestamp.After(*s.LastTimestamp) {
+			s.LastTimestamp = &timestamp
+		}
+	}
+	// A header key can repeat in a record, and records are counted once per key
+	recordHeaders := make(map[string]bool)
+	for _, header := range message.Headers {
+		if !recordHeaders[string(header.Key)] {
+			recordHeaders[string(header.Key)] = true
+			s.Headers[string(header.Key)]++
+		}
+	}
+	if message.Key != nil {
+		if schemaID, err := wireFormatSchemaID(message.Key); err == nil {
+			usage := s.schemaUsage(schemaID)
+			usage.KeyRecords++
+			s.checkPayload(usage, message.Key)
+		}
+	}
+	if message.Value != nil {
+		if schemaID, err := wireFormatSchemaID(message.Value); err == nil {
+			usage := s.schemaUsage(schemaID)
+			usage.ValueRecords++
+			s.checkPayload(usage, message.Value)
+		} else {
+			s.NoSchemaValues++
+		}
+	}
+	return nil
+}
+
+func (s *TopicStats) Close() error {
+	return nil
+}
+
+func (s *TopicStats) schemaUsage(schemaID int) *SchemaUsage {
+	usage, exists := s.schemas[schemaID]
+	if !exists {
+		usage = &SchemaUsage{ID: schemaID}
+		s.schemas[schemaID] = usage
+	}
+	return usage
+}
+
+func (s *TopicStats) checkPayload(usage *SchemaUsage, payload []byte) {
+	if s.deserialize == nil {
+		return
+	}
+	if _, _, err := s.deserialize(payload); err != nil {
+		log.Debugf("Failed to deserialize a payload with schema ID %d: %s", usage.ID, err)
+		usage.DecodeFailures++
+	}
+}
+
+// Deserialize payloads with their schema to count those that fail
+func (s *TopicStats) SetDeserializer(deserialize func(payload []byte) (string, int, error)) {
+	s.deserialize = deserialize
+}
+
+// Sort partitions and schemas and count keys. Call once all records are added
+func (s *TopicStats) Finish() {
+	s.DistinctKeys = int64(len(s.keyHashes))
+	s.Partitions = nil
+	for _, partition := range s.partitions {
+		partition.DistinctKeys = int64(len(partition.keyCounts))
+		s.Partitions = append(s.Partitions, partition)
+	}
+	sort.Slice(s.Partitions, func(i, j int) bool { return s.Partitions[i].Partition < s.Partitions[j].Partition })
+	s.Schemas = nil
+	for _, usage := range s.schemas {
+		s.Schemas = append(s.Schemas, usage)
+	}
+	sort.Slice(s.Schemas, func(i, j int) bool { return s.Schemas[i].ID < s.Schemas[j].ID })
+}
+
+// Find the subject versions registered with each schema ID
+func (s *TopicStats) ResolveSubjects(client *srclient.SchemaRegistryClient) {
+	for _, usage := range s.Schemas {
+		versions, err := client.GetSubjectVersionsById(usage.ID)
+		if err != nil {
+			log.Warnf("Failed to get the subjects of schema ID %d: %s", usage.ID, err)
+			continue
+		}
+		for _, version := range versions {
+			usage.Subjects = append(usage.Subjects, fmt.Sprintf("%s:%d", version.Subject, version.Version))
+		}
+		sort.Strings(usage.Subjects)
+	}
+}
+
+// Largest partition record count over the average. 1 when records are evenly spread
+func (s *TopicStats) PartitionSkew() float64 {
+	if len(s.Partitions) == 0 || s.Records == 0 {
+		return 0
+	}
+	var max int64
+	for _, partition := range s.Partitions {
+		if partition.Records > max {
+			max = partition.Records
+		}
+	}
+	return float64(max) / (float64(s.Records) / float64(len(s.Partitions)))
+}
+
+func percent(part, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+func (s *TopicStats) Render(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "text":
+		s.renderText(w)
+		return nil
+	}
+	return fmt.Errorf("unknown output format: %s (use text or json)", format)
+}
+
+func (s *TopicStats) renderText(w io.Writer) {
+	fmt.Fprintf(w, "Topic %s: %d records in %d partitions, %s\n", s.Topic, s.Records, len(s.Partitions), formatBytes(s.Bytes))
+	if s.FirstTimestamp != nil {
+		fmt.Fprintf(w, "Timestamps: %s to %s\n", s.FirstTimestamp.Format(time.RFC3339), s.LastTimestamp.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Tombstones: %d (%s)\n", s.Tombstones, percent(s.Tombstones, s.Records))
+	fmt.Fprintf(w, "Keys: %d distinct, %d records without a key\n", s.DistinctKeys, s.NullKeys)
+	fmt.Fprintf(w, "Partition skew: %.2f (largest partition over the average)\n", s.PartitionSkew())
+
+	newTable := func(header table.Row) table.Writer {
+		tb := table.NewWriter()
+		tb.SetStyle(table.StyleLight)
+		tb.SetOutputMirror(w)
+		tb.AppendHeader(header)
+		return tb
+	}
+	fmt.Fprintln(w)
+	tb := newTable(table.Row{"Partition", "Records", "Share", "Size", "Tombstones", "Distinct keys", "Top key", "Top key share"})
+	for _, partition := range s.Partitions {
+		topKey := partition.TopKey
+		if partition.TopKeyEscaped {
+			topKey += " (escaped)"
+		}
+		tb.AppendRow(table.Row{partition.Partition, partition.Records, percent(partition.Records, s.Records), formatBytes(partition.Bytes),
+			partition.Tombstones, partition.DistinctKeys, topKey, percent(partition.TopKeyRecords, partition.Records)})
+	}
+	tb.Render()
+
+	fmt.Fprintln(w)
+	tb = newTable(table.Row{"Size", "Keys", "Values"})
+	for i := range s.KeySizes.Buckets {
+		tb.AppendRow(table.Row{s.KeySizes.Buckets[i].Label, s.KeySizes.Buckets[i].Count, s.ValueSizes.Buckets[i].Count})
+	}
+	tb.AppendFooter(table.Row{"Min / avg / max",
+		fmt.Sprintf("%s / %s / %s", formatBytes(max(s.KeySizes.Min, 0)), formatBytes(s.KeySizes.Average()), formatBytes(s.KeySizes.Max)),
+		fmt.Sprintf("%s / %s / %s", formatBytes(max(s.ValueSizes.Min, 0)), formatBytes(s.ValueSizes.Average()), formatBytes(s.ValueSizes.Max))})
+	tb.Render()
+
+	if len(s.Headers) > 0 {
+		fmt.Fprintln(w)
+		tb = newTable(table.Row{"Header", "Records", "Share"})
+		for _, name := range sortedKeys(s.Headers) {
+			tb.AppendRow(table.Row{name, s.Headers[name], percent(s.Headers[name], s.Records)})
+		}
+		tb.Render()
+	}
+
+	fmt.Fprintln(w)
+	if len(s.Schemas) == 0 {
+		fmt.Fprintln(w, "No records in the schema registry wire format")
+		return
+	}
+	header := table.Row{"Schema ID", "Subjects", "Key records", "Value records"}
+	if s.deserialize != nil {
+		header = append(header, "Decode failures")
+	}
+	tb = newTable(header)
+	for _, usage := range s.Schemas {
+		row := table.Row{usage.ID, fmt.Sprint(usage.Subjects), usage.KeyRecords, usage.ValueRecords}
+		if s.deserialize != nil {
+			row = append(row, usage.DecodeFailures)
+		}
+		tb.AppendRow(row)
+	}
+	tb.Render()
+	if s.NoSchemaValues > 0 {
+		fmt.Fprintf(w, "%d values are not in the schema registry wire format\n", s.NoSchemaValues)
+	}
+}
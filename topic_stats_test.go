@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	histogram := newSizeHistogram()
+	for _, size := range []int64{0, 10, 64, 65, 2000, 2 * 1024 * 1024} {
+		histogram.add(size)
+	}
+	require.Equal(t, "0 B", histogram.Buckets[0].Label)
+	require.Equal(t, "1 B - 64 B", histogram.Buckets[1].Label)
+	require.Equal(t, "> 1 MiB", histogram.Buckets[len(histogram.Buckets)-1].Label)
+	var counts []int64
+	for _, bucket := range histogram.Buckets {
+		counts = append(counts, bucket.Count)
+	}
+	require.Equal(t, []int64{1, 2, 1, 0, 1, 0, 0, 0, 0, 1}, counts)
+	require.Equal(t, int64(0), histogram.Min)
+	require.Equal(t, int64(2*1024*1024), histogram.Max)
+	require.Equal(t, int64(6), histogram.Count)
+}
+
+func TestTopicStats(t *testing.T) {
+	start := time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC)
+	stats := NewTopicStats("orders")
+	stats.SetDeserializer(func(payload []byte) (string, int, error) {
+		if bytes.HasSuffix(payload, []byte("bad")) {
+			return "", 0, errors.New("invalid payload")
+		}
+		return "", 0, nil
+	})
+	records := []*sarama.ConsumerMessage{
+		{Partition: 0, Key: []byte("a"), Value: wireFormat(1, []byte("one")), Timestamp: start.Add(time.Minute),
+			Headers: []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("1")}}},
+		{Partition: 0, Key: []byte("a"), Value: wireFormat(1, []byte("bad")), Timestamp: start},
+		{Partition: 0, Key: []byte("b"), Value: nil, Timestamp: start.Add(time.Hour)},
+		{Partition: 1, Key: []byte("a"), Value: []byte("plain"), Timestamp: start.Add(2 * time.Minute),
+			Headers: []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("2")}, {Key: []byte("source"), Value: []byte("x")}, {Key: []byte("source"), Value: []byte("y")}}},
+		{Partition: 1, Key: nil, Value: []byte("no key"), Timestamp: start.Add(3 * time.Minute)},
+		{Partition: 1, Key: wireFormat(3, []byte("k")), Value: wireFormat(1, []byte("two")), Timestamp: start.Add(4 * time.Minute)},
+	}
+	for _, message := range records {
+		require.NoError(t, stats.Write(&sinkRecord{Message: message}))
+	}
+	stats.Finish()
+
+	require.Equal(t, int64(6), stats.Records)
+	require.Equal(t, int64(1), stats.Tombstones)
+	require.Equal(t, int64(1), stats.NullKeys)
+	require.Equal(t, int64(3), stats.DistinctKeys)
+	require.Equal(t, start, *stats.FirstTimestamp)
+	require.Equal(t, start.Add(time.Hour), *stats.LastTimestamp)
+	// A header key repeated in a record counts the record once
+	require.Equal(t, map[string]int64{"trace-id": 2, "source": 1}, stats.Headers)
+	require.Equal(t, int64(2), stats.NoSchemaValues)
+	require.InDelta(t, 1.0, stats.PartitionSkew(), 0.001)
+
+	require.Len(t, stats.Partitions, 2)
+	first := stats.Partitions[0]
+	require.Equal(t, int32(0), first.Partition)
+	require.Equal(t, int64(3), first.Records)
+	require.Equal(t, int64(1), first.Tombstones)
+	require.Equal(t, int64(2), first.DistinctKeys)
+	require.Equal(t, "a", first.TopKey)
+	require.Equal(t, int64(2), first.TopKeyRecords)
+	require.Equal(t, int64(2), stats.Partitions[1].DistinctKeys)
+
+	require.Len(t, stats.Schemas, 2)
+	require.Equal(t, SchemaUsage{ID: 1, ValueRecords: 3, DecodeFailures: 1}, *stats.Schemas[0])
+	require.Equal(t, SchemaUsage{ID: 3, KeyRecords: 1}, *stats.Schemas[1])
+
+	var output bytes.Buffer
+	require.NoError(t, stats.Render(&output, "text"))
+	require.Contains(t, output.String(), "Topic orders: 6 records in 2 partitions")
+	require.Contains(t, output.String(), "trace-id")
+
+	output.Reset()
+	require.NoError(t, stats.Render(&output, "json"))
+	var decoded TopicStats
+	require.NoError(t, json.Unmarshal(output.Bytes(), &decoded))
+	require.Equal(t, int64(6), decoded.Records)
+	require.Len(t, decoded.Partitions, 2)
+
+	require.Error(t, stats.Render(&output, "yaml"))
+}
+
+func TestTopicStatsBinaryTopKey(t *testing.T) {
+	stats := NewTopicStats("orders")
+	for range 2 {
+		require.NoError(t, stats.Write(&sinkRecord{Message: &sarama.ConsumerMessage{Key: []byte{0xff, 'k', 0x01}, Value: []byte("v")}}))
+	}
+	stats.Finish()
+	require.Equal(t, `\xffk\x01`, stats.Partitions[0].TopKey)
+	require.True(t, stats.Partitions[0].TopKeyEscaped)
+
+	var output bytes.Buffer
+	require.NoError(t, stats.Render(&output, "json"))
+	require.Contains(t, output.String(), `"top_key": "\\xffk\\x01"`)
+	require.Contains(t, output.String(), `"top_key_escaped": true`)
+	output.Reset()
+	require.NoError(t, stats.Render(&output, "text"))
+	require.Contains(t, output.String(), `\xffk\x01 (escaped)`)
+}
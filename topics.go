@@ -10,12 +10,16 @@ import (
 )
 
 type Topic struct {
-	Name              string             `yaml:"name"`
-	Partitions        int32              `yaml:"partitions"`
-	ReplicationFactor int16              `yaml:"replication_factor"`
-	Configs           map[string]*string `yaml:"configs"`
-	Key               Schema             `yaml:"key"`
-	Value             Schema             `yaml:"value"`
+	Name               string             `yaml:"name"`
+	Partitions         int32              `yaml:"partitions"`
+	ReplicationFactor  int16              `yaml:"replication_factor"`
+	Configs            map[string]*string `yaml:"configs"`
+	Key                Schema             `yaml:"key"`
+	Value              Schema             `yaml:"value"`
+	Values             []Schema           `yaml:"values"`               // Extra value schemas. Used with the record name strategies to have multiple record types per topic
+	SubjectStrategy    string             `yaml:"subject_strategy"`     // TopicNameStrategy, RecordNameStrategy or TopicRecordNameStrategy
+	KeySubjectStrategy string             `yaml:"key_subject_strategy"` // Strategy of the key subject. TopicNameStrategy if not set, as keys are often not records
+	SchemaContext      string             `yaml:"schema_context"`       // Registry context of the topic's subjects, like .tenant1. Empty is the default context
 }
 
 // Dry run data for a Topic
@@ -84,21 +88,92 @@ func (s *Topic) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
+	if raw.SubjectStrategy == "" {
+		raw.SubjectStrategy = defaultSubjectStrategy()
+	}
+	if !isValidSubjectStrategy(raw.SubjectStrategy) {
+		return fmt.Errorf("topic %s has unknown subject_strategy %s", raw.Name, raw.SubjectStrategy)
+	}
+	if raw.KeySubjectStrategy == "" {
+		raw.KeySubjectStrategy = TopicNameStrategy
+	}
+	if !isValidSubjectStrategy(raw.KeySubjectStrategy) {
+		return fmt.Errorf("topic %s has unknown key_subject_strategy %s", raw.Name, raw.KeySubjectStrategy)
+	}
+	if raw.SchemaContext != "" && !isValidSchemaContext(raw.SchemaContext) {
+		return fmt.Errorf("topic %s has invalid schema_context %s. Context names start with a dot", raw.Name, raw.SchemaContext)
+	}
+	if len(raw.Values) > 0 && raw.SubjectStrategy == TopicNameStrategy {
+		return fmt.Errorf("topic %s defines multiple value schemas, which needs RecordNameStrategy or TopicRecordNameStrategy", raw.Name)
+	}
+	*s = Topic(raw)
+	return nil
+}
+
+/*
+Read the schema files of the topic, from an input YAML in directory inputDir, and name its subjects.
+Subjects are named after the schemas with the record name strategies, so this runs once the files are read
+*/
+func (s *Topic) resolveSchemas(inputDir string) error {
+	if err := s.Key.loadSchemaFile(inputDir); err != nil {
+		return fmt.Errorf("topic %s key: %w", s.Name, err)
+	}
+	if err := s.Value.loadSchemaFile(inputDir); err != nil {
+		return fmt.Errorf("topic %s value: %w", s.Name, err)
+	}
+	for i := range s.Values {
+		if err := s.Values[i].loadSchemaFile(inputDir); err != nil {
+			return fmt.Errorf("topic %s values[%d]: %w", s.Name, i, err)
+		}
+	}
+	var err error
 	// Set key subject name
-	if (Schema{} == raw.Key) {
-	} else {
-		raw.Key.SubjectName = raw.Name + "-key"
+	if s.Key.IsDefined() {
+		s.Key.SubjectName, err = getSubjectForStrategy(s.KeySubjectStrategy, s.Name, &s.Key, true)
+		if err != nil {
+			return fmt.Errorf("topic %s key: %w", s.Name, err)
+		}
+		s.Key.SubjectName = qualifySubject(s.SchemaContext, s.Key.SubjectName)
 	}
 	// Set Value subject name
-	if (Schema{} == raw.Value) {
-	} else {
-		raw.Value.SubjectName = raw.Name + "-value"
+	if s.Value.IsDefined() {
+		s.Value.SubjectName, err = getSubjectForStrategy(s.SubjectStrategy, s.Name, &s.Value, false)
+		if err != nil {
+			return fmt.Errorf("topic %s value: %w", s.Name, err)
+		}
+		s.Value.SubjectName = qualifySubject(s.SchemaContext, s.Value.SubjectName)
+	}
+	seenSubjects := make(map[string]bool)
+	if s.Value.SubjectName != "" {
+		seenSubjects[s.Value.SubjectName] = true
+	}
+	for i := range s.Values {
+		s.Values[i].SubjectName, err = getSubjectForStrategy(s.SubjectStrategy, s.Name, &s.Values[i], false)
+		if err != nil {
+			return fmt.Errorf("topic %s values[%d]: %w", s.Name, i, err)
+		}
+		s.Values[i].SubjectName = qualifySubject(s.SchemaContext, s.Values[i].SubjectName)
+		if seenSubjects[s.Values[i].SubjectName] {
+			return fmt.Errorf("topic %s has more than one value schema for subject %s", s.Name, s.Values[i].SubjectName)
+		}
+		seenSubjects[s.Values[i].SubjectName] = true
 	}
-
-	*s = Topic(raw)
 	return nil
 }
 
+// Return all schemas (key, value and any extra values) defined for this topic
+func (s *Topic) Schemas() []Schema {
+	var schemas []Schema
+	if s.Key.IsDefined() {
+		schemas = append(schemas, s.Key)
+	}
+	if s.Value.IsDefined() {
+		schemas = append(schemas, s.Value)
+	}
+	schemas = append(schemas, s.Values...)
+	return schemas
+}
+
 // Compare two topic definitions of newTopic with oldTopic and give back a list of configs that are different from new to old
 func getTopicConfigDiff(newTopic Topic, oldTopic sarama.TopicDetail) []string {
 	var diff []string
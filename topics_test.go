@@ -1,7 +1,12 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestCalculatePartitionPlan(t *testing.T) {
@@ -57,3 +62,93 @@ func TestRandNonRpeatingIntSet(t *testing.T) {
 		t.Error("Should return error about available space for random set")
 	}
 }
+
+func TestTopicUnmarshalSubjectStrategy(t *testing.T) {
+	gafkaloConfig.Kafkalo.SchemaDir = "testdata/files/data"
+	defer func() { gafkaloConfig.Kafkalo.SchemaDir = "" }()
+
+	var topic Topic
+	data := `
+name: orders
+partitions: 1
+replication_factor: 1
+subject_strategy: TopicRecordNameStrategy
+value:
+  schema: schemas/schema.json
+values:
+  - schema: schemas/order-cancelled.json
+`
+	err := yaml.Unmarshal([]byte(data), &topic)
+	require.NoError(t, err)
+	require.NoError(t, topic.resolveSchemas(""))
+	require.Equal(t, "orders-my.example.userInfo", topic.Value.SubjectName)
+	require.Equal(t, "orders-com.example.orders.OrderCancelled", topic.Values[0].SubjectName)
+	require.Len(t, topic.Schemas(), 2)
+
+	// Default strategy comes from config
+	gafkaloConfig.Kafkalo.SubjectStrategy = RecordNameStrategy
+	defer func() { gafkaloConfig.Kafkalo.SubjectStrategy = "" }()
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nkey:\n  schema: schemas/schema-key.json\n"), &topic)
+	require.NoError(t, err)
+	require.NoError(t, topic.resolveSchemas(""))
+	require.Equal(t, RecordNameStrategy, topic.SubjectStrategy)
+	// Keys stay on TopicNameStrategy unless they have a strategy of their own
+	require.Equal(t, "orders-key", topic.Key.SubjectName)
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nkey_subject_strategy: RecordNameStrategy\nkey:\n  schema: schemas/schema-key.json\n"), &topic)
+	require.NoError(t, err)
+	require.NoError(t, topic.resolveSchemas(""))
+	require.Equal(t, "my.example.userInfo", topic.Key.SubjectName)
+
+	// Multiple values need a record name strategy
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nsubject_strategy: TopicNameStrategy\nvalues:\n  - schema: schemas/schema.json\n"), &topic)
+	require.Error(t, err)
+
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nsubject_strategy: Bogus\n"), &topic)
+	require.Error(t, err)
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nkey_subject_strategy: Bogus\n"), &topic)
+	require.Error(t, err)
+}
+
+func TestTopicPrimitiveKeyWithRecordNameStrategy(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.avsc"), []byte(`{"type":"string"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "value.avsc"), []byte(`{"type":"record","name":"Order","namespace":"com.example","fields":[]}`), 0644))
+
+	var topic Topic
+	err := yaml.Unmarshal([]byte("name: orders\nsubject_strategy: TopicRecordNameStrategy\nkey:\n  schema: key.avsc\nvalue:\n  schema: value.avsc\n"), &topic)
+	require.NoError(t, err)
+	require.NoError(t, topic.resolveSchemas(dir))
+	require.Equal(t, "orders-key", topic.Key.SubjectName)
+	require.Equal(t, "orders-com.example.Order", topic.Value.SubjectName)
+
+	// A record name strategy for the key needs a named key schema
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nkey_subject_strategy: RecordNameStrategy\nkey:\n  schema: key.avsc\n"), &topic)
+	require.NoError(t, err)
+	require.Error(t, topic.resolveSchemas(dir))
+}
+
+func TestTopicUnmarshalSchemaContext(t *testing.T) {
+	gafkaloConfig.Kafkalo.SchemaDir = "testdata/files/data"
+	defer func() { gafkaloConfig.Kafkalo.SchemaDir = "" }()
+
+	var topic Topic
+	err := yaml.Unmarshal([]byte("name: orders\nschema_context: .tenant\nvalue:\n  schema: schemas/schema.json\n  mode: readonly\n"), &topic)
+	require.NoError(t, err)
+	require.NoError(t, topic.resolveSchemas(""))
+	require.Equal(t, ":.tenant:orders-value", topic.Value.SubjectName)
+	require.Equal(t, "READONLY", topic.Value.Mode)
+
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nschema_context: tenant\n"), &topic)
+	require.Error(t, err)
+
+	topic = Topic{}
+	err = yaml.Unmarshal([]byte("name: orders\nvalue:\n  schema: schemas/schema.json\n  mode: WRITEONLY\n"), &topic)
+	require.Error(t, err)
+}